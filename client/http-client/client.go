@@ -1,16 +1,19 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"time"
 
 	cr "github.com/acidleroy/song-bid/cockroach"
+	"github.com/google/uuid"
 )
 
 type HttpClient interface {
@@ -21,49 +24,109 @@ type apiV1 struct {
 	client  HttpClient
 	baseUrl string
 	timeout time.Duration
+	// token is sent as the bearer token of every request when it is set.
+	token string
 }
 
 func NewApi(client HttpClient, baseUrl string, timeout time.Duration) apiV1 {
-	return apiV1{client, baseUrl, timeout}
+	return apiV1{client: client, baseUrl: baseUrl, timeout: timeout}
 
 }
 
-// PlayNextSong will fetch a list of bids that represen the next song to play.
-// It will return an error if it has any problems fetching the next song.
-func (a apiV1) PlayNextSong(ctx context.Context) ([]cr.BidRow, error) {
+// WithToken returns a copy of the api that makes its requests as the user the login token belongs to,
+// or as the operator when given the operator token, which the player endpoints require.
+func (a apiV1) WithToken(token string) apiV1 {
+	a.token = token
+	return a
+}
+
+// envelope is the body of every JSON response of the http-server.
+type envelope struct {
+	Data  json.RawMessage `json:"data"`
+	Error *ApiError       `json:"error"`
+}
+
+// ApiError is returned when the server answers with an error status. Code is one of the error codes
+// documented in cmd/http-server/README.md.
+type ApiError struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *ApiError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// do sends a request to the api and decodes the data of the response into out. It returns the
+// status code of the response, and an *ApiError for error statuses.
+func (a apiV1) do(ctx context.Context, method string, path string, body io.Reader, out interface{}) (int, error) {
 	ctx, cancel := context.WithTimeout(ctx, a.timeout)
 	defer cancel()
 
-	request, err := http.NewRequestWithContext(ctx, http.MethodPut, a.baseUrl+"player/play", nil)
+	request, err := http.NewRequestWithContext(ctx, method, a.baseUrl+path, body)
 	if err != nil {
-		log.Println("Received an error when getting NextSong: ", err)
-		return nil, err
+		log.Printf("Received an error when creating the request to %v: %v", path, err)
+		return 0, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if a.token != "" {
+		request.Header.Set("Authorization", "Bearer "+a.token)
 	}
 
 	response, err := a.client.Do(request)
 	if err != nil {
-		log.Println("Received an error when making the request to PlayNextSong.", err)
-		return nil, err
+		log.Printf("Received an error when making the request to %v: %v", path, err)
+		return 0, err
 	}
 
 	defer response.Body.Close()
 
-	if response.StatusCode != http.StatusOK {
-		log.Println("Received a bad status code: ", response.StatusCode)
-		return nil, errors.New("Bad status code: " + response.Status)
+	if response.StatusCode == http.StatusNoContent {
+		return response.StatusCode, nil
 	}
 
 	buf, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		log.Printf("Failed to read body in getNextSong: %s", err)
+		log.Printf("Failed to read body of %v: %s", path, err)
+		return response.StatusCode, err
+	}
 
+	result := envelope{}
+	if err := json.Unmarshal(buf, &result); err != nil {
+		log.Printf("Failed to Unmarshall Payload of %v, %v, buff = %v\n", path, err, string(buf))
+		if response.StatusCode >= 400 {
+			return response.StatusCode, &ApiError{StatusCode: response.StatusCode, Message: response.Status}
+		}
+		return response.StatusCode, err
 	}
 
-	bidRows := []cr.BidRow{}
+	if response.StatusCode >= 400 {
+		log.Println("Received a bad status code: ", response.StatusCode)
+		if result.Error == nil {
+			result.Error = &ApiError{Message: response.Status}
+		}
+		result.Error.StatusCode = response.StatusCode
+		return response.StatusCode, result.Error
+	}
 
-	err = json.Unmarshal(buf, &bidRows)
-	if err != nil {
-		log.Printf("Failed to Unmarshall Payload in PlayNextSong, %v, buff = %v\n", err, string(buf))
+	if out != nil && len(result.Data) > 0 {
+		if err := json.Unmarshal(result.Data, out); err != nil {
+			log.Printf("Failed to Unmarshall data of %v, %v, buff = %v\n", path, err, string(result.Data))
+			return response.StatusCode, err
+		}
+	}
+	return response.StatusCode, nil
+}
+
+// PlayNextSong will fetch a list of bids that represen the next song to play.
+// It will return an error if it has any problems fetching the next song, and an empty list when
+// there is nothing queued.
+func (a apiV1) PlayNextSong(ctx context.Context) ([]cr.BidRow, error) {
+	bidRows := []cr.BidRow{}
+	if _, err := a.do(ctx, http.MethodPut, "player/play", nil, &bidRows); err != nil {
 		return nil, err
 	}
 
@@ -74,32 +137,75 @@ func (a apiV1) PlayNextSong(ctx context.Context) ([]cr.BidRow, error) {
 	return bidRows, nil
 }
 
-func Finalize() error {
-	httpServer := "http://localhost:5050/"
-	client := &http.Client{}
-	request, err := http.NewRequest(http.MethodPut, httpServer+"player/finalize", nil)
-	if err != nil {
-		fmt.Println("Received an error when createing new request to finalize any songs: ", err)
-		return err
+// GetBids fetches the bids of the user the api was made for with WithToken, or every bid with the
+// operator token. When statuses are given only bids in one of those statuses are returned, e.g.
+// GetBids(ctx, cr.Queued) returns the bids waiting to be played.
+func (a apiV1) GetBids(ctx context.Context, statuses ...cr.SongStatus) ([]cr.BidRow, error) {
+	query := url.Values{}
+	for _, status := range statuses {
+		query.Add("status", status.String())
+	}
+	path := "bids"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
 	}
 
-	response, err := client.Do(request)
-	if err != nil {
-		fmt.Println("Received an error when making the request to finalize.", err)
-		return err
+	bidRows := []cr.BidRow{}
+	if _, err := a.do(ctx, http.MethodGet, path, nil, &bidRows); err != nil {
+		return nil, err
 	}
+	return bidRows, nil
+}
 
-	defer response.Body.Close()
+// GetQueue fetches the queued songs ranked by their total bids, and the song that is playing.
+func (a apiV1) GetQueue(ctx context.Context) (cr.Queue, error) {
+	queue := cr.Queue{}
+	if _, err := a.do(ctx, http.MethodGet, "queue", nil, &queue); err != nil {
+		return cr.Queue{}, err
+	}
+	return queue, nil
+}
 
-	contents, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return err
+// Cancel withdraws a queued bid and returns it with its coins refunded. The api must be made with
+// WithToken for the user who placed the bid; the server answers 403 for bids of other users and
+// 409 once the song is playing or played, returned as an *ApiError.
+func (a apiV1) Cancel(ctx context.Context, bidId uuid.UUID) (cr.BidRow, error) {
+	bidRow := cr.BidRow{}
+	if _, err := a.do(ctx, http.MethodDelete, "bids/"+bidId.String(), nil, &bidRow); err != nil {
+		return cr.BidRow{}, err
 	}
+	return bidRow, nil
+}
 
-	if response.StatusCode != http.StatusOK {
-		fmt.Println("Received a bad status code: ", response.StatusCode)
-		fmt.Println("Contents = ", string(contents))
-		return errors.New("Bad status code: " + response.Status)
+// Finalize marks the playing song as played once it finished and returns its bids. The server
+// answers 409 no_song_playing, returned as an *ApiError, when nothing is playing.
+func (a apiV1) Finalize(ctx context.Context) ([]cr.BidRow, error) {
+	return a.finishSong(ctx, "player/finalize")
+}
+
+// Skip marks the playing song as skipped, e.g. because it could not be played, and returns its bids.
+func (a apiV1) Skip(ctx context.Context) ([]cr.BidRow, error) {
+	return a.finishSong(ctx, "player/skip")
+}
+
+func (a apiV1) finishSong(ctx context.Context, path string) ([]cr.BidRow, error) {
+	bidRows := []cr.BidRow{}
+	if _, err := a.do(ctx, http.MethodPut, path, nil, &bidRows); err != nil {
+		return nil, err
+	}
+	return bidRows, nil
+}
+
+// ReportState tells the server what the player of a room is playing and how far along it is, and
+// returns the state as the server stored it.
+func (a apiV1) ReportState(ctx context.Context, state cr.PlayerState) (cr.PlayerState, error) {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return cr.PlayerState{}, err
+	}
+	stored := cr.PlayerState{}
+	if _, err := a.do(ctx, http.MethodPut, "player/state", bytes.NewReader(buf), &stored); err != nil {
+		return cr.PlayerState{}, err
 	}
-	return nil
+	return stored, nil
 }
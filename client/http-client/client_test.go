@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -35,7 +36,7 @@ func generateString(t *testing.T, data Any) string {
 func TestPlayNextSong(t *testing.T) {
 	id, _ := uuid.NewUUID()
 
-	validBidRow := []cr.BidRow{{BidAmount: 1, SongId: "song-id", BidId: id, SongStatus: 0, CreatedAt: time.Now(), UpdatedAt: time.Now()}}
+	validBidRow := []cr.BidRow{{BidAmount: 1, SongId: "song-id", BidId: id, SongStatus: cr.Queued, CreatedAt: time.Now(), UpdatedAt: time.Now()}}
 	testTable := []struct {
 		MockBody       string
 		MockStatusCode int
@@ -44,12 +45,19 @@ func TestPlayNextSong(t *testing.T) {
 		ExpectedError  error
 	}{
 		{
-			MockBody:       generateString(t, validBidRow),
+			MockBody:       generateString(t, map[string]Any{"data": validBidRow}),
 			MockStatusCode: 200,
 
 			ExpectedResult: validBidRow,
 			ExpectedError:  nil,
 		},
+		{
+			MockBody:       "",
+			MockStatusCode: 204,
+
+			ExpectedResult: []cr.BidRow{},
+			ExpectedError:  nil,
+		},
 	}
 
 	mockClient := &HttpClientMock{}
@@ -77,3 +85,174 @@ func TestPlayNextSong(t *testing.T) {
 
 	}
 }
+
+func TestGetBids(t *testing.T) {
+	id, _ := uuid.NewUUID()
+	playing := []cr.BidRow{{BidAmount: 1, SongId: "song-id", BidId: id, SongStatus: cr.Playing, CreatedAt: time.Now(), UpdatedAt: time.Now()}}
+
+	mockClient := &HttpClientMock{}
+	api := NewApi(mockClient, "http://some-fake-website.com/", time.Second)
+
+	mockClient.DoFunc = func(r *http.Request) (*http.Response, error) {
+		if r.URL.Query().Get("status") != "playing" {
+			t.Fatalf("Expected the status to be sent by name, instead the query was %v", r.URL.RawQuery)
+		}
+		return &http.Response{
+			Body:       io.NopCloser(strings.NewReader(generateString(t, map[string]Any{"data": playing}))),
+			StatusCode: 200,
+		}, nil
+	}
+
+	bids, err := api.GetBids(context.Background(), cr.Playing)
+	if err != nil {
+		t.Fatalf("Expected no error, but instead received %v.\n", err)
+	}
+	if len(bids) != 1 || bids[0].SongStatus != cr.Playing {
+		t.Fatalf("Expected the playing bid, but instead received %v.\n", bids)
+	}
+}
+
+func TestGetQueue(t *testing.T) {
+	queue := cr.Queue{
+		Playing: &cr.SongTotals{SongId: "song-a", TotalAmount: 5, BidCount: 1},
+		Songs:   []cr.RankedSong{{SongTotals: cr.SongTotals{SongId: "song-b", TotalAmount: 3, BidCount: 2, UniqueBidders: 2}, Rank: 1}},
+	}
+
+	mockClient := &HttpClientMock{}
+	api := NewApi(mockClient, "http://some-fake-website.com/", time.Second)
+
+	mockClient.DoFunc = func(r *http.Request) (*http.Response, error) {
+		if r.Method != http.MethodGet || r.URL.Path != "/queue" {
+			t.Fatalf("Expected GET /queue, instead received %v %v", r.Method, r.URL.Path)
+		}
+		return &http.Response{
+			Body:       io.NopCloser(strings.NewReader(generateString(t, map[string]Any{"data": queue}))),
+			StatusCode: 200,
+		}, nil
+	}
+
+	result, err := api.GetQueue(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, but instead received %v.\n", err)
+	}
+	if result.Playing == nil || result.Playing.SongId != "song-a" || len(result.Songs) != 1 || result.Songs[0].UniqueBidders != 2 {
+		t.Fatalf("Expected the queue, but instead received %+v.\n", result)
+	}
+}
+
+func TestApiError(t *testing.T) {
+	mockClient := &HttpClientMock{}
+	api := NewApi(mockClient, "http://some-fake-website.com/", time.Second)
+
+	mockClient.DoFunc = func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			Body:       io.NopCloser(strings.NewReader(`{"error": {"code": "song_already_playing", "message": "a song is already playing"}}`)),
+			StatusCode: 409,
+		}, nil
+	}
+
+	_, err := api.PlayNextSong(context.Background())
+	apiError := &ApiError{}
+	if !errors.As(err, &apiError) {
+		t.Fatalf("Expected an *ApiError, but instead received %v.\n", err)
+	}
+	if apiError.StatusCode != 409 || apiError.Code != "song_already_playing" {
+		t.Fatalf("Expected a 409 song_already_playing error, but instead received %+v.\n", apiError)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	id := uuid.New()
+	cancelled := cr.BidRow{BidAmount: 1, SongId: "song-id", BidId: id, SongStatus: cr.Cancelled, RefundReason: cr.ReasonCancelled}
+
+	mockClient := &HttpClientMock{}
+	api := NewApi(mockClient, "http://some-fake-website.com/", time.Second).WithToken("secret")
+
+	mockClient.DoFunc = func(r *http.Request) (*http.Response, error) {
+		if r.Method != http.MethodDelete || r.URL.Path != "/bids/"+id.String() {
+			t.Fatalf("Expected DELETE /bids/%v, instead received %v %v", id, r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Fatalf("Expected the token to be sent, instead the Authorization header was %q", r.Header.Get("Authorization"))
+		}
+		return &http.Response{
+			Body:       io.NopCloser(strings.NewReader(generateString(t, map[string]Any{"data": cancelled}))),
+			StatusCode: 200,
+		}, nil
+	}
+
+	bid, err := api.Cancel(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Expected no error, but instead received %v.\n", err)
+	}
+	if bid.BidId != id || bid.SongStatus != cr.Cancelled {
+		t.Fatalf("Expected the cancelled bid, but instead received %+v.\n", bid)
+	}
+
+	mockClient.DoFunc = func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			Body:       io.NopCloser(strings.NewReader(`{"error": {"code": "invalid_transition", "message": "bid is playing"}}`)),
+			StatusCode: 409,
+		}, nil
+	}
+	_, err = api.Cancel(context.Background(), id)
+	apiError := &ApiError{}
+	if !errors.As(err, &apiError) || apiError.StatusCode != 409 {
+		t.Fatalf("Expected a 409 error, but instead received %v.\n", err)
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	played := []cr.BidRow{{BidAmount: 1, SongId: "song-id", BidId: uuid.New(), SongStatus: cr.Played}}
+
+	mockClient := &HttpClientMock{}
+	api := NewApi(mockClient, "http://some-fake-website.com/", time.Second)
+
+	mockClient.DoFunc = func(r *http.Request) (*http.Response, error) {
+		if r.Method != http.MethodPut || r.URL.Path != "/player/finalize" {
+			t.Fatalf("Expected PUT /player/finalize, instead received %v %v", r.Method, r.URL.Path)
+		}
+		return &http.Response{
+			Body:       io.NopCloser(strings.NewReader(generateString(t, map[string]Any{"data": played}))),
+			StatusCode: 200,
+		}, nil
+	}
+
+	bids, err := api.Finalize(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, but instead received %v.\n", err)
+	}
+	if len(bids) != 1 || bids[0].SongStatus != cr.Played {
+		t.Fatalf("Expected the played bid, but instead received %v.\n", bids)
+	}
+}
+
+func TestReportState(t *testing.T) {
+	state := cr.PlayerState{Room: cr.DefaultRoom, Track: "song-id", ProgressMs: 1000, DurationMs: 180000, Device: "speaker"}
+
+	mockClient := &HttpClientMock{}
+	api := NewApi(mockClient, "http://some-fake-website.com/", time.Second)
+
+	mockClient.DoFunc = func(r *http.Request) (*http.Response, error) {
+		sent := cr.PlayerState{}
+		if err := json.NewDecoder(r.Body).Decode(&sent); err != nil || r.Method != http.MethodPut || r.URL.Path != "/player/state" {
+			t.Fatalf("Expected the state to be sent to PUT /player/state, instead received %v %v and %v", r.Method, r.URL.Path, err)
+		}
+		if sent != state {
+			t.Fatalf("Expected %+v to be sent, instead received %+v", state, sent)
+		}
+		sent.UpdatedAt = time.Now()
+		return &http.Response{
+			Body:       io.NopCloser(strings.NewReader(generateString(t, map[string]Any{"data": sent}))),
+			StatusCode: 200,
+		}, nil
+	}
+
+	stored, err := api.ReportState(context.Background(), state)
+	if err != nil {
+		t.Fatalf("Expected no error, but instead received %v.\n", err)
+	}
+	if stored.Track != "song-id" || stored.UpdatedAt.IsZero() {
+		t.Fatalf("Expected the stored state, but instead received %+v.\n", stored)
+	}
+}
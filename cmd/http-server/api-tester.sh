@@ -1,7 +1,18 @@
 #!/usr/local/bin/zsh
 
+# Bids need a user, and coins to pay for them: the tester's wallet has none until an invoice is paid.
+# The player endpoints need the operator token in SONG_BID_OPERATOR_TOKEN.
+username=${SONG_BID_TESTER:-api-tester}
+password=${SONG_BID_TESTER_PASSWORD:-api-tester-password}
+
 http :5050
 
-http :5050/api/v1/bids SongId="spotify:track:21GdrXAPYwIZPAFx6JaAxh" BidAmount:=1
-http :5050/api/v1/bids
-http  PUT :5050/api/v1/player/play
\ No newline at end of file
+http :5050/api/v1/users Username=$username Password=$password
+token=$(http --body :5050/api/v1/login Username=$username Password=$password | jq -r .data.Token)
+
+http :5050/api/v1/coins/invoice Coins:=10 "Authorization:Bearer $token"
+http :5050/api/v1/coins/balance "Authorization:Bearer $token"
+
+http :5050/api/v1/bids SongId="spotify:track:21GdrXAPYwIZPAFx6JaAxh" BidAmount:=1 "Authorization:Bearer $token"
+http :5050/api/v1/bids "Authorization:Bearer $token"
+http PUT :5050/api/v1/player/play "Authorization:Bearer $SONG_BID_OPERATOR_TOKEN"
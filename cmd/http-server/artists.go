@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// songArtistRequest is the body of PUT /song-artists/{songId}.
+type songArtistRequest struct {
+	Artist string
+}
+
+// songArtistResponse is the artist set for a song.
+type songArtistResponse struct {
+	SongId string
+	Artist string
+}
+
+// HandleGetSongArtists returns the artists the operator set, by song id.
+func (p *apiHandler) HandleGetSongArtists(w http.ResponseWriter, r *http.Request) {
+	artists, err := p.database.GetSongArtists()
+	if err != nil {
+		log.Printf("Failed to get the song artists: %v", err)
+		writeStoreError(w, err)
+		return
+	}
+	writeData(w, http.StatusOK, artists)
+}
+
+// HandlePutSongArtist sets the artist of a song, addressed as /song-artists/{songId}, and returns
+// it. Only the operator may set artists: they decide which artist reserve and artist limit apply.
+func (p *apiHandler) HandlePutSongArtist(w http.ResponseWriter, r *http.Request) {
+	if !p.requireOperator(w, r) {
+		return
+	}
+
+	songId := strings.TrimPrefix(r.URL.Path, prefix+"/song-artists/")
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	buf, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		log.Printf("Failed to read body: %s", err)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Could not read the request body")
+		return
+	}
+	request := songArtistRequest{}
+	if err := json.Unmarshal(buf, &request); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf(`Invalid JSON request, expecting: {"Artist": string}: %v`, err))
+		return
+	}
+	problems := []fieldError{}
+	if problem := p.validator.songIdError(songId); problem != nil {
+		problems = append(problems, *problem)
+	}
+	if strings.TrimSpace(request.Artist) == "" {
+		problems = append(problems, fieldError{"Artist", "is required"})
+	} else if len(request.Artist) > maxArtistLength || !utf8.ValidString(request.Artist) {
+		problems = append(problems, fieldError{"Artist", fmt.Sprintf("must be valid UTF-8 of at most %d characters", maxArtistLength)})
+	}
+	if len(problems) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, envelope{Error: &apiError{
+			Code:    codeInvalidRequest,
+			Message: "Invalid song artist",
+			Details: problems,
+		}})
+		return
+	}
+
+	if err := p.database.SetSongArtist(songId, request.Artist); err != nil {
+		log.Printf("Failed to set the artist of %v: %v", songId, err)
+		writeStoreError(w, err)
+		return
+	}
+	log.Printf("Set the artist of %v to %q", songId, request.Artist)
+	writeData(w, http.StatusOK, songArtistResponse{SongId: songId, Artist: request.Artist})
+}
+
+// HandleDeleteSongArtist forgets the artist of a song, addressed as /song-artists/{songId}. Only the
+// operator may clear artists.
+func (p *apiHandler) HandleDeleteSongArtist(w http.ResponseWriter, r *http.Request) {
+	if !p.requireOperator(w, r) {
+		return
+	}
+
+	songId := strings.TrimPrefix(r.URL.Path, prefix+"/song-artists/")
+	if err := p.database.ClearSongArtist(songId); err != nil {
+		log.Printf("Failed to clear the artist of %v: %v", songId, err)
+		writeStoreError(w, err)
+		return
+	}
+	log.Printf("Cleared the artist of %v", songId)
+	writeNoContent(w)
+}
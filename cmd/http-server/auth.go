@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/acidleroy/song-bid/cockroach"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sessionDuration is how long a login token stays valid.
+const sessionDuration time.Duration = 30 * 24 * time.Hour
+
+// usernamePattern limits usernames to something that is safe to show and to put in a url.
+var usernamePattern = regexp.MustCompile(`^[0-9A-Za-z_.-]{3,32}$`)
+
+// Passwords are hashed with bcrypt, which ignores everything after 72 bytes.
+const (
+	minPasswordLength int = 8
+	maxPasswordLength int = 72
+)
+
+// credentials is the body of the register and login requests.
+type credentials struct {
+	Username string
+	Password string
+}
+
+// loginResponse is the data returned after a successful login. The token is sent back as
+// "Authorization: Bearer <Token>".
+type loginResponse struct {
+	Token     string
+	ExpiresAt time.Time
+	User      cockroach.User
+}
+
+var (
+	// errUnauthorized is returned by authenticate for a malformed Authorization header.
+	errUnauthorized = errors.New("invalid Authorization header, expecting: Bearer <token>")
+	// errNoCredentials is returned by requireUser when the request has no Authorization header.
+	errNoCredentials = errors.New("this endpoint requires an Authorization header")
+)
+
+// EnvOperatorToken is the environment variable holding the token of the operator, see -operator-token.
+const EnvOperatorToken = "SONG_BID_OPERATOR_TOKEN"
+
+// HandleRegister creates a new user from a username and password.
+func (p *apiHandler) HandleRegister(w http.ResponseWriter, r *http.Request) {
+	creds, ok := decodeCredentials(w, r)
+	if !ok {
+		return
+	}
+
+	if errors := validateCredentials(creds); len(errors) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, envelope{Error: &apiError{
+			Code:    codeInvalidUser,
+			Message: "Invalid user",
+			Details: errors,
+		}})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(creds.Password), p.passwordCost)
+	if err != nil {
+		log.Printf("Failed to hash the password: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal Server error")
+		return
+	}
+
+	user, err := p.database.CreateUser(creds.Username, string(hash))
+	if err != nil {
+		log.Printf("Failed to create user %v: %v", creds.Username, err)
+		writeStoreError(w, err)
+		return
+	}
+	writeData(w, http.StatusCreated, user)
+}
+
+// HandleLogin exchanges a username and password for a session token.
+func (p *apiHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	creds, ok := decodeCredentials(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := p.database.GetUserByName(creds.Username)
+	if errors.Is(err, cockroach.ErrUserNotFound) {
+		writeError(w, http.StatusUnauthorized, codeInvalidCredentials, "Invalid username or password")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get user %v: %v", creds.Username, err)
+		writeStoreError(w, err)
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(creds.Password)); err != nil {
+		writeError(w, http.StatusUnauthorized, codeInvalidCredentials, "Invalid username or password")
+		return
+	}
+
+	token, err := newSessionToken()
+	if err != nil {
+		log.Printf("Failed to create a session token: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal Server error")
+		return
+	}
+	expiresAt := time.Now().Add(sessionDuration)
+	if err := p.database.CreateSession(hashToken(token), user.UserId, expiresAt); err != nil {
+		log.Printf("Failed to create a session for %v: %v", user.Username, err)
+		writeStoreError(w, err)
+		return
+	}
+	writeData(w, http.StatusOK, loginResponse{Token: token, ExpiresAt: expiresAt, User: user})
+}
+
+// HandleGetMe returns the authenticated user.
+func (p *apiHandler) HandleGetMe(w http.ResponseWriter, r *http.Request) {
+	user, ok := p.requireUser(w, r)
+	if !ok {
+		return
+	}
+	writeData(w, http.StatusOK, user)
+}
+
+// authenticate returns the user of the request's bearer token, or nil if the request has no
+// Authorization header.
+func (p *apiHandler) authenticate(r *http.Request) (*cockroach.User, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, nil
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	if token == header || token == "" {
+		return nil, errUnauthorized
+	}
+
+	user, err := p.database.GetSessionUser(hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// optionalUser authenticates requests that may be anonymous. It writes a 401 response and returns
+// false if the request has credentials that aren't valid.
+func (p *apiHandler) optionalUser(w http.ResponseWriter, r *http.Request) (*cockroach.User, bool) {
+	user, err := p.authenticate(r)
+	if err != nil {
+		writeAuthError(w, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// requireUser authenticates requests that need a user. It writes a 401 response and returns false
+// if the request isn't authenticated.
+func (p *apiHandler) requireUser(w http.ResponseWriter, r *http.Request) (*cockroach.User, bool) {
+	user, ok := p.optionalUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	if user == nil {
+		writeAuthError(w, errNoCredentials)
+		return nil, false
+	}
+	return user, true
+}
+
+// requireOperator authenticates requests that only the operator of the server may make, which
+// send the operator token as their bearer token. It writes a 401 response when the request has no
+// token and a 403 response when the token isn't the operator's, or no operator token is configured.
+func (p *apiHandler) requireOperator(w http.ResponseWriter, r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		writeAuthError(w, errNoCredentials)
+		return false
+	}
+	if p.operatorToken == "" {
+		writeError(w, http.StatusForbidden, codeForbidden, "Operator endpoints are disabled, start the server with an operator token")
+		return false
+	}
+	if !p.isOperator(r) {
+		writeError(w, http.StatusForbidden, codeForbidden, "Only the operator may do this")
+		return false
+	}
+	return true
+}
+
+// isOperator reports whether the request sends the operator token as its bearer token. It is
+// false for every request when no operator token is configured.
+func (p *apiHandler) isOperator(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(header, "Bearer ")
+	return p.operatorToken != "" && token != header && subtle.ConstantTimeCompare([]byte(token), []byte(p.operatorToken)) == 1
+}
+
+// writeAuthError answers a request whose credentials are missing or invalid with 401. Failures of
+// the store are passed on to writeStoreError.
+func writeAuthError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, cockroach.ErrSessionNotFound), errors.Is(err, errUnauthorized), errors.Is(err, errNoCredentials):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="song-bid"`)
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
+	default:
+		writeStoreError(w, err)
+	}
+}
+
+// decodeCredentials parses the body of the register and login requests. It writes a 400 response
+// and returns false when the body is missing or isn't valid JSON.
+func decodeCredentials(w http.ResponseWriter, r *http.Request) (credentials, bool) {
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	buf, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		log.Printf("Failed to read body: %s", err)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Could not read the request body")
+		return credentials{}, false
+	}
+
+	creds := credentials{}
+	if err := json.Unmarshal(buf, &creds); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest,
+			fmt.Sprintf(`Invalid JSON request, expecting: {"Username": string, "Password": string}: %v`, err))
+		return credentials{}, false
+	}
+	return creds, true
+}
+
+// validateCredentials returns every problem with a new user's username and password.
+func validateCredentials(creds credentials) []fieldError {
+	errors := []fieldError{}
+	if !usernamePattern.MatchString(creds.Username) {
+		errors = append(errors, fieldError{"Username", "must be 3 to 32 letters, digits, '_', '.' or '-'"})
+	}
+	if len(creds.Password) < minPasswordLength || len(creds.Password) > maxPasswordLength {
+		errors = append(errors, fieldError{"Password", fmt.Sprintf("must be %d to %d bytes long", minPasswordLength, maxPasswordLength)})
+	}
+	return errors
+}
+
+// newSessionToken returns a random token that is safe to send in a header.
+func newSessionToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken returns the hash under which a session token is stored, so that a leaked sessions
+// table can't be used to log in.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/acidleroy/song-bid/cockroach"
+)
+
+// doAuthRequest is doRequest with an Authorization header carrying the token.
+func doAuthRequest(api *apiHandler, token, method, path, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+	api.mux.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// loginHelper registers a user, gives them 100 coins and logs them in.
+func loginHelper(t *testing.T, api *apiHandler, username string) loginResponse {
+	t.Helper()
+	body := `{"Username": "` + username + `", "Password": "correct horse"}`
+	decodeResponse(t, doRequest(api, http.MethodPost, prefix+"/users", body), http.StatusCreated, nil)
+
+	login := loginResponse{}
+	decodeResponse(t, doRequest(api, http.MethodPost, prefix+"/login", body), http.StatusOK, &login)
+	if login.Token == "" || login.User.Username != username {
+		t.Fatalf("Expected a token for %v, instead got %+v", username, login)
+	}
+	if err := api.database.CreditCoins(login.User.UserId, 100, "test:"+username); err != nil {
+		t.Fatalf("Failed to credit coins: %v", err)
+	}
+	return login
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	api := newTestApi()
+
+	response := doRequest(api, http.MethodPost, prefix+"/users", `{"Username": "alice", "Password": "correct horse"}`)
+	user := cockroach.User{}
+	decodeResponse(t, response, http.StatusCreated, &user)
+	if user.Username != "alice" || strings.Contains(response.Body.String(), "PasswordHash") {
+		t.Fatalf("Expected alice without her password hash, instead got %s", response.Body.String())
+	}
+
+	body := decodeResponse(t, doRequest(api, http.MethodPost, prefix+"/users", `{"Username": "alice", "Password": "another password"}`), http.StatusConflict, nil)
+	if body.Error.Code != codeUserExists {
+		t.Fatalf("Expected user_exists, instead got %v", body.Error.Code)
+	}
+
+	body = decodeResponse(t, doRequest(api, http.MethodPost, prefix+"/users", `{"Username": "a", "Password": "short"}`), http.StatusUnprocessableEntity, nil)
+	if body.Error.Code != codeInvalidUser || len(body.Error.Details) != 2 {
+		t.Fatalf("Expected invalid_user for the username and password, instead got %+v", body.Error)
+	}
+
+	for _, credentials := range []string{`{"Username": "alice", "Password": "wrong password"}`, `{"Username": "bob", "Password": "correct horse"}`} {
+		body = decodeResponse(t, doRequest(api, http.MethodPost, prefix+"/login", credentials), http.StatusUnauthorized, nil)
+		if body.Error.Code != codeInvalidCredentials {
+			t.Fatalf("Expected invalid_credentials for %v, instead got %v", credentials, body.Error.Code)
+		}
+	}
+
+	login := loginResponse{}
+	decodeResponse(t, doRequest(api, http.MethodPost, prefix+"/login", `{"Username": "alice", "Password": "correct horse"}`), http.StatusOK, &login)
+	me := cockroach.User{}
+	decodeResponse(t, doAuthRequest(api, login.Token, http.MethodGet, prefix+"/users/me", ""), http.StatusOK, &me)
+	if me.UserId != user.UserId {
+		t.Fatalf("Expected the token to belong to alice, instead got %v", me)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	api := newTestApi()
+
+	for _, response := range []*httptest.ResponseRecorder{
+		doRequest(api, http.MethodGet, prefix+"/users/me", ""),
+		doRequest(api, http.MethodGet, prefix+"/bids?user=me", ""),
+		doRequest(api, http.MethodGet, prefix+"/bids", ""),
+		doAuthRequest(api, "not-a-token", http.MethodGet, prefix+"/users/me", ""),
+		doAuthRequest(api, "not-a-token", http.MethodPost, prefix+"/bids", `{"BidAmount": 1, "SongId": "`+songA+`"}`),
+	} {
+		body := decodeResponse(t, response, http.StatusUnauthorized, nil)
+		if body.Error.Code != codeUnauthorized || response.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("Expected unauthorized with a WWW-Authenticate header, instead got %+v", body.Error)
+		}
+	}
+}
+
+func TestGetBidsByUser(t *testing.T) {
+	api := newTestApi()
+	alice := loginHelper(t, api, "alice")
+	bob := loginHelper(t, api, "bob")
+
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodPost, prefix+"/bids", `{"BidAmount": 3, "SongId": "`+songA+`"}`), http.StatusCreated, nil)
+	decodeResponse(t, doAuthRequest(api, bob.Token, http.MethodPost, prefix+"/bids", `{"BidAmount": 5, "SongId": "`+songB+`"}`), http.StatusCreated, nil)
+	postBidHelper(t, api, 1, songB)
+
+	mine := []cockroach.BidRow{}
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodGet, prefix+"/bids?user=me", ""), http.StatusOK, &mine)
+	if len(mine) != 1 || mine[0].SongId != songA || mine[0].UserId.UUID != alice.User.UserId {
+		t.Fatalf("Expected only alice's bid, instead got %v", mine)
+	}
+
+	// Users only see their own bids, the operator those of anyone.
+	all := []cockroach.BidRow{}
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodGet, prefix+"/bids", ""), http.StatusOK, &all)
+	if len(all) != 1 || all[0].UserId.UUID != alice.User.UserId {
+		t.Fatalf("Expected alice to see only her bid, instead got %v", all)
+	}
+	bobPath := prefix + "/bids?user=" + bob.User.UserId.String()
+	decodeResponse(t, doRequest(api, http.MethodGet, bobPath, ""), http.StatusUnauthorized, nil)
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodGet, bobPath, ""), http.StatusForbidden, nil)
+	bobs := []cockroach.BidRow{}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodGet, bobPath, ""), http.StatusOK, &bobs)
+	if len(bobs) != 1 || bobs[0].BidAmount != 5 {
+		t.Fatalf("Expected only bob's bid, instead got %v", bobs)
+	}
+
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodGet, prefix+"/bids", ""), http.StatusOK, &all)
+	if len(all) != 3 || all[2].UserId.Valid {
+		t.Fatalf("Expected every bid with the last one anonymous, instead got %v", all)
+	}
+
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodGet, prefix+"/bids?user=not-a-uuid", ""), http.StatusBadRequest, nil)
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodGet, prefix+"/bids?user=6f1c0a4e-3b9f-4d4e-9b0a-3c8f0f1e2d3c", ""), http.StatusNotFound, nil)
+}
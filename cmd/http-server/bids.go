@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/acidleroy/song-bid/cockroach"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultIdempotencyRetention is how long an Idempotency-Key is remembered unless
+	// -idempotency-retention says otherwise.
+	defaultIdempotencyRetention time.Duration = 24 * time.Hour
+	// idempotencyPurgeInterval is how often the idempotency keys past their retention are deleted.
+	idempotencyPurgeInterval time.Duration = time.Hour
+	// maxIdempotencyKeyLength is the longest Idempotency-Key header accepted.
+	maxIdempotencyKeyLength int = 255
+)
+
+// postBidResponse is the data returned after a bid was created.
+type postBidResponse struct {
+	BidId uuid.UUID
+}
+
+// HandleGetBids returns the bids of the authenticated user. The operator gets every bid, or those
+// of one user with ?user={userId}.
+func (p *apiHandler) HandleGetBids(w http.ResponseWriter, r *http.Request) {
+	log.Println("Get all active bids")
+
+	// Optionally only return bids in the given statuses, e.g. ?status=queued&status=playing
+	statuses := map[cockroach.SongStatus]bool{}
+	for _, name := range r.URL.Query()["status"] {
+		status, err := cockroach.ParseSongStatus(name)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		statuses[status] = true
+	}
+
+	var bids []cockroach.BidRow
+	var err error
+	user := r.URL.Query().Get("user")
+	switch {
+	case p.isOperator(r) && user == "":
+		bids, err = p.database.GetBids()
+	case p.isOperator(r):
+		userId, ok := p.userIdFromQuery(w, user)
+		if !ok {
+			return
+		}
+		bids, err = p.database.GetBidsByUser(userId)
+	default:
+		me, ok := p.requireUser(w, r)
+		if !ok {
+			return
+		}
+		if user != "" && user != "me" && user != me.UserId.String() {
+			writeError(w, http.StatusForbidden, codeForbidden, "Only the operator may list the bids of other users")
+			return
+		}
+		bids, err = p.database.GetBidsByUser(me.UserId)
+	}
+	if err != nil {
+		log.Printf("Failed to get bids: %s", err)
+		writeStoreError(w, err)
+		return
+	}
+
+	filtered := []cockroach.BidRow{}
+	for _, bid := range bids {
+		if len(statuses) == 0 || statuses[bid.SongStatus] {
+			filtered = append(filtered, bid)
+		}
+	}
+	writeData(w, http.StatusOK, filtered)
+}
+
+// HandleGetQueue returns the ranked queue, the amount each song needs to move up and the playing song.
+func (p *apiHandler) HandleGetQueue(w http.ResponseWriter, r *http.Request) {
+	queue, err := p.database.GetQueue()
+	if err != nil {
+		log.Printf("Failed to get the queue: %s", err)
+		writeStoreError(w, err)
+		return
+	}
+	writeData(w, http.StatusOK, queue)
+}
+
+// HandlePostBid places a bid paid from the authenticated user's coins. Bids without an
+// Authorization header are only accepted, unpaid, when anonymous bids are allowed. Clients that
+// retry send an Idempotency-Key header: a retry with the same key and bid within the retention
+// window is answered with the original bid instead of placing, and paying for, another one. Keys
+// are scoped to the user, so anonymous bids can't send one.
+func (p *apiHandler) HandlePostBid(w http.ResponseWriter, r *http.Request) {
+	authenticate := p.requireUser
+	if p.allowAnonymousBids {
+		authenticate = p.optionalUser
+	}
+	user, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	buf, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		log.Printf("Failed to read body: %s", err)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Could not read the request body")
+		return
+	}
+
+	bid := p.validator.decodeBid(w, buf)
+	if bid == nil {
+		return
+	}
+	if user != nil {
+		bid.UserId = uuid.NullUUID{UUID: user.UserId, Valid: true}
+	}
+
+	var bidId uuid.UUID
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		if user == nil {
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "An Idempotency-Key header needs an Authorization header")
+			return
+		}
+		if !validIdempotencyKey(key) {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest,
+				fmt.Sprintf("The Idempotency-Key header must be 1 to %d printable ASCII characters", maxIdempotencyKeyLength))
+			return
+		}
+		var replayed bool
+		bidId, replayed, err = p.database.PostBidOnce(*bid, cockroach.IdempotencyKey{
+			Key:         key,
+			Fingerprint: bidFingerprint(*bid),
+			Retention:   p.idempotencyRetention,
+		})
+		if replayed {
+			log.Printf("Replaying bid %v for idempotency key %q", bidId, key)
+			w.Header().Set("Idempotent-Replayed", "true")
+		}
+	} else {
+		var posted *uuid.UUID
+		if posted, err = p.database.PostBid(*bid); err == nil {
+			bidId = *posted
+		}
+	}
+	if err != nil {
+		log.Printf("There was an error posting the bid: %v", err)
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", prefix+"/bids/"+bidId.String())
+	writeData(w, http.StatusCreated, postBidResponse{BidId: bidId})
+}
+
+// bidFingerprint hashes the decoded bid, so that a retry whose JSON is only formatted differently
+// still matches its idempotency key.
+func bidFingerprint(bid cockroach.PostBidData) string {
+	// A PostBidData only holds numbers and strings, which always encode.
+	encoded, _ := json.Marshal(bid)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// validIdempotencyKey reports whether an Idempotency-Key header is short printable ASCII.
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for _, c := range key {
+		if c < ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// HandleGetBid returns the status of a single bid, addressed as /bids/{bidId}. Refunded bids come
+// with the ledger transaction that gave their coins back. Only the user who placed the bid and the
+// operator may read it, anonymous bids only the operator.
+func (p *apiHandler) HandleGetBid(w http.ResponseWriter, r *http.Request) {
+	var user *cockroach.User
+	if !p.isOperator(r) {
+		var ok bool
+		if user, ok = p.requireUser(w, r); !ok {
+			return
+		}
+	}
+	bidId, ok := bidIdFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	bid, err := p.database.GetBid(bidId)
+	if err != nil {
+		log.Printf("Failed to get bid %v: %v", bidId, err)
+		writeStoreError(w, err)
+		return
+	}
+	if user != nil && (!bid.UserId.Valid || bid.UserId.UUID != user.UserId) {
+		writeError(w, http.StatusForbidden, codeForbidden, "Only the user who placed bid "+bidId.String()+" may read it")
+		return
+	}
+	status, err := p.bidStatus(bid)
+	if err != nil {
+		log.Printf("Failed to get the refund of bid %v: %v", bidId, err)
+		writeStoreError(w, err)
+		return
+	}
+	writeData(w, http.StatusOK, status)
+}
+
+// HandleCancelBid withdraws a queued bid of the authenticated user, addressed as /bids/{bidId}, and
+// gives its coins back. Bids of other users, and anonymous bids, can't be cancelled. It answers 409
+// once the song is playing or played.
+func (p *apiHandler) HandleCancelBid(w http.ResponseWriter, r *http.Request) {
+	user, ok := p.requireUser(w, r)
+	if !ok {
+		return
+	}
+	bidId, ok := bidIdFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	bid, err := p.database.GetBid(bidId)
+	if err != nil {
+		log.Printf("Failed to get bid %v: %v", bidId, err)
+		writeStoreError(w, err)
+		return
+	}
+	if !bid.UserId.Valid || bid.UserId.UUID != user.UserId {
+		writeError(w, http.StatusForbidden, codeForbidden, "Only the user who placed bid "+bidId.String()+" may cancel it")
+		return
+	}
+
+	cancelled, err := p.refunds.Cancel(bidId)
+	if err != nil {
+		log.Printf("Failed to cancel bid %v: %v", bidId, err)
+		writeStoreError(w, err)
+		return
+	}
+	status, err := p.bidStatus(cancelled)
+	if err != nil {
+		log.Printf("Failed to get the refund of bid %v: %v", bidId, err)
+		writeStoreError(w, err)
+		return
+	}
+	writeData(w, http.StatusOK, status)
+}
+
+// bidIdFromPath parses the {bidId} of /bids/{bidId}. It writes a 400 response and returns false if
+// the id isn't a UUID.
+func bidIdFromPath(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id := strings.TrimPrefix(r.URL.Path, prefix+"/bids/")
+	bidId, err := uuid.Parse(id)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid bid id "+id)
+		return uuid.UUID{}, false
+	}
+	return bidId, true
+}
+
+// userIdFromQuery resolves the ?user= filter the operator sends to GET /bids, a user id. It writes
+// an error response and returns false if it can't.
+func (p *apiHandler) userIdFromQuery(w http.ResponseWriter, user string) (uuid.UUID, bool) {
+	userId, err := uuid.Parse(user)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid user id "+user)
+		return uuid.UUID{}, false
+	}
+	if _, err := p.database.GetUser(userId); err != nil {
+		log.Printf("Failed to get user %v: %v", userId, err)
+		writeStoreError(w, err)
+		return uuid.UUID{}, false
+	}
+	return userId, true
+}
+
+// purgeIdempotencyKeys deletes the idempotency keys past their retention every
+// idempotencyPurgeInterval until ctx is done.
+func (p *apiHandler) purgeIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(idempotencyPurgeInterval)
+	defer ticker.Stop()
+	for {
+		if purged, err := p.database.PurgeIdempotencyKeys(p.idempotencyRetention); err != nil {
+			log.Printf("Purging idempotency keys failed: %v, retrying in %v", err, idempotencyPurgeInterval)
+		} else if purged > 0 {
+			log.Printf("Purged %d idempotency keys", purged)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// doIdempotentRequest posts a bid as the user of token, anonymously without one, with an
+// Idempotency-Key header.
+func doIdempotentRequest(api *apiHandler, token, key, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, prefix+"/bids", strings.NewReader(body))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	request.Header.Set("Idempotency-Key", key)
+	recorder := httptest.NewRecorder()
+	api.mux.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestRetriedBidIsPostedOnce(t *testing.T) {
+	api := newTestApi()
+	alice := loginHelper(t, api, "alice")
+	body := `{"BidAmount": 30, "SongId": "` + songA + `"}`
+
+	first := postBidResponse{}
+	response := doIdempotentRequest(api, alice.Token, "retry-1", body)
+	decodeResponse(t, response, http.StatusCreated, &first)
+	if response.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("Expected the first request not to be a replay")
+	}
+
+	// The retry may format the same bid differently.
+	retried := postBidResponse{}
+	response = doIdempotentRequest(api, alice.Token, "retry-1", `{ "SongId":"`+songA+`",  "BidAmount":30 }`)
+	decodeResponse(t, response, http.StatusCreated, &retried)
+	if retried.BidId != first.BidId || response.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("Expected the retry to replay bid %v, instead got %v", first.BidId, retried.BidId)
+	}
+	if response.Header().Get("Location") != prefix+"/bids/"+first.BidId.String() {
+		t.Fatalf("Expected the retry to point to the original bid, instead got %v", response.Header().Get("Location"))
+	}
+
+	balance := balanceResponse{}
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodGet, prefix+"/coins/balance", ""), http.StatusOK, &balance)
+	if balance.Balance != 70 {
+		t.Fatalf("Expected the bid to be paid once, instead the balance is %d", balance.Balance)
+	}
+
+	reused := decodeResponse(t, doIdempotentRequest(api, alice.Token, "retry-1", `{"BidAmount": 5, "SongId": "`+songA+`"}`), http.StatusUnprocessableEntity, nil)
+	if reused.Error.Code != codeIdempotencyKeyReused {
+		t.Fatalf("Expected idempotency_key_reused for another body, instead got %v", reused.Error.Code)
+	}
+	decodeResponse(t, doIdempotentRequest(api, alice.Token, strings.Repeat("k", maxIdempotencyKeyLength+1), body), http.StatusBadRequest, nil)
+	decodeResponse(t, doIdempotentRequest(api, alice.Token, "retry-2", body), http.StatusCreated, nil)
+
+	// Anonymous clients can't be told apart, so they can't send a key.
+	anonymous := decodeResponse(t, doIdempotentRequest(api, "", "retry-1", body), http.StatusUnauthorized, nil)
+	if anonymous.Error.Code != codeUnauthorized {
+		t.Fatalf("Expected unauthorized for an anonymous bid with a key, instead got %v", anonymous.Error.Code)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/acidleroy/song-bid/cockroach"
+	"github.com/acidleroy/song-bid/payments"
+)
+
+// defaultSatsPerCoin is the price of a coin unless -sats-per-coin says otherwise.
+const defaultSatsPerCoin int64 = 10
+
+// maxInvoiceCoins is the largest number of coins that can be bought with one invoice.
+const maxInvoiceCoins int64 = 100000
+
+// invoiceExpiry is how long an invoice for coins can be paid.
+const invoiceExpiry time.Duration = time.Hour
+
+// invoiceRequest is the body of POST /coins/invoice.
+type invoiceRequest struct {
+	Coins int64
+}
+
+// balanceResponse is the data returned for the coin balance of a user.
+type balanceResponse struct {
+	Balance int64
+}
+
+// HandleGetBalance returns the number of coins the authenticated user can spend on bids.
+func (p *apiHandler) HandleGetBalance(w http.ResponseWriter, r *http.Request) {
+	user, ok := p.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	balance, err := p.database.GetBalance(user.UserId)
+	if err != nil {
+		log.Printf("Failed to get the balance of %v: %v", user.UserId, err)
+		writeStoreError(w, err)
+		return
+	}
+	writeData(w, http.StatusOK, balanceResponse{Balance: balance})
+}
+
+// HandleGetCoinHistory returns the ledger transactions of the authenticated user's wallet.
+func (p *apiHandler) HandleGetCoinHistory(w http.ResponseWriter, r *http.Request) {
+	user, ok := p.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	history, err := p.database.GetCoinHistory(user.UserId)
+	if err != nil {
+		log.Printf("Failed to get the coin history of %v: %v", user.UserId, err)
+		writeStoreError(w, err)
+		return
+	}
+	writeData(w, http.StatusOK, history)
+}
+
+// HandleCreateInvoice creates a Lightning invoice for the coins the authenticated user wants to
+// buy. The coins are credited by the settlement watcher once the invoice is paid.
+func (p *apiHandler) HandleCreateInvoice(w http.ResponseWriter, r *http.Request) {
+	user, ok := p.requireUser(w, r)
+	if !ok {
+		return
+	}
+	if p.payments == nil {
+		writeError(w, http.StatusServiceUnavailable, codePaymentsUnavailable, "Buying coins is not enabled on this server")
+		return
+	}
+
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	buf, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		log.Printf("Failed to read body: %s", err)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Could not read the request body")
+		return
+	}
+	request := invoiceRequest{}
+	if err := json.Unmarshal(buf, &request); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf(`Invalid JSON request, expecting: {"Coins": int}: %v`, err))
+		return
+	}
+	if request.Coins <= 0 || request.Coins > maxInvoiceCoins {
+		writeJSON(w, http.StatusUnprocessableEntity, envelope{Error: &apiError{
+			Code:    codeInvalidRequest,
+			Message: "Invalid invoice",
+			Details: []fieldError{{"Coins", fmt.Sprintf("must be between 1 and %d", maxInvoiceCoins)}},
+		}})
+		return
+	}
+
+	amountSats := request.Coins * p.satsPerCoin
+	memo := fmt.Sprintf("song-bid: %d coins for %s", request.Coins, user.Username)
+	invoice, err := p.payments.CreateInvoice(r.Context(), amountSats, memo, invoiceExpiry)
+	if err != nil {
+		log.Printf("Failed to create an invoice: %v", err)
+		writeError(w, http.StatusBadGateway, codePaymentBackend, "The payment backend could not create an invoice")
+		return
+	}
+
+	coinInvoice := cockroach.CoinInvoice{
+		PaymentHash:    invoice.PaymentHash,
+		PaymentRequest: invoice.PaymentRequest,
+		UserId:         user.UserId,
+		Coins:          request.Coins,
+		AmountSats:     amountSats,
+		CreatedAt:      invoice.CreatedAt,
+		ExpiresAt:      invoice.ExpiresAt,
+	}
+	if err := p.database.CreateInvoice(coinInvoice); err != nil {
+		log.Printf("Failed to store invoice %v: %v", invoice.PaymentHash, err)
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", prefix+"/coins/invoice/"+invoice.PaymentHash)
+	writeData(w, http.StatusCreated, coinInvoice)
+}
+
+// HandleGetInvoice returns an invoice of the authenticated user, addressed as
+// /coins/invoice/{paymentHash}, to check whether it was paid.
+func (p *apiHandler) HandleGetInvoice(w http.ResponseWriter, r *http.Request) {
+	user, ok := p.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	paymentHash := strings.TrimPrefix(r.URL.Path, prefix+"/coins/invoice/")
+	invoice, err := p.database.GetInvoice(paymentHash)
+	if err == nil && invoice.UserId != user.UserId {
+		// Other users' invoices are reported as missing rather than forbidden.
+		err = fmt.Errorf("%w: %v", cockroach.ErrInvoiceNotFound, paymentHash)
+	}
+	if err != nil {
+		log.Printf("Failed to get invoice %v: %v", paymentHash, err)
+		writeStoreError(w, err)
+		return
+	}
+	writeData(w, http.StatusOK, invoice)
+}
+
+// watchSettlements credits the coins of paid invoices until ctx is done.
+func (p *apiHandler) watchSettlements(ctx context.Context) {
+	watcher := payments.Watcher{Backend: p.payments, Settler: p.database, RetryInterval: 10 * time.Second}
+	if err := watcher.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("Stopped watching for paid invoices: %v", err)
+	}
+}
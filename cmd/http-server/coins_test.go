@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/acidleroy/song-bid/cockroach"
+	"github.com/acidleroy/song-bid/ledger"
+	"github.com/acidleroy/song-bid/payments"
+)
+
+func TestBidsArePaidWithCoins(t *testing.T) {
+	api := newTestApi()
+	alice := loginHelper(t, api, "alice")
+
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodPost, prefix+"/bids", `{"BidAmount": 60, "SongId": "`+songA+`"}`), http.StatusCreated, nil)
+	body := decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodPost, prefix+"/bids", `{"BidAmount": 50, "SongId": "`+songB+`"}`), http.StatusPaymentRequired, nil)
+	if body.Error.Code != codeInsufficientFunds {
+		t.Fatalf("Expected insufficient_funds for a bid over the balance, instead got %v", body.Error.Code)
+	}
+
+	balance := balanceResponse{}
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodGet, prefix+"/coins/balance", ""), http.StatusOK, &balance)
+	if balance.Balance != 40 {
+		t.Fatalf("Expected 40 coins to be left, instead got %d", balance.Balance)
+	}
+
+	history := []ledger.Transaction{}
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodGet, prefix+"/coins/transactions", ""), http.StatusOK, &history)
+	if len(history) != 2 || history[0].Reference != "test:alice" {
+		t.Fatalf("Expected the credit and the bid, instead got %v", history)
+	}
+
+	decodeResponse(t, doRequest(api, http.MethodGet, prefix+"/coins/balance", ""), http.StatusUnauthorized, nil)
+}
+
+func TestAnonymousBidsNeedToBeAllowed(t *testing.T) {
+	api := newTestApi()
+	api.allowAnonymousBids = false
+
+	body := decodeResponse(t, doRequest(api, http.MethodPost, prefix+"/bids", `{"BidAmount": 1, "SongId": "`+songA+`"}`), http.StatusUnauthorized, nil)
+	if body.Error.Code != codeUnauthorized {
+		t.Fatalf("Expected unauthorized for an anonymous bid, instead got %v", body.Error.Code)
+	}
+}
+
+func TestBuyCoinsWithAnInvoice(t *testing.T) {
+	api := newTestApi()
+	backend := payments.NewFake()
+	api.payments = backend
+	alice := loginHelper(t, api, "alice")
+
+	response := doAuthRequest(api, alice.Token, http.MethodPost, prefix+"/coins/invoice", `{"Coins": 30}`)
+	invoice := cockroach.CoinInvoice{}
+	decodeResponse(t, response, http.StatusCreated, &invoice)
+	if invoice.AmountSats != 30*defaultSatsPerCoin || invoice.PaymentRequest == "" || invoice.Settled {
+		t.Fatalf("Expected an open invoice over %d sats, instead got %+v", 30*defaultSatsPerCoin, invoice)
+	}
+
+	// Paying the invoice twice, and catching up afterwards, credits the coins once.
+	watcher := payments.Watcher{Backend: backend, Settler: api.database}
+	backend.Settle(invoice.PaymentHash)
+	backend.Settle(invoice.PaymentHash)
+	for i := 0; i < 2; i++ {
+		if err := watcher.CatchUp(context.Background()); err != nil {
+			t.Fatalf("Failed to catch up: %v", err)
+		}
+	}
+
+	balance := balanceResponse{}
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodGet, prefix+"/coins/balance", ""), http.StatusOK, &balance)
+	if balance.Balance != 130 {
+		t.Fatalf("Expected the 30 coins to be credited once, instead got %d", balance.Balance)
+	}
+
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodGet, response.Header().Get("Location"), ""), http.StatusOK, &invoice)
+	if !invoice.Settled {
+		t.Fatalf("Expected the invoice to be settled, instead got %+v", invoice)
+	}
+	bob := loginHelper(t, api, "bob")
+	decodeResponse(t, doAuthRequest(api, bob.Token, http.MethodGet, response.Header().Get("Location"), ""), http.StatusNotFound, nil)
+
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodPost, prefix+"/coins/invoice", `{"Coins": 0}`), http.StatusUnprocessableEntity, nil)
+	decodeResponse(t, doRequest(api, http.MethodPost, prefix+"/coins/invoice", `{"Coins": 30}`), http.StatusUnauthorized, nil)
+}
+
+func TestBuyCoinsWithoutPayments(t *testing.T) {
+	api := newTestApi()
+	alice := loginHelper(t, api, "alice")
+
+	body := decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodPost, prefix+"/coins/invoice", `{"Coins": 30}`), http.StatusServiceUnavailable, nil)
+	if body.Error.Code != codePaymentsUnavailable {
+		t.Fatalf("Expected payments_unavailable, instead got %v", body.Error.Code)
+	}
+}
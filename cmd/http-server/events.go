@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/acidleroy/song-bid/events"
+	"golang.org/x/net/websocket"
+)
+
+// keepAliveInterval is how often an idle event stream sends a comment, so that proxies don't
+// close it.
+const keepAliveInterval time.Duration = 15 * time.Second
+
+// HandleEvents streams the changes of the queue as they happen, over WebSocket when the request
+// asks for an upgrade and as Server-Sent Events otherwise. ?type= limits the stream to the given
+// event types, e.g. ?type=bid.placed&type=song.started.
+func (p *apiHandler) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	types, ok := eventTypesFromQuery(w, r)
+	if !ok {
+		return
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		server := websocket.Server{
+			// The events are public, so pages of any origin may listen to them.
+			Handshake: func(*websocket.Config, *http.Request) error { return nil },
+			Handler:   func(ws *websocket.Conn) { p.streamWebSocket(ws, types) },
+		}
+		server.ServeHTTP(w, r)
+		return
+	}
+	p.streamServerSentEvents(w, r, types)
+}
+
+// streamServerSentEvents writes every event as a text/event-stream message until the client goes
+// away, or falls so far behind that it is dropped and has to reconnect.
+func (p *apiHandler) streamServerSentEvents(w http.ResponseWriter, r *http.Request, types []events.Type) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Streaming is not supported")
+		return
+	}
+
+	subscription := p.database.Events().Subscribe(r.Context(), types...)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-subscription:
+			if !ok {
+				return
+			}
+			buf, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("Failed to marshal event %v: %v", event.Id, err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, buf)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+// streamWebSocket sends every event as a JSON text message until the client closes the socket.
+// Messages sent by the client are ignored.
+func (p *apiHandler) streamWebSocket(ws *websocket.Conn, types []events.Type) {
+	ctx, cancel := context.WithCancel(ws.Request().Context())
+	defer cancel()
+	subscription := p.database.Events().Subscribe(ctx, types...)
+
+	go func() {
+		defer cancel()
+		var message []byte
+		for websocket.Message.Receive(ws, &message) == nil {
+		}
+	}()
+
+	for event := range subscription {
+		if err := websocket.JSON.Send(ws, event); err != nil {
+			log.Printf("Failed to send event %v: %v", event.Id, err)
+			return
+		}
+	}
+}
+
+// eventTypesFromQuery parses the ?type= filter of /events. It writes a 400 response and returns
+// false for unknown types.
+func eventTypesFromQuery(w http.ResponseWriter, r *http.Request) ([]events.Type, bool) {
+	types := []events.Type{}
+	for _, name := range r.URL.Query()["type"] {
+		known := false
+		for _, t := range events.Types {
+			known = known || string(t) == name
+		}
+		if !known {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Unknown event type "+name)
+			return nil, false
+		}
+		types = append(types, events.Type(name))
+	}
+	return types, true
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/acidleroy/song-bid/events"
+	"golang.org/x/net/websocket"
+)
+
+func TestServerSentEvents(t *testing.T) {
+	api := newTestApi()
+	server := httptest.NewServer(api.mux)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+prefix+"/events?type=bid.placed", nil)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("Failed to connect to the event stream: %v", err)
+	}
+	defer response.Body.Close()
+	if response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, instead got %v", response.Header.Get("Content-Type"))
+	}
+
+	decodeResponse(t, doRequest(api, http.MethodPost, prefix+"/bids", `{"BidAmount": 3, "SongId": "`+songA+`"}`), http.StatusCreated, nil)
+
+	lines := bufio.NewScanner(response.Body)
+	message := map[string]string{}
+	for lines.Scan() && (lines.Text() != "" || message["data"] == "") {
+		if field := strings.SplitN(lines.Text(), ": ", 2); len(field) == 2 {
+			message[field[0]] = field[1]
+		}
+	}
+	if message["event"] != string(events.BidPlaced) || message["id"] == "" {
+		t.Fatalf("Expected a bid.placed event, instead got %v", message)
+	}
+	event := struct {
+		Type events.Type
+		Data struct{ SongId string }
+	}{}
+	if err := json.Unmarshal([]byte(message["data"]), &event); err != nil || event.Data.SongId != songA {
+		t.Fatalf("Expected the event to carry the bid, instead got %v and %v", message["data"], err)
+	}
+
+	decodeResponse(t, doRequest(api, http.MethodGet, prefix+"/events?type=bid.exploded", ""), http.StatusBadRequest, nil)
+}
+
+func TestWebSocketEvents(t *testing.T) {
+	api := newTestApi()
+	server := httptest.NewServer(api.mux)
+	defer server.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+prefix+"/events", "", server.URL)
+	if err != nil {
+		t.Fatalf("Failed to open the websocket: %v", err)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// The subscription starts once the handshake is done, wait until the server listens.
+	for deadline := time.Now().Add(time.Second); !api.database.Events().HasSubscribers(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the websocket to subscribe to the events")
+		}
+	}
+	decodeResponse(t, doRequest(api, http.MethodPost, prefix+"/bids", `{"BidAmount": 3, "SongId": "`+songA+`"}`), http.StatusCreated, nil)
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/play", ""), http.StatusOK, nil)
+
+	received := []events.Type{}
+	for len(received) < 4 {
+		event := events.Event{}
+		if err := websocket.JSON.Receive(ws, &event); err != nil {
+			t.Fatalf("Failed to receive an event: %v", err)
+		}
+		received = append(received, event.Type)
+	}
+	expected := []events.Type{events.BidPlaced, events.QueueReordered, events.SongStarted, events.QueueReordered}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Fatalf("Expected the events %v, instead got %v", expected, received)
+		}
+	}
+}
@@ -1,179 +1,189 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/acidleroy/song-bid/cockroach"
+	"github.com/acidleroy/song-bid/payments"
+	"github.com/acidleroy/song-bid/refunds"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const prefix string = "/api/v1"
 
 type apiHandler struct {
-	mux      *http.ServeMux
-	database *cockroach.Database
+	mux          *http.ServeMux
+	database     cockroach.Store
+	validator    bidValidator
+	passwordCost int
+	// allowAnonymousBids lets requests without a user place bids that aren't paid with coins.
+	allowAnonymousBids bool
+	// payments creates the invoices for buying coins, buying is disabled when it is nil.
+	payments    payments.PaymentBackend
+	satsPerCoin int64
+	// refunds gives bidders their coins back when their song won't be played.
+	refunds *refunds.Engine
+	// idempotencyRetention is how long the Idempotency-Key of a bid is remembered.
+	idempotencyRetention time.Duration
+	// operatorToken authenticates the operator endpoints, they are disabled when it is empty.
+	operatorToken string
 }
 
-func NewApiHandler() *apiHandler {
-	return &apiHandler{mux: http.NewServeMux(), database: cockroach.Connect()}
-
-}
-
-func (p *apiHandler) HandleGetBids(w http.ResponseWriter, r *http.Request) {
-	log.Println("Get all active bids")
-	bids, err := p.database.GetBids()
-	if err != nil {
-		log.Printf("Failed to get bids: %s", err)
-		fmt.Fprint(w, "Internal Server error")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+func NewApiHandler(database cockroach.Store) *apiHandler {
+	return &apiHandler{
+		mux:                  http.NewServeMux(),
+		database:             database,
+		validator:            bidValidator{maxBidAmount: defaultMaxBidAmount},
+		passwordCost:         bcrypt.DefaultCost,
+		satsPerCoin:          defaultSatsPerCoin,
+		refunds:              refunds.NewEngine(database, 0, defaultRefundInterval),
+		idempotencyRetention: defaultIdempotencyRetention,
 	}
 
-	value, err := json.Marshal(bids)
-	if err != nil {
-		log.Printf("Failed to marshal bids: %v", err)
-		fmt.Fprint(w, "Internal Server error")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	fmt.Fprintf(w, "%s", string(value))
 }
 
-func (p *apiHandler) HandlePostBid(w http.ResponseWriter, r *http.Request) {
+// routes registers every endpoint of the api on the handler's mux.
+func (p *apiHandler) routes() {
+	p.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			log.Printf("Unhandled path: %v\n", r.URL.Path)
+			writeError(w, http.StatusNotFound, codeNotFound, "No such endpoint "+r.URL.Path)
+			return
+		}
+		writeData(w, http.StatusOK, map[string]string{"name": "song-bid", "version": "v1.0"})
+	})
 
-	if r.Body == nil {
-		fmt.Fprintf(w, `Invalid JSON request, expecting: {"BidAmount": int, "SongId": string}`)
-		w.WriteHeader(http.StatusBadRequest)
-	}
+	p.mux.Handle(prefix+"/bids", methods{http.MethodGet: p.HandleGetBids, http.MethodPost: p.HandlePostBid})
+	p.mux.Handle(prefix+"/bids/", methods{http.MethodGet: p.HandleGetBid, http.MethodDelete: p.HandleCancelBid})
+	p.mux.Handle(prefix+"/queue", methods{http.MethodGet: p.HandleGetQueue})
+	p.mux.Handle(prefix+"/users", methods{http.MethodPost: p.HandleRegister})
+	p.mux.Handle(prefix+"/users/me", methods{http.MethodGet: p.HandleGetMe})
+	p.mux.Handle(prefix+"/login", methods{http.MethodPost: p.HandleLogin})
+	p.mux.Handle(prefix+"/coins/balance", methods{http.MethodGet: p.HandleGetBalance})
+	p.mux.Handle(prefix+"/coins/transactions", methods{http.MethodGet: p.HandleGetCoinHistory})
+	p.mux.Handle(prefix+"/coins/invoice", methods{http.MethodPost: p.HandleCreateInvoice})
+	p.mux.Handle(prefix+"/coins/invoice/", methods{http.MethodGet: p.HandleGetInvoice})
+	p.mux.Handle(prefix+"/player/play", methods{http.MethodPut: p.HandlePlayerPlay})
+	p.mux.Handle(prefix+"/player/finalize", methods{http.MethodPut: p.HandlePlayerFinalize})
+	p.mux.Handle(prefix+"/player/skip", methods{http.MethodPut: p.HandlePlayerSkip})
+	p.mux.Handle(prefix+"/player/state", methods{http.MethodPut: p.HandlePutPlayerState})
+	p.mux.Handle(prefix+"/player/now-playing", methods{http.MethodGet: p.HandleGetNowPlaying})
+	p.mux.Handle(prefix+"/events", methods{http.MethodGet: p.HandleEvents})
+	p.mux.Handle(prefix+"/session/end", methods{http.MethodPost: p.HandleEndSession})
+	p.mux.Handle(prefix+"/refunds", methods{http.MethodPost: p.HandleRefundBids})
+	p.mux.Handle(prefix+"/banned-songs", methods{http.MethodGet: p.HandleGetBannedSongs, http.MethodPost: p.HandleBanSong})
+	p.mux.Handle(prefix+"/banned-songs/", methods{http.MethodDelete: p.HandleUnbanSong})
+	p.mux.Handle(prefix+"/pricing/", methods{http.MethodGet: p.HandleGetPricing, http.MethodPut: p.HandlePutPricing})
+	p.mux.Handle(prefix+"/song-artists", methods{http.MethodGet: p.HandleGetSongArtists})
+	p.mux.Handle(prefix+"/song-artists/", methods{http.MethodPut: p.HandlePutSongArtist, http.MethodDelete: p.HandleDeleteSongArtist})
+}
 
-	buf, err := ioutil.ReadAll(r.Body)
-	defer r.Body.Close()
+func main() {
+	inMemory := flag.Bool("memory", false, "keep bids in memory instead of connecting to CockroachDB")
+	migrate := flag.Bool("migrate", true, "apply pending schema migrations before starting")
+	maxBid := flag.Int("max-bid", defaultMaxBidAmount, "largest number of coins accepted in a single bid, 0 for no limit")
+	songIds := flag.String("song-ids", songIdsSpotify, "songs bids may name, the ones the music server's -player plays: spotify track URIs or mpd paths and URLs")
+	anonymousBids := flag.Bool("anonymous-bids", false, "accept bids without a user, which aren't paid with coins")
+	satsPerCoin := flag.Int64("sats-per-coin", defaultSatsPerCoin, "price of a coin in satoshis")
+	lndConfig := payments.LoadLNDConfig()
+	flag.StringVar(&lndConfig.Host, "lnd-host", lndConfig.Host, "host:port of the LND REST api, buying coins is disabled without it")
+	flag.StringVar(&lndConfig.MacaroonPath, "lnd-macaroon", lndConfig.MacaroonPath, "LND macaroon that can create and read invoices")
+	flag.StringVar(&lndConfig.TLSCertPath, "lnd-tlscert", lndConfig.TLSCertPath, "TLS certificate of the LND node")
+	bidMaxAge := flag.Duration("bid-max-age", defaultBidMaxAge, "refund bids that stayed queued for longer than this, 0 keeps them forever")
+	refundInterval := flag.Duration("refund-interval", defaultRefundInterval, "how often to look for bids older than -bid-max-age")
+	idempotencyRetention := flag.Duration("idempotency-retention", defaultIdempotencyRetention, "how long a retried bid with the same Idempotency-Key returns the original bid")
+	operatorToken := flag.String("operator-token", os.Getenv(EnvOperatorToken), "bearer token of the operator, the operator endpoints are disabled without it")
+	configFile := flag.String("config", os.Getenv(cockroach.EnvConfigFile), "JSON file with the database connection settings")
+	auction := flag.String("auction", cockroach.StrategySum, "auction strategy choosing the next song, e.g. quadratic")
+	bidLockWindow := flag.Duration("bid-lock-window", 0, "bids placed this long before the playing track ends only count from the round after the next song, 0 disables the lock")
+	bidDecay := flag.String("bid-decay", "none", "how older bids count less when ranking the queue: none, half_life=<duration> or linear=<duration>")
+	trackCooldown := flag.Duration("track-cooldown", 0, "how long a song can't play again after it started, 0 for no cooldown")
+	artistLimit := flag.Int("artist-limit", 0, "how many songs of an artist may start within an hour, 0 for no limit")
+	flag.Parse()
+
+	auctionConfig, err := cockroach.ParseAuctionConfig(*auction)
 	if err != nil {
-		log.Printf("Failed to read body: %s", err)
-		fmt.Fprint(w, "Internal Server error")
-		w.WriteHeader(http.StatusInternalServerError)
+		log.Fatalf("Invalid -auction: %v", err)
 	}
-
-	bid := cockroach.PostBidData{}
-	bid.UnmarshalJSON(buf)
-
-	uuid, err := p.database.PostBid(bid)
-	if err != nil {
-		fmt.Fprintf(w, "There was an error posting the bid.")
-		w.WriteHeader(http.StatusInternalServerError)
-	} else {
-		fmt.Fprintf(w, "The uuid is : %s", uuid)
+	if auctionConfig.Decay, err = cockroach.ParseDecay(*bidDecay); err != nil {
+		log.Fatalf("Invalid -bid-decay: %v", err)
 	}
-
-}
-
-func (p *apiHandler) HandleBids(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Handle the bid!")
-	switch r.Method {
-	case http.MethodGet:
-		p.HandleGetBids(w, r)
-	case http.MethodPost:
-		p.HandlePostBid(w, r)
-	default:
-		fmt.Fprintf(w, "Handle other methods\n")
+	if *bidLockWindow < 0 {
+		log.Fatalf("The bid lock window can't be negative, got %v", *bidLockWindow)
 	}
-}
-
-func (p *apiHandler) HandlePlayerPlay(w http.ResponseWriter, r *http.Request) {
-	log.Println("player/play")
-
-	switch r.Method {
-	case http.MethodPut:
-		if next, err := p.database.PlayNextSong(); err != nil {
-			log.Printf("Error playing next song: %v\n", err)
-			fmt.Fprintf(w, "Internal server error")
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		} else {
-			if len(next) > 0 {
-				log.Printf("Playing next song: %v", next[0])
-				if result, err := json.Marshal(next); err != nil {
-					log.Printf("There was an issue marshalling bids: %v\n", err)
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				} else {
-					log.Printf("Returning %d bids to the user.\n", len(next))
-					fmt.Fprintf(w, "%s", result)
-					return
-				}
-
-			} else {
-				log.Println("There are no songs to play!")
-				fmt.Fprintf(w, "{}")
-				return
-			}
-
-		}
-	default:
-		log.Printf("Method %v is not supported by '/play' \n", r.Method)
-		http.NotFound(w, r)
-		return
+	auctionConfig.LockWindow = *bidLockWindow
+	if *trackCooldown < 0 || *artistLimit < 0 {
+		log.Fatalf("The cooldowns can't be negative, got -track-cooldown %v and -artist-limit %d", *trackCooldown, *artistLimit)
 	}
-}
-
-func (p *apiHandler) HandlePlayerFinalize(w http.ResponseWriter, r *http.Request) {
-	log.Println("player/finalize")
-
-	switch r.Method {
-	case http.MethodPut:
-		if next, err := p.database.FinalizeCurrentSong(); err != nil {
-			log.Printf("Error finalizing current song: %v\n", err)
-			fmt.Fprintf(w, "Internal server error")
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		} else {
-			if len(next) > 0 {
-				log.Printf("Finalizing this song: %v", next[0])
-				if result, err := json.Marshal(next); err != nil {
-					log.Printf("There was an issue marshalling bids: %v\n", err)
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				} else {
-					log.Printf("Finalized these songs: %s\n", result)
-					fmt.Fprintf(w, "%s", result)
-					return
-				}
+	auctionConfig.Cooldowns = cockroach.Cooldowns{Track: *trackCooldown, ArtistLimit: *artistLimit}
 
-			} else {
-				log.Println("No songs currently playing")
-				fmt.Fprintf(w, "{}")
-				return
+	var database cockroach.Store
+	if *inMemory {
+		log.Println("Using the in-memory bid store, bids will be lost when the server stops.")
+		database = cockroach.NewMemoryStore()
+	} else {
+		config, err := cockroach.LoadConfig(*configFile)
+		if err != nil {
+			log.Fatalf("Could not load the database config: %v", err)
+		}
+		db, err := cockroach.ConnectWithConfig(context.Background(), config)
+		if err != nil {
+			log.Fatalf("Could not connect to the database: %v", err)
+		}
+		if *migrate {
+			if err := db.Migrate(context.Background()); err != nil {
+				db.Close()
+				log.Fatalf("Could not migrate the database: %v", err)
 			}
-
 		}
-	default:
-		log.Printf("Method %v is not supported by '/player/finalize' \n", r.Method)
-		http.NotFound(w, r)
-		return
+		database = db
 	}
-}
-
-func main() {
-
-	api := NewApiHandler()
+	database.SetAuctionConfig(auctionConfig)
 
+	api := NewApiHandler(database)
+	api.validator.maxBidAmount = *maxBid
+	if *songIds != songIdsSpotify && *songIds != songIdsMPD {
+		log.Fatalf("Unknown -song-ids %q, expected %v or %v", *songIds, songIdsSpotify, songIdsMPD)
+	}
+	api.validator.songIds = *songIds
+	api.allowAnonymousBids = *anonymousBids
+	if *satsPerCoin <= 0 {
+		log.Fatalf("The price of a coin must be positive, got %d sats", *satsPerCoin)
+	}
+	api.satsPerCoin = *satsPerCoin
+	if *refundInterval <= 0 {
+		log.Fatalf("The refund interval must be positive, got %v", *refundInterval)
+	}
+	api.refunds = refunds.NewEngine(database, *bidMaxAge, *refundInterval)
+	api.operatorToken = *operatorToken
+	api.idempotencyRetention = *idempotencyRetention
 	defer api.database.Close()
+	api.routes()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go api.refunds.Run(ctx)
+	go api.purgeIdempotencyKeys(ctx)
+	if api.operatorToken == "" {
+		log.Println("No operator token configured, the operator endpoints are disabled.")
+	}
 
-	api.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/" {
-			log.Printf("Unhandled path: %v\n", r.URL.Path)
-			http.NotFound(w, r)
-			return
+	if lndConfig.Host != "" {
+		lnd, err := payments.ConnectLND(lndConfig)
+		if err != nil {
+			log.Fatalf("Could not connect to LND: %v", err)
 		}
-		fmt.Fprintf(w, "Welcome to song-bid v1.0!\n")
-	})
-
-	api.mux.HandleFunc(prefix+"/bids", api.HandleBids)
-	api.mux.HandleFunc(prefix+"/player/play", api.HandlePlayerPlay)
-	api.mux.HandleFunc(prefix+"/player/finalize", api.HandlePlayerFinalize)
+		api.payments = lnd
+		go api.watchSettlements(ctx)
+	} else {
+		log.Println("No LND host configured, buying coins is disabled.")
+	}
 
 	// listen to port
 	fmt.Println("Starting the server on 5050.")
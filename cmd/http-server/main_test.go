@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/acidleroy/song-bid/cockroach"
+	"github.com/acidleroy/song-bid/ledger"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	songA = "spotify:track:6ADzlFXHPk846zUCEOM2C1"
+	songB = "spotify:track:1eVnOimXaPos2ua7Rxb7vY"
+)
+
+func newTestApi() *apiHandler {
+	api := NewApiHandler(cockroach.NewMemoryStore())
+	api.passwordCost = bcrypt.MinCost
+	api.allowAnonymousBids = true
+	api.operatorToken = operatorToken
+	api.routes()
+	return api
+}
+
+func doRequest(api *apiHandler, method, path, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	api.mux.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// decodeResponse checks the status and content type of a response and decodes the envelope, with
+// its data decoded into data.
+func decodeResponse(t *testing.T, response *httptest.ResponseRecorder, status int, data interface{}) envelope {
+	t.Helper()
+	if response.Code != status {
+		t.Fatalf("Expected status %d, instead got %d: %s", status, response.Code, response.Body.String())
+	}
+	if contentType := response.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("Expected Content-Type application/json, instead got %q", contentType)
+	}
+
+	body := envelope{Data: data}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to unmarshal response %q: %v", response.Body.String(), err)
+	}
+	return body
+}
+
+func postBidHelper(t *testing.T, api *apiHandler, amount int, songId string) postBidResponse {
+	t.Helper()
+	created := postBidResponse{}
+	response := doRequest(api, http.MethodPost, prefix+"/bids", fmt.Sprintf(`{"BidAmount": %d, "SongId": "%s"}`, amount, songId))
+	decodeResponse(t, response, http.StatusCreated, &created)
+	return created
+}
+
+func TestPostAndGetBids(t *testing.T) {
+	api := newTestApi()
+
+	response := doRequest(api, http.MethodPost, prefix+"/bids", `{"BidAmount": 3, "SongId": "`+songA+`"}`)
+	created := postBidResponse{}
+	decodeResponse(t, response, http.StatusCreated, &created)
+	if location := response.Header().Get("Location"); location != prefix+"/bids/"+created.BidId.String() {
+		t.Fatalf("Expected the Location header to point to the new bid, instead got %q", location)
+	}
+
+	bids := []cockroach.BidRow{}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodGet, prefix+"/bids", ""), http.StatusOK, &bids)
+	if len(bids) != 1 || bids[0].SongId != songA || bids[0].BidAmount != 3 || bids[0].BidId != created.BidId {
+		t.Fatalf("Expected the posted bid to be returned, instead got %v", bids)
+	}
+
+	bid := cockroach.BidRow{}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodGet, response.Header().Get("Location"), ""), http.StatusOK, &bid)
+	if bid.BidId != created.BidId {
+		t.Fatalf("Expected GET on the Location to return the bid, instead got %v", bid)
+	}
+}
+
+func TestGetBidsEmpty(t *testing.T) {
+	api := newTestApi()
+
+	response := doAuthRequest(api, operatorToken, http.MethodGet, prefix+"/bids", "")
+	decodeResponse(t, response, http.StatusOK, nil)
+	if !strings.Contains(response.Body.String(), `"data":[]`) {
+		t.Fatalf("Expected an empty array of bids, instead got %s", response.Body.String())
+	}
+}
+
+func TestGetQueue(t *testing.T) {
+	api := newTestApi()
+	postBidHelper(t, api, 5, songA)
+	postBidHelper(t, api, 2, songB)
+	postBidHelper(t, api, 1, songB)
+
+	queue := cockroach.Queue{}
+	decodeResponse(t, doRequest(api, http.MethodGet, prefix+"/queue", ""), http.StatusOK, &queue)
+	if queue.Playing != nil || len(queue.Songs) != 2 || queue.Songs[0].SongId != songA {
+		t.Fatalf("Expected song-a to lead a queue of 2 songs, instead got %+v", queue)
+	}
+	if second := queue.Songs[1]; second.Rank != 2 || second.TotalAmount != 3 || second.BidCount != 2 || second.AmountToOvertake != 2 {
+		t.Fatalf("Expected song-b to need 2 coins, winning the tie, to overtake song-a, instead got %+v", second)
+	}
+	if second := queue.Songs[1]; queue.Strategy != cockroach.StrategySum || queue.Decay != "none" || second.Score != 3 || second.EffectiveAmount != 3 {
+		t.Fatalf("Expected song-b to score its 3 coins without decay, instead got %+v", queue)
+	}
+
+	doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/play", "")
+	queue = cockroach.Queue{}
+	decodeResponse(t, doRequest(api, http.MethodGet, prefix+"/queue", ""), http.StatusOK, &queue)
+	if queue.Playing == nil || queue.Playing.SongId != songA || len(queue.Songs) != 1 || queue.Songs[0].AmountToOvertake != 0 {
+		t.Fatalf("Expected song-a to be playing ahead of song-b, instead got %+v", queue)
+	}
+}
+
+func TestGetUnknownBid(t *testing.T) {
+	api := newTestApi()
+
+	body := decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodGet, prefix+"/bids/6f1c0a4e-3b9f-4d4e-9b0a-3c8f0f1e2d3c", ""), http.StatusNotFound, nil)
+	if body.Error == nil || body.Error.Code != codeNotFound {
+		t.Fatalf("Expected a not_found error, instead got %+v", body.Error)
+	}
+
+	body = decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodGet, prefix+"/bids/not-a-uuid", ""), http.StatusBadRequest, nil)
+	if body.Error == nil || body.Error.Code != codeInvalidRequest {
+		t.Fatalf("Expected an invalid_request error, instead got %+v", body.Error)
+	}
+}
+
+func TestPlayerPlayAndFinalize(t *testing.T) {
+	api := newTestApi()
+	postBidHelper(t, api, 1, songA)
+	postBidHelper(t, api, 2, songB)
+
+	playing := []cockroach.BidRow{}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/play", ""), http.StatusOK, &playing)
+	if len(playing) != 1 || playing[0].SongId != songB || playing[0].SongStatus != cockroach.Playing {
+		t.Fatalf("Expected song-b to be playing, instead got %v", playing)
+	}
+
+	played := []cockroach.BidRow{}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/finalize", ""), http.StatusOK, &played)
+	if len(played) != 1 || played[0].SongStatus != cockroach.Played {
+		t.Fatalf("Expected song-b to be played, instead got %v", played)
+	}
+}
+
+func TestPlayerPlayEmptyQueue(t *testing.T) {
+	api := newTestApi()
+
+	response := doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/play", "")
+	if response.Code != http.StatusNoContent || response.Body.Len() != 0 {
+		t.Fatalf("Expected an empty 204 when the queue is empty, instead got %d: %s", response.Code, response.Body.String())
+	}
+}
+
+func TestNowPlaying(t *testing.T) {
+	api := newTestApi()
+	body := decodeResponse(t, doRequest(api, http.MethodGet, prefix+"/player/now-playing", ""), http.StatusNotFound, nil)
+	if body.Error == nil || body.Error.Code != codeNotFound {
+		t.Fatalf("Expected not_found before the player reported, instead got %+v", body.Error)
+	}
+
+	postBidHelper(t, api, 2, songA)
+	postBidHelper(t, api, 1, songB)
+	doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/play", "")
+	state := cockroach.PlayerState{}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/state",
+		`{"track": "`+songA+`", "progress_ms": 61000, "duration_ms": 215000, "device": "Living room"}`), http.StatusOK, &state)
+	if state.Room != cockroach.DefaultRoom || state.UpdatedAt.IsZero() {
+		t.Fatalf("Expected the state to be stored for the default room, instead got %+v", state)
+	}
+
+	playing := nowPlayingResponse{}
+	decodeResponse(t, doRequest(api, http.MethodGet, prefix+"/player/now-playing", ""), http.StatusOK, &playing)
+	if playing.Track != songA || playing.ProgressMs != 61000 || playing.Device != "Living room" {
+		t.Fatalf("Expected the reported state, instead got %+v", playing)
+	}
+	if len(playing.Bids) != 1 || playing.Bids[0].SongId != songA || playing.Bids[0].BidAmount != 2 {
+		t.Fatalf("Expected the bid that funded song-a, instead got %v", playing.Bids)
+	}
+	if response := doRequest(api, http.MethodGet, prefix+"/player/now-playing", ""); strings.Contains(response.Body.String(), "UserId") {
+		t.Fatalf("Expected the funding bids to leave out their bidders, instead got %s", response.Body.String())
+	}
+
+	// Tracks that weren't bid on, and other rooms, have no funding bids.
+	doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/state", `{"room": "patio", "track": "spotify:track:filler", "progress_ms": 0, "duration_ms": 0}`)
+	response := doRequest(api, http.MethodGet, prefix+"/player/now-playing?room=patio", "")
+	decodeResponse(t, response, http.StatusOK, nil)
+	if !strings.Contains(response.Body.String(), `"bids":[]`) {
+		t.Fatalf("Expected no bids for the patio, instead got %s", response.Body.String())
+	}
+}
+
+func TestBidLockWindow(t *testing.T) {
+	api := newTestApi()
+	api.database.SetAuctionConfig(cockroach.AuctionConfig{LockWindow: 30 * time.Second})
+
+	// The track ends in 10 seconds, so bids are already locked.
+	playing := nowPlayingResponse{}
+	doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/state", `{"track": "spotify:track:filler", "progress_ms": 50000, "duration_ms": 60000}`)
+	decodeResponse(t, doRequest(api, http.MethodGet, prefix+"/player/now-playing", ""), http.StatusOK, &playing)
+	if playing.BidCutoff == nil || !playing.BidCutoff.Equal(playing.UpdatedAt.Add(-20*time.Second)) {
+		t.Fatalf("Expected bids to lock 30 seconds before the track ends, instead got %+v", playing)
+	}
+
+	postBidHelper(t, api, 1, songA)
+	queue := cockroach.Queue{}
+	decodeResponse(t, doRequest(api, http.MethodGet, prefix+"/queue", ""), http.StatusOK, &queue)
+	if len(queue.Songs) != 0 || len(queue.Deferred) != 1 || queue.Deferred[0].SongId != songA {
+		t.Fatalf("Expected the bid on song-a to be deferred, instead got %+v", queue)
+	}
+	if response := doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/play", ""); response.Code != http.StatusNoContent {
+		t.Fatalf("Expected nothing to play in the locked round, instead got %d: %s", response.Code, response.Body.String())
+	}
+	played := []cockroach.BidRow{}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/play", ""), http.StatusOK, &played)
+	if len(played) != 1 || played[0].SongId != songA {
+		t.Fatalf("Expected song-a to play in the round after, instead got %v", played)
+	}
+}
+
+func TestInvalidPlayerState(t *testing.T) {
+	api := newTestApi()
+
+	for _, state := range []string{
+		`{"progress_ms": 0, "duration_ms": 1000}`,
+		`{"track": "song", "progress_ms": -1, "duration_ms": 1000}`,
+		`{"track": "song", "progress_ms": 2000, "duration_ms": 1000}`,
+		`{"track": "song", "room": "` + strings.Repeat("r", 65) + `"}`,
+	} {
+		body := decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/state", state), http.StatusUnprocessableEntity, nil)
+		if body.Error == nil || len(body.Error.Details) != 1 {
+			t.Fatalf("Expected one invalid field for %s, instead got %+v", state, body.Error)
+		}
+	}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/state", "not json"), http.StatusBadRequest, nil)
+}
+
+func TestStoreErrorStatusCodes(t *testing.T) {
+	for err, status := range map[error]int{
+		cockroach.ErrInvalidBid:         http.StatusBadRequest,
+		cockroach.ErrBidNotFound:        http.StatusNotFound,
+		cockroach.ErrSongAlreadyPlaying: http.StatusConflict,
+		cockroach.ErrUserExists:         http.StatusConflict,
+		ledger.ErrInsufficientFunds:     http.StatusPaymentRequired,
+		cockroach.ErrStoreUnavailable:   http.StatusServiceUnavailable,
+		fmt.Errorf("unexpected"):        http.StatusInternalServerError,
+	} {
+		recorder := httptest.NewRecorder()
+		writeStoreError(recorder, fmt.Errorf("post bid: %w", err))
+		body := decodeResponse(t, recorder, status, nil)
+		if body.Error == nil || body.Error.Code == "" || body.Error.Message == "" {
+			t.Fatalf("Expected an error code and message for %v, instead got %+v", err, body.Error)
+		}
+	}
+}
+
+func TestPlayerStateConflicts(t *testing.T) {
+	api := newTestApi()
+	postBidHelper(t, api, 1, songA)
+	postBidHelper(t, api, 2, songB)
+
+	body := decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/finalize", ""), http.StatusConflict, nil)
+	if body.Error.Code != codeNoSongPlaying {
+		t.Fatalf("Expected no_song_playing when finalizing with nothing playing, instead got %v", body.Error.Code)
+	}
+
+	doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/play", "")
+	body = decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/play", ""), http.StatusConflict, nil)
+	if body.Error.Code != codeSongAlreadyPlaying {
+		t.Fatalf("Expected song_already_playing when playing twice, instead got %v", body.Error.Code)
+	}
+
+	skipped := []cockroach.BidRow{}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/skip", ""), http.StatusOK, &skipped)
+	if len(skipped) != 1 || skipped[0].SongId != songB || skipped[0].SongStatus != cockroach.Refunded || skipped[0].RefundReason != cockroach.ReasonSkipped {
+		t.Fatalf("Expected song-b to be skipped and refunded, instead got %v", skipped)
+	}
+}
+
+func TestGetBidsByStatus(t *testing.T) {
+	api := newTestApi()
+	postBidHelper(t, api, 1, songA)
+	postBidHelper(t, api, 2, songB)
+	doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/play", "")
+
+	response := doAuthRequest(api, operatorToken, http.MethodGet, prefix+"/bids?status=playing", "")
+	if !strings.Contains(response.Body.String(), `"SongStatus":"playing"`) {
+		t.Fatalf("Expected the song status to be encoded by name, instead got %s", response.Body.String())
+	}
+	bids := []cockroach.BidRow{}
+	decodeResponse(t, response, http.StatusOK, &bids)
+	if len(bids) != 1 || bids[0].SongId != songB {
+		t.Fatalf("Expected only the playing bid, instead got %v", bids)
+	}
+
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodGet, prefix+"/bids?status=paused", ""), http.StatusBadRequest, nil)
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	api := newTestApi()
+
+	testTable := []struct {
+		Method, Path, Allow string
+	}{
+		{http.MethodDelete, prefix + "/bids", "GET, POST"},
+		{http.MethodGet, prefix + "/player/play", "PUT"},
+		{http.MethodPost, prefix + "/player/finalize", "PUT"},
+	}
+
+	for _, test := range testTable {
+		response := doRequest(api, test.Method, test.Path, "")
+		body := decodeResponse(t, response, http.StatusMethodNotAllowed, nil)
+		if allow := response.Header().Get("Allow"); allow != test.Allow {
+			t.Fatalf("Expected %v %v to allow %q, instead got %q", test.Method, test.Path, test.Allow, allow)
+		}
+		if body.Error.Code != codeMethodNotAllowed {
+			t.Fatalf("Expected method_not_allowed, instead got %v", body.Error.Code)
+		}
+	}
+}
+
+func TestUnknownPath(t *testing.T) {
+	api := newTestApi()
+
+	decodeResponse(t, doRequest(api, http.MethodGet, "/", ""), http.StatusOK, nil)
+	body := decodeResponse(t, doRequest(api, http.MethodGet, "/api/v2/bids", ""), http.StatusNotFound, nil)
+	if body.Error.Code != codeNotFound {
+		t.Fatalf("Expected not_found, instead got %v", body.Error.Code)
+	}
+}
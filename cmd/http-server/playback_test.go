@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	client "github.com/acidleroy/song-bid/client/http-client"
+	"github.com/acidleroy/song-bid/cockroach"
+	"github.com/acidleroy/song-bid/player"
+)
+
+// TestBidToPlayback plays the queue on the fake player through the api, from placing the bids to
+// the songs being played.
+func TestBidToPlayback(t *testing.T) {
+	api := newTestApi()
+	server := httptest.NewServer(api.mux)
+	defer server.Close()
+
+	fake := player.NewFake()
+	jukebox := player.NewJukebox(client.NewApi(server.Client(), server.URL+prefix+"/", time.Second).WithToken(operatorToken), fake, nil, cockroach.DefaultRoom)
+	step := func() {
+		t.Helper()
+		if err := jukebox.Step(context.Background()); err != nil {
+			t.Fatalf("Failed to step the jukebox: %v", err)
+		}
+	}
+	songStatus := func(songId string) cockroach.SongStatus {
+		t.Helper()
+		bids := []cockroach.BidRow{}
+		decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodGet, prefix+"/bids", ""), http.StatusOK, &bids)
+		for _, bid := range bids {
+			if bid.SongId == songId {
+				return bid.SongStatus
+			}
+		}
+		t.Fatalf("Expected a bid on %v, instead got %v", songId, bids)
+		return 0
+	}
+
+	postBidHelper(t, api, 1, songA)
+	postBidHelper(t, api, 2, songB)
+	fake.SetDuration(songB, time.Minute)
+
+	step()
+	fake.Advance(10 * time.Second)
+	step()
+	playing := nowPlayingResponse{}
+	decodeResponse(t, doRequest(api, http.MethodGet, prefix+"/player/now-playing", ""), http.StatusOK, &playing)
+	if playing.Track != songB || playing.ProgressMs != 10000 || len(playing.Bids) != 1 {
+		t.Fatalf("Expected song-b to be reported playing with its bid, instead got %+v", playing)
+	}
+
+	fake.Advance(time.Minute)
+	step()
+	if songStatus(songB) != cockroach.Played || songStatus(songA) != cockroach.Playing {
+		t.Fatalf("Expected song-b to be played and song-a to be playing next, instead played %v", fake.Played())
+	}
+
+	fake.Advance(10 * time.Second)
+	step()
+	fake.Advance(player.DefaultFakeDuration)
+	step()
+	if songStatus(songA) != cockroach.Played {
+		t.Fatalf("Expected song-a to be played once it ended")
+	}
+	if played := fake.Played(); len(played) != 2 || played[0] != songB || played[1] != songA {
+		t.Fatalf("Expected song-b then song-a to be played, instead played %v", played)
+	}
+}
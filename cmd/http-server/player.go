@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/acidleroy/song-bid/cockroach"
+)
+
+// HandlePlayerPlay starts the song with the highest bid and returns its bids. It answers 204 when
+// there is nothing queued. Only the operator, whose token the music server sends, may do this.
+func (p *apiHandler) HandlePlayerPlay(w http.ResponseWriter, r *http.Request) {
+	if !p.requireOperator(w, r) {
+		return
+	}
+	log.Println("player/play")
+
+	next, err := p.database.PlayNextSong()
+	if errors.Is(err, cockroach.ErrNoSongQueued) {
+		log.Println("There are no songs to play!")
+		writeNoContent(w)
+		return
+	}
+	if err != nil {
+		log.Printf("Error playing next song: %v\n", err)
+		writeStoreError(w, err)
+		return
+	}
+
+	log.Printf("Playing next song: %v", next[0].SongId)
+	writeData(w, http.StatusOK, next)
+}
+
+func (p *apiHandler) HandlePlayerFinalize(w http.ResponseWriter, r *http.Request) {
+	p.handleFinishSong(w, r, "finalize", p.database.FinalizeCurrentSong)
+}
+
+// HandlePlayerSkip skips the playing song and refunds its bids, since the song didn't play out. When
+// the refund fails the bids stay skipped and the operator can refund them with POST /refunds.
+func (p *apiHandler) HandlePlayerSkip(w http.ResponseWriter, r *http.Request) {
+	p.handleFinishSong(w, r, "skip", func() ([]cockroach.BidRow, error) {
+		skipped, err := p.database.SkipCurrentSong()
+		if err != nil {
+			return nil, err
+		}
+		refunded, err := p.refunds.RefundSkipped(skipped)
+		if err != nil {
+			log.Printf("Failed to refund the skipped bids of %v: %v", skipped[0].SongId, err)
+			return skipped, nil
+		}
+		return refunded, nil
+	})
+}
+
+// handleFinishSong ends the playing song with finish, which either finalizes or skips it. Only the
+// operator may do this.
+func (p *apiHandler) handleFinishSong(w http.ResponseWriter, r *http.Request, name string, finish func() ([]cockroach.BidRow, error)) {
+	if !p.requireOperator(w, r) {
+		return
+	}
+	log.Printf("player/%s", name)
+
+	finished, err := finish()
+	if err != nil {
+		log.Printf("Error running %s on the current song: %v\n", name, err)
+		writeStoreError(w, err)
+		return
+	}
+
+	log.Printf("Ran %s on this song: %v", name, finished[0].SongId)
+	writeData(w, http.StatusOK, finished)
+}
+
+const (
+	// maxPlayerFieldLength is the longest track or device name a player may report.
+	maxPlayerFieldLength int = 255
+	// maxRoomLength is the longest room name accepted.
+	maxRoomLength int = 64
+)
+
+// nowPlayingResponse is the state reported by the player of a room with the bids that paid for
+// the track, which is empty when the track wasn't bid on.
+type nowPlayingResponse struct {
+	cockroach.PlayerState
+	Bids []cockroach.PublicBid `json:"bids"`
+}
+
+// HandlePutPlayerState stores the track, progress and device the player of a room reports. The
+// room defaults to cockroach.DefaultRoom. Only the operator may report it, since the progress decides
+// which bids are deferred.
+func (p *apiHandler) HandlePutPlayerState(w http.ResponseWriter, r *http.Request) {
+	if !p.requireOperator(w, r) {
+		return
+	}
+	buf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Could not read the request body")
+		return
+	}
+	state := cockroach.PlayerState{}
+	if err := json.Unmarshal(buf, &state); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest,
+			fmt.Sprintf(`Invalid JSON request, expecting: {"track": string, "progress_ms": int, "duration_ms": int, "device": string}: %v`, err))
+		return
+	}
+	if state.Room == "" {
+		state.Room = cockroach.DefaultRoom
+	}
+	if errors := validatePlayerState(state); len(errors) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, envelope{Error: &apiError{
+			Code:    codeInvalidRequest,
+			Message: "Invalid player state",
+			Details: errors,
+		}})
+		return
+	}
+
+	stored, err := p.database.SetPlayerState(state)
+	if err != nil {
+		log.Printf("Failed to store the player state of %v: %v", state.Room, err)
+		writeStoreError(w, err)
+		return
+	}
+	writeData(w, http.StatusOK, stored)
+}
+
+// HandleGetNowPlaying returns what the player of a room, ?room= or cockroach.DefaultRoom, last
+// reported, with the bids that funded the track. Anyone may ask, so the bids leave out who placed
+// them.
+func (p *apiHandler) HandleGetNowPlaying(w http.ResponseWriter, r *http.Request) {
+	room := r.URL.Query().Get("room")
+	if room == "" {
+		room = cockroach.DefaultRoom
+	}
+
+	state, err := p.database.GetPlayerState(room)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	playing, err := p.database.GetPlayingBids()
+	if err != nil {
+		log.Printf("Failed to get the bids of %v: %v", state.Track, err)
+		writeStoreError(w, err)
+		return
+	}
+
+	funding := []cockroach.PublicBid{}
+	for _, bid := range playing {
+		if bid.SongId == state.Track {
+			funding = append(funding, bid.Public())
+		}
+	}
+	writeData(w, http.StatusOK, nowPlayingResponse{PlayerState: state, Bids: funding})
+}
+
+// validatePlayerState returns every problem with a reported player state.
+func validatePlayerState(state cockroach.PlayerState) []fieldError {
+	errors := []fieldError{}
+
+	if state.Track == "" || len(state.Track) > maxPlayerFieldLength {
+		errors = append(errors, fieldError{"track", fmt.Sprintf("must be between 1 and %d characters", maxPlayerFieldLength)})
+	}
+	if state.DurationMs < 0 {
+		errors = append(errors, fieldError{"duration_ms", "must not be negative"})
+	}
+	if state.ProgressMs < 0 {
+		errors = append(errors, fieldError{"progress_ms", "must not be negative"})
+	} else if state.DurationMs > 0 && state.ProgressMs > state.DurationMs {
+		errors = append(errors, fieldError{"progress_ms", "must not be past duration_ms"})
+	}
+	if len(state.Device) > maxPlayerFieldLength {
+		errors = append(errors, fieldError{"device", fmt.Sprintf("must not be longer than %d characters", maxPlayerFieldLength)})
+	}
+	if len(state.Room) > maxRoomLength {
+		errors = append(errors, fieldError{"room", fmt.Sprintf("must not be longer than %d characters", maxRoomLength)})
+	}
+	return errors
+}
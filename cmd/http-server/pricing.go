@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/acidleroy/song-bid/pricing"
+)
+
+// violationErrors returns the pricing violations as the details of an error response.
+func violationErrors(violations []pricing.Violation) []fieldError {
+	errors := make([]fieldError, len(violations))
+	for i, v := range violations {
+		errors[i] = fieldError{v.Field, v.Message}
+	}
+	return errors
+}
+
+// pricingRoom returns the room of a /pricing/{room} request. It writes a 404 response and returns
+// false when the room is missing or too long to exist.
+func pricingRoom(w http.ResponseWriter, r *http.Request) (string, bool) {
+	room := strings.TrimPrefix(r.URL.Path, prefix+"/pricing/")
+	if room == "" || strings.Contains(room, "/") || len(room) > maxRoomLength {
+		writeError(w, http.StatusNotFound, codeNotFound, "Expected /pricing/{room}")
+		return "", false
+	}
+	return room, true
+}
+
+// HandleGetPricing returns the pricing rules of a room, addressed as /pricing/{room}. Bids are
+// placed under the rules of cockroach.DefaultRoom, so other rooms are 404.
+func (p *apiHandler) HandleGetPricing(w http.ResponseWriter, r *http.Request) {
+	room, ok := pricingRoom(w, r)
+	if !ok {
+		return
+	}
+
+	rules, err := p.database.GetPricingRules(room)
+	if err != nil {
+		log.Printf("Failed to get the pricing rules of %v: %v", room, err)
+		writeStoreError(w, err)
+		return
+	}
+	writeData(w, http.StatusOK, rules)
+}
+
+// HandlePutPricing replaces the pricing rules of a room, addressed as /pricing/{room}, and returns
+// them. Only the operator may set them.
+func (p *apiHandler) HandlePutPricing(w http.ResponseWriter, r *http.Request) {
+	if !p.requireOperator(w, r) {
+		return
+	}
+	room, ok := pricingRoom(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	buf, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		log.Printf("Failed to read body: %s", err)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Could not read the request body")
+		return
+	}
+	rules := pricing.Rules{}
+	if err := json.Unmarshal(buf, &rules); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf(
+			`Invalid JSON request, expecting: {"MinBid": int, "MinIncrement": int, "SongReserves": {string: int}, "ArtistReserves": {string: int}}: %v`, err))
+		return
+	}
+	if violations := rules.Validate(); len(violations) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, envelope{Error: &apiError{
+			Code:    codeInvalidRequest,
+			Message: "Invalid pricing rules",
+			Details: violationErrors(violations),
+		}})
+		return
+	}
+
+	if err := p.database.SetPricingRules(room, rules); err != nil {
+		log.Printf("Failed to set the pricing rules of %v: %v", room, err)
+		writeStoreError(w, err)
+		return
+	}
+	log.Printf("Set the pricing rules of %v to %+v", room, rules)
+	writeData(w, http.StatusOK, rules.Clone())
+}
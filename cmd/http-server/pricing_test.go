@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/acidleroy/song-bid/cockroach"
+	"github.com/acidleroy/song-bid/pricing"
+)
+
+func TestPricingRules(t *testing.T) {
+	api := newTestApi()
+	path := prefix + "/pricing/" + cockroach.DefaultRoom
+
+	rules := pricing.Rules{}
+	decodeResponse(t, doRequest(api, http.MethodGet, path, ""), http.StatusOK, &rules)
+	if rules.MinBid != 0 || rules.SongReserves == nil {
+		t.Fatalf("Expected no rules with empty reserves, instead got %+v", rules)
+	}
+
+	body := `{"MinBid": 2, "MinIncrement": 3, "SongReserves": {"` + songB + `": 10}}`
+	decodeResponse(t, doRequest(api, http.MethodPut, path, body), http.StatusUnauthorized, nil)
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, path, `{"MinBid": -1, "ArtistReserves": {"": 5}}`), http.StatusUnprocessableEntity, nil)
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/pricing/", body), http.StatusNotFound, nil)
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/pricing/lounge", body), http.StatusNotFound, nil)
+	decodeResponse(t, doRequest(api, http.MethodGet, prefix+"/pricing/lounge", ""), http.StatusNotFound, nil)
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, path, body), http.StatusOK, &rules)
+	if rules.MinIncrement != 3 || rules.SongReserves[songB] != 10 || rules.ArtistReserves == nil {
+		t.Fatalf("Expected the rules to be stored, instead got %+v", rules)
+	}
+
+	// A bid below the minimum, and one that passes song A by less than the increment.
+	postBidHelper(t, api, 5, songA)
+	for _, bid := range []string{
+		`{"BidAmount": 1, "SongId": "` + songB + `"}`,
+		`{"BidAmount": 6, "SongId": "` + songB + `"}`,
+	} {
+		body := decodeResponse(t, doRequest(api, http.MethodPost, prefix+"/bids", bid), http.StatusUnprocessableEntity, nil)
+		if body.Error.Code != codeInvalidBid || len(body.Error.Details) != 1 || body.Error.Details[0].Field != "BidAmount" {
+			t.Fatalf("Expected the bid to be rejected on BidAmount, instead got %+v", body.Error)
+		}
+	}
+
+	// song B is below its reserve, so it can't play before reaching it.
+	postBidHelper(t, api, 4, songB)
+	queue := cockroach.Queue{}
+	decodeResponse(t, doRequest(api, http.MethodGet, prefix+"/queue", ""), http.StatusOK, &queue)
+	if len(queue.Songs) != 1 || queue.Songs[0].SongId != songA || len(queue.Ineligible) != 1 || queue.Ineligible[0].Reason != cockroach.IneligibleBelowReserve {
+		t.Fatalf("Expected song B to be below its reserve, instead got %+v", queue)
+	}
+}
+
+func TestSongArtists(t *testing.T) {
+	api := newTestApi()
+	path := prefix + "/song-artists/" + songB
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/pricing/"+cockroach.DefaultRoom,
+		`{"ArtistReserves": {"Daft Punk": 10}}`), http.StatusOK, nil)
+
+	decodeResponse(t, doRequest(api, http.MethodPut, path, `{"Artist": "Daft Punk"}`), http.StatusUnauthorized, nil)
+	body := decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/song-artists/not-a-song", `{"Artist": " "}`), http.StatusUnprocessableEntity, nil)
+	if len(body.Error.Details) != 2 || body.Error.Details[0].Field != "SongId" || body.Error.Details[1].Field != "Artist" {
+		t.Fatalf("Expected the song and the artist to be rejected, instead got %+v", body.Error)
+	}
+	artist := songArtistResponse{}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, path, `{"Artist": "Daft Punk"}`), http.StatusOK, &artist)
+	if artist.SongId != songB || artist.Artist != "Daft Punk" {
+		t.Fatalf("Expected the artist of song B to be set, instead got %+v", artist)
+	}
+	artists := map[string]string{}
+	decodeResponse(t, doRequest(api, http.MethodGet, prefix+"/song-artists", ""), http.StatusOK, &artists)
+	if len(artists) != 1 || artists[songB] != "Daft Punk" {
+		t.Fatalf("Expected the artist of song B to be listed, instead got %v", artists)
+	}
+
+	// Bids can't name an artist of their own, so song B is held back by the reserve of its artist.
+	postBidHelper(t, api, 5, songB)
+	queue := cockroach.Queue{}
+	decodeResponse(t, doRequest(api, http.MethodGet, prefix+"/queue", ""), http.StatusOK, &queue)
+	if len(queue.Songs) != 0 || len(queue.Ineligible) != 1 || queue.Ineligible[0].Reserve != 10 {
+		t.Fatalf("Expected song B to be below the reserve of its artist, instead got %+v", queue)
+	}
+
+	decodeResponse(t, doRequest(api, http.MethodDelete, path, ""), http.StatusUnauthorized, nil)
+	if response := doAuthRequest(api, operatorToken, http.MethodDelete, path, ""); response.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 clearing the artist, instead got %d: %s", response.Code, response.Body.String())
+	}
+	decodeResponse(t, doRequest(api, http.MethodGet, prefix+"/queue", ""), http.StatusOK, &queue)
+	if len(queue.Songs) != 1 || queue.Songs[0].SongId != songB {
+		t.Fatalf("Expected song B to be ranked once its artist is cleared, instead got %+v", queue)
+	}
+}
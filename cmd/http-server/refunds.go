@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/acidleroy/song-bid/cockroach"
+	"github.com/acidleroy/song-bid/ledger"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultBidMaxAge is how long a bid may stay queued before it is refunded unless -bid-max-age
+	// says otherwise.
+	defaultBidMaxAge time.Duration = 6 * time.Hour
+	// defaultRefundInterval is how often expired bids are looked for.
+	defaultRefundInterval time.Duration = time.Minute
+)
+
+// bidStatusResponse is a bid with the ledger transaction that refunded it, if it was refunded.
+type bidStatusResponse struct {
+	cockroach.BidRow
+	Refund *ledger.Transaction `json:",omitempty"`
+}
+
+// banSongRequest is the body of POST /banned-songs.
+type banSongRequest struct {
+	SongId string
+}
+
+// refundBidsRequest is the body of POST /refunds.
+type refundBidsRequest struct {
+	BidIds []uuid.UUID
+}
+
+// maxRefundBids is the most bids a single POST /refunds may refund.
+const maxRefundBids int = 100
+
+// HandleEndSession refunds every queued bid and returns them. Only the operator may end a session.
+func (p *apiHandler) HandleEndSession(w http.ResponseWriter, r *http.Request) {
+	if !p.requireOperator(w, r) {
+		return
+	}
+
+	refunded, err := p.refunds.EndSession()
+	if err != nil {
+		log.Printf("Failed to end the session: %v", err)
+		writeStoreError(w, err)
+		return
+	}
+	writeData(w, http.StatusOK, nonNilRows(refunded))
+}
+
+// HandleRefundBids refunds queued or skipped bids by hand, e.g. those of a song that was skipped,
+// and returns them. Only the operator may refund bids, and nothing is refunded if any of them can't
+// be.
+func (p *apiHandler) HandleRefundBids(w http.ResponseWriter, r *http.Request) {
+	if !p.requireOperator(w, r) {
+		return
+	}
+
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	buf, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		log.Printf("Failed to read body: %s", err)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Could not read the request body")
+		return
+	}
+	request := refundBidsRequest{}
+	if err := json.Unmarshal(buf, &request); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf(`Invalid JSON request, expecting: {"BidIds": [string]}: %v`, err))
+		return
+	}
+	if len(request.BidIds) == 0 || len(request.BidIds) > maxRefundBids {
+		writeJSON(w, http.StatusUnprocessableEntity, envelope{Error: &apiError{
+			Code:    codeInvalidRequest,
+			Message: "Invalid refund",
+			Details: []fieldError{{"BidIds", fmt.Sprintf("must list 1 to %d bids", maxRefundBids)}},
+		}})
+		return
+	}
+
+	refunded, err := p.refunds.Refund(request.BidIds)
+	if err != nil {
+		log.Printf("Failed to refund bids %v: %v", request.BidIds, err)
+		writeStoreError(w, err)
+		return
+	}
+	writeData(w, http.StatusOK, nonNilRows(refunded))
+}
+
+// HandleGetBannedSongs returns the songs that can't be bid on.
+func (p *apiHandler) HandleGetBannedSongs(w http.ResponseWriter, r *http.Request) {
+	songs, err := p.database.GetBannedSongs()
+	if err != nil {
+		log.Printf("Failed to get the banned songs: %v", err)
+		writeStoreError(w, err)
+		return
+	}
+	writeData(w, http.StatusOK, songs)
+}
+
+// HandleBanSong bans a song and returns the bids on it that were refunded.
+func (p *apiHandler) HandleBanSong(w http.ResponseWriter, r *http.Request) {
+	if !p.requireOperator(w, r) {
+		return
+	}
+
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	buf, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		log.Printf("Failed to read body: %s", err)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Could not read the request body")
+		return
+	}
+	request := banSongRequest{}
+	if err := json.Unmarshal(buf, &request); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf(`Invalid JSON request, expecting: {"SongId": string}: %v`, err))
+		return
+	}
+	if problem := p.validator.songIdError(request.SongId); problem != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, envelope{Error: &apiError{
+			Code:    codeInvalidRequest,
+			Message: "Invalid song",
+			Details: []fieldError{*problem},
+		}})
+		return
+	}
+
+	refunded, err := p.refunds.BanSong(request.SongId)
+	if err != nil {
+		log.Printf("Failed to ban song %v: %v", request.SongId, err)
+		writeStoreError(w, err)
+		return
+	}
+	log.Printf("Banned song %v", request.SongId)
+	writeData(w, http.StatusOK, nonNilRows(refunded))
+}
+
+// HandleUnbanSong accepts bids on a song again, addressed as /banned-songs/{songId}.
+func (p *apiHandler) HandleUnbanSong(w http.ResponseWriter, r *http.Request) {
+	if !p.requireOperator(w, r) {
+		return
+	}
+
+	songId := strings.TrimPrefix(r.URL.Path, prefix+"/banned-songs/")
+	if err := p.database.UnbanSong(songId); err != nil {
+		log.Printf("Failed to unban song %v: %v", songId, err)
+		writeStoreError(w, err)
+		return
+	}
+	log.Printf("Unbanned song %v", songId)
+	writeNoContent(w)
+}
+
+// bidStatus returns the bid with its refund, if it has one.
+func (p *apiHandler) bidStatus(bid cockroach.BidRow) (bidStatusResponse, error) {
+	status := bidStatusResponse{BidRow: bid}
+	if bid.RefundReason == "" || !bid.UserId.Valid {
+		return status, nil
+	}
+	refund, err := p.database.GetBidRefund(bid.BidId)
+	if errors.Is(err, ledger.ErrTransactionNotFound) {
+		return status, nil
+	}
+	if err != nil {
+		return status, err
+	}
+	status.Refund = &refund
+	return status, nil
+}
+
+// nonNilRows makes sure an empty list of bids is encoded as [] rather than null.
+func nonNilRows(rows []cockroach.BidRow) []cockroach.BidRow {
+	if rows == nil {
+		return []cockroach.BidRow{}
+	}
+	return rows
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/acidleroy/song-bid/cockroach"
+	"github.com/google/uuid"
+)
+
+const operatorToken = "operator-secret"
+
+func TestOperatorEndpointsNeedTheOperatorToken(t *testing.T) {
+	api := newTestApi()
+	alice := loginHelper(t, api, "alice")
+
+	api.operatorToken = ""
+	for _, check := range []struct {
+		response *httptest.ResponseRecorder
+		status   int
+		code     string
+	}{
+		{doRequest(api, http.MethodPost, prefix+"/session/end", ""), http.StatusUnauthorized, codeUnauthorized},
+		{doAuthRequest(api, operatorToken, http.MethodPost, prefix+"/session/end", ""), http.StatusForbidden, codeForbidden},
+	} {
+		body := decodeResponse(t, check.response, check.status, nil)
+		if body.Error.Code != check.code {
+			t.Fatalf("Expected %v without an operator token configured, instead got %v", check.code, body.Error.Code)
+		}
+	}
+
+	api.operatorToken = operatorToken
+	for _, token := range []string{alice.Token, "wrong-secret"} {
+		body := decodeResponse(t, doAuthRequest(api, token, http.MethodPost, prefix+"/banned-songs", `{"SongId": "`+songA+`"}`), http.StatusForbidden, nil)
+		if body.Error.Code != codeForbidden {
+			t.Fatalf("Expected forbidden for a token that isn't the operator's, instead got %v", body.Error.Code)
+		}
+	}
+
+	// Only the music server, with the operator token, may drive the player.
+	state := `{"track": "` + songA + `", "progress_ms": 0, "duration_ms": 60000}`
+	for _, path := range []string{"/player/play", "/player/finalize", "/player/skip", "/player/state"} {
+		decodeResponse(t, doRequest(api, http.MethodPut, prefix+path, state), http.StatusUnauthorized, nil)
+		decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodPut, prefix+path, state), http.StatusForbidden, nil)
+	}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPost, prefix+"/session/end", ""), http.StatusOK, nil)
+}
+
+func TestBanSongRefundsItsBids(t *testing.T) {
+	api := newTestApi()
+	alice := loginHelper(t, api, "alice")
+
+	posted := postBidResponse{}
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodPost, prefix+"/bids", `{"BidAmount": 30, "SongId": "`+songA+`"}`), http.StatusCreated, &posted)
+
+	refunded := []cockroach.BidRow{}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPost, prefix+"/banned-songs", `{"SongId": "`+songA+`"}`), http.StatusOK, &refunded)
+	if len(refunded) != 1 || refunded[0].BidId != posted.BidId {
+		t.Fatalf("Expected the bid to be refunded, instead got %v", refunded)
+	}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPost, prefix+"/banned-songs", `{"SongId": "song-a"}`), http.StatusUnprocessableEntity, nil)
+
+	status := bidStatusResponse{}
+	bidPath := prefix + "/bids/" + posted.BidId.String()
+	decodeResponse(t, doRequest(api, http.MethodGet, bidPath, ""), http.StatusUnauthorized, nil)
+	decodeResponse(t, doAuthRequest(api, loginHelper(t, api, "bob").Token, http.MethodGet, bidPath, ""), http.StatusForbidden, nil)
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodGet, bidPath, ""), http.StatusOK, &status)
+	if status.SongStatus != cockroach.Refunded || status.RefundReason != cockroach.ReasonSongBanned || status.Refund == nil {
+		t.Fatalf("Expected the bid to be refunded with its transaction, instead got %+v", status)
+	}
+	balance := balanceResponse{}
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodGet, prefix+"/coins/balance", ""), http.StatusOK, &balance)
+	if balance.Balance != 100 {
+		t.Fatalf("Expected the 30 coins back, instead the balance is %d", balance.Balance)
+	}
+
+	body := decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodPost, prefix+"/bids", `{"BidAmount": 1, "SongId": "`+songA+`"}`), http.StatusUnprocessableEntity, nil)
+	if body.Error.Code != codeSongBanned {
+		t.Fatalf("Expected song_banned for a bid on a banned song, instead got %v", body.Error.Code)
+	}
+	banned := []string{}
+	decodeResponse(t, doRequest(api, http.MethodGet, prefix+"/banned-songs", ""), http.StatusOK, &banned)
+	if len(banned) != 1 || banned[0] != songA {
+		t.Fatalf("Expected %v to be banned, instead got %v", songA, banned)
+	}
+
+	response := doAuthRequest(api, operatorToken, http.MethodDelete, prefix+"/banned-songs/"+songA, "")
+	if response.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 when unbanning, instead got %d: %s", response.Code, response.Body.String())
+	}
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodPost, prefix+"/bids", `{"BidAmount": 1, "SongId": "`+songA+`"}`), http.StatusCreated, nil)
+}
+
+func TestEndSessionRefundsQueuedBids(t *testing.T) {
+	api := newTestApi()
+	alice := loginHelper(t, api, "alice")
+
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodPost, prefix+"/bids", `{"BidAmount": 30, "SongId": "`+songA+`"}`), http.StatusCreated, nil)
+	decodeResponse(t, doRequest(api, http.MethodPost, prefix+"/bids", `{"BidAmount": 5, "SongId": "`+songB+`"}`), http.StatusCreated, nil)
+
+	refunded := []cockroach.BidRow{}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPost, prefix+"/session/end", ""), http.StatusOK, &refunded)
+	if len(refunded) != 2 {
+		t.Fatalf("Expected both bids to be refunded, instead got %v", refunded)
+	}
+	for _, row := range refunded {
+		status := bidStatusResponse{}
+		decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodGet, prefix+"/bids/"+row.BidId.String(), ""), http.StatusOK, &status)
+		if status.RefundReason != cockroach.ReasonSessionEnded || (status.Refund != nil) != row.UserId.Valid {
+			t.Fatalf("Expected a session_ended refund with a transaction for paid bids only, instead got %+v", status)
+		}
+	}
+
+	refunded = []cockroach.BidRow{}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPost, prefix+"/session/end", ""), http.StatusOK, &refunded)
+	if len(refunded) != 0 {
+		t.Fatalf("Expected nothing left to refund, instead got %v", refunded)
+	}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodGet, prefix+"/bids/"+uuid.New().String(), ""), http.StatusNotFound, nil)
+}
+
+func TestSkipRefundsTheBids(t *testing.T) {
+	api := newTestApi()
+	alice := loginHelper(t, api, "alice")
+
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodPost, prefix+"/bids", `{"BidAmount": 30, "SongId": "`+songA+`"}`), http.StatusCreated, nil)
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/play", ""), http.StatusOK, nil)
+	refunded := []cockroach.BidRow{}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/skip", ""), http.StatusOK, &refunded)
+	if len(refunded) != 1 || refunded[0].SongStatus != cockroach.Refunded || refunded[0].RefundReason != cockroach.ReasonSkipped {
+		t.Fatalf("Expected the bid of the skipped song to be refunded, instead got %+v", refunded)
+	}
+
+	balance := balanceResponse{}
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodGet, prefix+"/coins/balance", ""), http.StatusOK, &balance)
+	if balance.Balance != 100 {
+		t.Fatalf("Expected alice to have all 100 coins back, instead got %d", balance.Balance)
+	}
+}
+
+func TestRefundSkippedBids(t *testing.T) {
+	api := newTestApi()
+	alice := loginHelper(t, api, "alice")
+
+	skipped := postBidResponse{}
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodPost, prefix+"/bids", `{"BidAmount": 30, "SongId": "`+songA+`"}`), http.StatusCreated, &skipped)
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/play", ""), http.StatusOK, nil)
+	// A bid whose refund failed when its song was skipped stays skipped for the operator to refund.
+	if _, err := api.database.SkipCurrentSong(); err != nil {
+		t.Fatalf("Failed to skip: %v", err)
+	}
+
+	body := `{"BidIds": ["` + skipped.BidId.String() + `"]}`
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodPost, prefix+"/refunds", body), http.StatusForbidden, nil)
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPost, prefix+"/refunds", `{"BidIds": []}`), http.StatusUnprocessableEntity, nil)
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPost, prefix+"/refunds", `{"BidIds": ["`+uuid.New().String()+`"]}`), http.StatusNotFound, nil)
+
+	refunded := []cockroach.BidRow{}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPost, prefix+"/refunds", body), http.StatusOK, &refunded)
+	if len(refunded) != 1 || refunded[0].SongStatus != cockroach.Refunded || refunded[0].RefundReason != cockroach.ReasonOperator {
+		t.Fatalf("Expected the skipped bid to be refunded by the operator, instead got %+v", refunded)
+	}
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPost, prefix+"/refunds", body), http.StatusConflict, nil)
+
+	balance := balanceResponse{}
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodGet, prefix+"/coins/balance", ""), http.StatusOK, &balance)
+	if balance.Balance != 100 {
+		t.Fatalf("Expected alice to have all 100 coins back, instead got %d", balance.Balance)
+	}
+}
+
+func TestCancelBid(t *testing.T) {
+	api := newTestApi()
+	alice := loginHelper(t, api, "alice")
+	bob := loginHelper(t, api, "bob")
+
+	queued, playing, anonymous := postBidResponse{}, postBidResponse{}, postBidResponse{}
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodPost, prefix+"/bids", `{"BidAmount": 30, "SongId": "`+songA+`"}`), http.StatusCreated, &queued)
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodPost, prefix+"/bids", `{"BidAmount": 50, "SongId": "`+songB+`"}`), http.StatusCreated, &playing)
+	decodeResponse(t, doRequest(api, http.MethodPost, prefix+"/bids", `{"BidAmount": 1, "SongId": "`+songA+`"}`), http.StatusCreated, &anonymous)
+	decodeResponse(t, doAuthRequest(api, operatorToken, http.MethodPut, prefix+"/player/play", ""), http.StatusOK, nil)
+
+	decodeResponse(t, doRequest(api, http.MethodDelete, prefix+"/bids/"+queued.BidId.String(), ""), http.StatusUnauthorized, nil)
+	for _, bidId := range []uuid.UUID{queued.BidId, anonymous.BidId} {
+		body := decodeResponse(t, doAuthRequest(api, bob.Token, http.MethodDelete, prefix+"/bids/"+bidId.String(), ""), http.StatusForbidden, nil)
+		if body.Error.Code != codeForbidden {
+			t.Fatalf("Expected forbidden when cancelling a bid of someone else, instead got %v", body.Error.Code)
+		}
+	}
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodDelete, prefix+"/bids/"+uuid.New().String(), ""), http.StatusNotFound, nil)
+	body := decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodDelete, prefix+"/bids/"+playing.BidId.String(), ""), http.StatusConflict, nil)
+	if body.Error.Code != codeInvalidTransition {
+		t.Fatalf("Expected invalid_transition when cancelling a playing bid, instead got %v", body.Error.Code)
+	}
+
+	cancelled := bidStatusResponse{}
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodDelete, prefix+"/bids/"+queued.BidId.String(), ""), http.StatusOK, &cancelled)
+	if cancelled.SongStatus != cockroach.Cancelled || cancelled.RefundReason != cockroach.ReasonCancelled || cancelled.Refund == nil {
+		t.Fatalf("Expected the bid to be cancelled with its refund, instead got %+v", cancelled)
+	}
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodDelete, prefix+"/bids/"+queued.BidId.String(), ""), http.StatusConflict, nil)
+
+	balance := balanceResponse{}
+	decodeResponse(t, doAuthRequest(api, alice.Token, http.MethodGet, prefix+"/coins/balance", ""), http.StatusOK, &balance)
+	if balance.Balance != 50 {
+		t.Fatalf("Expected the 30 coins of the cancelled bid back, instead the balance is %d", balance.Balance)
+	}
+}
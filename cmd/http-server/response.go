@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/acidleroy/song-bid/cockroach"
+	"github.com/acidleroy/song-bid/ledger"
+	"github.com/acidleroy/song-bid/pricing"
+)
+
+// envelope is the body of every JSON response. Successful responses set Data, failed responses set
+// Error.
+type envelope struct {
+	Data  interface{} `json:"data,omitempty"`
+	Error *apiError   `json:"error,omitempty"`
+}
+
+// apiError describes why a request failed. Code is stable and meant for programs, Message is meant
+// for people. Details lists the problem with each field of an invalid request.
+type apiError struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Details []fieldError `json:"details,omitempty"`
+}
+
+// Error codes returned in apiError.Code.
+const (
+	codeInvalidRequest       = "invalid_request"
+	codeInvalidBid           = "invalid_bid"
+	codeInvalidUser          = "invalid_user"
+	codeUnauthorized         = "unauthorized"
+	codeForbidden            = "forbidden"
+	codeInvalidCredentials   = "invalid_credentials"
+	codeUserExists           = "user_exists"
+	codeSongBanned           = "song_banned"
+	codeIdempotencyKeyReused = "idempotency_key_reused"
+	codeInsufficientFunds    = "insufficient_funds"
+	codeDuplicate            = "duplicate_transaction"
+	codePaymentsUnavailable  = "payments_unavailable"
+	codePaymentBackend       = "payment_backend_error"
+	codeNotFound             = "not_found"
+	codeMethodNotAllowed     = "method_not_allowed"
+	codeNoSongQueued         = "no_song_queued"
+	codeNoSongPlaying        = "no_song_playing"
+	codeSongAlreadyPlaying   = "song_already_playing"
+	codeInvalidTransition    = "invalid_transition"
+	codeStoreUnavailable     = "store_unavailable"
+	codeInternal             = "internal_error"
+)
+
+func writeJSON(w http.ResponseWriter, status int, body envelope) {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("Failed to marshal response: %v", err)
+		status = http.StatusInternalServerError
+		buf, _ = json.Marshal(envelope{Error: &apiError{Code: codeInternal, Message: "Internal Server error"}})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(buf)
+}
+
+// writeData writes a successful response with data in the envelope.
+func writeData(w http.ResponseWriter, status int, data interface{}) {
+	writeJSON(w, status, envelope{Data: data})
+}
+
+// writeError writes a failed response.
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeJSON(w, status, envelope{Error: &apiError{Code: code, Message: message}})
+}
+
+// writeNoContent writes an empty 204 response.
+func writeNoContent(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeStoreError maps an error returned by the bid store to the matching HTTP status code.
+func writeStoreError(w http.ResponseWriter, err error) {
+	var rejected *pricing.RuleError
+	switch {
+	case errors.As(err, &rejected):
+		writeJSON(w, http.StatusUnprocessableEntity, envelope{Error: &apiError{
+			Code:    codeInvalidBid,
+			Message: "Bid rejected by the pricing rules",
+			Details: violationErrors(rejected.Violations),
+		}})
+	case errors.Is(err, pricing.ErrInvalidRules):
+		writeError(w, http.StatusUnprocessableEntity, codeInvalidRequest, err.Error())
+	case errors.Is(err, cockroach.ErrInvalidBid):
+		writeError(w, http.StatusBadRequest, codeInvalidBid, err.Error())
+	case errors.Is(err, cockroach.ErrIdempotencyKeyReused):
+		writeError(w, http.StatusUnprocessableEntity, codeIdempotencyKeyReused, err.Error())
+	case errors.Is(err, cockroach.ErrSongBanned):
+		writeError(w, http.StatusUnprocessableEntity, codeSongBanned, err.Error())
+	case errors.Is(err, cockroach.ErrNoSongQueued):
+		writeError(w, http.StatusNotFound, codeNoSongQueued, err.Error())
+	case errors.Is(err, cockroach.ErrBidNotFound), errors.Is(err, cockroach.ErrUserNotFound), errors.Is(err, cockroach.ErrInvoiceNotFound),
+		errors.Is(err, cockroach.ErrPlayerStateNotFound), errors.Is(err, cockroach.ErrUnknownRoom):
+		writeError(w, http.StatusNotFound, codeNotFound, err.Error())
+	case errors.Is(err, ledger.ErrInsufficientFunds):
+		writeError(w, http.StatusPaymentRequired, codeInsufficientFunds, err.Error())
+	case errors.Is(err, ledger.ErrDuplicateReference):
+		writeError(w, http.StatusConflict, codeDuplicate, err.Error())
+	case errors.Is(err, cockroach.ErrUserExists):
+		writeError(w, http.StatusConflict, codeUserExists, err.Error())
+	case errors.Is(err, cockroach.ErrSongAlreadyPlaying):
+		writeError(w, http.StatusConflict, codeSongAlreadyPlaying, err.Error())
+	case errors.Is(err, cockroach.ErrNoSongPlaying):
+		writeError(w, http.StatusConflict, codeNoSongPlaying, err.Error())
+	case errors.Is(err, cockroach.ErrInvalidTransition):
+		writeError(w, http.StatusConflict, codeInvalidTransition, err.Error())
+	case errors.Is(err, cockroach.ErrStoreUnavailable):
+		writeError(w, http.StatusServiceUnavailable, codeStoreUnavailable, "Service unavailable")
+	default:
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal Server error")
+	}
+}
+
+// methods dispatches a request to the handler registered for its method. Other methods are
+// answered with 405 and an Allow header listing the supported ones.
+type methods map[string]http.HandlerFunc
+
+func (m methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler, ok := m[r.Method]; ok {
+		handler(w, r)
+		return
+	}
+
+	allowed := []string{}
+	for method := range m {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+
+	log.Printf("Method %v is not supported by %v", r.Method, r.URL.Path)
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method "+r.Method+" is not allowed, use "+strings.Join(allowed, ", "))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/acidleroy/song-bid/cockroach"
+)
+
+const (
+	// defaultMaxBidAmount is the largest single bid accepted unless -max-bid says otherwise.
+	defaultMaxBidAmount int = 1000
+	// maxArtistLength is the longest artist the operator may set for a song.
+	maxArtistLength int = 255
+)
+
+// spotifyTrackUri matches Spotify track URIs, whose ids are 22 base62 characters.
+var spotifyTrackUri = regexp.MustCompile(`^spotify:track:[0-9A-Za-z]{22}$`)
+
+const (
+	// songIdsSpotify makes songs Spotify track URIs, for music servers with -player spotify.
+	songIdsSpotify = "spotify"
+	// songIdsMPD makes songs the paths and URLs MPD plays, for music servers with -player mpd.
+	songIdsMPD = "mpd"
+	// maxMPDSongIdLength is the longest MPD path or URL a song may have.
+	maxMPDSongIdLength int = 255
+)
+
+// validMPDSongId reports whether the song id is something MPD can add: the path of a file in its
+// music directory, which may not leave it, or an http or https URL.
+func validMPDSongId(songId string) bool {
+	if songId == "" || len(songId) > maxMPDSongIdLength || !utf8.ValidString(songId) {
+		return false
+	}
+	for _, r := range songId {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	if strings.Contains(songId, "://") {
+		u, err := url.Parse(songId)
+		return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	}
+	if strings.HasPrefix(songId, "/") {
+		return false
+	}
+	for _, segment := range strings.Split(songId, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldError describes the problem with one field of a request.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// bidValidator checks POST /bids requests before they reach the bid store.
+type bidValidator struct {
+	maxBidAmount int
+	// songIds is the kind of song ids the music server's player plays, songIdsSpotify when empty.
+	songIds string
+}
+
+// songIdError returns the problem with a song id, or nothing if the music server's player can
+// play it.
+func (v bidValidator) songIdError(songId string) *fieldError {
+	if v.songIds == songIdsMPD {
+		if !validMPDSongId(songId) {
+			return &fieldError{"SongId", fmt.Sprintf(`must be the path of a file in MPD's music directory, e.g. "Daft Punk/Discovery/01 One More Time.flac", or an http(s) URL, of up to %d characters`, maxMPDSongIdLength)}
+		}
+		return nil
+	}
+	if !spotifyTrackUri.MatchString(songId) {
+		return &fieldError{"SongId", `must be a Spotify track URI, e.g. "spotify:track:6ADzlFXHPk846zUCEOM2C1"`}
+	}
+	return nil
+}
+
+// decodeBid parses the body of a POST /bids request. It returns nil and writes a 400 response when
+// the body is missing or isn't valid JSON, and a 422 response when the bid itself is invalid.
+func (v bidValidator) decodeBid(w http.ResponseWriter, buf []byte) *cockroach.PostBidData {
+	if len(buf) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, `Empty request, expecting: {"BidAmount": int, "SongId": string}`)
+		return nil
+	}
+
+	bid := cockroach.PostBidData{}
+	if err := json.Unmarshal(buf, &bid); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest,
+			fmt.Sprintf(`Invalid JSON request, expecting: {"BidAmount": int, "SongId": string}: %v`, err))
+		return nil
+	}
+
+	if errors := v.validate(bid); len(errors) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, envelope{Error: &apiError{
+			Code:    codeInvalidBid,
+			Message: "Invalid bid",
+			Details: errors,
+		}})
+		return nil
+	}
+	return &bid
+}
+
+// validate returns every problem with the bid, or nothing if the bid is valid.
+func (v bidValidator) validate(bid cockroach.PostBidData) []fieldError {
+	errors := []fieldError{}
+
+	if bid.BidAmount <= 0 {
+		errors = append(errors, fieldError{"BidAmount", "must be a positive number of coins"})
+	} else if v.maxBidAmount > 0 && bid.BidAmount > v.maxBidAmount {
+		errors = append(errors, fieldError{"BidAmount", fmt.Sprintf("must not be more than %d coins", v.maxBidAmount)})
+	}
+
+	if problem := v.songIdError(bid.SongId); problem != nil {
+		errors = append(errors, *problem)
+	}
+	return errors
+}
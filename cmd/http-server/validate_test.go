@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPostBidValidation(t *testing.T) {
+	testTable := []struct {
+		Name           string
+		Body           string
+		ExpectedStatus int
+		ExpectedFields []string
+	}{
+		{"empty body", "", http.StatusBadRequest, nil},
+		{"malformed JSON", `{"BidAmount": 1, "SongId": "` + songA + `"`, http.StatusBadRequest, nil},
+		{"wrong type", `{"BidAmount": "lots", "SongId": "` + songA + `"}`, http.StatusBadRequest, nil},
+		{"zero amount", `{"BidAmount": 0, "SongId": "` + songA + `"}`, http.StatusUnprocessableEntity, []string{"BidAmount"}},
+		{"negative amount", `{"BidAmount": -5, "SongId": "` + songA + `"}`, http.StatusUnprocessableEntity, []string{"BidAmount"}},
+		{"amount over the cap", `{"BidAmount": 1001, "SongId": "` + songA + `"}`, http.StatusUnprocessableEntity, []string{"BidAmount"}},
+		{"missing song", `{"BidAmount": 1}`, http.StatusUnprocessableEntity, []string{"SongId"}},
+		{"album uri", `{"BidAmount": 1, "SongId": "spotify:album:6ADzlFXHPk846zUCEOM2C1"}`, http.StatusUnprocessableEntity, []string{"SongId"}},
+		{"short track id", `{"BidAmount": 1, "SongId": "spotify:track:6ADzlFXHPk"}`, http.StatusUnprocessableEntity, []string{"SongId"}},
+		{"everything wrong", `{"BidAmount": 0, "SongId": "some-song"}`, http.StatusUnprocessableEntity, []string{"BidAmount", "SongId"}},
+	}
+
+	for _, test := range testTable {
+		api := newTestApi()
+		response := doRequest(api, http.MethodPost, prefix+"/bids", test.Body)
+
+		body := decodeResponse(t, response, test.ExpectedStatus, nil)
+		if body.Error == nil {
+			t.Fatalf("%v: expected an error in %s", test.Name, response.Body.String())
+		}
+		if len(body.Error.Details) != len(test.ExpectedFields) {
+			t.Fatalf("%v: expected errors for %v, instead got %v", test.Name, test.ExpectedFields, body.Error.Details)
+		}
+		for i, field := range test.ExpectedFields {
+			if body.Error.Details[i].Field != field {
+				t.Fatalf("%v: expected errors for %v, instead got %v", test.Name, test.ExpectedFields, body.Error.Details)
+			}
+		}
+
+		if bids, _ := api.database.GetBids(); len(bids) != 0 {
+			t.Fatalf("%v: expected the bid to be rejected, instead it was stored", test.Name)
+		}
+	}
+}
+
+func TestPostBidWithoutCap(t *testing.T) {
+	api := newTestApi()
+	api.validator.maxBidAmount = 0
+
+	response := doRequest(api, http.MethodPost, prefix+"/bids", `{"BidAmount": 1000000, "SongId": "`+songA+`"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected a large bid to be accepted without a cap, instead got %d", response.Code)
+	}
+}
+
+func TestPostBidWithMPDSongIds(t *testing.T) {
+	api := newTestApi()
+	api.allowAnonymousBids = true
+	api.validator.songIds = songIdsMPD
+
+	for _, songId := range []string{"Daft Punk/Discovery/01 One More Time.flac", "https://radio.example.com/stream.mp3"} {
+		decodeResponse(t, doRequest(api, http.MethodPost, prefix+"/bids", `{"BidAmount": 1, "SongId": "`+songId+`"}`), http.StatusCreated, nil)
+	}
+	for _, songId := range []string{"/etc/passwd", "../secret.flac", "Daft Punk//One.flac", "ftp://example.com/a.mp3", ""} {
+		body := decodeResponse(t, doRequest(api, http.MethodPost, prefix+"/bids", `{"BidAmount": 1, "SongId": "`+songId+`"}`), http.StatusUnprocessableEntity, nil)
+		if len(body.Error.Details) != 1 || body.Error.Details[0].Field != "SongId" {
+			t.Fatalf("Expected %q to be rejected on SongId, instead got %+v", songId, body.Error)
+		}
+	}
+}
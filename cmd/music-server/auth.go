@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+	"golang.org/x/oauth2"
+)
+
+// redirectURI is the OAuth redirect URI for the application.
+// You must register an application at Spotify's developer portal
+// and enter this value.
+const redirectURI = "http://localhost:8080/callback"
+
+// newOAuthConfig returns the OAuth client of the app registered with Spotify, whose id and secret
+// are read from SPOTIFY_ID and SPOTIFY_SECRET.
+func newOAuthConfig() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     os.Getenv("SPOTIFY_ID"),
+		ClientSecret: os.Getenv("SPOTIFY_SECRET"),
+		Endpoint:     oauth2.Endpoint{AuthURL: spotifyauth.AuthURL, TokenURL: spotifyauth.TokenURL},
+		RedirectURL:  redirectURI,
+		Scopes:       []string{spotifyauth.ScopeUserReadCurrentlyPlaying, spotifyauth.ScopeUserReadPlaybackState, spotifyauth.ScopeUserModifyPlaybackState},
+	}
+}
+
+// randomString returns n random bytes encoded as unpadded base64url.
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// pkceChallenge is the S256 code challenge of a PKCE code verifier (RFC 7636).
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authFlow runs the authorization code flow with PKCE. Every login gets its own random state and
+// code verifier, and only the redirect of the latest login is accepted.
+type authFlow struct {
+	config *oauth2.Config
+
+	mu       sync.Mutex
+	state    string
+	verifier string
+	tokens   chan *oauth2.Token
+}
+
+func newAuthFlow(config *oauth2.Config) *authFlow {
+	return &authFlow{config: config, tokens: make(chan *oauth2.Token, 1)}
+}
+
+// start begins a login and returns the URL the user has to visit to authorize the app.
+func (a *authFlow) start() (string, error) {
+	state, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.state, a.verifier = state, verifier
+	return a.config.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("code_challenge", pkceChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256")), nil
+}
+
+// login waits until the user authorized the app at the URL returned by start, or ctx is done.
+func (a *authFlow) login(ctx context.Context) (*oauth2.Token, error) {
+	select {
+	case token := <-a.tokens:
+		return token, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// ServeHTTP handles Spotify's redirect to redirectURI. Redirects that don't carry the state of
+// the pending login are rejected, each state can only be used once.
+func (a *authFlow) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	state, verifier := a.state, a.verifier
+	valid := state != "" && subtle.ConstantTimeCompare([]byte(r.FormValue("state")), []byte(state)) == 1
+	if valid {
+		a.state, a.verifier = "", ""
+	}
+	a.mu.Unlock()
+
+	if !valid {
+		log.Printf("Rejected a Spotify redirect without the state of the pending login")
+		http.Error(w, "Unknown or expired login, please start again", http.StatusForbidden)
+		return
+	}
+	if reason := r.FormValue("error"); reason != "" {
+		log.Printf("Spotify login failed: %v", reason)
+		http.Error(w, "Login failed: "+reason, http.StatusForbidden)
+		a.restart()
+		return
+	}
+
+	token, err := a.config.Exchange(r.Context(), r.FormValue("code"), oauth2.SetAuthURLParam("code_verifier", verifier))
+	if err != nil {
+		log.Printf("Failed to exchange the Spotify authorization code: %v", err)
+		http.Error(w, "Couldn't get token", http.StatusForbidden)
+		a.restart()
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprintf(w, "Login Completed!")
+	select {
+	case a.tokens <- token:
+	default:
+	}
+}
+
+// restart begins a new login after a failed one and prints its URL.
+func (a *authFlow) restart() {
+	url, err := a.start()
+	if err != nil {
+		log.Printf("Failed to start a new login: %v", err)
+		return
+	}
+	fmt.Println("Please log in to Spotify by visiting the following page in your browser:", url)
+}
+
+// errNoToken is returned by validToken for tokens that can't be used or refreshed.
+var errNoToken = errors.New("token has expired and can't be refreshed")
+
+// validToken checks that a stored token can still be used, or at least refreshed.
+func validToken(token *oauth2.Token) error {
+	if token.RefreshToken == "" && !token.Valid() {
+		return errNoToken
+	}
+	return nil
+}
+
+// loginRetryDelay is how long to wait before trying again when Spotify can't be reached.
+const loginRetryDelay time.Duration = 10 * time.Second
+
+// playSpotify logs in to Spotify and calls play with the client until ctx is done. The stored token
+// is used when there is one, otherwise the user is asked to log in. When Spotify revokes the token
+// play is cancelled and the user is asked to log in again.
+func playSpotify(ctx context.Context, flow *authFlow, store *TokenStore, play func(context.Context, *spotify.Client)) {
+	for ctx.Err() == nil {
+		token, err := loadOrLogin(ctx, flow, store)
+		if err != nil {
+			log.Printf("Spotify login failed: %v", err)
+			return
+		}
+
+		source := store.TokenSource(ctx, flow.config, token)
+		client := spotify.New(oauth2.NewClient(ctx, source))
+		user, err := client.CurrentUser(ctx)
+		if err != nil {
+			select {
+			case <-source.Revoked():
+				log.Println("Spotify revoked the stored token, logging in again")
+				if err := store.Delete(); err != nil {
+					log.Printf("Failed to delete the revoked token: %v", err)
+				}
+			case <-time.After(loginRetryDelay):
+				log.Printf("Could not reach Spotify: %v", err)
+			case <-ctx.Done():
+			}
+			continue
+		}
+		fmt.Println("You are logged in as:", user.ID)
+
+		session, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-source.Revoked():
+				log.Println("Spotify revoked the token, logging in again")
+				cancel()
+			case <-session.Done():
+			}
+		}()
+		play(session, client)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err := store.Delete(); err != nil {
+			log.Printf("Failed to delete the revoked token: %v", err)
+		}
+	}
+}
+
+// loadOrLogin returns the stored token, or the token of a new login if there is no usable one.
+func loadOrLogin(ctx context.Context, flow *authFlow, store *TokenStore) (*oauth2.Token, error) {
+	token, err := store.Load()
+	if err == nil {
+		err = validToken(token)
+	}
+	if err == nil {
+		fmt.Println("Successfully loaded token from file.")
+		return token, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Could not use the stored token: %v", err)
+	}
+
+	url, err := flow.start()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Please log in to Spotify by visiting the following page in your browser:", url)
+	if token, err = flow.login(ctx); err != nil {
+		return nil, err
+	}
+	if err := store.Save(token); err != nil {
+		log.Printf("Failed to save the Spotify token: %v", err)
+	}
+	return token, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// loginHelper starts a login and returns the query of its authorization URL.
+func loginHelper(t *testing.T, flow *authFlow) url.Values {
+	authUrl, err := flow.start()
+	if err != nil {
+		t.Fatalf("Failed to start a login: %v", err)
+	}
+	parsed, err := url.Parse(authUrl)
+	if err != nil {
+		t.Fatalf("Failed to parse the authorization URL %q: %v", authUrl, err)
+	}
+	return parsed.Query()
+}
+
+func redirect(flow *authFlow, state string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	flow.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/callback?code=the-code&state="+url.QueryEscape(state), nil))
+	return recorder
+}
+
+func TestAuthFlow(t *testing.T) {
+	challenge := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "the-code" || pkceChallenge(r.FormValue("code_verifier")) != challenge {
+			t.Errorf("Expected the code and the verifier of the challenge, instead got %v", r.Form)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token": "access", "refresh_token": "refresh", "token_type": "Bearer", "expires_in": 3600}`)
+	}))
+	defer server.Close()
+	flow := newAuthFlow(&oauth2.Config{ClientID: "id", Endpoint: oauth2.Endpoint{AuthURL: "https://accounts.example/authorize", TokenURL: server.URL}})
+
+	first := loginHelper(t, flow)
+	query := loginHelper(t, flow)
+	if query.Get("state") == "" || query.Get("state") == first.Get("state") {
+		t.Fatalf("Expected every login to get a new random state, instead got %q twice", query.Get("state"))
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == first.Get("code_challenge") {
+		t.Fatalf("Expected a new S256 code challenge, instead got %v", query)
+	}
+	challenge = query.Get("code_challenge")
+
+	if response := redirect(flow, first.Get("state")); response.Code != http.StatusForbidden {
+		t.Fatalf("Expected the state of an earlier login to be rejected, instead got %d", response.Code)
+	}
+	if response := redirect(flow, query.Get("state")); response.Code != http.StatusOK {
+		t.Fatalf("Expected the login to complete, instead got %d: %s", response.Code, response.Body.String())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	token, err := flow.login(ctx)
+	if err != nil || token.AccessToken != "access" || token.RefreshToken != "refresh" {
+		t.Fatalf("Expected the token of the login, instead got %+v and %v", token, err)
+	}
+
+	if response := redirect(flow, query.Get("state")); response.Code != http.StatusForbidden {
+		t.Fatalf("Expected a state to be usable only once, instead got %d", response.Code)
+	}
+}
+
+func TestValidToken(t *testing.T) {
+	if err := validToken(&oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(-time.Minute)}); err != errNoToken {
+		t.Fatalf("Expected an expired token without refresh token to be unusable, instead received %v", err)
+	}
+	if err := validToken(&oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("Expected an expired token to be refreshable, instead received %v", err)
+	}
+}
@@ -9,184 +9,104 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
-	spotifyauth "github.com/zmb3/spotify/v2/auth"
-	"golang.org/x/oauth2"
-
+	bidclient "github.com/acidleroy/song-bid/client/http-client"
+	cr "github.com/acidleroy/song-bid/cockroach"
+	"github.com/acidleroy/song-bid/player"
 	"github.com/zmb3/spotify/v2"
 )
 
-// redirectURI is the OAuth redirect URI for the application.
-// You must register an application at Spotify's developer portal
-// and enter this value.
-const redirectURI = "http://localhost:8080/callback"
-
-var (
-	auth = spotifyauth.New(
-		spotifyauth.WithRedirectURL(redirectURI),
-		spotifyauth.WithScopes(spotifyauth.ScopeUserReadCurrentlyPlaying, spotifyauth.ScopeUserReadPlaybackState, spotifyauth.ScopeUserModifyPlaybackState),
-	)
-	ch        = make(chan *spotify.Client)
-	state     = "abc123"
-	tokenFile = ".spotify-token.json"
+const (
+	// EnvMPDPassword is the environment variable with the password of the Music Player Daemon.
+	EnvMPDPassword = "SONG_BID_MPD_PASSWORD"
+	// EnvOperatorToken is the environment variable with the operator token of the http-server,
+	// which the player endpoints require.
+	EnvOperatorToken = "SONG_BID_OPERATOR_TOKEN"
 )
 
-func getTokenFromFile(filename string) (*oauth2.Token, error) {
-	buf, err := ioutil.ReadFile(filename)
-	if err != nil {
-		fmt.Printf("Could not read token file %v: %v\n", filename, err)
-		return nil, err
-	}
-	result := &oauth2.Token{}
-	err = json.Unmarshal(buf, result)
-	if err != nil {
-		fmt.Printf("Error unmarshalling spotify token from file, %v: %v\n", filename, err)
-		return nil, err
-	}
-	return result, nil
+// defaultPlaylist is played on Spotify, in a loop, while nobody bid on a song.
+var defaultPlaylist = []string{
+	"spotify:track:6ADzlFXHPk846zUCEOM2C1",
+	"spotify:track:1eVnOimXaPos2ua7Rxb7vY",
+	"spotify:track:0nOm9qJ8lChfshtWsMNGX5",
+	"spotify:track:21GdrXAPYwIZPAFx6JaAxh",
+	"spotify:track:35KJGai6SDpUR65mMJ6lkP",
+	"spotify:track:5lpOIzbl08NopnkBYMC2cq",
+	"spotify:track:6z4EdxNQRQbah5yMpz2CSL",
+	"spotify:track:6LnE7XmNCJrRkUufVwJyLE",
+	"spotify:track:7oBAd2YeVYN8i0ShTppfRC",
+	"spotify:track:0nHV2PFo3cSocA0Bk1ebIH",
 }
 
-func writeTokenToFile(filename string, tok *oauth2.Token) error {
-
-	bytes, err := json.Marshal(tok)
-	if err != nil {
-		fmt.Println("Could not Marshal Oauth2 token: ", err)
-		return err
-	}
-
-	if err := ioutil.WriteFile(filename, bytes, 0644); err != nil {
-		fmt.Printf("Could not write file %v because %v\n", filename, err)
-		return err
+// parsePlaylist splits a comma separated list of track URIs, ignoring empty entries.
+func parsePlaylist(list string) []string {
+	tracks := []string{}
+	for _, uri := range strings.Split(list, ",") {
+		if uri = strings.TrimSpace(uri); uri != "" {
+			tracks = append(tracks, uri)
+		}
 	}
-
-	return nil
+	return tracks
 }
 
-func verifyToken(tok *oauth2.Token) bool {
-	now := time.Now().Unix()
-	if now >= tok.Expiry.Unix() {
-		fmt.Println("Token has expired")
-		return false
-	} else {
-		fmt.Println("Token is still valid")
-		return true
-	}
+// flagSet reports whether the flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
 }
 
-func trackGenerator() func() string {
-	tracks := []string{
-		"spotify:track:6ADzlFXHPk846zUCEOM2C1",
-		"spotify:track:1eVnOimXaPos2ua7Rxb7vY",
-		"spotify:track:0nOm9qJ8lChfshtWsMNGX5",
-		"spotify:track:21GdrXAPYwIZPAFx6JaAxh",
-		"spotify:track:35KJGai6SDpUR65mMJ6lkP",
-		"spotify:track:5lpOIzbl08NopnkBYMC2cq",
-		"spotify:track:6z4EdxNQRQbah5yMpz2CSL",
-		"spotify:track:6LnE7XmNCJrRkUufVwJyLE",
-		"spotify:track:7oBAd2YeVYN8i0ShTppfRC",
-		"spotify:track:0nHV2PFo3cSocA0Bk1ebIH",
+func main() {
+	apiUrl := flag.String("api", "http://localhost:5050/api/v1/", "base url of the http-server api")
+	playlist := flag.String("default-playlist", strings.Join(defaultPlaylist, ","), "comma separated track URIs played while no song is queued, empty for silence")
+	room := flag.String("room", cr.DefaultRoom, "room the player state is reported for")
+	pollInterval := flag.Duration("poll-interval", time.Second, "how often to ask the player whether the track ended")
+	backend := flag.String("player", "spotify", "what plays the queue: spotify, or mpd for local files")
+	spotifyDevice := flag.String("spotify-device", "", "id of the Spotify Connect device to play on, the active device when empty")
+	mpdAddress := flag.String("mpd-address", "localhost:6600", "host:port of the Music Player Daemon")
+	mpdPassword := flag.String("mpd-password", os.Getenv(EnvMPDPassword), "password of the Music Player Daemon")
+	tokenFile := flag.String("token-file", ".spotify-token.json", "file the Spotify token is kept in, readable by its owner only")
+	operatorToken := flag.String("operator-token", os.Getenv(EnvOperatorToken), "operator token of the http-server, which the player endpoints require")
+	flag.Parse()
+	if *operatorToken == "" {
+		log.Fatalf("An operator token is required to drive the player, set -operator-token or %v", EnvOperatorToken)
 	}
-	currentTrack := 0
-	nextTrack := func() string {
-		track := tracks[currentTrack%len(tracks)]
-		currentTrack++
-		return track
+	api := bidclient.NewApi(&http.Client{}, *apiUrl, 10*time.Second).WithToken(*operatorToken)
+	play := func(ctx context.Context, p player.Player) {
+		player.NewJukebox(api, p, parsePlaylist(*playlist), *room).Run(ctx, *pollInterval)
 	}
-	return nextTrack
-
-}
 
-func main() {
-	// We'll want these variables sooner rather than later
-	var client *spotify.Client
-	var playerState *spotify.PlayerState
+	switch *backend {
+	case "mpd":
+		// The built-in playlist is made of Spotify tracks, MPD only plays the playlist it is given.
+		if !flagSet("default-playlist") {
+			*playlist = ""
+		}
+		play(context.Background(), player.NewMPD(*mpdAddress, *mpdPassword))
+		return
+	case "spotify":
+	default:
+		log.Fatalf("Unknown player %q, use spotify or mpd", *backend)
+	}
 
-	http.HandleFunc("/callback", completeAuth)
+	flow := newAuthFlow(newOAuthConfig())
+	http.Handle("/callback", flow)
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		log.Println("Got request for:", r.URL.String())
 	})
 
-	initiateAuth := func() {
-
-		// See if we have a saved token file
-		tok, err := getTokenFromFile(tokenFile)
-		if err != nil {
-			fmt.Println("Could not read token file, must have user authorize app...")
-			url := auth.AuthURL(state)
-			fmt.Println("Please log in to Spotify by visiting the following page in your browser:", url)
-			// wait for auth to complete
-			client = <-ch
-			// write the token to the file for next time.
-			tok, _ = client.Token()
-			writeTokenToFile(tokenFile, tok)
-		} else {
-			fmt.Println("Successfully loaded token from file.")
-			client = spotify.New(auth.Client(context.Background(), tok))
-		}
-
-		// For now, we just print what the status is. Ideally, we would refresh the tokens if needed.
-		verifyToken(tok)
-
-		// use the client to make calls that require authorization
-		user, err := client.CurrentUser(context.Background())
-		if err != nil {
-			log.Fatal(err)
-		}
-		fmt.Println("You are logged in as:", user.ID)
-
-		playerState, err = client.PlayerState(context.Background())
-		if err != nil {
-			log.Fatal(err)
-		}
-		fmt.Printf("Found your %s (%s)\n", playerState.Device.Type, playerState.Device.Name)
-		nextSong := trackGenerator()
-		for {
-			playerState, err := client.PlayerState(context.Background())
-			if err != nil {
-				log.Fatal(err)
-			}
-			isPlaying := playerState.CurrentlyPlaying.Playing
-			if !isPlaying {
-				fmt.Println("No song is playing, getting next track from bids database")
-				song := spotify.URI(nextSong())
-				uris := []spotify.URI{song}
-				opts := spotify.PlayOptions{URIs: uris}
-				err = client.PlayOpt(context.Background(), &opts)
-				if err != nil {
-					fmt.Println("There was an error playing the next song: ", err)
-				}
-			}
-			time.Sleep(time.Second * 1)
-		}
-	}
-
-	go initiateAuth()
+	go playSpotify(context.Background(), flow, NewTokenStore(*tokenFile), func(ctx context.Context, client *spotify.Client) {
+		play(ctx, player.NewSpotify(client, *spotifyDevice))
+	})
 
 	http.ListenAndServe(":8080", nil)
-
-}
-
-func completeAuth(w http.ResponseWriter, r *http.Request) {
-	tok, err := auth.Token(r.Context(), state, r)
-	fmt.Println("Token = ", tok)
-	if err != nil {
-		http.Error(w, "Couldn't get token", http.StatusForbidden)
-		log.Fatal(err)
-	}
-	if st := r.FormValue("state"); st != state {
-		http.NotFound(w, r)
-		log.Fatalf("State mismatch: %s != %s\n", st, state)
-	}
-	// use the token to get an authenticated client
-	client := spotify.New(auth.Client(r.Context(), tok))
-	w.Header().Set("Content-Type", "text/html")
-	fmt.Fprintf(w, "Login Completed!")
-	ch <- client
 }
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// tokenFileMode lets only the owner read the token file, the refresh token in it gives control of
+// the Spotify account.
+const tokenFileMode os.FileMode = 0600
+
+// ErrTokenRevoked is returned, and the token source's Revoked channel closed, when Spotify no
+// longer accepts the refresh token, e.g. because the user removed the app's access.
+var ErrTokenRevoked = errors.New("spotify token was revoked")
+
+// TokenStore keeps the Spotify token in a file between runs of the music server.
+type TokenStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewTokenStore(path string) *TokenStore {
+	return &TokenStore{path: path}
+}
+
+// Load reads the stored token. It returns an error matching os.ErrNotExist when there is none.
+// A file other users can read, as written by earlier versions, is made private first.
+func (s *TokenStore) Load() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&^tokenFileMode != 0 {
+		log.Printf("Restricting the permissions of %v to %v", s.path, tokenFileMode)
+		if err := os.Chmod(s.path, tokenFileMode); err != nil {
+			return nil, err
+		}
+	}
+
+	buf, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	token := &oauth2.Token{}
+	if err := json.Unmarshal(buf, token); err != nil {
+		return nil, fmt.Errorf("unmarshal token %v: %w", s.path, err)
+	}
+	return token, nil
+}
+
+// Save replaces the stored token. The token is written to a private temporary file that is then
+// renamed over the old one, so a crash never leaves a truncated token behind.
+func (s *TokenStore) Save(token *oauth2.Token) error {
+	buf, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// CreateTemp creates the file readable by its owner only.
+	file, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(buf); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), s.path)
+}
+
+// Delete removes the stored token, so that the user has to log in again.
+func (s *TokenStore) Delete() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// TokenSource returns a source of valid tokens, refreshed with config when they expire. Every
+// refreshed token is saved to the store.
+func (s *TokenStore) TokenSource(ctx context.Context, config *oauth2.Config, token *oauth2.Token) *SavingTokenSource {
+	return &SavingTokenSource{
+		store:   s,
+		source:  config.TokenSource(ctx, token),
+		last:    token.AccessToken,
+		revoked: make(chan struct{}),
+	}
+}
+
+// SavingTokenSource saves every new token of the wrapped source to a TokenStore.
+type SavingTokenSource struct {
+	store  *TokenStore
+	source oauth2.TokenSource
+
+	mu   sync.Mutex
+	last string
+	// revoked is closed once the refresh token was rejected.
+	revoked     chan struct{}
+	revokedOnce sync.Once
+}
+
+// Token returns a valid token, refreshing and saving it if it expired. A token that can't be saved
+// is still returned, it is saved again with the next refresh.
+func (s *SavingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.source.Token()
+	if isRevoked(err) {
+		s.revokedOnce.Do(func() { close(s.revoked) })
+		return nil, fmt.Errorf("%w: %v", ErrTokenRevoked, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token.AccessToken != s.last {
+		if err := s.store.Save(token); err != nil {
+			log.Printf("Failed to save the refreshed Spotify token: %v", err)
+		} else {
+			s.last = token.AccessToken
+		}
+	}
+	return token, nil
+}
+
+// Revoked returns a channel that is closed once Spotify rejected the refresh token.
+func (s *SavingTokenSource) Revoked() <-chan struct{} {
+	return s.revoked
+}
+
+// isRevoked reports whether the token endpoint rejected the refresh token. Spotify answers
+// 400 invalid_grant for revoked refresh tokens; 5xx errors are worth retrying instead.
+func isRevoked(err error) bool {
+	retrieveError := &oauth2.RetrieveError{}
+	if !errors.As(err, &retrieveError) || retrieveError.Response == nil {
+		return false
+	}
+	status := retrieveError.Response.StatusCode
+	return status == http.StatusBadRequest || status == http.StatusUnauthorized
+}
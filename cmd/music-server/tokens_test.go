@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// tokenEndpoint answers refresh requests with a new access token, or rejects the refresh token
+// once revoke is set.
+func tokenEndpoint(t *testing.T, revoke *bool) *oauth2.Config {
+	refreshes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if *revoke {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "invalid_grant", "error_description": "Refresh token revoked"}`)
+			return
+		}
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "refresh" {
+			t.Errorf("Unexpected token request: %v", r.Form)
+		}
+		refreshes++
+		fmt.Fprintf(w, `{"access_token": "access-%d", "token_type": "Bearer", "expires_in": 3600}`, refreshes)
+	}))
+	t.Cleanup(server.Close)
+	return &oauth2.Config{ClientID: "id", Endpoint: oauth2.Endpoint{TokenURL: server.URL}}
+}
+
+func TestTokenStore(t *testing.T) {
+	dir := t.TempDir()
+	store := NewTokenStore(filepath.Join(dir, "token.json"))
+
+	if _, err := store.Load(); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected os.ErrNotExist before a token was saved, instead received %v", err)
+	}
+	if err := store.Save(&oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}); err != nil {
+		t.Fatalf("Failed to save the token: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "token.json"))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected the token file to be private, instead got %v and %v", info.Mode(), err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("Expected only the token file to be left, instead found %v", files)
+	}
+
+	// Token files written by earlier versions were world readable.
+	os.Chmod(filepath.Join(dir, "token.json"), 0644)
+	token, err := store.Load()
+	if err != nil || token.AccessToken != "access" || token.RefreshToken != "refresh" {
+		t.Fatalf("Expected the saved token, instead got %+v and %v", token, err)
+	}
+	if info, _ := os.Stat(filepath.Join(dir, "token.json")); info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected loading to make the token file private, instead it is %v", info.Mode())
+	}
+
+	if err := store.Delete(); err != nil {
+		t.Fatalf("Failed to delete the token: %v", err)
+	}
+	if err := store.Delete(); err != nil {
+		t.Fatalf("Expected deleting a missing token to succeed, instead received %v", err)
+	}
+}
+
+func TestSavingTokenSource(t *testing.T) {
+	revoke := false
+	config := tokenEndpoint(t, &revoke)
+	store := NewTokenStore(filepath.Join(t.TempDir(), "token.json"))
+	expired := &oauth2.Token{AccessToken: "expired", RefreshToken: "refresh", Expiry: time.Now().Add(-time.Minute)}
+	source := store.TokenSource(context.Background(), config, expired)
+
+	token, err := source.Token()
+	if err != nil || token.AccessToken != "access-1" {
+		t.Fatalf("Expected the expired token to be refreshed, instead got %+v and %v", token, err)
+	}
+	if saved, err := store.Load(); err != nil || saved.AccessToken != "access-1" || saved.RefreshToken != "refresh" {
+		t.Fatalf("Expected the refreshed token to be saved, instead got %+v and %v", saved, err)
+	}
+
+	// The refreshed token is reused while it is valid.
+	if token, _ := source.Token(); token.AccessToken != "access-1" {
+		t.Fatalf("Expected the refreshed token to be reused, instead got %+v", token)
+	}
+
+	revoke = true
+	source = store.TokenSource(context.Background(), config, expired)
+	if _, err := source.Token(); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Expected ErrTokenRevoked, instead received %v", err)
+	}
+	select {
+	case <-source.Revoked():
+	default:
+		t.Fatalf("Expected the Revoked channel to be closed")
+	}
+}
@@ -0,0 +1,112 @@
+// song-bid contains the operator commands for the song-bid database.
+//
+//	song-bid migrate [-config file] [up | down | to <version> | status]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/acidleroy/song-bid/cockroach"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: song-bid migrate [-config file] [up | down | to <version> | status]\n")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "migrate" {
+		usage()
+	}
+
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	configFile := flags.String("config", os.Getenv(cockroach.EnvConfigFile), "JSON file with the database connection settings")
+	flags.Parse(os.Args[2:])
+
+	config, err := cockroach.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("Could not load the database config: %v", err)
+	}
+
+	ctx := context.Background()
+	db, err := cockroach.ConnectWithConfig(ctx, config)
+	if err != nil {
+		log.Fatalf("Could not connect to the database: %v", err)
+	}
+	defer db.Close()
+
+	if err := migrate(ctx, db, flags.Args()); err != nil {
+		log.Printf("Migration failed: %v", err)
+		db.Close()
+		os.Exit(1)
+	}
+}
+
+func migrate(ctx context.Context, db *cockroach.Database, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		return db.Migrate(ctx)
+	case "down":
+		applied, err := db.AppliedMigrations(ctx)
+		if err != nil {
+			return err
+		}
+		current := cockroach.CurrentVersion(applied)
+		if current == 0 {
+			fmt.Println("Nothing to revert.")
+			return nil
+		}
+		return db.MigrateTo(ctx, current-1)
+	case "to":
+		if len(args) != 2 {
+			usage()
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		return db.MigrateTo(ctx, version)
+	case "status":
+		return status(ctx, db)
+	default:
+		usage()
+	}
+	return nil
+}
+
+func status(ctx context.Context, db *cockroach.Database) error {
+	migrations, err := cockroach.Migrations()
+	if err != nil {
+		return err
+	}
+	applied, err := db.AppliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	byVersion := map[int]cockroach.AppliedMigration{}
+	for _, row := range applied {
+		byVersion[row.Version] = row
+	}
+	for _, migration := range migrations {
+		state := "pending"
+		if row, ok := byVersion[migration.Version]; ok {
+			state = "applied " + row.AppliedAt.Format("2006-01-02 15:04:05")
+			if row.Checksum != migration.Checksum() || (row.DownChecksum != "" && row.DownChecksum != migration.DownChecksum()) {
+				state += " (checksum mismatch)"
+			}
+		}
+		fmt.Printf("%04d_%-30s %s\n", migration.Version, migration.Name, state)
+	}
+	return nil
+}
@@ -0,0 +1,463 @@
+package cockroach
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/acidleroy/song-bid/ledger"
+	"github.com/google/uuid"
+)
+
+// Names of the auction strategies, as accepted by NewAuctionStrategy.
+const (
+	StrategySum           = "sum"
+	StrategyTimeWeighted  = "time_weighted"
+	StrategyQuadratic     = "quadratic"
+	StrategyUniqueBidders = "unique_bidders"
+	StrategySecondPrice   = "second_price"
+)
+
+// defaultTimeWeightPeriod is how long a bid has to wait for its weight to double with TimeWeighted.
+const defaultTimeWeightPeriod time.Duration = 10 * time.Minute
+
+// SongBids are the queued bids on a song, the input of an AuctionStrategy.
+type SongBids struct {
+	SongId string
+	Bids   []BidRow
+	// decay lowers the amount old bids count for, nil counts every bid in full.
+	decay Decay
+}
+
+// Amount returns what a bid counts for in the auction at time now, its amount lowered by the
+// decay of the queue.
+func (s SongBids) Amount(bid BidRow, now time.Time) float64 {
+	if s.decay == nil {
+		return float64(bid.BidAmount)
+	}
+	return float64(bid.BidAmount) * s.decay.Weight(now.Sub(bid.CreatedAt))
+}
+
+// effectiveAmount sums what the bids count for at time now.
+func (s SongBids) effectiveAmount(now time.Time) float64 {
+	amount := 0.0
+	for _, bid := range s.Bids {
+		amount += s.Amount(bid, now)
+	}
+	return amount
+}
+
+// totals sums the bids. UniqueBidders counts the users who bid; anonymous bids are counted in
+// BidCount only.
+func (s SongBids) totals() SongTotals {
+	totals := SongTotals{SongId: s.SongId, BidCount: len(s.Bids)}
+	users := map[uuid.UUID]bool{}
+	for _, bid := range s.Bids {
+		totals.TotalAmount += bid.BidAmount
+		if bid.UserId.Valid && !users[bid.UserId.UUID] {
+			users[bid.UserId.UUID] = true
+			totals.UniqueBidders++
+		}
+	}
+	return totals
+}
+
+// bidders sums what the bids of every bidder on the song count for at time now. The anonymous bids
+// count as a single bidder, keyed by uuid.Nil, since anyone can split a payment into many of them.
+func (s SongBids) bidders(now time.Time) map[uuid.UUID]float64 {
+	coins := map[uuid.UUID]float64{}
+	for _, bid := range s.Bids {
+		coins[bid.UserId.UUID] += s.Amount(bid, now)
+	}
+	return coins
+}
+
+// AuctionStrategy decides which queued song plays next. Songs are ranked by their score, highest
+// first; equal scores go to the song with the larger total amount and then to the smaller song id,
+// so that the ranking is deterministic.
+type AuctionStrategy interface {
+	// Name is the name the strategy is configured with.
+	Name() string
+	// Score rates the queued bids of a song at time now. Strategies count every bid for
+	// song.Amount, so that the decay of old bids applies to all of them.
+	Score(song SongBids, now time.Time) float64
+}
+
+// Pricer is implemented by strategies where the song that plays next pays less than was bid on it.
+// The difference is given back to its bidders when the song starts.
+type Pricer interface {
+	// Price returns the coins the winning song pays. runnerUp is nil when no other song was queued.
+	Price(winner SongBids, runnerUp *SongBids, now time.Time) int
+}
+
+// SumOfBids ranks songs by the sum of their bids.
+type SumOfBids struct{}
+
+func (SumOfBids) Name() string { return StrategySum }
+
+func (SumOfBids) Score(song SongBids, now time.Time) float64 {
+	return song.effectiveAmount(now)
+}
+
+// TimeWeighted ranks songs by the sum of their bids, each weighted by how long it has been queued:
+// a bid counts once when it is placed and once more for every Period it waited since, so that
+// songs with old bids aren't starved by newer ones.
+type TimeWeighted struct {
+	Period time.Duration
+}
+
+func (TimeWeighted) Name() string { return StrategyTimeWeighted }
+
+func (s TimeWeighted) Score(song SongBids, now time.Time) float64 {
+	period := s.Period
+	if period <= 0 {
+		period = defaultTimeWeightPeriod
+	}
+	score := 0.0
+	for _, bid := range song.Bids {
+		waited := now.Sub(bid.CreatedAt)
+		if waited < 0 {
+			waited = 0
+		}
+		score += song.Amount(bid, now) * (1 + float64(waited)/float64(period))
+	}
+	return score
+}
+
+// Quadratic ranks songs by quadratic voting: the coins a bidder spends on a song buy the square
+// root of that many votes, so four coins buy two votes and nine coins three. A crowd of small
+// bidders outweighs a single large one. The anonymous bids on a song share the votes of one bidder.
+type Quadratic struct{}
+
+func (Quadratic) Name() string { return StrategyQuadratic }
+
+func (Quadratic) Score(song SongBids, now time.Time) float64 {
+	votes := 0.0
+	for _, coins := range song.bidders(now) {
+		votes += math.Sqrt(coins)
+	}
+	return votes
+}
+
+// UniqueBidders ranks songs by the sum of their bids times the number of bidders, so that a song
+// many people want beats one with the same amount from a single bidder. Bidders are counted like
+// SongTotals.UniqueBidders, signed-in users only, since anyone can post many anonymous bids; a song
+// with anonymous bids only counts as having one bidder.
+type UniqueBidders struct{}
+
+func (UniqueBidders) Name() string { return StrategyUniqueBidders }
+
+func (UniqueBidders) Score(song SongBids, now time.Time) float64 {
+	bidders := song.totals().UniqueBidders
+	if bidders < 1 {
+		bidders = 1
+	}
+	return song.effectiveAmount(now) * float64(bidders)
+}
+
+// SecondPrice ranks songs by the sum of their bids, but the song that plays next only pays one coin
+// more than the runner-up, like in a Vickrey auction. A song that was the only one queued pays its
+// full amount.
+type SecondPrice struct{}
+
+func (SecondPrice) Name() string { return StrategySecondPrice }
+
+func (SecondPrice) Score(song SongBids, now time.Time) float64 {
+	return song.effectiveAmount(now)
+}
+
+func (SecondPrice) Price(winner SongBids, runnerUp *SongBids, now time.Time) int {
+	total := winner.totals().TotalAmount
+	if runnerUp == nil {
+		return total
+	}
+	if price := runnerUp.totals().TotalAmount + 1; price < total {
+		return price
+	}
+	return total
+}
+
+// NewAuctionStrategy returns the strategy with the given name.
+func NewAuctionStrategy(name string) (AuctionStrategy, error) {
+	switch name {
+	case StrategySum:
+		return SumOfBids{}, nil
+	case StrategyTimeWeighted:
+		return TimeWeighted{Period: defaultTimeWeightPeriod}, nil
+	case StrategyQuadratic:
+		return Quadratic{}, nil
+	case StrategyUniqueBidders:
+		return UniqueBidders{}, nil
+	case StrategySecondPrice:
+		return SecondPrice{}, nil
+	}
+	return nil, fmt.Errorf("unknown auction strategy %q", name)
+}
+
+// AuctionConfig selects the auction strategy of every room. Rooms without a strategy of their own
+// use Default, and SumOfBids is used when Default is nil. Only the strategy of DefaultRoom ranks
+// the queue. Decay applies to the bids in every room; nil counts bids in full however old they are.
+type AuctionConfig struct {
+	Default AuctionStrategy
+	Rooms   map[string]AuctionStrategy
+	Decay   Decay
+	// LockWindow is how long before the end of a track new bids stop counting for the song that
+	// follows it, so that nobody can snipe the next song in its last second. Zero disables the lock.
+	LockWindow time.Duration
+	// Cooldowns keep the songs that started recently, and their artists, from playing next.
+	Cooldowns Cooldowns
+}
+
+// For returns the strategy of the room.
+func (c AuctionConfig) For(room string) AuctionStrategy {
+	if strategy, ok := c.Rooms[room]; ok {
+		return strategy
+	}
+	if c.Default != nil {
+		return c.Default
+	}
+	return SumOfBids{}
+}
+
+// ParseAuctionConfig parses a comma separated list of strategies, e.g. "quadratic" or
+// "default=quadratic". An entry without a room sets the default strategy, and entries for a room
+// other than DefaultRoom fail with ErrUnknownRoom.
+func ParseAuctionConfig(value string) (AuctionConfig, error) {
+	config := AuctionConfig{Rooms: map[string]AuctionStrategy{}}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		room, name, ok := strings.Cut(entry, "=")
+		if !ok {
+			name = room
+		}
+		strategy, err := NewAuctionStrategy(strings.TrimSpace(name))
+		if err != nil {
+			return AuctionConfig{}, err
+		}
+		if !ok {
+			config.Default = strategy
+		} else if err := checkRoom(strings.TrimSpace(room)); err != nil {
+			return AuctionConfig{}, err
+		} else {
+			config.Rooms[DefaultRoom] = strategy
+		}
+	}
+	return config, nil
+}
+
+// ranking is how the queue of a room is ranked.
+type ranking struct {
+	strategy AuctionStrategy
+	decay    Decay
+}
+
+// ranking returns how the queue of the room is ranked.
+func (c AuctionConfig) ranking(room string) ranking {
+	return ranking{strategy: c.For(room), decay: c.Decay}
+}
+
+// scoredSong is a queued song and its score.
+type scoredSong struct {
+	bids   SongBids
+	totals SongTotals
+	score  float64
+	// effectiveAmount is what the bids count for after decay.
+	effectiveAmount float64
+}
+
+// ranksAbove reports whether s ranks above other.
+func (s scoredSong) ranksAbove(other scoredSong) bool {
+	if s.score != other.score {
+		return s.score > other.score
+	}
+	if s.totals.TotalAmount != other.totals.TotalAmount {
+		return s.totals.TotalAmount > other.totals.TotalAmount
+	}
+	return s.bids.SongId < other.bids.SongId
+}
+
+func scoreSong(r ranking, song SongBids, now time.Time) scoredSong {
+	song.decay = r.decay
+	return scoredSong{bids: song, totals: song.totals(), score: r.strategy.Score(song, now), effectiveAmount: song.effectiveAmount(now)}
+}
+
+// scoreSongs scores the songs and sorts them the way PlayNextSong chooses them, next song first.
+func scoreSongs(r ranking, songs []SongBids, now time.Time) []scoredSong {
+	scored := make([]scoredSong, len(songs))
+	for i, song := range songs {
+		scored[i] = scoreSong(r, song, now)
+	}
+	sort.Slice(scored, func(i, j int) bool { return scored[i].ranksAbove(scored[j]) })
+	return scored
+}
+
+// rankSongs ranks the queued songs the way PlayNextSong chooses them.
+func rankSongs(r ranking, songs []SongBids, now time.Time) []RankedSong {
+	return rankScored(r, eligibility{}, scoreSongs(r, songs, now), now)
+}
+
+// rankScored ranks songs scored by scoreSongs. The amounts to overtake are raised to what the
+// pricing rules accept.
+func rankScored(r ranking, e eligibility, scored []scoredSong, now time.Time) []RankedSong {
+	ranked := make([]RankedSong, len(scored))
+	for i, song := range scored {
+		ranked[i] = RankedSong{SongTotals: song.totals, Rank: i + 1, Score: roundScore(song.score), EffectiveAmount: roundScore(song.effectiveAmount)}
+		if i > 0 {
+			if amount := amountToOvertake(r, song.bids, scored[i-1], now); amount > 0 {
+				ranked[i].AmountToOvertake = e.rules.Raise(amount, e.pricingSong(song.bids), leader(r, e.rules, scored, song.bids, now))
+			}
+		}
+	}
+	return ranked
+}
+
+// roundScore rounds a score to two decimals for clients; the ranking uses the exact score.
+func roundScore(score float64) float64 {
+	return math.Round(score*100) / 100
+}
+
+// maxOvertakeSearch bounds the search of amountToOvertake.
+const maxOvertakeSearch = 1 << 30
+
+// amountToOvertake returns the smallest new bid that makes song rank above the song above it. The
+// new bid is taken to come from a new bidder and to be placed at now, so that it counts in full;
+// every strategy rates a song higher the more is bid on it, so the amount can be found by bisection.
+func amountToOvertake(r ranking, song SongBids, above scoredSong, now time.Time) int {
+	overtakes := func(amount int) bool {
+		bids := append(append([]BidRow{}, song.Bids...), BidRow{BidAmount: amount, SongId: song.SongId, BidId: uuid.New(), CreatedAt: now, UpdatedAt: now})
+		return scoreSong(r, SongBids{SongId: song.SongId, Bids: bids}, now).ranksAbove(above)
+	}
+
+	high := 1
+	for !overtakes(high) {
+		if high >= maxOvertakeSearch {
+			return 0
+		}
+		high *= 2
+	}
+	low := high / 2
+	for low+1 < high {
+		middle := low + (high-low)/2
+		if overtakes(middle) {
+			high = middle
+		} else {
+			low = middle
+		}
+	}
+	return high
+}
+
+// summedBids returns the totals of the scored songs, in their order.
+func summedBids(scored []scoredSong) []PostBidData {
+	result := make([]PostBidData, len(scored))
+	for i, song := range scored {
+		result[i] = PostBidData{BidAmount: song.totals.TotalAmount, SongId: song.bids.SongId}
+	}
+	return result
+}
+
+// groupBySong groups the bids by song, in the order the songs first appear.
+func groupBySong(bids []BidRow) []SongBids {
+	index := map[string]int{}
+	songs := []SongBids{}
+	for _, bid := range bids {
+		i, ok := index[bid.SongId]
+		if !ok {
+			i = len(songs)
+			index[bid.SongId] = i
+			songs = append(songs, SongBids{SongId: bid.SongId})
+		}
+		songs[i].Bids = append(songs[i].Bids, bid)
+	}
+	return songs
+}
+
+// rebateReference is the ledger reference of the transaction that gives back part of a bid.
+func rebateReference(bidId uuid.UUID) string {
+	return "rebate:" + bidId.String()
+}
+
+// rebates returns the ledger transactions that give back what the song that plays next paid above
+// the price of the strategy, if it is a Pricer. The difference is shared in proportion to the bids;
+// the coins left over by rounding go to the largest bids. Anonymous bids weren't paid with coins
+// and get nothing back. Prices are in coins, decay doesn't change them, and the song pays at least
+// its reserve price.
+func rebates(r ranking, e eligibility, scored []scoredSong, now time.Time) []ledger.Transaction {
+	pricer, ok := r.strategy.(Pricer)
+	if !ok || len(scored) == 0 {
+		return nil
+	}
+	winner := scored[0]
+	var runnerUp *SongBids
+	if len(scored) > 1 {
+		runnerUp = &scored[1].bids
+	}
+	total := winner.totals.TotalAmount
+	price := pricer.Price(winner.bids, runnerUp, now)
+	if reserve := e.rules.Reserve(e.pricingSong(winner.bids)); reserve > price {
+		price = reserve
+	}
+	excess := total - price
+	if excess <= 0 || total <= 0 {
+		return nil
+	}
+
+	bids := append([]BidRow{}, winner.bids.Bids...)
+	sort.Slice(bids, func(i, j int) bool {
+		if bids[i].BidAmount != bids[j].BidAmount {
+			return bids[i].BidAmount > bids[j].BidAmount
+		}
+		return bids[i].BidId.String() < bids[j].BidId.String()
+	})
+	shares := make([]int, len(bids))
+	left := excess
+	for i, bid := range bids {
+		shares[i] = excess * bid.BidAmount / total
+		left -= shares[i]
+	}
+	for i := 0; left > 0; i = (i + 1) % len(bids) {
+		if shares[i] < bids[i].BidAmount {
+			shares[i]++
+			left--
+		}
+	}
+
+	var result []ledger.Transaction
+	for i, bid := range bids {
+		if bid.UserId.Valid && shares[i] > 0 {
+			result = append(result, ledger.Transfer(rebateReference(bid.BidId), ledger.BidsAccount, ledger.UserAccount(bid.UserId.UUID), int64(shares[i])))
+		}
+	}
+	return result
+}
+
+// SetAuctionConfig sets the strategies that rank the queue.
+func (db *Database) SetAuctionConfig(config AuctionConfig) {
+	db.auctionMu.Lock()
+	defer db.auctionMu.Unlock()
+	db.auction = config
+}
+
+// ranking returns how the queue is ranked.
+func (db *Database) ranking() ranking {
+	db.auctionMu.RLock()
+	defer db.auctionMu.RUnlock()
+	return db.auction.ranking(DefaultRoom)
+}
+
+// SetAuctionConfig sets the strategies that rank the queue.
+func (m *MemoryStore) SetAuctionConfig(config AuctionConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.auction = config
+}
+
+// ranking returns how the queue is ranked. The caller must hold m.mu.
+func (m *MemoryStore) ranking() ranking {
+	return m.auction.ranking(DefaultRoom)
+}
@@ -0,0 +1,210 @@
+package cockroach
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// songBidsHelper returns anonymous bids of the given amounts on a song, placed at createdAt.
+func songBidsHelper(songId string, createdAt time.Time, amounts ...int) SongBids {
+	song := SongBids{SongId: songId}
+	for _, amount := range amounts {
+		song.Bids = append(song.Bids, BidRow{BidAmount: amount, SongId: songId, BidId: uuid.New(), CreatedAt: createdAt, UpdatedAt: createdAt})
+	}
+	return song
+}
+
+// bidderHelper creates a user holding the given number of coins.
+func bidderHelper(t *testing.T, store Store, coins int64) uuid.NullUUID {
+	user, err := store.CreateUser("bidder-"+uuid.New().String()[:8], "hash")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := store.CreditCoins(user.UserId, coins, "test:"+user.Username); err != nil {
+		t.Fatalf("Failed to credit coins: %v", err)
+	}
+	return uuid.NullUUID{UUID: user.UserId, Valid: true}
+}
+
+// playNextHelper plays the next song and returns its id.
+func playNextHelper(t *testing.T, store Store) string {
+	played, err := store.PlayNextSong()
+	if err != nil {
+		t.Fatalf("Failed to play next song: %v", err)
+	}
+	return played[0].SongId
+}
+
+// TestAuctionStrategies plays the same bids with every strategy: song-a has one 7 coin bid placed
+// now, song-b two 3 coin bids of two other users placed 10 minutes earlier.
+func TestAuctionStrategies(t *testing.T) {
+	for _, test := range []struct {
+		strategy AuctionStrategy
+		next     string
+		// overtake is what song-b, or song-a if it plays later, has to bid to go first.
+		overtake int
+	}{
+		{SumOfBids{}, "song-a", 2},
+		{TimeWeighted{Period: 10 * time.Minute}, "song-b", 5},
+		{Quadratic{}, "song-b", 1},
+		{UniqueBidders{}, "song-b", 5},
+		{SecondPrice{}, "song-a", 2},
+	} {
+		store := NewMemoryStore()
+		now := time.Now()
+		store.now = func() time.Time { return now }
+		store.SetAuctionConfig(AuctionConfig{Default: test.strategy})
+
+		for _, data := range []PostBidData{
+			{BidAmount: 3, SongId: "song-b", UserId: bidderHelper(t, store, 10)},
+			{BidAmount: 3, SongId: "song-b", UserId: bidderHelper(t, store, 10)},
+		} {
+			if _, err := store.PostBid(data); err != nil {
+				t.Fatalf("Failed to post bid: %v", err)
+			}
+		}
+		now = now.Add(10 * time.Minute)
+		if _, err := store.PostBid(PostBidData{BidAmount: 7, SongId: "song-a", UserId: bidderHelper(t, store, 10)}); err != nil {
+			t.Fatalf("Failed to post bid: %v", err)
+		}
+
+		queue, err := store.GetQueue()
+		if err != nil || len(queue.Songs) != 2 || queue.Songs[0].SongId != test.next || queue.Songs[1].AmountToOvertake != test.overtake {
+			t.Fatalf("Expected %v to rank %v first and the other song to need %d coins, instead got %+v and %v", test.strategy.Name(), test.next, test.overtake, queue, err)
+		}
+		if highest, err := store.GetHighestBid(); err != nil || highest.SongId != test.next {
+			t.Fatalf("Expected %v to have the highest bid with %v, instead got %+v and %v", test.next, test.strategy.Name(), highest, err)
+		}
+		if next := playNextHelper(t, store); next != test.next {
+			t.Fatalf("Expected %v to play %v next, instead it played %v", test.strategy.Name(), test.next, next)
+		}
+	}
+}
+
+func TestAuctionTieBreak(t *testing.T) {
+	now := time.Now()
+	// Equal votes go to the larger amount, then to the smaller song id.
+	ranked := rankSongs(ranking{strategy: Quadratic{}}, []SongBids{
+		songBidsHelper("song-c", now, 4),
+		songBidsHelper("song-b", now, 1, 1),
+		songBidsHelper("song-a", now, 1, 1),
+	}, now)
+	if ranked[0].SongId != "song-c" || ranked[1].SongId != "song-a" || ranked[2].SongId != "song-b" {
+		t.Fatalf("Expected song-c, song-a, song-b, instead got %+v", ranked)
+	}
+}
+
+func TestQuadraticPoolsAnonymousBids(t *testing.T) {
+	now := time.Now()
+	// Nine anonymous coins buy the 3 votes of one bidder, however many bids they are split into.
+	split := songBidsHelper("song-a", now, 1, 1, 1, 1, 1, 1, 1, 1, 1)
+	single := songBidsHelper("song-b", now, 9)
+	if splitScore, singleScore := (Quadratic{}).Score(split, now), (Quadratic{}).Score(single, now); splitScore != 3 || singleScore != 3 {
+		t.Fatalf("Expected 3 votes for both songs, instead got %v and %v", splitScore, singleScore)
+	}
+}
+
+func TestUniqueBiddersCountsSignedInUsers(t *testing.T) {
+	now := time.Now()
+	// Five anonymous bids count as one bidder, two users as two, as in the queue's totals.
+	anonymous := songBidsHelper("song-a", now, 1, 1, 1, 1, 1)
+	users := songBidsHelper("song-b", now, 2, 2)
+	for i := range users.Bids {
+		users.Bids[i].UserId = uuid.NullUUID{UUID: uuid.New(), Valid: true}
+	}
+	if score := (UniqueBidders{}).Score(anonymous, now); score != 5 || anonymous.totals().UniqueBidders != 0 {
+		t.Fatalf("Expected anonymous bids to count as one bidder, instead got a score of %v", score)
+	}
+	if score := (UniqueBidders{}).Score(users, now); score != 8 || users.totals().UniqueBidders != 2 {
+		t.Fatalf("Expected 2 bidders, instead got a score of %v", score)
+	}
+}
+
+func TestParseAuctionConfig(t *testing.T) {
+	config, err := ParseAuctionConfig("quadratic, default=second_price")
+	if err != nil {
+		t.Fatalf("Failed to parse the auction config: %v", err)
+	}
+	if config.Default.Name() != StrategyQuadratic || config.For(DefaultRoom).Name() != StrategySecondPrice {
+		t.Fatalf("Expected quadratic by default and second price in the default room, instead got %+v", config)
+	}
+	if _, err := ParseAuctionConfig("lounge=second_price"); !errors.Is(err, ErrUnknownRoom) {
+		t.Fatalf("Expected a strategy for a room bids can't be placed in to be rejected, instead got %v", err)
+	}
+	if config, _ := ParseAuctionConfig(""); config.For(DefaultRoom).Name() != StrategySum {
+		t.Fatalf("Expected the sum of bids without configuration, instead got %+v", config)
+	}
+	if _, err := ParseAuctionConfig("lounge=highest"); err == nil {
+		t.Fatalf("Expected an unknown strategy to be rejected")
+	}
+}
+
+// auctionStoreHelper checks that the store ranks with its strategy and gives back what a second
+// price winner paid too much, on a store holding no other bids.
+func auctionStoreHelper(t *testing.T, store Store) {
+	alice, bob, carol := bidderHelper(t, store, 20), bidderHelper(t, store, 20), bidderHelper(t, store, 20)
+	postBidsHelper(t, store, []PostBidData{
+		{BidAmount: 9, SongId: "song-a", UserId: alice},
+		{BidAmount: 4, SongId: "song-b", UserId: bob},
+		{BidAmount: 4, SongId: "song-b", UserId: carol},
+	})
+
+	store.SetAuctionConfig(AuctionConfig{Default: Quadratic{}})
+	if sums, err := store.GetBidsGroupBySongId(); err != nil || len(sums) != 2 || sums[0].SongId != "song-b" || sums[0].BidAmount != 8 {
+		t.Fatalf("Expected 2 votes each to put song-b first, instead got %+v and %v", sums, err)
+	}
+
+	// song-a wins with 9 coins against 8 and pays all of them.
+	store.SetAuctionConfig(AuctionConfig{Default: SecondPrice{}})
+	if next := playNextHelper(t, store); next != "song-a" {
+		t.Fatalf("Expected song-a to play next, instead got %v", next)
+	}
+	if _, err := store.FinalizeCurrentSong(); err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+	postBidsHelper(t, store, []PostBidData{{BidAmount: 2, SongId: "song-c", UserId: alice}})
+	if next := playNextHelper(t, store); next != "song-b" {
+		t.Fatalf("Expected song-b to play next, instead got %v", next)
+	}
+	// song-b pays 3 for 8 coins, 5 go back: 3 to the bid sorted first and 2 to the other.
+	var given int64
+	for _, bidder := range []uuid.NullUUID{bob, carol} {
+		balance, err := store.GetBalance(bidder.UUID)
+		if err != nil || balance < 18 || balance > 19 {
+			t.Fatalf("Expected 2 or 3 of 4 coins back, instead the balance is %d and %v", balance, err)
+		}
+		given += balance - 16
+	}
+	if given != 5 {
+		t.Fatalf("Expected 5 coins to be given back, instead got %d", given)
+	}
+	if balance, err := store.GetBalance(alice.UUID); err != nil || balance != 9 {
+		t.Fatalf("Expected alice to pay the full 11 coins, instead the balance is %d and %v", balance, err)
+	}
+	history, err := store.GetCoinHistory(bob.UUID)
+	if err != nil || len(history) != 3 || !strings.HasPrefix(history[2].Reference, "rebate:") {
+		t.Fatalf("Expected the rebate in the coin history, instead got %+v and %v", history, err)
+	}
+
+	// Skipping song-b and refunding its bids gives back what the rebates didn't, and no more.
+	skipped, err := store.SkipCurrentSong()
+	if err != nil || len(skipped) != 2 {
+		t.Fatalf("Expected the 2 bids of song-b to be skipped, instead got %v and %v", skipped, err)
+	}
+	if _, err := store.RefundBids([]uuid.UUID{skipped[0].BidId, skipped[1].BidId}, ReasonOperator); err != nil {
+		t.Fatalf("Failed to refund the skipped bids: %v", err)
+	}
+	for _, bidder := range []uuid.NullUUID{bob, carol} {
+		if balance, err := store.GetBalance(bidder.UUID); err != nil || balance != 20 {
+			t.Fatalf("Expected the bidders of song-b to have all 20 coins back, instead the balance is %d and %v", balance, err)
+		}
+	}
+}
+
+func TestAuction(t *testing.T) {
+	eachStore(t, auctionStoreHelper)
+}
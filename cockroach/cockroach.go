@@ -3,31 +3,50 @@ package cockroach
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
+	"os"
+	"sync"
 	"time"
 
+	"github.com/acidleroy/song-bid/events"
+	"github.com/acidleroy/song-bid/ledger"
 	"github.com/cockroachdb/cockroach-go/v2/crdb/crdbpgx"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 type BidRow struct {
 	BidAmount  int
 	SongId     string
 	BidId      uuid.UUID
-	SongStatus int
+	SongStatus SongStatus
 	CreatedAt  time.Time
 	UpdatedAt  time.Time
+	// UserId is the user who placed the bid, it is null for anonymous bids.
+	UserId uuid.NullUUID
+	// RefundReason says why a refunded or cancelled bid was given back.
+	RefundReason RefundReason `json:",omitempty"`
+	// Deferred bids were placed in the lock window before a song change. They don't count for the
+	// song that plays next, only from the round after.
+	Deferred bool `json:",omitempty"`
 }
 
 type PostBidData struct {
 	BidAmount int
 	SongId    string
+	// UserId is set by the server from the authenticated user, it can't be sent by clients.
+	UserId uuid.NullUUID `json:"-"`
 }
 
 type Database struct {
-	connection *pgx.Conn
+	connection *pgxpool.Pool
 	tableName  string
+	ledger     *ledger.Database
+	bus        *events.Bus
+	auctionMu  sync.RWMutex
+	auction    AuctionConfig
 }
 
 func (bid *PostBidData) UnmarshalJSON(b []byte) error {
@@ -47,181 +66,336 @@ func (bid *PostBidData) UnmarshalJSON(b []byte) error {
 
 const databaseName string = "song_bid"
 
-func Connect() *Database {
-	// Connect to the "company_db" database.
-	connectionString := "postgresql://root@localhost:26257/song_bids?sslmode=disable"
-
-	// Connect to the "song-bid" database
-	config, err := pgx.ParseConfig(connectionString)
+// Connect connects to the database described by LoadConfig, i.e. the config file named by
+// SONG_BID_DATABASE_CONFIG and the SONG_BID_DATABASE_* environment variables.
+func Connect() (*Database, error) {
+	config, err := LoadConfig(os.Getenv(EnvConfigFile))
+	if err != nil {
+		return nil, err
+	}
+	return ConnectWithConfig(context.Background(), config)
+}
 
+// ConnectWithConfig opens a connection pool to the database. The pool is safe to share between
+// concurrent http handlers.
+func ConnectWithConfig(ctx context.Context, config Config) (*Database, error) {
+	poolConfig, err := config.poolConfig()
 	if err != nil {
-		log.Fatal("error configuring the database: ", err)
+		return nil, err
 	}
-	config.Database = databaseName
-	conn, err := pgx.ConnectConfig(context.Background(), config)
 
+	pool, err := pgxpool.ConnectConfig(ctx, poolConfig)
 	if err != nil {
-		log.Fatal("error connecting to the database: ", err)
+		return nil, storeError("connect", err)
 	}
 
-	db := Database{connection: conn, tableName: "tbl_bid"}
-	return &db
+	db := Database{connection: pool, tableName: "tbl_bid", ledger: ledger.NewDatabase(pool), bus: events.NewBus()}
+	return &db, nil
 }
 
 func (db *Database) Close() {
 	log.Print("Closing connection")
-	defer db.connection.Close(context.Background())
+	db.connection.Close()
 }
 
 func insertRow(ctx context.Context, tx pgx.Tx, data BidRow) error {
 	// Insert four rows into the "accounts" table.
-	log.Printf("Inserting new row: bidAmount = %d, songId = %s, bidId = %s,  songStatus = %d, createdAt = %s, updatedAt = %s",
+	log.Printf("Inserting new row: bidAmount = %d, songId = %s, bidId = %s,  songStatus = %v, createdAt = %s, updatedAt = %s",
 		data.BidAmount, data.SongId, data.BidId, data.SongStatus, data.CreatedAt, data.UpdatedAt)
 	if _, err := tx.Exec(ctx,
-		"INSERT INTO tbl_bid (bid_id, song_id, bid_amount, song_status, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6)",
-		data.BidId, data.SongId, data.BidAmount, data.SongStatus, data.CreatedAt, data.UpdatedAt); err != nil {
+		"INSERT INTO tbl_bid (bid_id, song_id, bid_amount, song_status, created_at, updated_at, user_id, deferred) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		data.BidId, data.SongId, data.BidAmount, data.SongStatus, data.CreatedAt, data.UpdatedAt, data.UserId, data.Deferred); err != nil {
 		return err
 	}
 	return nil
 }
 
-// PostBid 	creates a new entry in the database for a song that has not yet been played.
+// PostBid 	creates a new entry in the database for a song that has not yet been played. Bids of
+// users are paid from their coin wallet in the same transaction; ledger.ErrInsufficientFunds is
+// returned, and nothing is stored, if the wallet doesn't hold enough coins, and a pricing.RuleError
+// if the bid breaks the pricing rules. Bids placed in the lock window before the end of the playing
+// track are deferred.
 func (db *Database) PostBid(data PostBidData) (result *uuid.UUID, err error) {
+	if err := validateBid(data); err != nil {
+		return nil, err
+	}
 
-	bidId := uuid.New()
-	row := BidRow{data.BidAmount, data.SongId, bidId, 0, time.Now(), time.Now()}
-
+	var row BidRow
 	err = crdbpgx.ExecuteTx(context.Background(), db.connection, pgx.TxOptions{}, func(tx pgx.Tx) error {
-		return insertRow(context.Background(), tx, row)
+		var err error
+		row, err = db.postBidTx(context.Background(), tx, data)
+		return err
 	})
 
 	if err != nil {
-		return nil, err
+		return nil, txError("post bid", err)
 	}
-	return &bidId, nil
+	db.publishBidPlaced(row)
+	return &row.BidId, nil
 
 }
 
-func (db *Database) GetBids() ([]BidRow, error) {
-	rows, err := db.connection.Query(context.Background(), "SELECT * FROM tbl_bid")
+// postBidTx stores a new bid and pays for it in the transaction tx. The pricing rules and the lock
+// window are checked in the same transaction.
+func (db *Database) postBidTx(ctx context.Context, tx pgx.Tx, data PostBidData) (BidRow, error) {
+	banned, err := isSongBanned(ctx, tx, data.SongId)
 	if err != nil {
-		log.Fatal(err)
+		return BidRow{}, err
 	}
-	defer rows.Close()
-	var result []BidRow
-
-	for rows.Next() {
-		bidRow := BidRow{}
-
-		if err := rows.Scan(&bidRow.BidId, &bidRow.SongId, &bidRow.BidAmount, &bidRow.SongStatus, &bidRow.CreatedAt, &bidRow.UpdatedAt); err != nil {
-			log.Fatal(err)
+	if banned {
+		return BidRow{}, fmt.Errorf("%w: %v", ErrSongBanned, data.SongId)
+	}
+	e, err := db.eligibilityTx(ctx, tx)
+	if err != nil {
+		return BidRow{}, err
+	}
+	var queued []BidRow
+	if e.rules.MinIncrement > 0 {
+		// Only the increment depends on what was bid so far.
+		if queued, err = bidsWithStatus(ctx, tx, Queued); err != nil {
+			return BidRow{}, err
 		}
-		//log.Println("bid row = ", bidRow)
-		result = append(result, bidRow)
 	}
-	return result, nil
+	if err := checkBid(e, db.ranking(), data, queued, time.Now()); err != nil {
+		return BidRow{}, err
+	}
+	locked, err := db.isLockedTx(ctx, tx)
+	if err != nil {
+		return BidRow{}, err
+	}
+	bidId := uuid.New()
+	now := time.Now()
+	row := BidRow{BidAmount: data.BidAmount, SongId: data.SongId, BidId: bidId, SongStatus: Queued, CreatedAt: now, UpdatedAt: now, UserId: data.UserId, Deferred: locked}
+	if err := insertRow(ctx, tx, row); err != nil {
+		return BidRow{}, err
+	}
+	if data.UserId.Valid {
+		if _, err := ledger.PostTx(ctx, tx, payForBid(bidId, data)); err != nil {
+			return BidRow{}, err
+		}
+	}
+	return row, nil
 }
 
-func (db *Database) GetHighestBid() (PostBidData, error) {
-	// Sum all unplayed bids and get the highest one
-	rows, err := db.connection.Query(context.Background(), "select SUM(bid_amount) as bid, song_id from tbl_bid where song_status=0 group by song_id order by bid DESC limit 1")
+func (db *Database) GetBids() ([]BidRow, error) {
+	rows, err := db.connection.Query(context.Background(), "SELECT "+bidColumns+" FROM tbl_bid")
 	if err != nil {
-		log.Fatal(err)
+		return nil, storeError("get bids", err)
 	}
 	defer rows.Close()
 
-	bidData := PostBidData{}
-	for rows.Next() {
-		if err := rows.Scan(&bidData.BidAmount, &bidData.SongId); err != nil {
-			log.Fatal(err)
-		}
+	result, err := scanBidRows(rows)
+	if err != nil {
+		return nil, storeError("get bids", err)
 	}
-	return bidData, nil
+	return result, nil
 }
 
-// GetBidsGroupBySongId gets all the songs that haven't been played yet, sums their values by songId and returns the result
-func (db *Database) GetBidsGroupBySongId() ([]PostBidData, error) {
-	// Sum all unplayed bids and get the highest one
-	rows, err := db.connection.Query(context.Background(), "select SUM(bid_amount) as bid, song_id from tbl_bid where song_status=0 group by song_id order by bid DESC")
+// GetBid returns the bid with the given id, or ErrBidNotFound if there is none.
+func (db *Database) GetBid(bidId uuid.UUID) (BidRow, error) {
+	rows, err := db.connection.Query(context.Background(), "SELECT "+bidColumns+" FROM tbl_bid WHERE bid_id = $1", bidId.String())
 	if err != nil {
-		log.Fatal(err)
+		return BidRow{}, storeError("get bid", err)
 	}
 	defer rows.Close()
 
-	bidData := PostBidData{}
-	results := []PostBidData{}
+	result, err := scanBidRows(rows)
+	if err != nil {
+		return BidRow{}, storeError("get bid", err)
+	}
+	if len(result) == 0 {
+		return BidRow{}, fmt.Errorf("get bid: %w: %v", ErrBidNotFound, bidId)
+	}
+	return result[0], nil
+}
+
+// bidColumns lists the columns of tbl_bid in the order scanBidRows expects them.
+const bidColumns string = "bid_id, song_id, bid_amount, song_status, created_at, updated_at, user_id, refund_reason, deferred"
+
+// scanBidRows reads every row of a query that returns bidColumns.
+func scanBidRows(rows pgx.Rows) ([]BidRow, error) {
+	var result []BidRow
+
 	for rows.Next() {
-		if err := rows.Scan(&bidData.BidAmount, &bidData.SongId); err != nil {
-			log.Fatal(err)
+		bidRow := BidRow{}
+		var refundReason string
+
+		if err := rows.Scan(&bidRow.BidId, &bidRow.SongId, &bidRow.BidAmount, &bidRow.SongStatus, &bidRow.CreatedAt, &bidRow.UpdatedAt, &bidRow.UserId, &refundReason, &bidRow.Deferred); err != nil {
+			return nil, err
 		}
-		results = append(results, bidData)
+		bidRow.RefundReason = RefundReason(refundReason)
+		result = append(result, bidRow)
 	}
-	return results, nil
+	return result, rows.Err()
 }
 
-// PlayNextSong plays the song that has the aggregate high bid in the queue. It also sets the
-// the state of all the songs that represent the highest bid to "song_status = 1".  There should
-// only be one song in the bid list that has the status set to 1 because it wouldn't make sense to
-// play two songs simultaneously .
-//The function  returns all the bids for this particular song. It is sufficient to grab the first song in the list
-// to determine what the song id is.
-func (db *Database) PlayNextSong() ([]BidRow, error) { // TODO: Currently bused
+func (db *Database) GetHighestBid() (PostBidData, error) {
+	sums, err := db.rankedSums("get highest bid")
+	if err != nil || len(sums) == 0 {
+		return PostBidData{}, err
+	}
+	return sums[0], nil
+}
 
-	// Find the next song, then set all bids for that song to the "Playing", i.e 1
-	rows, err := db.connection.Query(context.Background(),
-		`UPDATE tbl_bid SET (song_status, updated_at) = (1, $1) FROM (
-		SELECT SUM(bid_amount) as bid, song_id from tbl_bid where song_status=0 group by song_id order by bid DESC limit 1) as tmp
-		WHERE tbl_bid.song_id = tmp.song_id AND tbl_bid.song_status=0 RETURNING tbl_bid.bid_id, tbl_bid.song_id, tbl_bid.bid_amount, tbl_bid.song_status, tbl_bid.created_at, tbl_bid.updated_at;`, time.Now())
+// GetBidsGroupBySongId gets all the songs that haven't been played yet, sums their values by songId and returns the result
+// in the order the auction strategy plays them.
+func (db *Database) GetBidsGroupBySongId() ([]PostBidData, error) {
+	return db.rankedSums("get bids grouped by song")
+}
 
+// rankedSums sums the queued bids by song, in the order the auction strategy plays them. Deferred
+// bids, and songs that can't play next, are left out.
+func (db *Database) rankedSums(op string) ([]PostBidData, error) {
+	var queued []BidRow
+	var e eligibility
+	err := crdbpgx.ExecuteTx(context.Background(), db.connection, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+		var err error
+		if e, err = db.eligibilityTx(context.Background(), tx); err != nil {
+			return err
+		}
+		queued, err = bidsWithStatus(context.Background(), tx, Queued)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, storeError(op, err)
 	}
-	defer rows.Close()
+	eligible, _ := splitDeferred(queued)
+	now := time.Now()
+	winnable, _ := e.split(scoreSongs(db.ranking(), groupBySong(eligible), now), now)
+	return summedBids(winnable), nil
+}
+
+// PlayNextSong plays the song the auction strategy ranks first in the queue, among those that meet
+// their reserve price and aren't held back by a cooldown. It sets the state of all the bids for
+// that song to playing, records that the song started and returns them; it is sufficient to grab
+// the first bid in the list to determine what the song id is. Only one song may play at a time, so
+// ErrSongAlreadyPlaying is returned while another song is playing and ErrNoSongQueued when no song
+// with queued bids that aren't deferred may play. With a Pricer strategy the bidders get back what the
+// song paid above its price. Deferred bids only sit out one round: they count again afterwards,
+// even when no song was queued. The check, the update and the rebates run in a single transaction.
+func (db *Database) PlayNextSong() ([]BidRow, error) {
 	var result []BidRow
+	noSongQueued := false
+	err := crdbpgx.ExecuteTx(context.Background(), db.connection, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		ctx := context.Background()
 
-	for rows.Next() {
-		bidRow := BidRow{}
+		var playing int
+		if err := tx.QueryRow(ctx, "SELECT count(*) FROM tbl_bid WHERE song_status = $1", Playing).Scan(&playing); err != nil {
+			return err
+		}
+		if playing > 0 {
+			return ErrSongAlreadyPlaying
+		}
 
-		if err := rows.Scan(&bidRow.BidId, &bidRow.SongId, &bidRow.BidAmount, &bidRow.SongStatus, &bidRow.CreatedAt, &bidRow.UpdatedAt); err != nil {
-			log.Fatal(err)
+		// Rank the queued songs, then set all bids for the first one to playing, including those
+		// that were deferred.
+		queued, err := bidsWithStatus(ctx, tx, Queued)
+		if err != nil {
+			return err
 		}
-		// log.Println("bid row = ", bidRow)
-		result = append(result, bidRow)
+		e, err := db.eligibilityTx(ctx, tx)
+		if err != nil {
+			return err
+		}
+		eligible, _ := splitDeferred(queued)
+		r := db.ranking()
+		now := time.Now()
+		scored, _ := e.split(scoreSongs(r, groupBySong(eligible), now), now)
+		if err := releaseDeferredTx(ctx, tx); err != nil {
+			return err
+		}
+		// The released bids have to be committed, so ErrNoSongQueued is returned after the
+		// transaction.
+		noSongQueued = len(scored) == 0
+		if noSongQueued {
+			return nil
+		}
+		if result, err = transitionRows(ctx, tx, Queued, Playing, "song_id = $3", scored[0].bids.SongId); err != nil {
+			return err
+		}
+		if err := insertSongPlayTx(ctx, tx, newSongPlay(e, result, now)); err != nil {
+			return err
+		}
+		for _, rebate := range rebates(r, e, scored, now) {
+			if _, err := ledger.PostTx(ctx, tx, rebate); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil && noSongQueued {
+		// Deferred bids may have joined the ranking.
+		db.publishQueue()
+		err = ErrNoSongQueued
 	}
+	if err != nil {
+		return nil, txError("play next song", err)
+	}
+	db.bus.Publish(events.SongStarted, PublicBids(result))
+	db.publishQueue()
 	return result, nil
 }
 
+// FinalizeCurrentSong marks the bids of the playing song as played and returns them.
+// ErrNoSongPlaying is returned when no song is playing.
 func (db *Database) FinalizeCurrentSong() ([]BidRow, error) {
-	// Find all songs that have status set to 1, and change it to 2, essentially marking the song as played.
-	rows, err := db.connection.Query(context.Background(),
-		`UPDATE tbl_bid SET (song_status, updated_at) = (2, $1) FROM (
-		SELECT * from tbl_bid where song_status=1 ) as tmp
-		WHERE tbl_bid.bid_id = tmp.bid_id AND  RETURNING tbl_bid.bid_id, tbl_bid.song_id, tbl_bid.bid_amount, tbl_bid.song_status, tbl_bid.created_at, tbl_bid.updated_at;`, time.Now())
+	return db.finishCurrentSong("finalize current song", Played)
+}
+
+// SkipCurrentSong marks the bids of the playing song as skipped, e.g. because the operator stopped
+// it early, and returns them. Skipped bids may later be refunded. ErrNoSongPlaying is returned when
+// no song is playing.
+func (db *Database) SkipCurrentSong() ([]BidRow, error) {
+	return db.finishCurrentSong("skip current song", Skipped)
+}
 
+func (db *Database) finishCurrentSong(op string, status SongStatus) ([]BidRow, error) {
+	var result []BidRow
+	err := crdbpgx.ExecuteTx(context.Background(), db.connection, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		var err error
+		result, err = transitionRows(context.Background(), tx, Playing, status, "TRUE")
+		if err != nil {
+			return err
+		}
+		if len(result) == 0 {
+			return ErrNoSongPlaying
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return nil, txError(op, err)
 	}
-	defer rows.Close()
-	var result []BidRow
+	db.bus.Publish(events.SongFinished, PublicBids(result))
+	return result, nil
+}
 
-	for rows.Next() {
-		bidRow := BidRow{}
+// transitionRows moves the bids with status from that also match the where clause to status to. The
+// where clause may use $3 and onwards for its arguments.
+func transitionRows(ctx context.Context, tx pgx.Tx, from, to SongStatus, where string, args ...interface{}) ([]BidRow, error) {
+	if !canTransition(from, to) {
+		return nil, fmt.Errorf("%w: from %v to %v", ErrInvalidTransition, from, to)
+	}
 
-		if err := rows.Scan(&bidRow.BidId, &bidRow.SongId, &bidRow.BidAmount, &bidRow.SongStatus, &bidRow.CreatedAt, &bidRow.UpdatedAt); err != nil {
-			log.Fatal(err)
-		}
-		// log.Println("bid row = ", bidRow)
-		result = append(result, bidRow)
+	rows, err := tx.Query(ctx,
+		"UPDATE tbl_bid SET (song_status, updated_at) = ($1, now()) WHERE song_status = $2 AND "+where+" RETURNING "+bidColumns,
+		append([]interface{}{to, from}, args...)...)
+	if err != nil {
+		return nil, err
 	}
-	return result, nil
+	defer rows.Close()
+	return scanBidRows(rows)
 }
 
 func (db *Database) ClearRows() error {
 	log.Println("WARNING: cleared all rows from table.")
-	return crdbpgx.ExecuteTx(context.Background(), db.connection, pgx.TxOptions{}, func(tx pgx.Tx) error {
-		if _, err := tx.Exec(context.Background(), "TRUNCATE tbl_bid"); err != nil {
+	err := crdbpgx.ExecuteTx(context.Background(), db.connection, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(context.Background(), "TRUNCATE tbl_bid, song_plays"); err != nil {
 			return err
 		}
 		return nil
 	})
+	if err != nil {
+		return storeError("clear rows", err)
+	}
+	return nil
 }
@@ -1,20 +1,62 @@
 package cockroach
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"testing"
+
+	"github.com/acidleroy/song-bid/ledger"
+	"github.com/acidleroy/song-bid/pricing"
+	"github.com/google/uuid"
 )
 
-//cockroach sql --insecure --host=localhost:26257
+// connectHelper connects to the local test database, migrates it to the latest schema and fails
+// the test if it can't.
+func connectHelper(t *testing.T) *Database {
+	db, err := Connect()
+	if err != nil {
+		t.Logf("Failed to connect to the database: %v", err)
+		t.FailNow()
+	}
+	if err := db.Migrate(context.Background()); err != nil {
+		db.Close()
+		t.Logf("Failed to migrate the database: %v", err)
+		t.FailNow()
+	}
+	return db
+}
+
+// eachStore runs test against a new MemoryStore and against the test database, each as a subtest
+// on a store holding no bids. The pricing rules of the database are reset afterwards.
+func eachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("MemoryStore", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+	t.Run("Database", func(t *testing.T) {
+		db := connectHelper(t)
+		defer db.Close()
+		if err := db.ClearRows(); err != nil {
+			t.Logf("Failed to clear rows: %v", err)
+			t.FailNow()
+		}
+		defer db.ClearRows()
+		defer db.SetPricingRules(DefaultRoom, pricing.Rules{})
+
+		test(t, db)
+	})
+}
+
+// cockroach sql --insecure --host=localhost:26257
 func TestConnection(t *testing.T) {
 	t.Log("Testing for valid connection")
-	db := Connect()
+	db := connectHelper(t)
 	defer db.Close()
 }
 
 func TestPostBid(t *testing.T) {
-	db := Connect()
+	db := connectHelper(t)
 
 	defer db.Close()
 	defer db.ClearRows()
@@ -60,7 +102,7 @@ func TestPostBidDataUnmarshalBadJson(t *testing.T) {
 
 func TestGetBids(t *testing.T) {
 	t.Log("Testing GetBids")
-	db := Connect()
+	db := connectHelper(t)
 	defer db.Close()
 
 	bids, err := db.GetBids()
@@ -82,7 +124,7 @@ func TestGetBids(t *testing.T) {
 
 func TestGetBidsGroupBySongId(t *testing.T) {
 	t.Log("Testing getting the next song to play")
-	db := Connect()
+	db := connectHelper(t)
 	defer db.Close()
 	defer db.ClearRows()
 
@@ -127,7 +169,7 @@ func TestGetBidsGroupBySongId(t *testing.T) {
 func TestGetNextSong(t *testing.T) {
 
 	t.Log("Testing getting the next song to play")
-	db := Connect()
+	db := connectHelper(t)
 	defer db.Close()
 	defer db.ClearRows()
 
@@ -166,7 +208,7 @@ func TestGetNextSong(t *testing.T) {
 
 func TestGetNextSongEmpty(t *testing.T) {
 	t.Log("Testing getting the next song to play when there is no song")
-	db := Connect()
+	db := connectHelper(t)
 	defer db.Close()
 	defer db.ClearRows()
 
@@ -184,7 +226,7 @@ func TestGetNextSongEmpty(t *testing.T) {
 
 func TestPlayNextSong(t *testing.T) {
 	t.Log("Testing PlayNextSong")
-	db := Connect()
+	db := connectHelper(t)
 	defer db.Close()
 	defer db.ClearRows()
 
@@ -224,12 +266,12 @@ func TestPlayNextSong(t *testing.T) {
 
 func TestPlayNextSongEmpytList(t *testing.T) {
 	t.Log("Testing PlayNextSong")
-	db := Connect()
+	db := connectHelper(t)
 	defer db.Close()
 	defer db.ClearRows()
 
-	if rows, err := db.PlayNextSong(); err != nil {
-		t.Logf("Received an error when attempting to play next song %v", err)
+	if rows, err := db.PlayNextSong(); !errors.Is(err, ErrNoSongQueued) {
+		t.Logf("Expected ErrNoSongQueued when attempting to play next song, received %v", err)
 		t.FailNow()
 	} else {
 		if len(rows) != 0 {
@@ -248,7 +290,7 @@ func playNextSongHelper(t *testing.T, db *Database) {
 
 func TestFinalizePlayingSongs(t *testing.T) {
 	t.Log("Testing PlayNextSong")
-	db := Connect()
+	db := connectHelper(t)
 	defer db.Close()
 	defer db.ClearRows()
 	bids := []PostBidData{
@@ -290,3 +332,125 @@ func TestFinalizePlayingSongs(t *testing.T) {
 	}
 
 }
+
+func TestPlayNextSongWhilePlaying(t *testing.T) {
+	t.Log("Testing PlayNextSong while another song is playing")
+	db := connectHelper(t)
+	defer db.Close()
+	defer db.ClearRows()
+
+	bids := []PostBidData{
+		{BidAmount: 2, SongId: "song-a"},
+		{BidAmount: 5, SongId: "song-b"},
+	}
+
+	for _, bid := range bids {
+		if _, err := db.PostBid(bid); err != nil {
+			t.Logf("Failed to post bid: %v", err)
+			t.FailNow()
+		}
+	}
+
+	playNextSongHelper(t, db)
+	if _, err := db.PlayNextSong(); !errors.Is(err, ErrSongAlreadyPlaying) {
+		t.Logf("Expected ErrSongAlreadyPlaying, received %v", err)
+		t.FailNow()
+	}
+
+	if _, err := db.FinalizeCurrentSong(); err != nil {
+		t.Logf("Could not finalize current song, got an error: %v\n", err)
+		t.FailNow()
+	}
+	if _, err := db.FinalizeCurrentSong(); !errors.Is(err, ErrNoSongPlaying) {
+		t.Logf("Expected ErrNoSongPlaying when finalizing twice, received %v", err)
+		t.FailNow()
+	}
+
+	rows, err := db.PlayNextSong()
+	if err != nil || len(rows) != 1 || rows[0].SongId != "song-a" {
+		t.Logf("Expected song-a to play next, instead got %v and %v", rows, err)
+		t.FailNow()
+	}
+}
+
+func TestSkipAndRefundBids(t *testing.T) {
+	t.Log("Testing SkipCurrentSong and RefundBids")
+	db := connectHelper(t)
+	defer db.Close()
+	defer db.ClearRows()
+
+	skippedId, err := db.PostBid(PostBidData{BidAmount: 5, SongId: "song-a"})
+	if err != nil {
+		t.Logf("Failed to post bid: %v", err)
+		t.FailNow()
+	}
+	queuedId, err := db.PostBid(PostBidData{BidAmount: 1, SongId: "song-b"})
+	if err != nil {
+		t.Logf("Failed to post bid: %v", err)
+		t.FailNow()
+	}
+
+	playNextSongHelper(t, db)
+	if rows, err := db.SkipCurrentSong(); err != nil || len(rows) != 1 || rows[0].SongStatus != Skipped {
+		t.Logf("Expected song-a to be skipped, instead got %v and %v", rows, err)
+		t.FailNow()
+	}
+
+	rows, err := db.RefundBids([]uuid.UUID{*skippedId, *queuedId}, ReasonOperator)
+	if err != nil || len(rows) != 2 {
+		t.Logf("Expected both bids to be refunded, instead got %v and %v", rows, err)
+		t.FailNow()
+	}
+
+	if _, err := db.RefundBids([]uuid.UUID{*queuedId}, ReasonOperator); !errors.Is(err, ErrInvalidTransition) {
+		t.Logf("Expected ErrInvalidTransition when refunding twice, received %v", err)
+		t.FailNow()
+	}
+	if _, err := db.RefundBids([]uuid.UUID{uuid.New()}, ReasonOperator); !errors.Is(err, ErrBidNotFound) {
+		t.Logf("Expected ErrBidNotFound for an unknown bid, received %v", err)
+		t.FailNow()
+	}
+}
+
+func TestPostBidPaysFromWallet(t *testing.T) {
+	db := connectHelper(t)
+	defer db.Close()
+	defer db.ClearRows()
+
+	user, err := db.CreateUser("wallet-"+uuid.New().String()[:8], "hash")
+	if err != nil {
+		t.Logf("Failed to create user: %v", err)
+		t.FailNow()
+	}
+	userId := uuid.NullUUID{UUID: user.UserId, Valid: true}
+
+	if _, err := db.PostBid(PostBidData{BidAmount: 1, SongId: "song-a", UserId: userId}); !errors.Is(err, ledger.ErrInsufficientFunds) {
+		t.Logf("Expected ErrInsufficientFunds with an empty wallet, received %v", err)
+		t.FailNow()
+	}
+	if err := db.CreditCoins(user.UserId, 5, "purchase:"+user.UserId.String()); err != nil {
+		t.Logf("Failed to credit coins: %v", err)
+		t.FailNow()
+	}
+	if err := db.CreditCoins(user.UserId, 5, "purchase:"+user.UserId.String()); !errors.Is(err, ledger.ErrDuplicateReference) {
+		t.Logf("Expected ErrDuplicateReference when crediting twice, received %v", err)
+		t.FailNow()
+	}
+	if _, err := db.PostBid(PostBidData{BidAmount: 3, SongId: "song-a", UserId: userId}); err != nil {
+		t.Logf("Failed to post bid: %v", err)
+		t.FailNow()
+	}
+	if _, err := db.PostBid(PostBidData{BidAmount: 3, SongId: "song-b", UserId: userId}); !errors.Is(err, ledger.ErrInsufficientFunds) {
+		t.Logf("Expected ErrInsufficientFunds for a bid over the balance, received %v", err)
+		t.FailNow()
+	}
+
+	if balance, err := db.GetBalance(user.UserId); err != nil || balance != 2 {
+		t.Logf("Expected 2 coins to be left, instead got %d and %v", balance, err)
+		t.FailNow()
+	}
+	if bids, err := db.GetBidsByUser(user.UserId); err != nil || len(bids) != 1 {
+		t.Logf("Expected only the paid bid to be stored, instead got %v and %v", bids, err)
+		t.FailNow()
+	}
+}
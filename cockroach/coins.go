@@ -0,0 +1,91 @@
+package cockroach
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/acidleroy/song-bid/ledger"
+	"github.com/google/uuid"
+)
+
+// CoinStore describes the coin wallets of the users. The coins are kept in a ledger; bids of users
+// are paid from their wallet when they are posted.
+type CoinStore interface {
+	// GetBalance returns the number of coins the user can spend.
+	GetBalance(userId uuid.UUID) (int64, error)
+	// GetCoinHistory returns the ledger transactions of the user's wallet, oldest first.
+	GetCoinHistory(userId uuid.UUID) ([]ledger.Transaction, error)
+	// CreditCoins adds coins the user bought to their wallet. The reference is unique, crediting
+	// it a second time fails with ledger.ErrDuplicateReference.
+	CreditCoins(userId uuid.UUID, amount int64, reference string) error
+}
+
+// bidReference is the ledger reference of the transaction that pays for a bid.
+func bidReference(bidId uuid.UUID) string {
+	return "bid:" + bidId.String()
+}
+
+// payForBid returns the ledger transaction that moves the coins of a bid from the bidder's wallet.
+func payForBid(bidId uuid.UUID, data PostBidData) ledger.Transaction {
+	return ledger.Transfer(bidReference(bidId), ledger.UserAccount(data.UserId.UUID), ledger.BidsAccount, int64(data.BidAmount))
+}
+
+// coinCredit returns the ledger transaction that adds bought coins to a user's wallet.
+func coinCredit(userId uuid.UUID, amount int64, reference string) (ledger.Transaction, error) {
+	if amount <= 0 {
+		return ledger.Transaction{}, fmt.Errorf("%w: credit of %d coins", ledger.ErrInvalidTransaction, amount)
+	}
+	return ledger.Transfer(reference, ledger.IssuedAccount, ledger.UserAccount(userId), amount), nil
+}
+
+// GetBalance returns the number of coins the user can spend.
+func (db *Database) GetBalance(userId uuid.UUID) (int64, error) {
+	balance, err := db.ledger.Balance(context.Background(), ledger.UserAccount(userId))
+	if err != nil {
+		return 0, storeError("get balance", err)
+	}
+	return balance, nil
+}
+
+// GetCoinHistory returns the ledger transactions of the user's wallet, oldest first.
+func (db *Database) GetCoinHistory(userId uuid.UUID) ([]ledger.Transaction, error) {
+	history, err := db.ledger.History(context.Background(), ledger.UserAccount(userId))
+	if err != nil {
+		return nil, storeError("get coin history", err)
+	}
+	return history, nil
+}
+
+// CreditCoins adds coins the user bought to their wallet.
+func (db *Database) CreditCoins(userId uuid.UUID, amount int64, reference string) error {
+	t, err := coinCredit(userId, amount, reference)
+	if err != nil {
+		return err
+	}
+	if _, err := db.ledger.Post(context.Background(), t); err != nil {
+		return txError("credit coins", err)
+	}
+	return nil
+}
+
+// GetBalance returns the number of coins the user can spend.
+func (m *MemoryStore) GetBalance(userId uuid.UUID) (int64, error) {
+	return m.ledger.Balance(context.Background(), ledger.UserAccount(userId))
+}
+
+// GetCoinHistory returns the ledger transactions of the user's wallet, oldest first.
+func (m *MemoryStore) GetCoinHistory(userId uuid.UUID) ([]ledger.Transaction, error) {
+	return m.ledger.History(context.Background(), ledger.UserAccount(userId))
+}
+
+// CreditCoins adds coins the user bought to their wallet.
+func (m *MemoryStore) CreditCoins(userId uuid.UUID, amount int64, reference string) error {
+	t, err := coinCredit(userId, amount, reference)
+	if err != nil {
+		return err
+	}
+	if _, err := m.ledger.Post(context.Background(), t); err != nil {
+		return fmt.Errorf("credit coins: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package cockroach
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Environment variables read by LoadConfig. They take precedence over the values of a config file.
+const (
+	EnvConfigFile       = "SONG_BID_DATABASE_CONFIG"
+	EnvDSN              = "SONG_BID_DATABASE_URL"
+	EnvSSLMode          = "SONG_BID_DATABASE_SSLMODE"
+	EnvSSLRootCert      = "SONG_BID_DATABASE_SSLROOTCERT"
+	EnvSSLCert          = "SONG_BID_DATABASE_SSLCERT"
+	EnvSSLKey           = "SONG_BID_DATABASE_SSLKEY"
+	EnvMaxConns         = "SONG_BID_DATABASE_MAX_CONNS"
+	EnvStatementTimeout = "SONG_BID_DATABASE_STATEMENT_TIMEOUT"
+	EnvApplicationName  = "SONG_BID_DATABASE_APPLICATION_NAME"
+)
+
+const defaultDSN string = "postgresql://root@localhost:26257/" + databaseName + "?sslmode=disable"
+
+// Config describes how to connect to the CockroachDB cluster. The TLS settings are added to the DSN
+// as query parameters, so they only need to be set when the DSN doesn't already contain them.
+type Config struct {
+	// DSN is a postgresql:// URL including the database name.
+	DSN string `json:"dsn"`
+	// SSLMode is one of disable, require, verify-ca or verify-full.
+	SSLMode     string `json:"sslmode"`
+	SSLRootCert string `json:"sslrootcert"`
+	SSLCert     string `json:"sslcert"`
+	SSLKey      string `json:"sslkey"`
+	// MaxConns limits the size of the connection pool. Zero uses the pgxpool default.
+	MaxConns int32 `json:"max_conns"`
+	// StatementTimeout aborts any statement that runs longer. Zero means no timeout.
+	StatementTimeout time.Duration `json:"-"`
+	// ApplicationName shows up in the CockroachDB console and in crdb_internal tables.
+	ApplicationName string `json:"application_name"`
+}
+
+// DefaultConfig connects to an insecure local single node cluster.
+func DefaultConfig() Config {
+	return Config{
+		DSN:             defaultDSN,
+		MaxConns:        10,
+		ApplicationName: "song-bid",
+	}
+}
+
+// LoadConfig starts with DefaultConfig, applies the JSON config file at path, if path is not empty,
+// and finally applies the SONG_BID_DATABASE_* environment variables.
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+
+	if path != "" {
+		if err := config.readFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := config.readEnv(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+func (c *Config) readFile(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read database config %v: %w", path, err)
+	}
+
+	// The statement timeout is written as a duration string, e.g. "5s", in the file.
+	type T2 Config
+	file := struct {
+		*T2
+		StatementTimeout string `json:"statement_timeout"`
+	}{T2: (*T2)(c)}
+
+	if err := json.Unmarshal(buf, &file); err != nil {
+		return fmt.Errorf("could not parse database config %v: %w", path, err)
+	}
+
+	if file.StatementTimeout != "" {
+		timeout, err := time.ParseDuration(file.StatementTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid statement_timeout in %v: %w", path, err)
+		}
+		c.StatementTimeout = timeout
+	}
+	return nil
+}
+
+func (c *Config) readEnv() error {
+	fields := map[string]*string{
+		EnvDSN:             &c.DSN,
+		EnvSSLMode:         &c.SSLMode,
+		EnvSSLRootCert:     &c.SSLRootCert,
+		EnvSSLCert:         &c.SSLCert,
+		EnvSSLKey:          &c.SSLKey,
+		EnvApplicationName: &c.ApplicationName,
+	}
+	for name, field := range fields {
+		if value, ok := os.LookupEnv(name); ok {
+			*field = value
+		}
+	}
+
+	if value, ok := os.LookupEnv(EnvMaxConns); ok {
+		maxConns, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid %v: %w", EnvMaxConns, err)
+		}
+		c.MaxConns = int32(maxConns)
+	}
+
+	if value, ok := os.LookupEnv(EnvStatementTimeout); ok {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %v: %w", EnvStatementTimeout, err)
+		}
+		c.StatementTimeout = timeout
+	}
+	return nil
+}
+
+// poolConfig turns the Config into the pgxpool configuration used by ConnectWithConfig.
+func (c Config) poolConfig() (*pgxpool.Config, error) {
+	dsn, err := url.Parse(c.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database DSN: %w", err)
+	}
+
+	query := dsn.Query()
+	for name, value := range map[string]string{
+		"sslmode":     c.SSLMode,
+		"sslrootcert": c.SSLRootCert,
+		"sslcert":     c.SSLCert,
+		"sslkey":      c.SSLKey,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	dsn.RawQuery = query.Encode()
+
+	config, err := pgxpool.ParseConfig(dsn.String())
+	if err != nil {
+		return nil, fmt.Errorf("error configuring the database: %w", err)
+	}
+
+	if c.MaxConns > 0 {
+		config.MaxConns = c.MaxConns
+	}
+	if c.ApplicationName != "" {
+		config.ConnConfig.RuntimeParams["application_name"] = c.ApplicationName
+	}
+	if c.StatementTimeout > 0 {
+		config.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10)
+	}
+	return config, nil
+}
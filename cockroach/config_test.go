@@ -0,0 +1,79 @@
+package cockroach
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	config, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("Failed to load default config: %v", err)
+	}
+	if config.DSN != defaultDSN {
+		t.Fatalf("Expected the default DSN %v, instead got %v", defaultDSN, config.DSN)
+	}
+}
+
+func TestLoadConfigFileAndEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	contents := `{
+		"dsn": "postgresql://songbid@db.example.com:26257/song_bid",
+		"sslmode": "verify-full",
+		"sslrootcert": "/certs/ca.crt",
+		"max_conns": 4,
+		"statement_timeout": "5s",
+		"application_name": "from-file"
+	}`
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	t.Setenv(EnvApplicationName, "from-env")
+	t.Setenv(EnvMaxConns, "8")
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if config.SSLMode != "verify-full" || config.SSLRootCert != "/certs/ca.crt" {
+		t.Fatalf("Expected the TLS settings from the file, instead got %+v", config)
+	}
+	if config.StatementTimeout != 5*time.Second {
+		t.Fatalf("Expected a statement timeout of 5s, instead got %v", config.StatementTimeout)
+	}
+	if config.ApplicationName != "from-env" || config.MaxConns != 8 {
+		t.Fatalf("Expected the environment to override the file, instead got %+v", config)
+	}
+
+	// The CA file doesn't exist here, so only ask for an encrypted connection.
+	config.SSLMode, config.SSLRootCert = "require", ""
+	poolConfig, err := config.poolConfig()
+	if err != nil {
+		t.Fatalf("Failed to build the pool config: %v", err)
+	}
+	if poolConfig.MaxConns != 8 {
+		t.Fatalf("Expected MaxConns to be 8, instead got %d", poolConfig.MaxConns)
+	}
+	if poolConfig.ConnConfig.Database != databaseName || poolConfig.ConnConfig.Host != "db.example.com" {
+		t.Fatalf("Expected to connect to %v on db.example.com, instead got %v on %v",
+			databaseName, poolConfig.ConnConfig.Database, poolConfig.ConnConfig.Host)
+	}
+	if poolConfig.ConnConfig.TLSConfig == nil {
+		t.Fatal("Expected sslmode=require to enable TLS")
+	}
+	params := poolConfig.ConnConfig.RuntimeParams
+	if params["statement_timeout"] != "5000" || params["application_name"] != "from-env" {
+		t.Fatalf("Expected statement_timeout and application_name runtime params, instead got %v", params)
+	}
+}
+
+func TestLoadConfigInvalidEnv(t *testing.T) {
+	t.Setenv(EnvStatementTimeout, "soon")
+
+	if _, err := LoadConfig(""); err == nil {
+		t.Fatal("Expected an error for an invalid statement timeout")
+	}
+}
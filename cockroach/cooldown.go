@@ -0,0 +1,198 @@
+package cockroach
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/acidleroy/song-bid/pricing"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// artistLimitWindow is the period in which Cooldowns.ArtistLimit songs of an artist may start.
+const artistLimitWindow time.Duration = time.Hour
+
+// Cooldowns keep the same song or artist from winning round after round. The zero value doesn't
+// restrict anything.
+type Cooldowns struct {
+	// Track is how long a song can't play again after it started, 0 for no cooldown.
+	Track time.Duration
+	// ArtistLimit is how many songs of an artist may start within an hour, 0 for no limit. The
+	// artist of a song is the one the operator set, songs without one aren't limited.
+	ArtistLimit int
+}
+
+// lookback is how far back the songs that started matter to the cooldowns, 0 if they don't.
+func (c Cooldowns) lookback() time.Duration {
+	var lookback time.Duration
+	if c.Track > 0 {
+		lookback = c.Track
+	}
+	if c.ArtistLimit > 0 && artistLimitWindow > lookback {
+		lookback = artistLimitWindow
+	}
+	return lookback
+}
+
+// SongPlay records that a song started playing, with the artists it had when it started.
+type SongPlay struct {
+	SongId    string
+	Artists   []string
+	StartedAt time.Time
+}
+
+// newSongPlay records the start of the song whose bids were moved to playing.
+func newSongPlay(e eligibility, playing []BidRow, now time.Time) SongPlay {
+	song := e.pricingSong(SongBids{SongId: playing[0].SongId, Bids: playing})
+	return SongPlay{SongId: song.SongId, Artists: song.Artists, StartedAt: now}
+}
+
+// check returns why the cooldowns keep the song from playing at now, and when they stop to, given
+// the songs that started before. The reason is empty if the song may play.
+func (c Cooldowns) check(song pricing.Song, plays []SongPlay, now time.Time) (IneligibleReason, time.Time) {
+	if c.Track > 0 {
+		var last time.Time
+		for _, play := range plays {
+			if play.SongId == song.SongId && play.StartedAt.After(last) {
+				last = play.StartedAt
+			}
+		}
+		if until := last.Add(c.Track); !last.IsZero() && now.Before(until) {
+			return IneligibleCooldown, until
+		}
+	}
+
+	if c.ArtistLimit > 0 {
+		var latest time.Time
+		for _, artist := range song.Artists {
+			var started []time.Time
+			for _, play := range plays {
+				if now.Sub(play.StartedAt) < artistLimitWindow && namesArtist(play.Artists, artist) {
+					started = append(started, play.StartedAt)
+				}
+			}
+			if len(started) < c.ArtistLimit {
+				continue
+			}
+			// The artist may play again once enough of its songs dropped out of the window.
+			sort.Slice(started, func(i, j int) bool { return started[i].Before(started[j]) })
+			if until := started[len(started)-c.ArtistLimit].Add(artistLimitWindow); until.After(latest) {
+				latest = until
+			}
+		}
+		if !latest.IsZero() {
+			return IneligibleArtistLimit, latest
+		}
+	}
+	return "", time.Time{}
+}
+
+// namesArtist reports whether the artist is one of artists, without regard to case.
+func namesArtist(artists []string, artist string) bool {
+	for _, named := range artists {
+		if strings.EqualFold(named, artist) {
+			return true
+		}
+	}
+	return false
+}
+
+// eligibility decides, besides the bids, which queued songs may play next: those that meet their
+// reserve price and aren't held back by a cooldown.
+type eligibility struct {
+	rules     pricing.Rules
+	cooldowns Cooldowns
+	// plays are the songs that started within the lookback of the cooldowns.
+	plays []SongPlay
+	// artists are the artists the operator set, by song id.
+	artists map[string]string
+}
+
+// ineligibleSong is a scored song that can't play next, why, and until when if that is known.
+type ineligibleSong struct {
+	scoredSong
+	reason IneligibleReason
+	until  time.Time
+}
+
+// split separates the scored songs that may play next from those that can't, keeping their order.
+// A song held back by a cooldown is reported for that before its reserve.
+func (e eligibility) split(scored []scoredSong, now time.Time) (winnable []scoredSong, ineligible []ineligibleSong) {
+	for _, song := range scored {
+		priced := e.pricingSong(song.bids)
+		if reason, until := e.cooldowns.check(priced, e.plays, now); reason != "" {
+			ineligible = append(ineligible, ineligibleSong{scoredSong: song, reason: reason, until: until})
+		} else if !e.rules.MeetsReserve(priced) {
+			ineligible = append(ineligible, ineligibleSong{scoredSong: song, reason: IneligibleBelowReserve})
+		} else {
+			winnable = append(winnable, song)
+		}
+	}
+	return winnable, ineligible
+}
+
+// cooldowns returns the cooldowns applied when the next song is chosen.
+func (db *Database) cooldowns() Cooldowns {
+	db.auctionMu.RLock()
+	defer db.auctionMu.RUnlock()
+	return db.auction.Cooldowns
+}
+
+// eligibilityTx reads the pricing rules, the song artists and the songs that started recently in the
+// transaction tx.
+func (db *Database) eligibilityTx(ctx context.Context, tx pgx.Tx) (eligibility, error) {
+	rules, err := pricingRulesTx(ctx, tx, DefaultRoom)
+	if err != nil {
+		return eligibility{}, err
+	}
+	artists, err := songArtistsTx(ctx, tx)
+	if err != nil {
+		return eligibility{}, err
+	}
+	e := eligibility{rules: rules, cooldowns: db.cooldowns(), artists: artists}
+	lookback := e.cooldowns.lookback()
+	if lookback <= 0 {
+		return e, nil
+	}
+
+	rows, err := tx.Query(ctx, "SELECT song_id, artists, started_at FROM song_plays WHERE room = $1 AND started_at > $2",
+		DefaultRoom, time.Now().Add(-lookback))
+	if err != nil {
+		return eligibility{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		play := SongPlay{}
+		if err := rows.Scan(&play.SongId, &play.Artists, &play.StartedAt); err != nil {
+			return eligibility{}, err
+		}
+		e.plays = append(e.plays, play)
+	}
+	return e, rows.Err()
+}
+
+// insertSongPlayTx records the start of a song in the transaction tx.
+func insertSongPlayTx(ctx context.Context, tx pgx.Tx, play SongPlay) error {
+	artists := play.Artists
+	if artists == nil {
+		artists = []string{}
+	}
+	_, err := tx.Exec(ctx, "INSERT INTO song_plays (play_id, room, song_id, artists, started_at) VALUES ($1, $2, $3, $4, $5)",
+		uuid.New(), DefaultRoom, play.SongId, artists, play.StartedAt)
+	return err
+}
+
+// eligibility returns the pricing rules, the song artists and the songs that started recently. The
+// caller must hold m.mu.
+func (m *MemoryStore) eligibility() eligibility {
+	e := eligibility{rules: m.pricing[DefaultRoom].Clone(), cooldowns: m.auction.Cooldowns, artists: m.artists}
+	lookback := e.cooldowns.lookback()
+	for _, play := range m.plays {
+		if lookback > 0 && m.now().Sub(play.StartedAt) < lookback {
+			e.plays = append(e.plays, play)
+		}
+	}
+	return e
+}
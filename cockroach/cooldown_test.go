@@ -0,0 +1,106 @@
+package cockroach
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/acidleroy/song-bid/pricing"
+)
+
+func TestCooldownsCheck(t *testing.T) {
+	now := time.Now()
+	plays := []SongPlay{
+		{SongId: "song-a", Artists: []string{"The Band"}, StartedAt: now.Add(-50 * time.Minute)},
+		{SongId: "song-b", Artists: []string{"the band"}, StartedAt: now.Add(-20 * time.Minute)},
+		{SongId: "song-a", StartedAt: now.Add(-10 * time.Minute)},
+	}
+	cooldowns := Cooldowns{Track: 30 * time.Minute, ArtistLimit: 2}
+
+	for _, test := range []struct {
+		song   pricing.Song
+		reason IneligibleReason
+		until  time.Time
+	}{
+		// The last play counts for the cooldown.
+		{pricing.Song{SongId: "song-a"}, IneligibleCooldown, now.Add(20 * time.Minute)},
+		// Two songs of the band started in the last hour, the first one drops out in 10 minutes.
+		{pricing.Song{SongId: "song-d", Artists: []string{"THE BAND"}}, IneligibleArtistLimit, now.Add(10 * time.Minute)},
+		{pricing.Song{SongId: "song-c", Artists: []string{"Someone Else"}}, "", time.Time{}},
+	} {
+		reason, until := cooldowns.check(test.song, plays, now)
+		if reason != test.reason || !until.Equal(test.until) {
+			t.Fatalf("Expected %+v to be held back by %q until %v, instead got %q and %v", test.song, test.reason, test.until, reason, until)
+		}
+	}
+	if reason, _ := (Cooldowns{}).check(pricing.Song{SongId: "song-a"}, plays, now); reason != "" {
+		t.Fatalf("Expected no cooldowns to let every song play, instead got %q", reason)
+	}
+}
+
+// cooldownStoreHelper checks that songs held back by a cooldown are skipped and reported in the
+// queue, on a store holding no other bids.
+func cooldownStoreHelper(t *testing.T, store Store) {
+	store.SetAuctionConfig(AuctionConfig{Cooldowns: Cooldowns{Track: time.Hour, ArtistLimit: 1}})
+	songArtistsHelper(t, store, map[string]string{"song-a": "Someone Else", "song-b": "The Band", "song-c": "the band"})
+	postBidsHelper(t, store, []PostBidData{{BidAmount: 5, SongId: "song-a"}})
+	started := time.Now()
+	if next := playNextHelper(t, store); next != "song-a" {
+		t.Fatalf("Expected song-a to play, instead got %v", next)
+	}
+	if _, err := store.FinalizeCurrentSong(); err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	postBidsHelper(t, store, []PostBidData{
+		{BidAmount: 9, SongId: "song-a"},
+		{BidAmount: 3, SongId: "song-b"},
+		{BidAmount: 2, SongId: "song-c"},
+	})
+	queue, err := store.GetQueue()
+	if err != nil || len(queue.Songs) != 2 || queue.Songs[0].SongId != "song-b" || len(queue.Ineligible) != 1 {
+		t.Fatalf("Expected song-a to sit out its cooldown, instead got %+v and %v", queue, err)
+	}
+	cooldown := queue.Ineligible[0]
+	if cooldown.SongId != "song-a" || cooldown.Reason != IneligibleCooldown || cooldown.EligibleAt == nil ||
+		cooldown.EligibleAt.Before(started.Add(time.Hour)) || cooldown.EligibleAt.After(time.Now().Add(time.Hour)) {
+		t.Fatalf("Expected song-a to be eligible an hour after it started, instead got %+v", cooldown)
+	}
+
+	// song-b starts instead of song-a and uses up the band's limit.
+	if next := playNextHelper(t, store); next != "song-b" {
+		t.Fatalf("Expected song-b to play, instead got %v", next)
+	}
+	if _, err := store.FinalizeCurrentSong(); err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+	queue, err = store.GetQueue()
+	if err != nil || len(queue.Songs) != 0 || len(queue.Ineligible) != 2 || queue.Ineligible[1].SongId != "song-c" || queue.Ineligible[1].Reason != IneligibleArtistLimit {
+		t.Fatalf("Expected song-c to be held back by the artist limit, instead got %+v and %v", queue, err)
+	}
+	if _, err := store.PlayNextSong(); !errors.Is(err, ErrNoSongQueued) {
+		t.Fatalf("Expected ErrNoSongQueued while every song is held back, instead received %v", err)
+	}
+
+	// The limit follows the artist the operator set, not what was bid under.
+	songArtistsHelper(t, store, map[string]string{"song-c": "Someone New"})
+	if next := playNextHelper(t, store); next != "song-c" {
+		t.Fatalf("Expected song-c to play once it is by another artist, instead got %v", next)
+	}
+	if _, err := store.FinalizeCurrentSong(); err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	// Only the clock of the MemoryStore can be moved an hour on, when both cooldowns are over.
+	if memory, ok := store.(*MemoryStore); ok {
+		later := time.Now().Add(time.Hour + time.Minute)
+		memory.now = func() time.Time { return later }
+		if next := playNextHelper(t, store); next != "song-a" {
+			t.Fatalf("Expected song-a to play once its cooldown ended, instead got %v", next)
+		}
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	eachStore(t, cooldownStoreHelper)
+}
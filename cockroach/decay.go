@@ -0,0 +1,79 @@
+package cockroach
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Decay makes older bids count less when the queue is ranked, so that money bid long ago doesn't
+// keep beating fresher interest. The coins of a bid aren't touched; only the amount it counts for
+// in the auction shrinks.
+type Decay interface {
+	// Weight is how much of a bid of the given age still counts, from 1 for a new bid down to 0.
+	Weight(age time.Duration) float64
+	// String describes the decay the way ParseDecay accepts it.
+	String() string
+}
+
+// HalfLife halves the weight of a bid every HalfLife.
+type HalfLife struct {
+	HalfLife time.Duration
+}
+
+func (d HalfLife) Weight(age time.Duration) float64 {
+	if age <= 0 || d.HalfLife <= 0 {
+		return 1
+	}
+	return math.Exp2(-float64(age) / float64(d.HalfLife))
+}
+
+func (d HalfLife) String() string { return "half_life=" + d.HalfLife.String() }
+
+// LinearDecay lowers the weight of a bid evenly until it counts for nothing after Lifetime.
+type LinearDecay struct {
+	Lifetime time.Duration
+}
+
+func (d LinearDecay) Weight(age time.Duration) float64 {
+	if age <= 0 || d.Lifetime <= 0 {
+		return 1
+	}
+	return math.Max(0, 1-float64(age)/float64(d.Lifetime))
+}
+
+func (d LinearDecay) String() string { return "linear=" + d.Lifetime.String() }
+
+// decayName describes the decay, which is nil for none.
+func decayName(decay Decay) string {
+	if decay == nil {
+		return "none"
+	}
+	return decay.String()
+}
+
+// ParseDecay parses "none", "half_life=<duration>" or "linear=<duration>". None returns a nil Decay.
+func ParseDecay(value string) (Decay, error) {
+	kind, duration, ok := strings.Cut(strings.TrimSpace(value), "=")
+	if kind == "none" || kind == "" {
+		return nil, nil
+	}
+	if !ok {
+		return nil, fmt.Errorf("decay %q needs a duration, e.g. %v=30m", value, kind)
+	}
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid decay %q: %w", value, err)
+	}
+	if d <= 0 {
+		return nil, fmt.Errorf("invalid decay %q: the duration must be positive", value)
+	}
+	switch kind {
+	case "half_life":
+		return HalfLife{HalfLife: d}, nil
+	case "linear":
+		return LinearDecay{Lifetime: d}, nil
+	}
+	return nil, fmt.Errorf("unknown decay %q, expected none, half_life or linear", kind)
+}
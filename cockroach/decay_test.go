@@ -0,0 +1,72 @@
+package cockroach
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDecayWeight(t *testing.T) {
+	for _, test := range []struct {
+		decay  Decay
+		age    time.Duration
+		weight float64
+	}{
+		{HalfLife{HalfLife: 30 * time.Minute}, 0, 1},
+		{HalfLife{HalfLife: 30 * time.Minute}, 30 * time.Minute, 0.5},
+		{HalfLife{HalfLife: 30 * time.Minute}, time.Hour, 0.25},
+		{LinearDecay{Lifetime: time.Hour}, 15 * time.Minute, 0.75},
+		{LinearDecay{Lifetime: time.Hour}, 2 * time.Hour, 0},
+		// Bids placed in the future, e.g. by a clock running ahead, count in full.
+		{LinearDecay{Lifetime: time.Hour}, -time.Minute, 1},
+	} {
+		if weight := test.decay.Weight(test.age); math.Abs(weight-test.weight) > 1e-9 {
+			t.Fatalf("Expected %v to weigh a bid of age %v %v, instead got %v", test.decay, test.age, test.weight, weight)
+		}
+	}
+}
+
+func TestParseDecay(t *testing.T) {
+	if decay, err := ParseDecay("none"); err != nil || decay != nil {
+		t.Fatalf("Expected no decay, instead got %v and %v", decay, err)
+	}
+	if decay, err := ParseDecay("half_life=30m"); err != nil || decay != (HalfLife{HalfLife: 30 * time.Minute}) {
+		t.Fatalf("Expected a half life of 30 minutes, instead got %v and %v", decay, err)
+	}
+	if decay, err := ParseDecay("linear=2h"); err != nil || decay.String() != "linear=2h0m0s" {
+		t.Fatalf("Expected a linear decay over 2 hours, instead got %v and %v", decay, err)
+	}
+	for _, value := range []string{"half_life", "linear=0s", "linear=soon", "exponential=1h"} {
+		if _, err := ParseDecay(value); err == nil {
+			t.Fatalf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+// TestMemoryStoreDecay checks that an old large bid loses to a fresh smaller one once it decayed.
+func TestMemoryStoreDecay(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	store.SetAuctionConfig(AuctionConfig{Decay: HalfLife{HalfLife: 10 * time.Minute}})
+
+	postBidsHelper(t, store, []PostBidData{{BidAmount: 8, SongId: "song-a"}})
+	now = now.Add(20 * time.Minute)
+	postBidsHelper(t, store, []PostBidData{{BidAmount: 3, SongId: "song-b"}})
+
+	queue, err := store.GetQueue()
+	if err != nil || queue.Strategy != StrategySum || queue.Decay != "half_life=10m0s" || len(queue.Songs) != 2 {
+		t.Fatalf("Expected 2 songs ranked by their decayed sum, instead got %+v and %v", queue, err)
+	}
+	first, second := queue.Songs[0], queue.Songs[1]
+	if first.SongId != "song-b" || first.Score != 3 || second.SongId != "song-a" || second.TotalAmount != 8 || second.EffectiveAmount != 2 || second.Score != 2 {
+		t.Fatalf("Expected song-b's 3 coins to beat song-a's 8 coins that count for 2, instead got %+v", queue.Songs)
+	}
+	// A new bid counts in full, 1 coin ties song-b and the larger total breaks the tie.
+	if second.AmountToOvertake != 1 {
+		t.Fatalf("Expected song-a to need 1 coin, instead got %d", second.AmountToOvertake)
+	}
+	if next := playNextHelper(t, store); next != "song-b" {
+		t.Fatalf("Expected song-b to play next, instead got %v", next)
+	}
+}
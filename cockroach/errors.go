@@ -0,0 +1,100 @@
+package cockroach
+
+import (
+	"errors"
+	"fmt"
+)
+
+// sentinelError is the type of the errors a store returns on purpose, as opposed to failures of the
+// database. DomainError marks them for txError.
+type sentinelError string
+
+func (e sentinelError) Error() string { return string(e) }
+
+func (sentinelError) DomainError() {}
+
+// domainError is implemented by the errors of this package, and of the ledger and pricing packages,
+// that turn a request down. Transactions return them on purpose, so txError passes them on.
+type domainError interface {
+	error
+	DomainError()
+}
+
+var (
+	// ErrNoSongQueued is returned when there are no unplayed bids to choose the next song from.
+	ErrNoSongQueued = error(sentinelError("no song is queued"))
+	// ErrSongAlreadyPlaying is returned when a song is playing and the request needs it not to be.
+	ErrSongAlreadyPlaying = error(sentinelError("a song is already playing"))
+	// ErrNoSongPlaying is returned when the request needs a song to be playing and none is.
+	ErrNoSongPlaying = error(sentinelError("no song is playing"))
+	// ErrBidNotFound is returned when a bid id doesn't match any stored bid.
+	ErrBidNotFound = error(sentinelError("bid not found"))
+	// ErrInvalidTransition is returned when a bid can not move from its song status to the requested one.
+	ErrInvalidTransition = error(sentinelError("invalid song status transition"))
+	// ErrInvalidBid is returned when a bid can not be stored, e.g. because of a non positive amount.
+	ErrInvalidBid = error(sentinelError("invalid bid"))
+	// ErrSongBanned is returned when bidding on a song the operator banned.
+	ErrSongBanned = error(sentinelError("song is banned"))
+	// ErrIdempotencyKeyReused is returned when an idempotency key is sent again with another request.
+	ErrIdempotencyKeyReused = error(sentinelError("idempotency key was used for another request"))
+	// ErrStoreUnavailable is returned when the database could not be reached or a query failed.
+	ErrStoreUnavailable = errors.New("bid store is unavailable")
+	// ErrUserExists is returned when registering a username that is already taken.
+	ErrUserExists = error(sentinelError("user already exists"))
+	// ErrUserNotFound is returned when a user id or username doesn't match any stored user.
+	ErrUserNotFound = error(sentinelError("user not found"))
+	// ErrSessionNotFound is returned when a session token is unknown or has expired.
+	ErrSessionNotFound = error(sentinelError("session not found"))
+	// ErrInvoiceNotFound is returned when a payment hash doesn't match any stored invoice.
+	ErrInvoiceNotFound = error(sentinelError("invoice not found"))
+	// ErrPlayerStateNotFound is returned when no player reported its state for a room.
+	ErrPlayerStateNotFound = error(sentinelError("no player state reported"))
+	// ErrUnknownRoom is returned for a room other than DefaultRoom, the only room bids are placed in.
+	ErrUnknownRoom = error(sentinelError("unknown room"))
+	// ErrMigration is returned when the schema in the database doesn't match the embedded migrations.
+	ErrMigration = error(sentinelError("schema migration failed"))
+)
+
+// StoreError wraps a failure of the underlying database together with the operation that was
+// being run. It matches ErrStoreUnavailable with errors.Is.
+type StoreError struct {
+	Op  string
+	Err error
+}
+
+func (e *StoreError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Op, e.Err)
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+func (e *StoreError) Is(target error) bool {
+	return target == ErrStoreUnavailable
+}
+
+func storeError(op string, err error) error {
+	return &StoreError{Op: op, Err: err}
+}
+
+// txError returns the errors a transaction raised on purpose, the domain errors, as they are,
+// wrapped with the operation, and wraps everything else in a StoreError.
+func txError(op string, err error) error {
+	var domain domainError
+	if errors.As(err, &domain) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return storeError(op, err)
+}
+
+// validateBid checks the invariants every stored bid must satisfy.
+func validateBid(data PostBidData) error {
+	if data.BidAmount <= 0 {
+		return fmt.Errorf("%w: bid amount must be positive, got %d", ErrInvalidBid, data.BidAmount)
+	}
+	if data.SongId == "" {
+		return fmt.Errorf("%w: song id must not be empty", ErrInvalidBid)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package cockroach
+
+import (
+	"log"
+	"time"
+
+	"github.com/acidleroy/song-bid/events"
+	"github.com/google/uuid"
+)
+
+// PublicBid is a bid as anyone may see it: everything but the user who placed it. Events and the
+// other endpoints that don't need a login send bids in this form.
+type PublicBid struct {
+	BidAmount    int
+	SongId       string
+	BidId        uuid.UUID
+	SongStatus   SongStatus
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	RefundReason RefundReason `json:",omitempty"`
+	Deferred     bool         `json:",omitempty"`
+}
+
+// Public returns the bid without its bidder.
+func (r BidRow) Public() PublicBid {
+	return PublicBid{
+		BidAmount:    r.BidAmount,
+		SongId:       r.SongId,
+		BidId:        r.BidId,
+		SongStatus:   r.SongStatus,
+		CreatedAt:    r.CreatedAt,
+		UpdatedAt:    r.UpdatedAt,
+		RefundReason: r.RefundReason,
+		Deferred:     r.Deferred,
+	}
+}
+
+// PublicBids returns the bids without their bidders.
+func PublicBids(rows []BidRow) []PublicBid {
+	result := make([]PublicBid, len(rows))
+	for i, row := range rows {
+		result[i] = row.Public()
+	}
+	return result
+}
+
+// Events returns the bus the store publishes its changes to.
+func (db *Database) Events() *events.Bus {
+	return db.bus
+}
+
+// publishBidPlaced publishes a new bid and the queue it changed.
+func (db *Database) publishBidPlaced(row BidRow) {
+	db.bus.Publish(events.BidPlaced, row.Public())
+	db.publishQueue()
+}
+
+// publishRefunds publishes refunded bids and, if there were any, the queue they left.
+func (db *Database) publishRefunds(rows []BidRow) {
+	for _, row := range rows {
+		db.bus.Publish(events.BidRefunded, row.Public())
+	}
+	if len(rows) > 0 {
+		db.publishQueue()
+	}
+}
+
+// publishQueue publishes the ranked queue. It is only read when somebody listens.
+func (db *Database) publishQueue() {
+	if !db.bus.HasSubscribers() {
+		return
+	}
+	queue, err := db.GetBidsGroupBySongId()
+	if err != nil {
+		log.Printf("Could not publish the queue: %v", err)
+		return
+	}
+	db.bus.Publish(events.QueueReordered, queue)
+}
+
+// Events returns the bus the store publishes its changes to.
+func (m *MemoryStore) Events() *events.Bus {
+	return m.bus
+}
+
+// publishQueue publishes the ranked queue. The caller must hold m.mu.
+func (m *MemoryStore) publishQueue() {
+	if m.bus.HasSubscribers() {
+		m.bus.Publish(events.QueueReordered, m.rankedSums())
+	}
+}
@@ -0,0 +1,175 @@
+package cockroach
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/cockroach-go/v2/crdb/crdbpgx"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// IdempotencyKey identifies a request that may be retried. Key is chosen by the client and only
+// has to be unique among its own requests, so it is scoped to the user placing the bid; anonymous
+// bids can't have one, since nothing tells their clients apart. Fingerprint is a hash of the
+// request: a key sent again with another fingerprint fails with ErrIdempotencyKeyReused. Keys are
+// forgotten once they are older than Retention, and PurgeIdempotencyKeys deletes them.
+type IdempotencyKey struct {
+	Key         string
+	Fingerprint string
+	Retention   time.Duration
+}
+
+// idempotencyScope is the key under which an idempotency key is stored: the user's id and the key.
+type idempotencyScope struct {
+	scope string
+	key   string
+}
+
+// newIdempotencyScope returns the scope of the key of a bid, or ErrInvalidBid for an anonymous bid.
+func newIdempotencyScope(data PostBidData, key IdempotencyKey) (idempotencyScope, error) {
+	if !data.UserId.Valid {
+		return idempotencyScope{}, fmt.Errorf("%w: an idempotency key needs a signed-in bidder", ErrInvalidBid)
+	}
+	return idempotencyScope{scope: data.UserId.UUID.String(), key: key.Key}, nil
+}
+
+// memoryIdempotencyKey is an idempotency key kept by the MemoryStore.
+type memoryIdempotencyKey struct {
+	bidId       uuid.UUID
+	fingerprint string
+	createdAt   time.Time
+}
+
+// errIdempotencyRace is returned inside a transaction that lost the race to store an idempotency
+// key against a concurrent request with the same key.
+var errIdempotencyRace = errors.New("idempotency key was stored concurrently")
+
+// PostBidOnce posts a bid, unless a bid was already posted with the key within its retention.
+// The bid and its key are stored in the same transaction, so a retried request never pays twice.
+func (db *Database) PostBidOnce(data PostBidData, key IdempotencyKey) (uuid.UUID, bool, error) {
+	if err := validateBid(data); err != nil {
+		return uuid.UUID{}, false, err
+	}
+	scope, err := newIdempotencyScope(data, key)
+	if err != nil {
+		return uuid.UUID{}, false, fmt.Errorf("post bid once: %w", err)
+	}
+
+	// A request that loses the race against a concurrent one with the same key tries again, and
+	// then finds the key the other request stored.
+	for attempt := 0; ; attempt++ {
+		bidId, replayed, err := db.postBidOnce(data, key, scope)
+		if errors.Is(err, errIdempotencyRace) && attempt == 0 {
+			continue
+		}
+		if err != nil {
+			return uuid.UUID{}, false, txError("post bid once", err)
+		}
+		return bidId, replayed, nil
+	}
+}
+
+func (db *Database) postBidOnce(data PostBidData, key IdempotencyKey, scope idempotencyScope) (bidId uuid.UUID, replayed bool, err error) {
+	var posted BidRow
+	err = crdbpgx.ExecuteTx(context.Background(), db.connection, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		ctx := context.Background()
+		// The age of the key is taken on the clock of the database, which stored it.
+		var fingerprint string
+		var retained bool
+		err := tx.QueryRow(ctx,
+			"SELECT bid_id, fingerprint, now() - created_at < $3 * INTERVAL '1 microsecond' FROM bid_idempotency_keys WHERE key_scope = $1 AND idempotency_key = $2 FOR UPDATE",
+			scope.scope, scope.key, key.Retention.Microseconds()).Scan(&bidId, &fingerprint, &retained)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+		case err != nil:
+			return err
+		case retained:
+			if fingerprint != key.Fingerprint {
+				return fmt.Errorf("%w: %v", ErrIdempotencyKeyReused, key.Key)
+			}
+			replayed = true
+			return nil
+		default:
+			if _, err := tx.Exec(ctx, "DELETE FROM bid_idempotency_keys WHERE key_scope = $1 AND idempotency_key = $2", scope.scope, scope.key); err != nil {
+				return err
+			}
+		}
+
+		row, err := db.postBidTx(ctx, tx, data)
+		if err != nil {
+			return err
+		}
+		bidId, replayed, posted = row.BidId, false, row
+		tag, err := tx.Exec(ctx,
+			"INSERT INTO bid_idempotency_keys (key_scope, idempotency_key, fingerprint, bid_id) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING",
+			scope.scope, scope.key, key.Fingerprint, bidId)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return errIdempotencyRace
+		}
+		return nil
+	})
+	if err == nil && !replayed {
+		db.publishBidPlaced(posted)
+	}
+	return bidId, replayed, err
+}
+
+// PostBidOnce posts a bid, unless a bid was already posted with the key within its retention.
+func (m *MemoryStore) PostBidOnce(data PostBidData, key IdempotencyKey) (uuid.UUID, bool, error) {
+	if err := validateBid(data); err != nil {
+		return uuid.UUID{}, false, err
+	}
+
+	scope, err := newIdempotencyScope(data, key)
+	if err != nil {
+		return uuid.UUID{}, false, fmt.Errorf("post bid once: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if stored, ok := m.keys[scope]; ok && m.now().Sub(stored.createdAt) < key.Retention {
+		if stored.fingerprint != key.Fingerprint {
+			return uuid.UUID{}, false, fmt.Errorf("post bid once: %w: %v", ErrIdempotencyKeyReused, key.Key)
+		}
+		return stored.bidId, true, nil
+	}
+
+	row, err := m.postBid(data)
+	if err != nil {
+		return uuid.UUID{}, false, err
+	}
+	m.keys[scope] = memoryIdempotencyKey{bidId: row.BidId, fingerprint: key.Fingerprint, createdAt: m.now()}
+	return row.BidId, false, nil
+}
+
+// PurgeIdempotencyKeys deletes the idempotency keys older than retention and returns how many.
+func (db *Database) PurgeIdempotencyKeys(retention time.Duration) (int64, error) {
+	tag, err := db.connection.Exec(context.Background(),
+		"DELETE FROM bid_idempotency_keys WHERE created_at < now() - $1 * INTERVAL '1 microsecond'", retention.Microseconds())
+	if err != nil {
+		return 0, storeError("purge idempotency keys", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// PurgeIdempotencyKeys deletes the idempotency keys older than retention and returns how many.
+func (m *MemoryStore) PurgeIdempotencyKeys(retention time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var purged int64
+	for scope, stored := range m.keys {
+		if m.now().Sub(stored.createdAt) >= retention {
+			delete(m.keys, scope)
+			purged++
+		}
+	}
+	return purged, nil
+}
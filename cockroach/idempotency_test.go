@@ -0,0 +1,73 @@
+package cockroach
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// idempotencyHelper checks that a retried bid is only posted and paid for once.
+func idempotencyHelper(t *testing.T, store Store) {
+	user, err := store.CreateUser("retrier-"+uuid.New().String()[:8], "hash")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := store.CreditCoins(user.UserId, 100, "test:"+user.Username); err != nil {
+		t.Fatalf("Failed to credit coins: %v", err)
+	}
+	data := PostBidData{BidAmount: 10, SongId: "song-a", UserId: uuid.NullUUID{UUID: user.UserId, Valid: true}}
+	key := IdempotencyKey{Key: "retry-me", Fingerprint: "body-hash", Retention: time.Hour}
+
+	first, replayed, err := store.PostBidOnce(data, key)
+	if err != nil || replayed {
+		t.Fatalf("Expected the first request to post the bid, instead got %v and %v", replayed, err)
+	}
+	second, replayed, err := store.PostBidOnce(data, key)
+	if err != nil || !replayed || second != first {
+		t.Fatalf("Expected the retry to return bid %v, instead got %v, %v and %v", first, second, replayed, err)
+	}
+	if balance, _ := store.GetBalance(user.UserId); balance != 90 {
+		t.Fatalf("Expected the bid to be paid once, instead the balance is %d", balance)
+	}
+
+	other := key
+	other.Fingerprint = "another-body-hash"
+	if _, _, err := store.PostBidOnce(data, other); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Fatalf("Expected ErrIdempotencyKeyReused for another request with the key, instead received %v", err)
+	}
+
+	// Anonymous clients can't be told apart, so their bids can't have a key.
+	if _, _, err := store.PostBidOnce(PostBidData{BidAmount: 1, SongId: "song-a"}, key); !errors.Is(err, ErrInvalidBid) {
+		t.Fatalf("Expected ErrInvalidBid for an anonymous bid with a key, instead received %v", err)
+	}
+
+	// The same key of another user, or an expired key, posts a new bid.
+	if bidId, replayed, err := store.PostBidOnce(PostBidData{BidAmount: 1, SongId: "song-a", UserId: bidderHelper(t, store, 10)}, key); err != nil || replayed || bidId == first {
+		t.Fatalf("Expected the bid of another user with the key to be posted, instead got %v, %v and %v", bidId, replayed, err)
+	}
+	expired := key
+	expired.Retention = 0
+	if bidId, replayed, err := store.PostBidOnce(data, expired); err != nil || replayed || bidId == first {
+		t.Fatalf("Expected an expired key to post a new bid, instead got %v, %v and %v", bidId, replayed, err)
+	}
+	if balance, _ := store.GetBalance(user.UserId); balance != 80 {
+		t.Fatalf("Expected two bids to be paid, instead the balance is %d", balance)
+	}
+
+	// Purging forgets only the keys older than the retention.
+	if purged, err := store.PurgeIdempotencyKeys(time.Hour); err != nil || purged != 0 {
+		t.Fatalf("Expected no key to be old enough to purge, instead purged %d and %v", purged, err)
+	}
+	if purged, err := store.PurgeIdempotencyKeys(0); err != nil || purged != 2 {
+		t.Fatalf("Expected both keys to be purged, instead purged %d and %v", purged, err)
+	}
+	if bidId, replayed, err := store.PostBidOnce(data, key); err != nil || replayed || bidId == first {
+		t.Fatalf("Expected a purged key to post a new bid, instead got %v, %v and %v", bidId, replayed, err)
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	eachStore(t, idempotencyHelper)
+}
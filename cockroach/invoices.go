@@ -0,0 +1,212 @@
+package cockroach
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/acidleroy/song-bid/ledger"
+	"github.com/cockroachdb/cockroach-go/v2/crdb/crdbpgx"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// CoinInvoice is a Lightning invoice a user pays to buy coins. The coins are credited once, when
+// the invoice is settled.
+type CoinInvoice struct {
+	PaymentHash    string
+	PaymentRequest string
+	UserId         uuid.UUID
+	Coins          int64
+	AmountSats     int64
+	Settled        bool
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+	SettledAt      *time.Time
+}
+
+// InvoiceStore keeps the invoices of coin purchases. It implements payments.Settler.
+type InvoiceStore interface {
+	// CreateInvoice stores a new, unsettled invoice.
+	CreateInvoice(invoice CoinInvoice) error
+	// GetInvoice returns an invoice, or ErrInvoiceNotFound.
+	GetInvoice(paymentHash string) (CoinInvoice, error)
+	// PendingPaymentHashes returns the unsettled invoices that may still be paid.
+	PendingPaymentHashes() ([]string, error)
+	// SettleInvoice marks an invoice as paid and credits its coins to the user, exactly once. It
+	// returns whether this call credited the coins; unknown invoices are ignored.
+	SettleInvoice(paymentHash string) (bool, error)
+}
+
+// pendingInvoiceWindow is how long after it expired an unsettled invoice is still looked up, in
+// case it was paid just before.
+const pendingInvoiceWindow time.Duration = 24 * time.Hour
+
+// invoiceReference is the ledger reference of the transaction that credits an invoice.
+func invoiceReference(paymentHash string) string {
+	return "invoice:" + paymentHash
+}
+
+const invoiceColumns string = "payment_hash, payment_request, user_id, coins, amount_sats, settled, created_at, expires_at, settled_at"
+
+// CreateInvoice stores a new, unsettled invoice.
+func (db *Database) CreateInvoice(invoice CoinInvoice) error {
+	err := crdbpgx.ExecuteTx(context.Background(), db.connection, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(),
+			"INSERT INTO invoices ("+invoiceColumns+") VALUES ($1, $2, $3, $4, $5, false, $6, $7, NULL)",
+			invoice.PaymentHash, invoice.PaymentRequest, invoice.UserId, invoice.Coins, invoice.AmountSats, invoice.CreatedAt, invoice.ExpiresAt)
+		return err
+	})
+	if err != nil {
+		return storeError("create invoice", err)
+	}
+	return nil
+}
+
+// GetInvoice returns the invoice with the given payment hash, or ErrInvoiceNotFound.
+func (db *Database) GetInvoice(paymentHash string) (CoinInvoice, error) {
+	invoice, err := getInvoice(context.Background(), db.connection, paymentHash, "")
+	if errors.Is(err, pgx.ErrNoRows) {
+		return CoinInvoice{}, fmt.Errorf("get invoice: %w: %v", ErrInvoiceNotFound, paymentHash)
+	}
+	if err != nil {
+		return CoinInvoice{}, storeError("get invoice", err)
+	}
+	return invoice, nil
+}
+
+// queryRower is implemented by both connection pools and transactions.
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// getInvoice reads an invoice, suffix is appended to the query, e.g. to lock the row.
+func getInvoice(ctx context.Context, q queryRower, paymentHash string, suffix string) (CoinInvoice, error) {
+	invoice := CoinInvoice{}
+	err := q.QueryRow(ctx, "SELECT "+invoiceColumns+" FROM invoices WHERE payment_hash = $1"+suffix, paymentHash).Scan(
+		&invoice.PaymentHash, &invoice.PaymentRequest, &invoice.UserId, &invoice.Coins, &invoice.AmountSats,
+		&invoice.Settled, &invoice.CreatedAt, &invoice.ExpiresAt, &invoice.SettledAt)
+	return invoice, err
+}
+
+// PendingPaymentHashes returns the unsettled invoices that may still be paid.
+func (db *Database) PendingPaymentHashes() ([]string, error) {
+	rows, err := db.connection.Query(context.Background(),
+		"SELECT payment_hash FROM invoices WHERE NOT settled AND expires_at > $1", time.Now().Add(-pendingInvoiceWindow))
+	if err != nil {
+		return nil, storeError("get pending invoices", err)
+	}
+	defer rows.Close()
+
+	hashes := []string{}
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, storeError("get pending invoices", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, storeError("get pending invoices", err)
+	}
+	return hashes, nil
+}
+
+// SettleInvoice marks an invoice as paid and credits its coins in the same transaction. The row is
+// locked while it is read, so concurrent calls for the same invoice credit it only once.
+func (db *Database) SettleInvoice(paymentHash string) (bool, error) {
+	var credited bool
+	err := crdbpgx.ExecuteTx(context.Background(), db.connection, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		ctx := context.Background()
+		credited = false
+
+		invoice, err := getInvoice(ctx, tx, paymentHash, " FOR UPDATE")
+		if errors.Is(err, pgx.ErrNoRows) || (err == nil && invoice.Settled) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		t, err := coinCredit(invoice.UserId, invoice.Coins, invoiceReference(paymentHash))
+		if err != nil {
+			return err
+		}
+		if _, err := ledger.PostTx(ctx, tx, t); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "UPDATE invoices SET (settled, settled_at) = (true, now()) WHERE payment_hash = $1", paymentHash); err != nil {
+			return err
+		}
+		credited = true
+		return nil
+	})
+	if err != nil {
+		return false, txError("settle invoice", err)
+	}
+	return credited, nil
+}
+
+// CreateInvoice stores a new, unsettled invoice.
+func (m *MemoryStore) CreateInvoice(invoice CoinInvoice) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	invoice.Settled = false
+	invoice.SettledAt = nil
+	m.invoices[invoice.PaymentHash] = invoice
+	return nil
+}
+
+// GetInvoice returns the invoice with the given payment hash, or ErrInvoiceNotFound.
+func (m *MemoryStore) GetInvoice(paymentHash string) (CoinInvoice, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	invoice, ok := m.invoices[paymentHash]
+	if !ok {
+		return CoinInvoice{}, fmt.Errorf("get invoice: %w: %v", ErrInvoiceNotFound, paymentHash)
+	}
+	return invoice, nil
+}
+
+// PendingPaymentHashes returns the unsettled invoices that may still be paid.
+func (m *MemoryStore) PendingPaymentHashes() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hashes := []string{}
+	cutoff := m.now().Add(-pendingInvoiceWindow)
+	for hash, invoice := range m.invoices {
+		if !invoice.Settled && invoice.ExpiresAt.After(cutoff) {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes, nil
+}
+
+// SettleInvoice marks an invoice as paid and credits its coins, exactly once.
+func (m *MemoryStore) SettleInvoice(paymentHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	invoice, ok := m.invoices[paymentHash]
+	if !ok || invoice.Settled {
+		return false, nil
+	}
+
+	t, err := coinCredit(invoice.UserId, invoice.Coins, invoiceReference(paymentHash))
+	if err != nil {
+		return false, err
+	}
+	if _, err := m.ledger.Post(context.Background(), t); err != nil {
+		return false, fmt.Errorf("settle invoice: %w", err)
+	}
+
+	now := m.now()
+	invoice.Settled = true
+	invoice.SettledAt = &now
+	m.invoices[paymentHash] = invoice
+	return true, nil
+}
@@ -0,0 +1,71 @@
+package cockroach
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// invoiceStoreHelper checks that a settled invoice credits its coins exactly once.
+func invoiceStoreHelper(t *testing.T, store Store) {
+	user, err := store.CreateUser("buyer-"+uuid.New().String()[:8], "hash")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	hash := uuid.New().String()
+	invoice := CoinInvoice{
+		PaymentHash:    hash,
+		PaymentRequest: "lnfake",
+		UserId:         user.UserId,
+		Coins:          25,
+		AmountSats:     250,
+		CreatedAt:      time.Now(),
+		ExpiresAt:      time.Now().Add(time.Hour),
+	}
+	if err := store.CreateInvoice(invoice); err != nil {
+		t.Fatalf("Failed to create invoice: %v", err)
+	}
+
+	pending, err := store.PendingPaymentHashes()
+	if err != nil || !containsHash(pending, hash) {
+		t.Fatalf("Expected the invoice to be pending, instead got %v and %v", pending, err)
+	}
+
+	for i, expected := range []bool{true, false} {
+		if credited, err := store.SettleInvoice(hash); err != nil || credited != expected {
+			t.Fatalf("Expected settling #%d to credit %v, instead got %v and %v", i+1, expected, credited, err)
+		}
+	}
+	if credited, err := store.SettleInvoice("unknown-" + hash); err != nil || credited {
+		t.Fatalf("Expected an unknown invoice to be ignored, instead got %v and %v", credited, err)
+	}
+
+	if balance, _ := store.GetBalance(user.UserId); balance != 25 {
+		t.Fatalf("Expected 25 coins to be credited once, instead got %d", balance)
+	}
+	settled, err := store.GetInvoice(hash)
+	if err != nil || !settled.Settled || settled.SettledAt == nil {
+		t.Fatalf("Expected the invoice to be settled, instead got %+v and %v", settled, err)
+	}
+	if pending, _ := store.PendingPaymentHashes(); containsHash(pending, hash) {
+		t.Fatalf("Expected the settled invoice to no longer be pending, instead got %v", pending)
+	}
+	if _, err := store.GetInvoice("unknown-" + hash); !errors.Is(err, ErrInvoiceNotFound) {
+		t.Fatalf("Expected ErrInvoiceNotFound for an unknown invoice, instead received %v", err)
+	}
+}
+
+func containsHash(hashes []string, hash string) bool {
+	for _, h := range hashes {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+func TestInvoices(t *testing.T) {
+	eachStore(t, invoiceStoreHelper)
+}
@@ -0,0 +1,98 @@
+package cockroach
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// bidCutoff returns when new bids stop counting for the song that follows the track the player
+// last reported: LockWindow before the track ends. It is nil when no lock applies, i.e. without a
+// lock window, when the duration of the track is unknown, or once the track should have ended.
+// Only the player of DefaultRoom locks bids.
+func bidCutoff(state PlayerState, window time.Duration, now time.Time) *time.Time {
+	if window <= 0 || state.DurationMs <= 0 || state.Room != DefaultRoom {
+		return nil
+	}
+	end := state.UpdatedAt.Add(time.Duration(state.DurationMs-state.ProgressMs) * time.Millisecond)
+	if !now.Before(end) {
+		return nil
+	}
+	cutoff := end.Add(-window)
+	return &cutoff
+}
+
+// isLocked reports whether a bid placed at now falls into the lock window before the cutoff's song
+// change, and so only counts from the round after next.
+func isLocked(cutoff *time.Time, now time.Time) bool {
+	return cutoff != nil && !now.Before(*cutoff)
+}
+
+// splitDeferred separates the bids that count for the next song from those placed in its lock
+// window.
+func splitDeferred(bids []BidRow) (eligible []BidRow, deferred []BidRow) {
+	for _, bid := range bids {
+		if bid.Deferred {
+			deferred = append(deferred, bid)
+		} else {
+			eligible = append(eligible, bid)
+		}
+	}
+	return eligible, deferred
+}
+
+// lockWindow returns how long before the end of a track new bids are deferred.
+func (db *Database) lockWindow() time.Duration {
+	db.auctionMu.RLock()
+	defer db.auctionMu.RUnlock()
+	return db.auction.LockWindow
+}
+
+// isLockedTx reports whether a bid placed now falls into the lock window of the default room, read
+// in the transaction that stores the bid so that a concurrent report of the player is seen.
+func (db *Database) isLockedTx(ctx context.Context, tx pgx.Tx) (bool, error) {
+	window := db.lockWindow()
+	if window <= 0 {
+		return false, nil
+	}
+	state := PlayerState{}
+	err := tx.QueryRow(ctx, "SELECT "+playerStateColumns+" FROM player_states WHERE room = $1", DefaultRoom).Scan(
+		&state.Room, &state.Track, &state.ProgressMs, &state.DurationMs, &state.Device, &state.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	return isLocked(bidCutoff(state, window, now), now), nil
+}
+
+// releaseDeferredTx lets the bids deferred by the lock window count from now on.
+func releaseDeferredTx(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, "UPDATE tbl_bid SET deferred = false WHERE song_status = $1 AND deferred", Queued)
+	return err
+}
+
+// isLocked reports whether a bid placed now falls into the lock window of the default room. The
+// caller must hold m.mu.
+func (m *MemoryStore) isLocked() bool {
+	state, ok := m.players[DefaultRoom]
+	if !ok {
+		return false
+	}
+	now := m.now()
+	return isLocked(bidCutoff(state, m.auction.LockWindow, now), now)
+}
+
+// releaseDeferred lets the bids deferred by the lock window count from now on. The caller must
+// hold m.mu.
+func (m *MemoryStore) releaseDeferred() {
+	for i := range m.bids {
+		if m.bids[i].SongStatus == Queued {
+			m.bids[i].Deferred = false
+		}
+	}
+}
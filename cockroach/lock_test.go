@@ -0,0 +1,96 @@
+package cockroach
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBidCutoff(t *testing.T) {
+	now := time.Now()
+	state := PlayerState{Room: DefaultRoom, ProgressMs: 45000, DurationMs: 60000, UpdatedAt: now}
+
+	cutoff := bidCutoff(state, 10*time.Second, now)
+	if cutoff == nil || !cutoff.Equal(now.Add(5*time.Second)) {
+		t.Fatalf("Expected bids to lock 10 seconds before the track ends in 15, instead got %v", cutoff)
+	}
+	if isLocked(cutoff, now) || !isLocked(cutoff, now.Add(5*time.Second)) {
+		t.Fatalf("Expected bids to lock from the cutoff on")
+	}
+	if cutoff := bidCutoff(state, 10*time.Second, now.Add(15*time.Second)); cutoff != nil {
+		t.Fatalf("Expected no lock once the track should have ended, instead got %v", cutoff)
+	}
+	for _, state := range []PlayerState{
+		{Room: DefaultRoom, ProgressMs: 45000, UpdatedAt: now},
+		{Room: "lounge", ProgressMs: 45000, DurationMs: 60000, UpdatedAt: now},
+	} {
+		if cutoff := bidCutoff(state, 10*time.Second, now); cutoff != nil {
+			t.Fatalf("Expected no lock for %+v, instead got %v", state, cutoff)
+		}
+	}
+	if cutoff := bidCutoff(state, 0, now); cutoff != nil {
+		t.Fatalf("Expected no lock without a lock window, instead got %v", cutoff)
+	}
+}
+
+// lockStoreHelper checks that bids placed in the lock window sit out the next song, on a store
+// holding no other bids. The reported track ends in 20 seconds and bids lock 30 seconds before.
+func lockStoreHelper(t *testing.T, store Store) {
+	store.SetAuctionConfig(AuctionConfig{LockWindow: 30 * time.Second})
+	postBidsHelper(t, store, []PostBidData{{BidAmount: 1, SongId: "song-a"}})
+	if _, err := store.SetPlayerState(PlayerState{Room: DefaultRoom, Track: "song-0", ProgressMs: 40000, DurationMs: 60000}); err != nil {
+		t.Fatalf("Failed to set the player state: %v", err)
+	}
+	state, err := store.GetPlayerState(DefaultRoom)
+	if err != nil || state.BidCutoff == nil || !state.BidCutoff.Equal(state.UpdatedAt.Add(-10*time.Second)) {
+		t.Fatalf("Expected bids to lock 10 seconds before the state was reported, instead got %+v and %v", state, err)
+	}
+
+	postBidsHelper(t, store, []PostBidData{{BidAmount: 5, SongId: "song-b"}, {BidAmount: 5, SongId: "song-a"}})
+	bids, err := store.GetBids()
+	if err != nil || len(bids) != 3 {
+		t.Fatalf("Expected 3 bids, instead got %v and %v", bids, err)
+	}
+	for _, bid := range bids {
+		if bid.Deferred != (bid.BidAmount == 5) {
+			t.Fatalf("Expected only the bids placed after the cutoff to be deferred, instead got %+v", bid)
+		}
+	}
+
+	queue, err := store.GetQueue()
+	if err != nil || len(queue.Songs) != 1 || queue.Songs[0].SongId != "song-a" || queue.Songs[0].TotalAmount != 1 || len(queue.Deferred) != 2 {
+		t.Fatalf("Expected song-a's first bid to be ranked and 2 songs with deferred bids, instead got %+v and %v", queue, err)
+	}
+
+	// song-a plays with its deferred bid, song-b's deferred bid counts from now on.
+	played, err := store.PlayNextSong()
+	if err != nil || len(played) != 2 || played[0].SongId != "song-a" {
+		t.Fatalf("Expected both bids of song-a to play, instead got %v and %v", played, err)
+	}
+	if _, err := store.FinalizeCurrentSong(); err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+	queue, err = store.GetQueue()
+	if err != nil || len(queue.Songs) != 1 || queue.Songs[0].SongId != "song-b" || len(queue.Deferred) != 0 {
+		t.Fatalf("Expected song-b's bid to count after the round, instead got %+v and %v", queue, err)
+	}
+
+	// A round without any bids that count still releases the deferred ones.
+	if next := playNextHelper(t, store); next != "song-b" {
+		t.Fatalf("Expected song-b to play, instead got %v", next)
+	}
+	postBidsHelper(t, store, []PostBidData{{BidAmount: 2, SongId: "song-c"}})
+	if _, err := store.FinalizeCurrentSong(); err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+	if _, err := store.PlayNextSong(); !errors.Is(err, ErrNoSongQueued) {
+		t.Fatalf("Expected ErrNoSongQueued while song-c's bid is deferred, instead received %v", err)
+	}
+	if next := playNextHelper(t, store); next != "song-c" {
+		t.Fatalf("Expected song-c to play in the round after, instead got %v", next)
+	}
+}
+
+func TestLock(t *testing.T) {
+	eachStore(t, lockStoreHelper)
+}
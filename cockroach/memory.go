@@ -0,0 +1,334 @@
+package cockroach
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/acidleroy/song-bid/events"
+	"github.com/acidleroy/song-bid/ledger"
+	"github.com/acidleroy/song-bid/pricing"
+	"github.com/google/uuid"
+)
+
+// MemoryStore is an in-process BidStore. It follows the same rules as Database: new bids start
+// queued, PlayNextSong moves the bids of the song the auction strategy ranks first to playing and
+// FinalizeCurrentSong moves everything that is playing to played.
+type MemoryStore struct {
+	mu       sync.Mutex
+	bids     []BidRow
+	users    []User
+	sessions map[string]memorySession
+	invoices map[string]CoinInvoice
+	banned   map[string]time.Time
+	keys     map[idempotencyScope]memoryIdempotencyKey
+	players  map[string]PlayerState
+	auction  AuctionConfig
+	pricing  map[string]pricing.Rules
+	plays    []SongPlay
+	artists  map[string]string
+	ledger   *ledger.Memory
+	bus      *events.Bus
+	now      func() time.Time
+}
+
+// memorySession is a login session kept by the MemoryStore.
+type memorySession struct {
+	userId    uuid.UUID
+	expiresAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: map[string]memorySession{},
+		invoices: map[string]CoinInvoice{},
+		banned:   map[string]time.Time{},
+		keys:     map[idempotencyScope]memoryIdempotencyKey{},
+		players:  map[string]PlayerState{},
+		pricing:  map[string]pricing.Rules{},
+		artists:  map[string]string{},
+		ledger:   ledger.NewMemory(),
+		bus:      events.NewBus(),
+		now:      time.Now,
+	}
+}
+
+func (m *MemoryStore) Close() {}
+
+// PostBid creates a new entry in the store for a song that has not yet been played. Bids of users
+// are paid from their coin wallet, and not stored if it doesn't hold enough coins.
+func (m *MemoryStore) PostBid(data PostBidData) (*uuid.UUID, error) {
+	if err := validateBid(data); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	row, err := m.postBid(data)
+	if err != nil {
+		return nil, err
+	}
+	return &row.BidId, nil
+}
+
+// postBid stores a new bid, pays for it and publishes it. The caller must hold m.mu.
+func (m *MemoryStore) postBid(data PostBidData) (BidRow, error) {
+	if _, ok := m.banned[data.SongId]; ok {
+		return BidRow{}, fmt.Errorf("post bid: %w: %v", ErrSongBanned, data.SongId)
+	}
+	e := m.eligibility()
+	var queued []BidRow
+	if e.rules.MinIncrement > 0 {
+		queued = m.bidsWithStatus(Queued)
+	}
+	if err := checkBid(e, m.ranking(), data, queued, m.now()); err != nil {
+		return BidRow{}, fmt.Errorf("post bid: %w", err)
+	}
+	bidId := uuid.New()
+	if data.UserId.Valid {
+		if _, err := m.ledger.Post(context.Background(), payForBid(bidId, data)); err != nil {
+			return BidRow{}, fmt.Errorf("post bid: %w", err)
+		}
+	}
+	now := m.now()
+	row := BidRow{BidAmount: data.BidAmount, SongId: data.SongId, BidId: bidId, SongStatus: Queued, CreatedAt: now, UpdatedAt: now, UserId: data.UserId, Deferred: m.isLocked()}
+	m.bids = append(m.bids, row)
+	m.bus.Publish(events.BidPlaced, row.Public())
+	m.publishQueue()
+	return row, nil
+}
+
+func (m *MemoryStore) GetBids() ([]BidRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []BidRow
+	result = append(result, m.bids...)
+	return result, nil
+}
+
+// GetBidsByUser returns every bid placed by the given user.
+func (m *MemoryStore) GetBidsByUser(userId uuid.UUID) ([]BidRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []BidRow
+	for _, row := range m.bids {
+		if row.UserId.Valid && row.UserId.UUID == userId {
+			result = append(result, row)
+		}
+	}
+	return result, nil
+}
+
+// GetBid returns the bid with the given id, or ErrBidNotFound if there is none.
+func (m *MemoryStore) GetBid(bidId uuid.UUID) (BidRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, row := range m.bids {
+		if row.BidId == bidId {
+			return row, nil
+		}
+	}
+	return BidRow{}, fmt.Errorf("get bid: %w: %v", ErrBidNotFound, bidId)
+}
+
+func (m *MemoryStore) GetHighestBid() (PostBidData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sums := m.rankedSums()
+	if len(sums) == 0 {
+		return PostBidData{}, nil
+	}
+	return sums[0], nil
+}
+
+// GetBidsGroupBySongId gets all the songs that haven't been played yet, sums their values by songId and returns the result
+// in the order the auction strategy plays them.
+func (m *MemoryStore) GetBidsGroupBySongId() ([]PostBidData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.rankedSums(), nil
+}
+
+// PlayNextSong sets the bids of the song the auction strategy ranks first, among those that meet
+// their reserve price and aren't held back by a cooldown, to playing and returns them.
+// ErrSongAlreadyPlaying is returned while another song is playing and ErrNoSongQueued when no song
+// with queued bids that aren't deferred may play. With a Pricer strategy the bidders get back what
+// the song paid above its price. Deferred bids count again afterwards.
+func (m *MemoryStore) PlayNextSong() ([]BidRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, row := range m.bids {
+		if row.SongStatus == Playing {
+			return nil, fmt.Errorf("play next song: %w", ErrSongAlreadyPlaying)
+		}
+	}
+
+	eligible, deferred := splitDeferred(m.bidsWithStatus(Queued))
+	r := m.ranking()
+	e := m.eligibility()
+	scored, _ := e.split(scoreSongs(r, groupBySong(eligible), m.now()), m.now())
+	m.releaseDeferred()
+	if len(scored) == 0 {
+		if len(deferred) > 0 {
+			m.publishQueue()
+		}
+		return nil, fmt.Errorf("play next song: %w", ErrNoSongQueued)
+	}
+	for _, rebate := range rebates(r, e, scored, m.now()) {
+		if _, err := m.ledger.Post(context.Background(), rebate); err != nil {
+			return nil, fmt.Errorf("play next song: %w", err)
+		}
+	}
+	result := m.updateStatus(func(row BidRow) bool {
+		return row.SongId == scored[0].bids.SongId && row.SongStatus == Queued
+	}, Playing)
+	m.plays = append(m.plays, newSongPlay(e, result, m.now()))
+	m.bus.Publish(events.SongStarted, PublicBids(result))
+	m.publishQueue()
+	return result, nil
+}
+
+// FinalizeCurrentSong marks the bids of the playing song as played and returns them.
+// ErrNoSongPlaying is returned when no song is playing.
+func (m *MemoryStore) FinalizeCurrentSong() ([]BidRow, error) {
+	return m.finishCurrentSong("finalize current song", Played)
+}
+
+// SkipCurrentSong marks the bids of the playing song as skipped and returns them.
+// ErrNoSongPlaying is returned when no song is playing.
+func (m *MemoryStore) SkipCurrentSong() ([]BidRow, error) {
+	return m.finishCurrentSong("skip current song", Skipped)
+}
+
+func (m *MemoryStore) finishCurrentSong(op string, status SongStatus) ([]BidRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := m.updateStatus(func(row BidRow) bool {
+		return row.SongStatus == Playing
+	}, status)
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%s: %w", op, ErrNoSongPlaying)
+	}
+	m.bus.Publish(events.SongFinished, PublicBids(result))
+	return result, nil
+}
+
+// CreateUser stores a new user. ErrUserExists is returned if the username is taken.
+func (m *MemoryStore) CreateUser(username string, passwordHash string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.Username == username {
+			return User{}, fmt.Errorf("create user: %w: %v", ErrUserExists, username)
+		}
+	}
+	user := User{UserId: uuid.New(), Username: username, PasswordHash: passwordHash, CreatedAt: m.now()}
+	m.users = append(m.users, user)
+	return user, nil
+}
+
+// GetUser returns the user with the given id, or ErrUserNotFound if there is none.
+func (m *MemoryStore) GetUser(userId uuid.UUID) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user, ok := m.findUser(userId); ok {
+		return user, nil
+	}
+	return User{}, fmt.Errorf("get user: %w: %v", ErrUserNotFound, userId)
+}
+
+// GetUserByName returns the user with the given username, or ErrUserNotFound if there is none.
+func (m *MemoryStore) GetUserByName(username string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return User{}, fmt.Errorf("get user by name: %w: %v", ErrUserNotFound, username)
+}
+
+// CreateSession stores a login session for the user that is valid until expiresAt.
+func (m *MemoryStore) CreateSession(tokenHash string, userId uuid.UUID, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.findUser(userId); !ok {
+		return fmt.Errorf("create session: %w: %v", ErrUserNotFound, userId)
+	}
+	m.sessions[tokenHash] = memorySession{userId: userId, expiresAt: expiresAt}
+	return nil
+}
+
+// GetSessionUser returns the user of the session with the given token hash. ErrSessionNotFound is
+// returned if there is no such session or it has expired.
+func (m *MemoryStore) GetSessionUser(tokenHash string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[tokenHash]
+	if !ok || !m.now().Before(session.expiresAt) {
+		return User{}, fmt.Errorf("get session user: %w", ErrSessionNotFound)
+	}
+	user, ok := m.findUser(session.userId)
+	if !ok {
+		return User{}, fmt.Errorf("get session user: %w", ErrSessionNotFound)
+	}
+	return user, nil
+}
+
+func (m *MemoryStore) findUser(userId uuid.UUID) (User, bool) {
+	for _, user := range m.users {
+		if user.UserId == userId {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+func (m *MemoryStore) ClearRows() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log.Println("WARNING: cleared all rows from memory store.")
+	m.bids = nil
+	m.plays = nil
+	return nil
+}
+
+// rankedSums sums the queued bids by song, in the order the auction strategy plays them. Deferred
+// bids, and songs that can't play next, are left out. The caller must hold m.mu.
+func (m *MemoryStore) rankedSums() []PostBidData {
+	eligible, _ := splitDeferred(m.bidsWithStatus(Queued))
+	winnable, _ := m.eligibility().split(scoreSongs(m.ranking(), groupBySong(eligible), m.now()), m.now())
+	return summedBids(winnable)
+}
+
+// updateStatus moves every bid matching the predicate, that is allowed to, to the given status and
+// returns the updated rows.
+func (m *MemoryStore) updateStatus(match func(BidRow) bool, status SongStatus) []BidRow {
+	now := m.now()
+	var result []BidRow
+	for i := range m.bids {
+		if match(m.bids[i]) && canTransition(m.bids[i].SongStatus, status) {
+			m.bids[i].SongStatus = status
+			m.bids[i].UpdatedAt = now
+			result = append(result, m.bids[i])
+		}
+	}
+	return result
+}
@@ -0,0 +1,385 @@
+package cockroach
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/acidleroy/song-bid/events"
+	"github.com/acidleroy/song-bid/ledger"
+	"github.com/acidleroy/song-bid/pricing"
+	"github.com/google/uuid"
+)
+
+func postBidsHelper(t *testing.T, store BidStore, bids []PostBidData) {
+	for _, bid := range bids {
+		if _, err := store.PostBid(bid); err != nil {
+			t.Logf("Failed to post bid: %v", err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestMemoryStorePostBid(t *testing.T) {
+	store := NewMemoryStore()
+
+	result, err := store.PostBid(PostBidData{BidAmount: 1, SongId: "some-song-id"})
+	if err != nil {
+		t.Fatalf("Received an error: %v", err)
+	}
+	if result == nil {
+		t.Fatal("Result should have had an ID")
+	}
+
+	bids, err := store.GetBids()
+	if err != nil {
+		t.Fatalf("Failed to get bids: %v", err)
+	}
+	if len(bids) != 1 || bids[0].BidId != *result || bids[0].SongStatus != 0 {
+		t.Fatalf("Expected a single unplayed bid with id %v, instead got %v", *result, bids)
+	}
+
+	bid, err := store.GetBid(*result)
+	if err != nil || bid != bids[0] {
+		t.Fatalf("Expected GetBid to return %v, instead got %v and %v", bids[0], bid, err)
+	}
+	if _, err := store.GetBid(uuid.New()); !errors.Is(err, ErrBidNotFound) {
+		t.Fatalf("Expected ErrBidNotFound for an unknown bid, instead received %v", err)
+	}
+}
+
+func TestMemoryStoreGetBidsGroupBySongId(t *testing.T) {
+	store := NewMemoryStore()
+	postBidsHelper(t, store, []PostBidData{
+		{BidAmount: 2, SongId: "song-a"},
+		{BidAmount: 2, SongId: "song-a"},
+		{BidAmount: 5, SongId: "song-b"},
+		{BidAmount: 5, SongId: "song-b"},
+	})
+
+	results, err := store.GetBidsGroupBySongId()
+	if err != nil {
+		t.Fatalf("Received an error from GetBidsGroupBySongId, %v", err)
+	}
+
+	expected := []PostBidData{{BidAmount: 10, SongId: "song-b"}, {BidAmount: 4, SongId: "song-a"}}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected the length of results to be %d, but instead got %d", len(expected), len(results))
+	}
+	for i := range expected {
+		if results[i] != expected[i] {
+			t.Fatalf("Expected result %d to be %v, instead got %v", i, expected[i], results[i])
+		}
+	}
+
+	highest, err := store.GetHighestBid()
+	if err != nil {
+		t.Fatalf("Got an error when calling GetHighestBid: %v", err)
+	}
+	if highest != expected[0] {
+		t.Fatalf("Expected the highest bid to be %v, instead got %v", expected[0], highest)
+	}
+}
+
+func TestMemoryStoreGetHighestBidEmpty(t *testing.T) {
+	store := NewMemoryStore()
+
+	bid, err := store.GetHighestBid()
+	if err != nil {
+		t.Fatalf("Got an error when calling GetHighestBid: %v", err)
+	}
+	if bid.BidAmount != 0 || bid.SongId != "" {
+		t.Fatalf("Expected to get an empty bid, but instead got %v", bid)
+	}
+}
+
+func TestMemoryStorePlayAndFinalize(t *testing.T) {
+	store := NewMemoryStore()
+	postBidsHelper(t, store, []PostBidData{
+		{BidAmount: 2, SongId: "song-a"},
+		{BidAmount: 2, SongId: "song-a"},
+		{BidAmount: 5, SongId: "song-b"},
+		{BidAmount: 5, SongId: "song-b"},
+	})
+
+	playing, err := store.PlayNextSong()
+	if err != nil {
+		t.Fatalf("Received an error when attempting to play next song %v", err)
+	}
+	if len(playing) != 2 {
+		t.Fatalf("Expected 2 playing bids, instead received %d", len(playing))
+	}
+	for _, row := range playing {
+		if row.SongStatus != Playing || row.SongId != "song-b" {
+			t.Fatalf("Expected song-b with status 1, instead received %v", row)
+		}
+	}
+
+	queue, _ := store.GetBidsGroupBySongId()
+	if len(queue) != 1 || queue[0].SongId != "song-a" {
+		t.Fatalf("Expected only song-a to remain queued, instead got %v", queue)
+	}
+
+	finalized, err := store.FinalizeCurrentSong()
+	if err != nil {
+		t.Fatalf("Could not finalize current song, got an error: %v", err)
+	}
+	if len(finalized) != 2 {
+		t.Fatalf("Expected to have only updated 2 rows, but instead updated %d", len(finalized))
+	}
+	for _, row := range finalized {
+		if row.SongStatus != Played {
+			t.Fatalf("Expected song status to be 2, instead got %v", row.SongStatus)
+		}
+	}
+}
+
+func TestMemoryStorePlayNextSongEmptyList(t *testing.T) {
+	store := NewMemoryStore()
+
+	rows, err := store.PlayNextSong()
+	if !errors.Is(err, ErrNoSongQueued) {
+		t.Fatalf("Expected ErrNoSongQueued when the queue is empty, instead received %v", err)
+	}
+	if len(rows) != 0 {
+		t.Fatalf("Should have received no rows, instead received %d", len(rows))
+	}
+}
+
+func TestMemoryStoreClearRows(t *testing.T) {
+	store := NewMemoryStore()
+	postBidsHelper(t, store, []PostBidData{{BidAmount: 1, SongId: "song-a"}})
+
+	if err := store.ClearRows(); err != nil {
+		t.Fatalf("Failed to clear rows: %v", err)
+	}
+	if bids, _ := store.GetBids(); len(bids) != 0 {
+		t.Fatalf("Expected no bids after ClearRows, instead got %d", len(bids))
+	}
+}
+
+func TestMemoryStorePostInvalidBid(t *testing.T) {
+	store := NewMemoryStore()
+
+	for _, bid := range []PostBidData{
+		{BidAmount: 0, SongId: "song-a"},
+		{BidAmount: -1, SongId: "song-a"},
+		{BidAmount: 1, SongId: ""},
+	} {
+		if _, err := store.PostBid(bid); !errors.Is(err, ErrInvalidBid) {
+			t.Fatalf("Expected ErrInvalidBid for %v, instead received %v", bid, err)
+		}
+	}
+}
+
+func TestStoreErrorIsStoreUnavailable(t *testing.T) {
+	cause := errors.New("connection reset")
+	err := storeError("get bids", cause)
+
+	if !errors.Is(err, ErrStoreUnavailable) {
+		t.Fatalf("Expected %v to match ErrStoreUnavailable", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Fatalf("Expected %v to wrap its cause", err)
+	}
+}
+
+func TestTxErrorPassesDomainErrors(t *testing.T) {
+	for _, cause := range []error{
+		ErrUnknownRoom,
+		fmt.Errorf("%w: song-a", ErrSongBanned),
+		ledger.ErrInsufficientFunds,
+		&pricing.RuleError{Violations: []pricing.Violation{{Field: "BidAmount", Message: "must be at least 2"}}},
+	} {
+		if err := txError("post bid", cause); !errors.Is(err, cause) || errors.Is(err, ErrStoreUnavailable) {
+			t.Fatalf("Expected %v to be passed on as it is, instead got %v", cause, err)
+		}
+	}
+	if err := txError("post bid", errors.New("connection reset")); !errors.Is(err, ErrStoreUnavailable) {
+		t.Fatalf("Expected other errors to be store errors, instead got %v", err)
+	}
+}
+
+func TestMemoryStorePlayNextSongWhilePlaying(t *testing.T) {
+	store := NewMemoryStore()
+	postBidsHelper(t, store, []PostBidData{
+		{BidAmount: 2, SongId: "song-a"},
+		{BidAmount: 5, SongId: "song-b"},
+	})
+
+	if _, err := store.PlayNextSong(); err != nil {
+		t.Fatalf("Received an error when attempting to play next song %v", err)
+	}
+	if _, err := store.PlayNextSong(); !errors.Is(err, ErrSongAlreadyPlaying) {
+		t.Fatalf("Expected ErrSongAlreadyPlaying while song-b is playing, instead received %v", err)
+	}
+
+	bids, _ := store.GetBids()
+	playing := 0
+	for _, bid := range bids {
+		if bid.SongStatus == Playing {
+			playing++
+		}
+	}
+	if playing != 1 {
+		t.Fatalf("Expected exactly one playing bid, instead found %d", playing)
+	}
+
+	if _, err := store.FinalizeCurrentSong(); err != nil {
+		t.Fatalf("Could not finalize current song, got an error: %v", err)
+	}
+	rows, err := store.PlayNextSong()
+	if err != nil || len(rows) != 1 || rows[0].SongId != "song-a" {
+		t.Fatalf("Expected song-a to play after song-b was finalized, instead got %v and %v", rows, err)
+	}
+}
+
+func TestMemoryStoreFinishWithoutPlayingSong(t *testing.T) {
+	store := NewMemoryStore()
+	postBidsHelper(t, store, []PostBidData{{BidAmount: 2, SongId: "song-a"}})
+
+	if _, err := store.FinalizeCurrentSong(); !errors.Is(err, ErrNoSongPlaying) {
+		t.Fatalf("Expected ErrNoSongPlaying from FinalizeCurrentSong, instead received %v", err)
+	}
+	if _, err := store.SkipCurrentSong(); !errors.Is(err, ErrNoSongPlaying) {
+		t.Fatalf("Expected ErrNoSongPlaying from SkipCurrentSong, instead received %v", err)
+	}
+}
+
+func TestMemoryStoreSkipAndRefund(t *testing.T) {
+	store := NewMemoryStore()
+	postBidsHelper(t, store, []PostBidData{
+		{BidAmount: 5, SongId: "song-a"},
+		{BidAmount: 1, SongId: "song-b"},
+	})
+
+	if _, err := store.PlayNextSong(); err != nil {
+		t.Fatalf("Received an error when attempting to play next song %v", err)
+	}
+	skipped, err := store.SkipCurrentSong()
+	if err != nil || len(skipped) != 1 || skipped[0].SongStatus != Skipped {
+		t.Fatalf("Expected song-a to be skipped, instead got %v and %v", skipped, err)
+	}
+
+	bids, _ := store.GetBids()
+	queued := bids[1]
+	refunded, err := store.RefundBids([]uuid.UUID{skipped[0].BidId, queued.BidId}, ReasonOperator)
+	if err != nil {
+		t.Fatalf("Failed to refund bids: %v", err)
+	}
+	if len(refunded) != 2 {
+		t.Fatalf("Expected 2 refunded bids, instead got %d", len(refunded))
+	}
+	for _, row := range refunded {
+		if row.SongStatus != Refunded {
+			t.Fatalf("Expected song status to be refunded, instead got %v", row.SongStatus)
+		}
+	}
+
+	if _, err := store.RefundBids([]uuid.UUID{queued.BidId}, ReasonOperator); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("Expected ErrInvalidTransition when refunding twice, instead received %v", err)
+	}
+	if _, err := store.RefundBids([]uuid.UUID{uuid.New()}, ReasonOperator); !errors.Is(err, ErrBidNotFound) {
+		t.Fatalf("Expected ErrBidNotFound for an unknown bid, instead received %v", err)
+	}
+}
+
+func TestMemoryStoreRefundIsAllOrNothing(t *testing.T) {
+	store := NewMemoryStore()
+	postBidsHelper(t, store, []PostBidData{
+		{BidAmount: 5, SongId: "song-a"},
+		{BidAmount: 1, SongId: "song-b"},
+	})
+	playing, _ := store.PlayNextSong()
+	bids, _ := store.GetBids()
+
+	if _, err := store.RefundBids([]uuid.UUID{bids[1].BidId, playing[0].BidId}, ReasonOperator); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("Expected ErrInvalidTransition when refunding a playing bid, instead received %v", err)
+	}
+	if bids, _ := store.GetBids(); bids[1].SongStatus != Queued {
+		t.Fatalf("Expected the queued bid to be left alone, instead it has status %v", bids[1].SongStatus)
+	}
+
+	// A credit that fails leaves the bids refunded before it alone too.
+	alice := bidderHelper(t, store, 10)
+	postBidsHelper(t, store, []PostBidData{
+		{BidAmount: 2, SongId: "song-c", UserId: alice},
+		{BidAmount: 3, SongId: "song-c", UserId: alice},
+	})
+	bids, _ = store.GetBids()
+	if _, err := store.ledger.Post(context.Background(), refundCredit(bids[3], 0)); err != nil {
+		t.Fatalf("Failed to post the refund ahead of time: %v", err)
+	}
+	if _, err := store.RefundQueuedBids(RefundFilter{SongId: "song-c"}, ReasonOperator); !errors.Is(err, ledger.ErrDuplicateReference) {
+		t.Fatalf("Expected ErrDuplicateReference, instead received %v", err)
+	}
+	if bids, _ := store.GetBids(); bids[2].SongStatus != Queued {
+		t.Fatalf("Expected the first bid of song-c to stay queued, instead it has status %v", bids[2].SongStatus)
+	}
+	if balance, _ := store.GetBalance(alice.UUID); balance != 8 {
+		t.Fatalf("Expected only the refund posted ahead of time, instead alice holds %d coins", balance)
+	}
+}
+
+func TestMemoryStorePostBidPaysFromWallet(t *testing.T) {
+	store := NewMemoryStore()
+	user, _ := store.CreateUser("alice", "hash")
+	userId := uuid.NullUUID{UUID: user.UserId, Valid: true}
+
+	if _, err := store.PostBid(PostBidData{BidAmount: 1, SongId: "song-a", UserId: userId}); !errors.Is(err, ledger.ErrInsufficientFunds) {
+		t.Fatalf("Expected ErrInsufficientFunds with an empty wallet, instead received %v", err)
+	}
+	if err := store.CreditCoins(user.UserId, 5, "purchase:1"); err != nil {
+		t.Fatalf("Failed to credit coins: %v", err)
+	}
+	if _, err := store.PostBid(PostBidData{BidAmount: 3, SongId: "song-a", UserId: userId}); err != nil {
+		t.Fatalf("Failed to post bid: %v", err)
+	}
+	if _, err := store.PostBid(PostBidData{BidAmount: 3, SongId: "song-b", UserId: userId}); !errors.Is(err, ledger.ErrInsufficientFunds) {
+		t.Fatalf("Expected ErrInsufficientFunds for a bid over the balance, instead received %v", err)
+	}
+
+	if balance, _ := store.GetBalance(user.UserId); balance != 2 {
+		t.Fatalf("Expected 2 coins to be left, instead got %d", balance)
+	}
+	if bids, _ := store.GetBids(); len(bids) != 1 {
+		t.Fatalf("Expected only the paid bid to be stored, instead got %v", bids)
+	}
+}
+
+func TestMemoryStorePublishesEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewMemoryStore()
+	subscription := store.Events().Subscribe(ctx)
+	postBidsHelper(t, store, []PostBidData{{BidAmount: 5, SongId: "song-a"}})
+	if _, err := store.PlayNextSong(); err != nil {
+		t.Fatalf("Received an error when attempting to play next song %v", err)
+	}
+	if _, err := store.SkipCurrentSong(); err != nil {
+		t.Fatalf("Received an error when attempting to skip the song %v", err)
+	}
+	bids, _ := store.GetBids()
+	if _, err := store.RefundBids([]uuid.UUID{bids[0].BidId}, ReasonOperator); err != nil {
+		t.Fatalf("Failed to refund bids: %v", err)
+	}
+
+	expected := []events.Type{events.BidPlaced, events.QueueReordered, events.SongStarted, events.QueueReordered, events.SongFinished, events.BidRefunded, events.QueueReordered}
+	for i, eventType := range expected {
+		select {
+		case event := <-subscription:
+			if event.Type != eventType {
+				t.Fatalf("Expected event %d to be %v, instead got %v", i, eventType, event.Type)
+			}
+			switch event.Data.(type) {
+			case BidRow, []BidRow:
+				t.Fatalf("Expected event %d to leave out the bidders, instead got %+v", i, event.Data)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected event %d to be %v, instead received nothing", i, eventType)
+		}
+	}
+}
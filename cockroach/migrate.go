@@ -0,0 +1,287 @@
+package cockroach
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/cockroach-go/v2/crdb/crdbpgx"
+	"github.com/jackc/pgx/v4"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationName matches files such as 0002_add_user_id.up.sql.
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one step of the schema. Up moves the schema to Version, Down moves it back to the
+// previous version.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Checksum identifies the contents of the Up script. It is stored in schema_version so that
+// editing a migration after it has been applied is detected.
+func (m Migration) Checksum() string {
+	return checksum(m.Up)
+}
+
+// DownChecksum identifies the contents of the Down script, stored next to Checksum so that a
+// migration isn't reverted with another script than the one it was applied with.
+func (m Migration) DownChecksum() string {
+	return checksum(m.Down)
+}
+
+func checksum(script string) string {
+	sum := sha256.Sum256([]byte(script))
+	return hex.EncodeToString(sum[:])
+}
+
+// AppliedMigration is a row of the schema_version table. DownChecksum is empty for migrations
+// applied before it was recorded.
+type AppliedMigration struct {
+	Version      int
+	Name         string
+	Checksum     string
+	DownChecksum string
+	AppliedAt    time.Time
+}
+
+// Migrations returns the migrations embedded in the package ordered by version.
+func Migrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, "migrations")
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read migrations: %w", err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected file in migrations: %v", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+
+		buf, err := fs.ReadFile(fsys, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("could not read migration %v: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %v and %v", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(buf)
+		} else {
+			migration.Down = string(buf)
+		}
+	}
+
+	result := []Migration{}
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%v needs both an up and a down script", migration.Version, migration.Name)
+		}
+		result = append(result, *migration)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+
+	for i, migration := range result {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migrations must be numbered consecutively from 1, found %d at position %d", migration.Version, i+1)
+		}
+	}
+	return result, nil
+}
+
+// createSchemaVersionTable creates the schema_version table, and the schema_lock table with the
+// single row every migration step locks. The row is seeded in a statement of its own, so that it
+// exists before the first step of any run locks it.
+func (db *Database) createSchemaVersionTable(ctx context.Context) error {
+	_, err := db.connection.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_version (
+		version INT PRIMARY KEY,
+		name STRING NOT NULL,
+		checksum STRING NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return storeError("create schema_version", err)
+	}
+	if _, err := db.connection.Exec(ctx, "ALTER TABLE schema_version ADD COLUMN IF NOT EXISTS down_checksum STRING NOT NULL DEFAULT ''"); err != nil {
+		return storeError("create schema_version", err)
+	}
+	if _, err := db.connection.Exec(ctx, "CREATE TABLE IF NOT EXISTS schema_lock (id INT PRIMARY KEY)"); err != nil {
+		return storeError("create schema_lock", err)
+	}
+	if _, err := db.connection.Exec(ctx, "INSERT INTO schema_lock (id) VALUES (1) ON CONFLICT (id) DO NOTHING"); err != nil {
+		return storeError("create schema_lock", err)
+	}
+	return nil
+}
+
+// AppliedMigrations returns the rows of the schema_version table ordered by version.
+func (db *Database) AppliedMigrations(ctx context.Context) ([]AppliedMigration, error) {
+	if err := db.createSchemaVersionTable(ctx); err != nil {
+		return nil, err
+	}
+
+	var result []AppliedMigration
+	err := crdbpgx.ExecuteTx(ctx, db.connection, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+		var err error
+		result, err = appliedMigrationsTx(ctx, tx)
+		return err
+	})
+	if err != nil {
+		return nil, storeError("get applied migrations", err)
+	}
+	return result, nil
+}
+
+// appliedMigrationsTx reads the rows of the schema_version table ordered by version in the
+// transaction tx.
+func appliedMigrationsTx(ctx context.Context, tx pgx.Tx) ([]AppliedMigration, error) {
+	rows, err := tx.Query(ctx, "SELECT version, name, checksum, down_checksum, applied_at FROM schema_version ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []AppliedMigration{}
+	for rows.Next() {
+		applied := AppliedMigration{}
+		if err := rows.Scan(&applied.Version, &applied.Name, &applied.Checksum, &applied.DownChecksum, &applied.AppliedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, applied)
+	}
+	return result, rows.Err()
+}
+
+// verifyMigrations checks that the applied migrations are the first ones without a gap, and that
+// every one of them still matches the embedded scripts.
+func verifyMigrations(migrations []Migration, applied []AppliedMigration) error {
+	for i, row := range applied {
+		if row.Version > len(migrations) {
+			return fmt.Errorf("%w: database is at version %d but only %d migrations are known", ErrMigration, row.Version, len(migrations))
+		}
+		if row.Version != i+1 {
+			return fmt.Errorf("%w: migration %d_%v is missing below version %d", ErrMigration, i+1, migrations[i].Name, row.Version)
+		}
+		migration := migrations[row.Version-1]
+		if row.Checksum != migration.Checksum() {
+			return fmt.Errorf("%w: migration %d_%v was changed after it was applied", ErrMigration, migration.Version, migration.Name)
+		}
+		if row.DownChecksum != "" && row.DownChecksum != migration.DownChecksum() {
+			return fmt.Errorf("%w: the down script of migration %d_%v was changed after it was applied", ErrMigration, migration.Version, migration.Name)
+		}
+	}
+	return nil
+}
+
+// CurrentVersion returns the highest applied version, 0 when nothing is applied.
+func CurrentVersion(applied []AppliedMigration) int {
+	current := 0
+	for _, row := range applied {
+		if row.Version > current {
+			current = row.Version
+		}
+	}
+	return current
+}
+
+// Migrate applies every embedded migration that hasn't been applied yet. Each migration runs in
+// its own transaction together with its schema_version row.
+func (db *Database) Migrate(ctx context.Context) error {
+	return db.MigrateTo(ctx, -1)
+}
+
+// MigrateTo moves the schema up or down to the given version. A negative version means the latest
+// embedded migration and version 0 removes everything. Every step locks the row of schema_lock and
+// reads the current version again in its transaction, so two runs at the same time take turns
+// instead of applying the same migration twice, even on an empty database.
+func (db *Database) MigrateTo(ctx context.Context, version int) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	if version < 0 {
+		version = len(migrations)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("%w: unknown version %d, the latest is %d", ErrMigration, version, len(migrations))
+	}
+	if err := db.createSchemaVersionTable(ctx); err != nil {
+		return err
+	}
+
+	for {
+		op, done, step := "migrate", false, ""
+		err := crdbpgx.ExecuteTx(ctx, db.connection, pgx.TxOptions{}, func(tx pgx.Tx) error {
+			op, done = "migrate", false
+			if _, err := tx.Exec(ctx, "SELECT id FROM schema_lock WHERE id = 1 FOR UPDATE"); err != nil {
+				return err
+			}
+			applied, err := appliedMigrationsTx(ctx, tx)
+			if err != nil {
+				return err
+			}
+			if err := verifyMigrations(migrations, applied); err != nil {
+				return err
+			}
+
+			current := CurrentVersion(applied)
+			switch {
+			case current < version:
+				migration := migrations[current]
+				op = fmt.Sprintf("apply migration %d_%v", migration.Version, migration.Name)
+				step = fmt.Sprintf("Applied migration %d_%v", migration.Version, migration.Name)
+				if _, err := tx.Exec(ctx, migration.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, "INSERT INTO schema_version (version, name, checksum, down_checksum) VALUES ($1, $2, $3, $4)",
+					migration.Version, migration.Name, migration.Checksum(), migration.DownChecksum())
+				return err
+			case current > version:
+				migration := migrations[current-1]
+				op = fmt.Sprintf("revert migration %d_%v", migration.Version, migration.Name)
+				step = fmt.Sprintf("Reverted migration %d_%v", migration.Version, migration.Name)
+				if _, err := tx.Exec(ctx, migration.Down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, "DELETE FROM schema_version WHERE version = $1", migration.Version)
+				return err
+			default:
+				done = true
+				return nil
+			}
+		})
+		if err != nil {
+			return txError(op, err)
+		}
+		if done {
+			return nil
+		}
+		log.Println(step)
+	}
+}
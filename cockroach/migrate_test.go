@@ -0,0 +1,121 @@
+package cockroach
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Failed to load the embedded migrations: %v", err)
+	}
+	if len(migrations) == 0 || migrations[0].Name != "create_tbl_bid" {
+		t.Fatalf("Expected the first migration to create tbl_bid, instead got %v", migrations)
+	}
+}
+
+func TestLoadMigrationsRejectsBadLayouts(t *testing.T) {
+	testTable := map[string]fstest.MapFS{
+		"missing down": {
+			"m/0001_a.up.sql": {Data: []byte("SELECT 1")},
+		},
+		"gap in versions": {
+			"m/0001_a.up.sql":   {Data: []byte("SELECT 1")},
+			"m/0001_a.down.sql": {Data: []byte("SELECT 1")},
+			"m/0003_c.up.sql":   {Data: []byte("SELECT 1")},
+			"m/0003_c.down.sql": {Data: []byte("SELECT 1")},
+		},
+		"unexpected file": {
+			"m/README.md": {Data: []byte("hello")},
+		},
+	}
+
+	for name, fsys := range testTable {
+		if _, err := loadMigrations(fsys, "m"); err == nil {
+			t.Fatalf("Expected an error for %v", name)
+		}
+	}
+}
+
+func TestVerifyMigrationsChecksum(t *testing.T) {
+	migrations := []Migration{{Version: 1, Name: "a", Up: "SELECT 1", Down: "SELECT 1"}}
+
+	applied := []AppliedMigration{{Version: 1, Name: "a", Checksum: migrations[0].Checksum()}}
+	if err := verifyMigrations(migrations, applied); err != nil {
+		t.Fatalf("Expected matching checksums to verify, got %v", err)
+	}
+
+	applied[0].Checksum = "edited"
+	if err := verifyMigrations(migrations, applied); !errors.Is(err, ErrMigration) {
+		t.Fatalf("Expected ErrMigration for a changed migration, got %v", err)
+	}
+
+	applied = append(applied, AppliedMigration{Version: 2, Name: "b"})
+	if err := verifyMigrations(migrations, applied); !errors.Is(err, ErrMigration) {
+		t.Fatalf("Expected ErrMigration for an unknown version, got %v", err)
+	}
+
+	migrations = append(migrations, Migration{Version: 2, Name: "b", Up: "SELECT 2", Down: "SELECT 2"})
+	applied = []AppliedMigration{
+		{Version: 1, Name: "a", Checksum: migrations[0].Checksum(), DownChecksum: migrations[0].DownChecksum()},
+		{Version: 2, Name: "b", Checksum: migrations[1].Checksum()},
+	}
+	if err := verifyMigrations(migrations, applied); err != nil || CurrentVersion(applied) != 2 {
+		t.Fatalf("Expected version 2 to verify without a down checksum, got %v", err)
+	}
+	applied[0].DownChecksum = "edited"
+	if err := verifyMigrations(migrations, applied); !errors.Is(err, ErrMigration) {
+		t.Fatalf("Expected ErrMigration for a changed down script, got %v", err)
+	}
+	if err := verifyMigrations(migrations, applied[1:]); !errors.Is(err, ErrMigration) {
+		t.Fatalf("Expected ErrMigration for a missing migration, got %v", err)
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	db := connectHelper(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	if err := db.MigrateTo(ctx, 0); err != nil {
+		t.Fatalf("Failed to revert all migrations: %v", err)
+	}
+	if applied, err := db.AppliedMigrations(ctx); err != nil || len(applied) != 0 {
+		t.Fatalf("Expected no applied migrations, got %v and %v", applied, err)
+	}
+
+	if err := db.Migrate(ctx); err != nil {
+		t.Fatalf("Failed to migrate up again: %v", err)
+	}
+	migrations, _ := Migrations()
+	if applied, err := db.AppliedMigrations(ctx); err != nil || len(applied) != len(migrations) {
+		t.Fatalf("Expected %d applied migrations, got %v and %v", len(migrations), applied, err)
+	}
+}
+
+func TestMigrateConcurrently(t *testing.T) {
+	db := connectHelper(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	if err := db.MigrateTo(ctx, 0); err != nil {
+		t.Fatalf("Failed to revert all migrations: %v", err)
+	}
+	// Both runs start on an empty schema_version table and take turns on the lock row.
+	errs := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- db.Migrate(ctx) }()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Expected concurrent runs to migrate, instead received %v", err)
+		}
+	}
+	migrations, _ := Migrations()
+	if applied, err := db.AppliedMigrations(ctx); err != nil || len(applied) != len(migrations) {
+		t.Fatalf("Expected %d applied migrations, got %v and %v", len(migrations), applied, err)
+	}
+}
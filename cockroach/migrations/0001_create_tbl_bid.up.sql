@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS "tbl_bid" (
+    "bid_id" UUID PRIMARY KEY,
+    "song_id" STRING(100),
+    "bid_amount" INT,
+    "song_status" INT,
+    "created_at" TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+ALTER TABLE "tbl_bid" DROP CONSTRAINT IF EXISTS "check_song_status";
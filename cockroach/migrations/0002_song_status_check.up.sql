@@ -0,0 +1 @@
+ALTER TABLE "tbl_bid" ADD CONSTRAINT "check_song_status" CHECK ("song_status" BETWEEN 0 AND 5);
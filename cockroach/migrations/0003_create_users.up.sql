@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS "users" (
+    "user_id" UUID PRIMARY KEY,
+    "username" STRING NOT NULL UNIQUE,
+    "password_hash" STRING NOT NULL,
+    "created_at" TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS "sessions" (
+    "token_hash" STRING PRIMARY KEY,
+    "user_id" UUID NOT NULL REFERENCES "users" ("user_id") ON DELETE CASCADE,
+    "expires_at" TIMESTAMPTZ NOT NULL
+);
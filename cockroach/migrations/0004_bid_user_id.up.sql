@@ -0,0 +1 @@
+ALTER TABLE "tbl_bid" ADD COLUMN IF NOT EXISTS "user_id" UUID REFERENCES "users" ("user_id");
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS "tbl_bid"@"tbl_bid_user_id_idx";
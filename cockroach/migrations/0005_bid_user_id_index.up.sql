@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS "tbl_bid_user_id_idx" ON "tbl_bid" ("user_id");
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS "ledger_transactions" (
+    "transaction_id" UUID PRIMARY KEY,
+    "reference" STRING NOT NULL UNIQUE,
+    "created_at" TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS "ledger_entries" (
+    "transaction_id" UUID NOT NULL REFERENCES "ledger_transactions" ("transaction_id") ON DELETE CASCADE,
+    "account_id" STRING NOT NULL,
+    "amount" INT8 NOT NULL CHECK ("amount" <> 0),
+    PRIMARY KEY ("transaction_id", "account_id"),
+    INDEX "ledger_entries_account_id_idx" ("account_id")
+);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS "invoices" (
+    "payment_hash" STRING PRIMARY KEY,
+    "payment_request" STRING NOT NULL,
+    "user_id" UUID NOT NULL REFERENCES "users" ("user_id"),
+    "coins" INT8 NOT NULL CHECK ("coins" > 0),
+    "amount_sats" INT8 NOT NULL CHECK ("amount_sats" > 0),
+    "settled" BOOL NOT NULL DEFAULT false,
+    "created_at" TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "expires_at" TIMESTAMPTZ NOT NULL,
+    "settled_at" TIMESTAMPTZ,
+    INDEX "invoices_user_id_idx" ("user_id"),
+    INDEX "invoices_pending_idx" ("settled", "expires_at")
+);
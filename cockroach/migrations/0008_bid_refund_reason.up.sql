@@ -0,0 +1 @@
+ALTER TABLE "tbl_bid" ADD COLUMN IF NOT EXISTS "refund_reason" STRING NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS "banned_songs" (
+    "song_id" STRING(100) PRIMARY KEY,
+    "banned_at" TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
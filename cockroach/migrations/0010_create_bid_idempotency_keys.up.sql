@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS "bid_idempotency_keys" (
+    "key_scope" STRING NOT NULL,
+    "idempotency_key" STRING(255) NOT NULL,
+    "fingerprint" STRING NOT NULL,
+    "bid_id" UUID NOT NULL,
+    "created_at" TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("key_scope", "idempotency_key")
+);
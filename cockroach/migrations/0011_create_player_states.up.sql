@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS "player_states" (
+    "room" STRING(64) PRIMARY KEY,
+    "track" STRING(255) NOT NULL,
+    "progress_ms" INT8 NOT NULL CHECK ("progress_ms" >= 0),
+    "duration_ms" INT8 NOT NULL CHECK ("duration_ms" >= 0),
+    "device" STRING(255) NOT NULL DEFAULT '',
+    "updated_at" TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+ALTER TABLE "tbl_bid" ADD COLUMN IF NOT EXISTS "deferred" BOOL NOT NULL DEFAULT false;
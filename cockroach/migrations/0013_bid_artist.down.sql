@@ -0,0 +1 @@
+ALTER TABLE "tbl_bid" DROP COLUMN IF EXISTS "artist";
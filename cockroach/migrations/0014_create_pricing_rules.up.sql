@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS "pricing_rules" (
+    "room" STRING(64) PRIMARY KEY,
+    "rules" JSONB NOT NULL,
+    "updated_at" TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS "song_plays" (
+    "play_id" UUID PRIMARY KEY,
+    "room" STRING(64) NOT NULL,
+    "song_id" STRING(100) NOT NULL,
+    "artists" STRING[] NOT NULL,
+    "started_at" TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX "song_plays_room_started_at_idx" ("room", "started_at")
+);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS "song_artists" (
+    "song_id" STRING(100) PRIMARY KEY,
+    "artist" STRING(255) NOT NULL,
+    "created_at" TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
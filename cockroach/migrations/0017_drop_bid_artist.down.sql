@@ -0,0 +1 @@
+ALTER TABLE "tbl_bid" ADD COLUMN IF NOT EXISTS "artist" STRING(255) NOT NULL DEFAULT '';
@@ -0,0 +1,94 @@
+package cockroach
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// DefaultRoom is the room of players that don't name one. Bids aren't placed in a room yet, so the
+// queue is ranked, priced and locked in DefaultRoom only, and the settings of other rooms are
+// rejected with ErrUnknownRoom.
+const DefaultRoom string = "default"
+
+// PlayerState is what the player of a room last reported: the track it plays, how far into the
+// track it is and on which device. UpdatedAt is set by the store when the state is reported, so
+// readers can tell how old ProgressMs is.
+type PlayerState struct {
+	Room       string    `json:"room"`
+	Track      string    `json:"track"`
+	ProgressMs int64     `json:"progress_ms"`
+	DurationMs int64     `json:"duration_ms"`
+	Device     string    `json:"device"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	// BidCutoff is set by the store when bids are locked near the end of the track: bids placed from
+	// then on only count from the round after the next song. It is nil without a lock.
+	BidCutoff *time.Time `json:"bid_cutoff,omitempty"`
+}
+
+// PlayerStore keeps the playback state the player of each room reports.
+type PlayerStore interface {
+	// SetPlayerState replaces the state of the room and returns it as stored.
+	SetPlayerState(state PlayerState) (PlayerState, error)
+	// GetPlayerState returns the last state reported for the room, or ErrPlayerStateNotFound.
+	GetPlayerState(room string) (PlayerState, error)
+}
+
+const playerStateColumns string = "room, track, progress_ms, duration_ms, device, updated_at"
+
+// SetPlayerState replaces the state of the room and returns it as stored.
+func (db *Database) SetPlayerState(state PlayerState) (PlayerState, error) {
+	state.UpdatedAt = time.Now()
+	state.BidCutoff = bidCutoff(state, db.lockWindow(), state.UpdatedAt)
+	_, err := db.connection.Exec(context.Background(),
+		"UPSERT INTO player_states ("+playerStateColumns+") VALUES ($1, $2, $3, $4, $5, $6)",
+		state.Room, state.Track, state.ProgressMs, state.DurationMs, state.Device, state.UpdatedAt)
+	if err != nil {
+		return PlayerState{}, storeError("set player state", err)
+	}
+	return state, nil
+}
+
+// GetPlayerState returns the last state reported for the room, or ErrPlayerStateNotFound.
+func (db *Database) GetPlayerState(room string) (PlayerState, error) {
+	state := PlayerState{}
+	err := db.connection.QueryRow(context.Background(),
+		"SELECT "+playerStateColumns+" FROM player_states WHERE room = $1", room).Scan(
+		&state.Room, &state.Track, &state.ProgressMs, &state.DurationMs, &state.Device, &state.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return PlayerState{}, fmt.Errorf("get player state: %w: %v", ErrPlayerStateNotFound, room)
+	}
+	if err != nil {
+		return PlayerState{}, storeError("get player state", err)
+	}
+	state.BidCutoff = bidCutoff(state, db.lockWindow(), time.Now())
+	return state, nil
+}
+
+// SetPlayerState replaces the state of the room and returns it as stored.
+func (m *MemoryStore) SetPlayerState(state PlayerState) (PlayerState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state.UpdatedAt = m.now()
+	state.BidCutoff = nil
+	m.players[state.Room] = state
+	state.BidCutoff = bidCutoff(state, m.auction.LockWindow, state.UpdatedAt)
+	return state, nil
+}
+
+// GetPlayerState returns the last state reported for the room, or ErrPlayerStateNotFound.
+func (m *MemoryStore) GetPlayerState(room string) (PlayerState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.players[room]
+	if !ok {
+		return PlayerState{}, fmt.Errorf("get player state: %w: %v", ErrPlayerStateNotFound, room)
+	}
+	state.BidCutoff = bidCutoff(state, m.auction.LockWindow, m.now())
+	return state, nil
+}
@@ -0,0 +1,34 @@
+package cockroach
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// playerStoreHelper checks that the player state is kept per room and replaced by each report.
+func playerStoreHelper(t *testing.T, store Store) {
+	room := "room-" + uuid.New().String()[:8]
+	if _, err := store.GetPlayerState(room); !errors.Is(err, ErrPlayerStateNotFound) {
+		t.Fatalf("Expected ErrPlayerStateNotFound before the player reported, instead received %v", err)
+	}
+
+	for _, progress := range []int64{1000, 2000} {
+		stored, err := store.SetPlayerState(PlayerState{Room: room, Track: "song-a", ProgressMs: progress, DurationMs: 180000, Device: "speaker"})
+		if err != nil || stored.UpdatedAt.IsZero() {
+			t.Fatalf("Failed to set the player state: %+v and %v", stored, err)
+		}
+	}
+	state, err := store.GetPlayerState(room)
+	if err != nil || state.Track != "song-a" || state.ProgressMs != 2000 || state.DurationMs != 180000 || state.Device != "speaker" {
+		t.Fatalf("Expected the last reported state, instead got %+v and %v", state, err)
+	}
+	if _, err := store.GetPlayerState("other-" + room); !errors.Is(err, ErrPlayerStateNotFound) {
+		t.Fatalf("Expected the state of another room to be unknown, instead received %v", err)
+	}
+}
+
+func TestPlayerState(t *testing.T) {
+	eachStore(t, playerStoreHelper)
+}
@@ -0,0 +1,254 @@
+package cockroach
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/acidleroy/song-bid/pricing"
+	"github.com/cockroachdb/cockroach-go/v2/crdb/crdbpgx"
+	"github.com/jackc/pgx/v4"
+)
+
+// PricingStore keeps the pricing rules of DefaultRoom, the only room that has any, and the artists
+// of songs.
+type PricingStore interface {
+	// GetPricingRules returns the rules of the room, the zero rules if none were set.
+	GetPricingRules(room string) (pricing.Rules, error)
+	// SetPricingRules replaces the rules of the room. Rules that don't validate fail with
+	// pricing.ErrInvalidRules.
+	SetPricingRules(room string, rules pricing.Rules) error
+	// GetSongArtists returns the artists the operator set, by song id.
+	GetSongArtists() (map[string]string, error)
+	// SetSongArtist sets the artist of the song, replacing the one set before. Artist reserves and
+	// the artist limit apply to the song from then on, queued bids included.
+	SetSongArtist(songId, artist string) error
+	// ClearSongArtist forgets the artist of the song, if one was set.
+	ClearSongArtist(songId string) error
+}
+
+// pricingSong returns what the pricing rules know about a queued song.
+func (e eligibility) pricingSong(song SongBids) pricing.Song {
+	result := pricing.Song{SongId: song.SongId}
+	for _, bid := range song.Bids {
+		result.Total += bid.BidAmount
+	}
+	if artist, ok := e.artists[song.SongId]; ok {
+		result.Artists = []string{artist}
+	}
+	return result
+}
+
+// leader returns the song that plays next as the increment of the rules sees it from song, or nil
+// if there is no increment, no song may win or song can't pass it. How far song is behind is found
+// in the units of the ranking, like the amount to overtake.
+func leader(r ranking, rules pricing.Rules, winnable []scoredSong, song SongBids, now time.Time) *pricing.Leader {
+	if rules.MinIncrement <= 0 || len(winnable) == 0 || winnable[0].bids.SongId == song.SongId {
+		return nil
+	}
+	amount := amountToOvertake(r, song, winnable[0], now)
+	if amount <= 0 {
+		return nil
+	}
+	return &pricing.Leader{SongId: winnable[0].bids.SongId, Behind: amount - 1}
+}
+
+// checkBid checks a new bid against the pricing rules, given the queued bids. Only the bids that
+// count for the next song decide which song leads.
+func checkBid(e eligibility, r ranking, data PostBidData, queued []BidRow, now time.Time) error {
+	eligible, _ := splitDeferred(queued)
+	songs := groupBySong(eligible)
+	winnable, _ := e.split(scoreSongs(r, songs, now), now)
+
+	song := SongBids{SongId: data.SongId}
+	for _, s := range songs {
+		if s.SongId == data.SongId {
+			song = s
+		}
+	}
+	return e.rules.CheckBid(pricing.Bid{SongId: data.SongId, Amount: data.BidAmount},
+		e.pricingSong(song), leader(r, e.rules, winnable, song, now))
+}
+
+// songArtistsTx reads the artists the operator set in the transaction tx.
+func songArtistsTx(ctx context.Context, tx pgx.Tx) (map[string]string, error) {
+	rows, err := tx.Query(ctx, "SELECT song_id, artist FROM song_artists")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	artists := map[string]string{}
+	for rows.Next() {
+		var songId, artist string
+		if err := rows.Scan(&songId, &artist); err != nil {
+			return nil, err
+		}
+		artists[songId] = artist
+	}
+	return artists, rows.Err()
+}
+
+// validateRules returns pricing.ErrInvalidRules if the rules don't validate.
+func validateRules(rules pricing.Rules) error {
+	if violations := rules.Validate(); len(violations) > 0 {
+		return fmt.Errorf("%w: %v %v", pricing.ErrInvalidRules, violations[0].Field, violations[0].Message)
+	}
+	return nil
+}
+
+// GetPricingRules returns the rules of the room, the zero rules if none were set.
+func (db *Database) GetPricingRules(room string) (pricing.Rules, error) {
+	if err := checkRoom(room); err != nil {
+		return pricing.Rules{}, fmt.Errorf("get pricing rules: %w", err)
+	}
+	var rules pricing.Rules
+	err := crdbpgx.ExecuteTx(context.Background(), db.connection, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+		var err error
+		rules, err = pricingRulesTx(context.Background(), tx, room)
+		return err
+	})
+	if err != nil {
+		return pricing.Rules{}, storeError("get pricing rules", err)
+	}
+	return rules, nil
+}
+
+// SetPricingRules replaces the rules of the room.
+func (db *Database) SetPricingRules(room string, rules pricing.Rules) error {
+	if err := checkRoom(room); err != nil {
+		return fmt.Errorf("set pricing rules: %w", err)
+	}
+	if err := validateRules(rules); err != nil {
+		return fmt.Errorf("set pricing rules: %w", err)
+	}
+	encoded, err := json.Marshal(rules.Clone())
+	if err != nil {
+		return fmt.Errorf("set pricing rules: %w", err)
+	}
+	if _, err := db.connection.Exec(context.Background(),
+		"UPSERT INTO pricing_rules (room, rules, updated_at) VALUES ($1, $2::JSONB, now())", room, string(encoded)); err != nil {
+		return storeError("set pricing rules", err)
+	}
+	return nil
+}
+
+// GetSongArtists returns the artists the operator set, by song id.
+func (db *Database) GetSongArtists() (map[string]string, error) {
+	var artists map[string]string
+	err := crdbpgx.ExecuteTx(context.Background(), db.connection, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+		var err error
+		artists, err = songArtistsTx(context.Background(), tx)
+		return err
+	})
+	if err != nil {
+		return nil, storeError("get song artists", err)
+	}
+	return artists, nil
+}
+
+// SetSongArtist sets the artist of the song, replacing the one set before.
+func (db *Database) SetSongArtist(songId, artist string) error {
+	if _, err := db.connection.Exec(context.Background(),
+		"INSERT INTO song_artists (song_id, artist) VALUES ($1, $2) ON CONFLICT (song_id) DO UPDATE SET artist = excluded.artist",
+		songId, artist); err != nil {
+		return storeError("set song artist", err)
+	}
+	db.publishQueue()
+	return nil
+}
+
+// ClearSongArtist forgets the artist of the song, if one was set.
+func (db *Database) ClearSongArtist(songId string) error {
+	if _, err := db.connection.Exec(context.Background(), "DELETE FROM song_artists WHERE song_id = $1", songId); err != nil {
+		return storeError("clear song artist", err)
+	}
+	db.publishQueue()
+	return nil
+}
+
+// checkRoom returns ErrUnknownRoom for every room but DefaultRoom, whose settings would never apply.
+func checkRoom(room string) error {
+	if room != DefaultRoom {
+		return fmt.Errorf("%w: %v", ErrUnknownRoom, room)
+	}
+	return nil
+}
+
+// pricingRulesTx reads the rules of the room in the transaction tx.
+func pricingRulesTx(ctx context.Context, tx pgx.Tx, room string) (pricing.Rules, error) {
+	var encoded []byte
+	err := tx.QueryRow(ctx, "SELECT rules FROM pricing_rules WHERE room = $1", room).Scan(&encoded)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return pricing.Rules{}.Clone(), nil
+	}
+	if err != nil {
+		return pricing.Rules{}, err
+	}
+	rules := pricing.Rules{}
+	if err := json.Unmarshal(encoded, &rules); err != nil {
+		return pricing.Rules{}, err
+	}
+	return rules.Clone(), nil
+}
+
+// GetPricingRules returns the rules of the room, the zero rules if none were set.
+func (m *MemoryStore) GetPricingRules(room string) (pricing.Rules, error) {
+	if err := checkRoom(room); err != nil {
+		return pricing.Rules{}, fmt.Errorf("get pricing rules: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.pricing[room].Clone(), nil
+}
+
+// SetPricingRules replaces the rules of the room.
+func (m *MemoryStore) SetPricingRules(room string, rules pricing.Rules) error {
+	if err := checkRoom(room); err != nil {
+		return fmt.Errorf("set pricing rules: %w", err)
+	}
+	if err := validateRules(rules); err != nil {
+		return fmt.Errorf("set pricing rules: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pricing[room] = rules.Clone()
+	return nil
+}
+
+// GetSongArtists returns the artists the operator set, by song id.
+func (m *MemoryStore) GetSongArtists() (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	artists := make(map[string]string, len(m.artists))
+	for songId, artist := range m.artists {
+		artists[songId] = artist
+	}
+	return artists, nil
+}
+
+// SetSongArtist sets the artist of the song, replacing the one set before.
+func (m *MemoryStore) SetSongArtist(songId, artist string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.artists[songId] = artist
+	m.publishQueue()
+	return nil
+}
+
+// ClearSongArtist forgets the artist of the song, if one was set.
+func (m *MemoryStore) ClearSongArtist(songId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.artists, songId)
+	m.publishQueue()
+	return nil
+}
@@ -0,0 +1,155 @@
+package cockroach
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/acidleroy/song-bid/pricing"
+	"github.com/google/uuid"
+)
+
+func TestCheckBidCountsLikeTheRanking(t *testing.T) {
+	now := time.Now()
+	r := ranking{strategy: Quadratic{}}
+	e := eligibility{rules: pricing.Rules{MinIncrement: 5}}
+	// song-a has 4 votes for 16 coins, song-b 3 votes for 3 coins of three users: a bid of 2 coins on
+	// song-b gives it the lead, though its total stays far below that of song-a.
+	songB := songBidsHelper("song-b", now, 1, 1, 1)
+	for i := range songB.Bids {
+		songB.Bids[i].UserId = uuid.NullUUID{UUID: uuid.New(), Valid: true}
+	}
+	queued := append(songBidsHelper("song-a", now, 16).Bids, songB.Bids...)
+
+	for _, test := range []struct {
+		amount int
+		ok     bool
+	}{{1, true}, {4, false}, {6, true}} {
+		err := checkBid(e, r, PostBidData{BidAmount: test.amount, SongId: "song-b"}, queued, now)
+		if (err == nil) != test.ok {
+			t.Fatalf("Expected a bid of %d to be accepted: %v, instead got %v", test.amount, test.ok, err)
+		}
+	}
+}
+
+func TestRebatesKeepTheReserve(t *testing.T) {
+	now := time.Now()
+	winner := songBidsHelper("song-a", now, 10)
+	winner.Bids[0].UserId = uuid.NullUUID{UUID: uuid.New(), Valid: true}
+	scored := scoreSongs(ranking{strategy: SecondPrice{}}, []SongBids{winner, songBidsHelper("song-b", now, 3)}, now)
+
+	// song-a would pay 4 coins, but its reserve is 8.
+	for _, test := range []struct {
+		rules  pricing.Rules
+		rebate int64
+	}{
+		{pricing.Rules{}, 6},
+		{pricing.Rules{SongReserves: map[string]int{"song-a": 8}}, 2},
+		{pricing.Rules{SongReserves: map[string]int{"song-a": 12}}, 0},
+	} {
+		var given int64
+		for _, rebate := range rebates(ranking{strategy: SecondPrice{}}, eligibility{rules: test.rules}, scored, now) {
+			given += rebate.Entries[1].Amount
+		}
+		if given != test.rebate {
+			t.Fatalf("Expected %d coins back with %+v, instead got %d", test.rebate, test.rules, given)
+		}
+	}
+}
+
+// pricingStoreHelper checks that bids have to meet the pricing rules and that songs below their
+// reserve are skipped, on a store holding no other bids.
+func pricingStoreHelper(t *testing.T, store Store) {
+	if rules, err := store.GetPricingRules(DefaultRoom); err != nil || rules.MinBid != 0 || rules.SongReserves == nil {
+		t.Fatalf("Expected no rules with empty reserves, instead got %+v and %v", rules, err)
+	}
+	if err := store.SetPricingRules(DefaultRoom, pricing.Rules{MinBid: -1}); !errors.Is(err, pricing.ErrInvalidRules) {
+		t.Fatalf("Expected ErrInvalidRules, instead received %v", err)
+	}
+	rules := pricing.Rules{MinBid: 2, MinIncrement: 3, SongReserves: map[string]int{"song-c": 10}, ArtistReserves: map[string]int{"The Band": 6}}
+	if err := store.SetPricingRules(DefaultRoom, rules); err != nil {
+		t.Fatalf("Failed to set the pricing rules: %v", err)
+	}
+	if stored, err := store.GetPricingRules(DefaultRoom); err != nil || stored.MinIncrement != 3 || stored.ArtistReserves["The Band"] != 6 {
+		t.Fatalf("Expected the rules to be stored, instead got %+v and %v", stored, err)
+	}
+
+	// Below the minimum, and past song-a's 5 coins by less than the increment.
+	for _, data := range []PostBidData{
+		{BidAmount: 1, SongId: "song-a"},
+		{BidAmount: 5, SongId: "song-a"},
+		{BidAmount: 6, SongId: "song-b"},
+	} {
+		_, err := store.PostBid(data)
+		var rejected *pricing.RuleError
+		if data.BidAmount == 5 {
+			if err != nil {
+				t.Fatalf("Failed to post bid: %v", err)
+			}
+		} else if !errors.As(err, &rejected) || rejected.Violations[0].Field != "BidAmount" {
+			t.Fatalf("Expected %+v to be rejected, instead received %v", data, err)
+		}
+	}
+	songArtistsHelper(t, store, map[string]string{"song-b": "Someone Else", "song-d": "the band"})
+	postBidsHelper(t, store, []PostBidData{
+		{BidAmount: 8, SongId: "song-b"},
+		{BidAmount: 4, SongId: "song-c"},
+		{BidAmount: 2, SongId: "song-d"},
+	})
+
+	// song-a wins a tie with song-b, so 3 coins take it past song-b and it needs the increment on top
+	// of the 2 coins that leave it behind.
+	queue, err := store.GetQueue()
+	if err != nil || len(queue.Songs) != 2 || queue.Songs[0].SongId != "song-b" || queue.Songs[1].AmountToOvertake != 5 {
+		t.Fatalf("Expected song-b and song-a to be ranked, instead got %+v and %v", queue, err)
+	}
+	// song-c needs 6 more coins, which would pass song-b by less than the increment.
+	if len(queue.Ineligible) != 2 || queue.Ineligible[0].SongId != "song-c" || queue.Ineligible[0].Reason != IneligibleBelowReserve ||
+		queue.Ineligible[0].Reserve != 10 || queue.Ineligible[0].AmountToReserve != 7 || queue.Ineligible[1].Reserve != 6 {
+		t.Fatalf("Expected song-c and song-d to be below their reserve, instead got %+v", queue.Ineligible)
+	}
+	if highest, err := store.GetHighestBid(); err != nil || highest.SongId != "song-b" {
+		t.Fatalf("Expected song-b to have the highest bid, instead got %+v and %v", highest, err)
+	}
+
+	for _, songId := range []string{"song-b", "song-a"} {
+		if next := playNextHelper(t, store); next != songId {
+			t.Fatalf("Expected %v to play next, instead got %v", songId, next)
+		}
+		if _, err := store.FinalizeCurrentSong(); err != nil {
+			t.Fatalf("Failed to finalize: %v", err)
+		}
+	}
+	if _, err := store.PlayNextSong(); !errors.Is(err, ErrNoSongQueued) {
+		t.Fatalf("Expected ErrNoSongQueued while the other songs are below their reserve, instead received %v", err)
+	}
+	if bids, err := store.GetBids(); err != nil || len(bids) != 4 {
+		t.Fatalf("Expected the 4 accepted bids, instead got %v and %v", bids, err)
+	}
+
+	// The artist reserve follows the artist the operator set, queued bids included.
+	if err := store.ClearSongArtist("song-d"); err != nil {
+		t.Fatalf("Failed to clear the artist: %v", err)
+	}
+	if artists, err := store.GetSongArtists(); err != nil || len(artists) != 1 || artists["song-b"] != "Someone Else" {
+		t.Fatalf("Expected only the artist of song-b, instead got %v and %v", artists, err)
+	}
+	if next := playNextHelper(t, store); next != "song-d" {
+		t.Fatalf("Expected song-d to play without its artist reserve, instead got %v", next)
+	}
+}
+
+// songArtistsHelper sets the artists of the songs, and clears them again when the test is done.
+func songArtistsHelper(t *testing.T, store Store, artists map[string]string) {
+	for songId, artist := range artists {
+		if err := store.SetSongArtist(songId, artist); err != nil {
+			t.Fatalf("Failed to set the artist of %v: %v", songId, err)
+		}
+		songId := songId
+		t.Cleanup(func() { store.ClearSongArtist(songId) })
+	}
+}
+
+func TestPricing(t *testing.T) {
+	eachStore(t, pricingStoreHelper)
+}
@@ -0,0 +1,173 @@
+package cockroach
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/cockroach-go/v2/crdb/crdbpgx"
+	"github.com/jackc/pgx/v4"
+)
+
+// SongTotals sums the bids on a song. UniqueBidders counts the users who bid on it; anonymous
+// bids are counted in BidCount only.
+type SongTotals struct {
+	SongId        string
+	TotalAmount   int
+	BidCount      int
+	UniqueBidders int
+}
+
+// RankedSong is a queued song and its place in the queue, 1 being the song that plays next.
+type RankedSong struct {
+	SongTotals
+	Rank int
+	// Score is what the auction strategy ranks the song by, and EffectiveAmount what its bids count
+	// for once older ones decayed. Both are rounded to two decimals.
+	Score           float64
+	EffectiveAmount float64
+	// AmountToOvertake is the number of coins that have to be bid on the song for it to rank above
+	// the song just above it. It is 0 for the first song.
+	AmountToOvertake int
+}
+
+// IneligibleReason says why a queued song can't play next.
+type IneligibleReason string
+
+const (
+	// IneligibleBelowReserve is used for songs whose bids don't add up to their reserve price yet.
+	IneligibleBelowReserve IneligibleReason = "below_reserve"
+	// IneligibleCooldown is used for songs that started too recently to play again.
+	IneligibleCooldown IneligibleReason = "cooldown"
+	// IneligibleArtistLimit is used for songs of an artist whose songs started as often as the
+	// hourly limit allows.
+	IneligibleArtistLimit IneligibleReason = "artist_limit"
+)
+
+// IneligibleSong is a queued song that can't play next, and why.
+type IneligibleSong struct {
+	SongTotals
+	Reason IneligibleReason
+	// Reserve is the total a song below its reserve price needs to be able to win, and
+	// AmountToReserve the bid that gets it there.
+	Reserve         int `json:",omitempty"`
+	AmountToReserve int `json:",omitempty"`
+	// EligibleAt is when the cooldown holding a song back ends.
+	EligibleAt *time.Time `json:",omitempty"`
+}
+
+// Queue is the ranking that decides which song plays next, and the song that is playing.
+type Queue struct {
+	// Strategy and Decay name the auction strategy and the decay the songs are ranked with.
+	Strategy string
+	Decay    string
+	// Playing is nil when no song is playing.
+	Playing *SongTotals
+	// Songs are the songs that can play next, ranked. Songs that can't are left out of the ranking
+	// and listed in Ineligible.
+	Songs      []RankedSong
+	Ineligible []IneligibleSong
+	// Deferred sums the bids placed in the lock window by song. They aren't part of the ranking
+	// for the next song, and join it once the next song started.
+	Deferred []SongTotals
+}
+
+// newQueue ranks the queued bids that may play next and adds the songs that can't and why, the
+// deferred bids and the playing song.
+func newQueue(r ranking, e eligibility, queued []BidRow, playing []BidRow, now time.Time) Queue {
+	eligible, deferred := splitDeferred(queued)
+	winnable, ineligible := e.split(scoreSongs(r, groupBySong(eligible), now), now)
+	queue := Queue{Strategy: r.strategy.Name(), Decay: decayName(r.decay), Songs: rankScored(r, e, winnable, now), Ineligible: []IneligibleSong{}, Deferred: []SongTotals{}}
+	for _, song := range ineligible {
+		entry := IneligibleSong{SongTotals: song.totals, Reason: song.reason}
+		if song.reason == IneligibleBelowReserve {
+			priced := e.pricingSong(song.bids)
+			entry.Reserve = e.rules.Reserve(priced)
+			entry.AmountToReserve = e.rules.Raise(entry.Reserve-priced.Total, priced, leader(r, e.rules, winnable, song.bids, now))
+		}
+		if !song.until.IsZero() {
+			until := song.until
+			entry.EligibleAt = &until
+		}
+		queue.Ineligible = append(queue.Ineligible, entry)
+	}
+	for _, song := range groupBySong(deferred) {
+		queue.Deferred = append(queue.Deferred, song.totals())
+	}
+	if songs := groupBySong(playing); len(songs) > 0 {
+		totals := songs[0].totals()
+		queue.Playing = &totals
+	}
+	return queue
+}
+
+// GetQueue returns the ranked queue and the playing song, read in one transaction.
+func (db *Database) GetQueue() (Queue, error) {
+	var queued, playing []BidRow
+	var e eligibility
+	err := crdbpgx.ExecuteTx(context.Background(), db.connection, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+		var err error
+		if e, err = db.eligibilityTx(context.Background(), tx); err != nil {
+			return err
+		}
+		if queued, err = bidsWithStatus(context.Background(), tx, Queued); err != nil {
+			return err
+		}
+		playing, err = bidsWithStatus(context.Background(), tx, Playing)
+		return err
+	})
+	if err != nil {
+		return Queue{}, storeError("get queue", err)
+	}
+	return newQueue(db.ranking(), e, queued, playing, time.Now()), nil
+}
+
+// GetPlayingBids returns the bids of the playing song, oldest first.
+func (db *Database) GetPlayingBids() ([]BidRow, error) {
+	var result []BidRow
+	err := crdbpgx.ExecuteTx(context.Background(), db.connection, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+		var err error
+		result, err = bidsWithStatus(context.Background(), tx, Playing)
+		return err
+	})
+	if err != nil {
+		return nil, storeError("get playing bids", err)
+	}
+	return result, nil
+}
+
+// bidsWithStatus returns the bids with the given status, oldest first.
+func bidsWithStatus(ctx context.Context, tx pgx.Tx, status SongStatus) ([]BidRow, error) {
+	rows, err := tx.Query(ctx, "SELECT "+bidColumns+" FROM tbl_bid WHERE song_status = $1 ORDER BY created_at, bid_id", status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanBidRows(rows)
+}
+
+// GetQueue returns the ranked queue and the playing song.
+func (m *MemoryStore) GetQueue() (Queue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return newQueue(m.ranking(), m.eligibility(), m.bidsWithStatus(Queued), m.bidsWithStatus(Playing), m.now()), nil
+}
+
+// GetPlayingBids returns the bids of the playing song, oldest first.
+func (m *MemoryStore) GetPlayingBids() ([]BidRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.bidsWithStatus(Playing), nil
+}
+
+// bidsWithStatus returns the bids with the given status. The caller must hold m.mu.
+func (m *MemoryStore) bidsWithStatus(status SongStatus) []BidRow {
+	var bids []BidRow
+	for _, row := range m.bids {
+		if row.SongStatus == status {
+			bids = append(bids, row)
+		}
+	}
+	return bids
+}
@@ -0,0 +1,82 @@
+package cockroach
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRankSongs(t *testing.T) {
+	now := time.Now()
+	ranked := rankSongs(ranking{strategy: SumOfBids{}}, []SongBids{
+		songBidsHelper("song-c", now, 4),
+		songBidsHelper("song-a", now, 6, 4),
+		songBidsHelper("song-b", now, 4),
+		songBidsHelper("song-0", now, 1),
+	}, now)
+
+	expected := []struct {
+		songId           string
+		amountToOvertake int
+	}{{"song-a", 0}, {"song-b", 7}, {"song-c", 1}, {"song-0", 3}}
+	for i, song := range expected {
+		if ranked[i].SongId != song.songId || ranked[i].Rank != i+1 || ranked[i].AmountToOvertake != song.amountToOvertake {
+			t.Fatalf("Expected %v to rank %d and need %d coins, instead got %+v", song.songId, i+1, song.amountToOvertake, ranked[i])
+		}
+	}
+}
+
+// queueHelper checks the queue of a store holding no other bids.
+func queueHelper(t *testing.T, store Store) {
+	user, err := store.CreateUser("ranker-"+uuid.New().String()[:8], "hash")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := store.CreditCoins(user.UserId, 10, "test:"+user.Username); err != nil {
+		t.Fatalf("Failed to credit coins: %v", err)
+	}
+	alice := uuid.NullUUID{UUID: user.UserId, Valid: true}
+	for _, data := range []PostBidData{
+		{BidAmount: 5, SongId: "song-a"},
+		{BidAmount: 2, SongId: "song-b", UserId: alice},
+		{BidAmount: 2, SongId: "song-b", UserId: alice},
+		{BidAmount: 3, SongId: "song-c"},
+	} {
+		if _, err := store.PostBid(data); err != nil {
+			t.Fatalf("Failed to post bid: %v", err)
+		}
+	}
+
+	queue, err := store.GetQueue()
+	if err != nil {
+		t.Fatalf("Failed to get the queue: %v", err)
+	}
+	if queue.Playing != nil || len(queue.Songs) != 3 {
+		t.Fatalf("Expected 3 queued songs and none playing, instead got %+v", queue)
+	}
+	if song := queue.Songs[1]; song.SongId != "song-b" || song.TotalAmount != 4 || song.BidCount != 2 || song.UniqueBidders != 1 || song.AmountToOvertake != 2 {
+		t.Fatalf("Expected song-b second with 2 bids of one user, instead got %+v", song)
+	}
+
+	if _, err := store.PlayNextSong(); err != nil {
+		t.Fatalf("Failed to play next song: %v", err)
+	}
+	queue, err = store.GetQueue()
+	if err != nil || queue.Playing == nil || queue.Playing.SongId != "song-a" || len(queue.Songs) != 2 || queue.Songs[0].Rank != 1 {
+		t.Fatalf("Expected song-a to be playing ahead of 2 queued songs, instead got %+v and %v", queue, err)
+	}
+	playing, err := store.GetPlayingBids()
+	if err != nil || len(playing) != queue.Playing.BidCount {
+		t.Fatalf("Expected the %d bids of song-a to be playing, instead got %v and %v", queue.Playing.BidCount, playing, err)
+	}
+	for _, bid := range playing {
+		if bid.SongId != "song-a" || bid.SongStatus != Playing {
+			t.Fatalf("Expected only playing bids of song-a, instead got %+v", bid)
+		}
+	}
+}
+
+func TestQueue(t *testing.T) {
+	eachStore(t, queueHelper)
+}
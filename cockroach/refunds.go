@@ -0,0 +1,396 @@
+package cockroach
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/acidleroy/song-bid/events"
+	"github.com/acidleroy/song-bid/ledger"
+	"github.com/cockroachdb/cockroach-go/v2/crdb/crdbpgx"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// RefundReason records why a bid was refunded. It is empty for bids that weren't.
+type RefundReason string
+
+const (
+	// ReasonExpired is used for bids that stayed queued longer than the maximum bid age.
+	ReasonExpired RefundReason = "expired"
+	// ReasonSessionEnded is used for the bids still queued when the operator ends the session.
+	ReasonSessionEnded RefundReason = "session_ended"
+	// ReasonSongBanned is used for the queued bids of a song the operator banned.
+	ReasonSongBanned RefundReason = "song_banned"
+	// ReasonCancelled is used for bids their bidder withdrew. Cancelled bids end in the Cancelled
+	// status rather than Refunded.
+	ReasonCancelled RefundReason = "cancelled"
+	// ReasonSkipped is used for the bids of a song that was skipped, so it didn't play out.
+	ReasonSkipped RefundReason = "skipped"
+	// ReasonOperator is used for bids the operator refunded by hand, e.g. those of a skipped song
+	// whose refund failed.
+	ReasonOperator RefundReason = "operator"
+)
+
+// status is the song status a bid refunded for this reason ends in.
+func (r RefundReason) status() SongStatus {
+	if r == ReasonCancelled {
+		return Cancelled
+	}
+	return Refunded
+}
+
+// RefundFilter selects queued bids to refund. Empty fields don't restrict the selection, so the
+// zero value selects every queued bid.
+type RefundFilter struct {
+	SongId        string
+	CreatedBefore time.Time
+}
+
+// RefundStore refunds bids whose song won't be played and keeps the songs the operator banned.
+// Refunded bids of users are credited back to their wallet, exactly once.
+type RefundStore interface {
+	// RefundQueuedBids refunds every queued bid matching the filter and returns them.
+	RefundQueuedBids(filter RefundFilter, reason RefundReason) ([]BidRow, error)
+	// GetBidRefund returns the ledger transaction that refunded a bid, or
+	// ledger.ErrTransactionNotFound if it wasn't refunded or was anonymous.
+	GetBidRefund(bidId uuid.UUID) (ledger.Transaction, error)
+	// BanSong rejects future bids for the song and refunds its queued bids.
+	BanSong(songId string) ([]BidRow, error)
+	// UnbanSong accepts bids for the song again.
+	UnbanSong(songId string) error
+	// GetBannedSongs returns the banned songs.
+	GetBannedSongs() ([]string, error)
+}
+
+// refundReference is the ledger reference of the transaction that refunds a bid.
+func refundReference(bidId uuid.UUID) string {
+	return "refund:" + bidId.String()
+}
+
+// refundCredit returns the ledger transaction that moves the coins of a bid back to its bidder,
+// less the rebated coins they already got back when its song started.
+func refundCredit(row BidRow, rebated int64) ledger.Transaction {
+	return ledger.Transfer(refundReference(row.BidId), ledger.BidsAccount, ledger.UserAccount(row.UserId.UUID), int64(row.BidAmount)-rebated)
+}
+
+// rebated returns the coins given back on a bid by a second-price rebate, looking the rebate up
+// with lookup. It is 0 for bids that got nothing back.
+func rebated(ctx context.Context, row BidRow, lookup func(ctx context.Context, reference string) (ledger.Transaction, error)) (int64, error) {
+	t, err := lookup(ctx, rebateReference(row.BidId))
+	if errors.Is(err, ledger.ErrTransactionNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range t.Entries {
+		if entry.AccountId == ledger.UserAccount(row.UserId.UUID) {
+			return entry.Amount, nil
+		}
+	}
+	return 0, nil
+}
+
+// RefundBids refunds the given bids for the reason and returns them. Only queued and skipped bids
+// can be refunded, and only queued bids cancelled; ErrBidNotFound or ErrInvalidTransition is
+// returned, and nothing is changed, if any of the bids doesn't exist or is in another state.
+func (db *Database) RefundBids(bidIds []uuid.UUID, reason RefundReason) ([]BidRow, error) {
+	ids := make([]string, len(bidIds))
+	for i, id := range bidIds {
+		ids[i] = id.String()
+	}
+
+	var result []BidRow
+	err := crdbpgx.ExecuteTx(context.Background(), db.connection, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		ctx := context.Background()
+		rows, err := tx.Query(ctx, "SELECT "+bidColumns+" FROM tbl_bid WHERE bid_id = ANY($1::UUID[]) FOR UPDATE", ids)
+		if err != nil {
+			return err
+		}
+		current, err := scanBidRows(rows)
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		if err := checkTransitions(bidIds, current, reason.status()); err != nil {
+			return err
+		}
+
+		result = nil
+		for _, from := range []SongStatus{Queued, Skipped} {
+			if !canTransition(from, reason.status()) {
+				continue
+			}
+			refunded, err := refundRows(ctx, tx, from, reason, "bid_id = ANY($4::UUID[])", ids)
+			if err != nil {
+				return err
+			}
+			result = append(result, refunded...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, txError("refund bids", err)
+	}
+	db.publishRefunds(result)
+	return result, nil
+}
+
+// RefundQueuedBids refunds every queued bid matching the filter and returns them.
+func (db *Database) RefundQueuedBids(filter RefundFilter, reason RefundReason) ([]BidRow, error) {
+	where := []string{"TRUE"}
+	args := []interface{}{}
+	if filter.SongId != "" {
+		args = append(args, filter.SongId)
+		where = append(where, fmt.Sprintf("song_id = $%d", len(args)+3))
+	}
+	if !filter.CreatedBefore.IsZero() {
+		args = append(args, filter.CreatedBefore)
+		where = append(where, fmt.Sprintf("created_at < $%d", len(args)+3))
+	}
+
+	var result []BidRow
+	err := crdbpgx.ExecuteTx(context.Background(), db.connection, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		var err error
+		result, err = refundRows(context.Background(), tx, Queued, reason, strings.Join(where, " AND "), args...)
+		return err
+	})
+	if err != nil {
+		return nil, txError("refund queued bids", err)
+	}
+	db.publishRefunds(result)
+	return result, nil
+}
+
+// refundRows moves the bids with status from that match the where clause to the status of the
+// reason and credits their bidders. The where clause may use $4 and onwards for its arguments.
+func refundRows(ctx context.Context, tx pgx.Tx, from SongStatus, reason RefundReason, where string, args ...interface{}) ([]BidRow, error) {
+	to := reason.status()
+	if !canTransition(from, to) {
+		return nil, fmt.Errorf("%w: from %v to %v", ErrInvalidTransition, from, to)
+	}
+
+	rows, err := tx.Query(ctx,
+		"UPDATE tbl_bid SET (song_status, updated_at, refund_reason) = ($1, now(), $3) WHERE song_status = $2 AND "+where+" RETURNING "+bidColumns,
+		append([]interface{}{to, from, string(reason)}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	result, err := scanBidRows(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range result {
+		if !row.UserId.Valid {
+			continue
+		}
+		rebate, err := rebated(ctx, row, func(ctx context.Context, reference string) (ledger.Transaction, error) {
+			return ledger.LookupTx(ctx, tx, reference)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if rebate >= int64(row.BidAmount) {
+			continue
+		}
+		if _, err := ledger.PostTx(ctx, tx, refundCredit(row, rebate)); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// GetBidRefund returns the ledger transaction that refunded a bid.
+func (db *Database) GetBidRefund(bidId uuid.UUID) (ledger.Transaction, error) {
+	t, err := db.ledger.Lookup(context.Background(), refundReference(bidId))
+	if errors.Is(err, ledger.ErrTransactionNotFound) {
+		return ledger.Transaction{}, fmt.Errorf("get bid refund: %w", err)
+	}
+	if err != nil {
+		return ledger.Transaction{}, storeError("get bid refund", err)
+	}
+	return t, nil
+}
+
+// BanSong rejects future bids for the song and refunds its queued bids in the same transaction.
+func (db *Database) BanSong(songId string) ([]BidRow, error) {
+	var result []BidRow
+	err := crdbpgx.ExecuteTx(context.Background(), db.connection, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		ctx := context.Background()
+		if _, err := tx.Exec(ctx, "UPSERT INTO banned_songs (song_id, banned_at) VALUES ($1, now())", songId); err != nil {
+			return err
+		}
+		var err error
+		result, err = refundRows(ctx, tx, Queued, ReasonSongBanned, "song_id = $4", songId)
+		return err
+	})
+	if err != nil {
+		return nil, txError("ban song", err)
+	}
+	db.publishRefunds(result)
+	return result, nil
+}
+
+// UnbanSong accepts bids for the song again.
+func (db *Database) UnbanSong(songId string) error {
+	if _, err := db.connection.Exec(context.Background(), "DELETE FROM banned_songs WHERE song_id = $1", songId); err != nil {
+		return storeError("unban song", err)
+	}
+	return nil
+}
+
+// GetBannedSongs returns the banned songs, in the order they were banned.
+func (db *Database) GetBannedSongs() ([]string, error) {
+	rows, err := db.connection.Query(context.Background(), "SELECT song_id FROM banned_songs ORDER BY banned_at, song_id")
+	if err != nil {
+		return nil, storeError("get banned songs", err)
+	}
+	defer rows.Close()
+
+	songs := []string{}
+	for rows.Next() {
+		var songId string
+		if err := rows.Scan(&songId); err != nil {
+			return nil, storeError("get banned songs", err)
+		}
+		songs = append(songs, songId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, storeError("get banned songs", err)
+	}
+	return songs, nil
+}
+
+// isSongBanned reports whether bids for the song are rejected.
+func isSongBanned(ctx context.Context, tx pgx.Tx, songId string) (bool, error) {
+	var banned bool
+	err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM banned_songs WHERE song_id = $1)", songId).Scan(&banned)
+	return banned, err
+}
+
+// RefundBids refunds the given bids for the reason and returns them. Nothing is changed if any of
+// the bids doesn't exist or can't be refunded.
+func (m *MemoryStore) RefundBids(bidIds []uuid.UUID, reason RefundReason) ([]BidRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := checkTransitions(bidIds, m.bids, reason.status()); err != nil {
+		return nil, fmt.Errorf("refund bids: %w", err)
+	}
+
+	refund := map[uuid.UUID]bool{}
+	for _, bidId := range bidIds {
+		refund[bidId] = true
+	}
+	return m.refund(func(row BidRow) bool {
+		return refund[row.BidId]
+	}, reason)
+}
+
+// RefundQueuedBids refunds every queued bid matching the filter and returns them.
+func (m *MemoryStore) RefundQueuedBids(filter RefundFilter, reason RefundReason) ([]BidRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.refund(func(row BidRow) bool {
+		return row.SongStatus == Queued &&
+			(filter.SongId == "" || row.SongId == filter.SongId) &&
+			(filter.CreatedBefore.IsZero() || row.CreatedAt.Before(filter.CreatedBefore))
+	}, reason)
+}
+
+// refund moves every bid matching the predicate, that is allowed to, to the status of the reason
+// and credits their bidders. Nothing is changed if any of the credits fails.
+func (m *MemoryStore) refund(match func(BidRow) bool, reason RefundReason) ([]BidRow, error) {
+	to := reason.status()
+	var matched []int
+	var credits []ledger.Transaction
+	for i := range m.bids {
+		if !match(m.bids[i]) || !canTransition(m.bids[i].SongStatus, to) {
+			continue
+		}
+		matched = append(matched, i)
+		if !m.bids[i].UserId.Valid {
+			continue
+		}
+		rebate, err := rebated(context.Background(), m.bids[i], m.ledger.Lookup)
+		if err != nil {
+			return nil, fmt.Errorf("refund bids: %w", err)
+		}
+		if rebate < int64(m.bids[i].BidAmount) {
+			credits = append(credits, refundCredit(m.bids[i], rebate))
+		}
+	}
+	if _, err := m.ledger.PostAll(context.Background(), credits); err != nil {
+		return nil, fmt.Errorf("refund bids: %w", err)
+	}
+
+	now := m.now()
+	var result []BidRow
+	for _, i := range matched {
+		m.bids[i].SongStatus = to
+		m.bids[i].UpdatedAt = now
+		m.bids[i].RefundReason = reason
+		result = append(result, m.bids[i])
+		m.bus.Publish(events.BidRefunded, m.bids[i].Public())
+	}
+	if len(result) > 0 {
+		m.publishQueue()
+	}
+	return result, nil
+}
+
+// GetBidRefund returns the ledger transaction that refunded a bid.
+func (m *MemoryStore) GetBidRefund(bidId uuid.UUID) (ledger.Transaction, error) {
+	t, err := m.ledger.Lookup(context.Background(), refundReference(bidId))
+	if err != nil {
+		return ledger.Transaction{}, fmt.Errorf("get bid refund: %w", err)
+	}
+	return t, nil
+}
+
+// BanSong rejects future bids for the song and refunds its queued bids.
+func (m *MemoryStore) BanSong(songId string) ([]BidRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.banned[songId]; !ok {
+		m.banned[songId] = m.now()
+	}
+	return m.refund(func(row BidRow) bool {
+		return row.SongId == songId && row.SongStatus == Queued
+	}, ReasonSongBanned)
+}
+
+// UnbanSong accepts bids for the song again.
+func (m *MemoryStore) UnbanSong(songId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.banned, songId)
+	return nil
+}
+
+// GetBannedSongs returns the banned songs, in the order they were banned.
+func (m *MemoryStore) GetBannedSongs() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	songs := []string{}
+	for songId := range m.banned {
+		songs = append(songs, songId)
+	}
+	sort.Slice(songs, func(i, j int) bool {
+		if !m.banned[songs[i]].Equal(m.banned[songs[j]]) {
+			return m.banned[songs[i]].Before(m.banned[songs[j]])
+		}
+		return songs[i] < songs[j]
+	})
+	return songs, nil
+}
@@ -0,0 +1,108 @@
+package cockroach
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/acidleroy/song-bid/ledger"
+	"github.com/google/uuid"
+)
+
+// refundStoreHelper checks that banning, cancelling and expiring bids credit their bidders once.
+// The song ids are unique because ClearRows leaves the banned songs of the database in place.
+func refundStoreHelper(t *testing.T, store Store) {
+	suffix := uuid.New().String()[:8]
+	user, err := store.CreateUser("bidder-"+suffix, "hash")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := store.CreditCoins(user.UserId, 30, "test:bidder-"+suffix); err != nil {
+		t.Fatalf("Failed to credit coins: %v", err)
+	}
+	userId := uuid.NullUUID{UUID: user.UserId, Valid: true}
+	banned, other := "banned-"+suffix, "other-"+suffix
+
+	userBid, err := store.PostBid(PostBidData{BidAmount: 10, SongId: banned, UserId: userId})
+	if err != nil {
+		t.Fatalf("Failed to post bid: %v", err)
+	}
+	anonymousBid, err := store.PostBid(PostBidData{BidAmount: 5, SongId: banned})
+	if err != nil {
+		t.Fatalf("Failed to post bid: %v", err)
+	}
+	otherBid, err := store.PostBid(PostBidData{BidAmount: 10, SongId: other, UserId: userId})
+	if err != nil {
+		t.Fatalf("Failed to post bid: %v", err)
+	}
+
+	refunded, err := store.BanSong(banned)
+	if err != nil || len(refunded) != 2 {
+		t.Fatalf("Expected banning to refund 2 bids, instead got %v and %v", refunded, err)
+	}
+	for _, row := range refunded {
+		if row.SongStatus != Refunded || row.RefundReason != ReasonSongBanned {
+			t.Fatalf("Expected a refunded bid for a banned song, instead got %+v", row)
+		}
+	}
+	if balance, _ := store.GetBalance(user.UserId); balance != 20 {
+		t.Fatalf("Expected the 10 coins of the banned bid back, instead the balance is %d", balance)
+	}
+	if _, err := store.PostBid(PostBidData{BidAmount: 1, SongId: banned}); !errors.Is(err, ErrSongBanned) {
+		t.Fatalf("Expected ErrSongBanned for a banned song, instead received %v", err)
+	}
+	if songs, err := store.GetBannedSongs(); err != nil || !containsHash(songs, banned) {
+		t.Fatalf("Expected %v to be banned, instead got %v and %v", banned, songs, err)
+	}
+
+	refund, err := store.GetBidRefund(*userBid)
+	if err != nil || refund.Reference != refundReference(*userBid) {
+		t.Fatalf("Expected the refund of the bid, instead got %+v and %v", refund, err)
+	}
+	if _, err := store.GetBidRefund(*anonymousBid); !errors.Is(err, ledger.ErrTransactionNotFound) {
+		t.Fatalf("Expected no refund transaction for an anonymous bid, instead received %v", err)
+	}
+
+	cancelled, err := store.RefundBids([]uuid.UUID{*otherBid}, ReasonCancelled)
+	if err != nil || len(cancelled) != 1 || cancelled[0].SongStatus != Cancelled {
+		t.Fatalf("Expected the bid to be cancelled, instead got %v and %v", cancelled, err)
+	}
+	if _, err := store.RefundBids([]uuid.UUID{*otherBid}, ReasonCancelled); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("Expected ErrInvalidTransition when cancelling twice, instead received %v", err)
+	}
+	if balance, _ := store.GetBalance(user.UserId); balance != 30 {
+		t.Fatalf("Expected every coin back, instead the balance is %d", balance)
+	}
+
+	if err := store.UnbanSong(banned); err != nil {
+		t.Fatalf("Failed to unban song: %v", err)
+	}
+	if _, err := store.PostBid(PostBidData{BidAmount: 3, SongId: banned, UserId: userId}); err != nil {
+		t.Fatalf("Failed to post bid after unbanning: %v", err)
+	}
+	filter := RefundFilter{SongId: banned, CreatedBefore: time.Now().Add(-time.Hour)}
+	if expired, err := store.RefundQueuedBids(filter, ReasonExpired); err != nil || len(expired) != 0 {
+		t.Fatalf("Expected a new bid not to expire, instead got %v and %v", expired, err)
+	}
+	filter.CreatedBefore = time.Now().Add(time.Hour)
+	if expired, err := store.RefundQueuedBids(filter, ReasonExpired); err != nil || len(expired) != 1 || expired[0].RefundReason != ReasonExpired {
+		t.Fatalf("Expected the bid to expire, instead got %v and %v", expired, err)
+	}
+
+	bids, err := store.GetBidsByUser(user.UserId)
+	if err != nil || len(bids) != 3 {
+		t.Fatalf("Expected the 3 bids of the user, instead got %v and %v", bids, err)
+	}
+	for _, row := range bids {
+		if row.RefundReason == "" {
+			t.Fatalf("Expected every bid of the user to have a refund reason, instead got %+v", row)
+		}
+	}
+	if balance, _ := store.GetBalance(user.UserId); balance != 30 {
+		t.Fatalf("Expected every coin back, instead the balance is %d", balance)
+	}
+}
+
+func TestRefunds(t *testing.T) {
+	eachStore(t, refundStoreHelper)
+}
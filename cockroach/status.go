@@ -0,0 +1,130 @@
+package cockroach
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// SongStatus is the state of a bid, stored in tbl_bid.song_status. It is encoded in JSON as its
+// name, e.g. "queued".
+type SongStatus int
+
+const (
+	// Queued bids are waiting for their song to win.
+	Queued SongStatus = iota
+	// Playing bids belong to the song that is currently playing.
+	Playing
+	// Played bids belong to a song that played to the end.
+	Played
+	// Skipped bids belong to a song that was stopped before it finished.
+	Skipped
+	// Refunded bids were given back to the bidder.
+	Refunded
+	// Cancelled bids were withdrawn by the bidder before their song played.
+	Cancelled
+)
+
+var songStatusNames = []string{"queued", "playing", "played", "skipped", "refunded", "cancelled"}
+
+func (s SongStatus) String() string {
+	if s < 0 || int(s) >= len(songStatusNames) {
+		return fmt.Sprintf("SongStatus(%d)", int(s))
+	}
+	return songStatusNames[s]
+}
+
+// ParseSongStatus returns the SongStatus with the given name.
+func ParseSongStatus(name string) (SongStatus, error) {
+	for i, statusName := range songStatusNames {
+		if statusName == name {
+			return SongStatus(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown song status %q", name)
+}
+
+func (s SongStatus) MarshalJSON() ([]byte, error) {
+	if s < 0 || int(s) >= len(songStatusNames) {
+		return nil, fmt.Errorf("can not marshal unknown song status %d", int(s))
+	}
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON accepts the status name. The integer stored in the database is accepted as well
+// for clients that still send it.
+func (s *SongStatus) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		var value int
+		if err := json.Unmarshal(b, &value); err != nil {
+			return fmt.Errorf("song status must be a string, got %s", string(b))
+		}
+		if value < 0 || value >= len(songStatusNames) {
+			return fmt.Errorf("unknown song status %d", value)
+		}
+		*s = SongStatus(value)
+		return nil
+	}
+
+	status, err := ParseSongStatus(name)
+	if err != nil {
+		return err
+	}
+	*s = status
+	return nil
+}
+
+// Value stores the status as its integer in the database.
+func (s SongStatus) Value() (driver.Value, error) {
+	return int64(s), nil
+}
+
+// Scan reads the status from its integer in the database.
+func (s *SongStatus) Scan(src interface{}) error {
+	value, ok := src.(int64)
+	if !ok {
+		return fmt.Errorf("can not scan %T into a SongStatus", src)
+	}
+	*s = SongStatus(value)
+	return nil
+}
+
+// transitions lists the statuses a bid may move to from each status. Played, refunded and
+// cancelled bids are final.
+var transitions = map[SongStatus][]SongStatus{
+	Queued:  {Playing, Refunded, Cancelled},
+	Playing: {Played, Skipped},
+	Skipped: {Refunded},
+}
+
+// canTransition reports whether a bid may move from one song status to another.
+func canTransition(from, to SongStatus) bool {
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTransitions makes sure every one of bidIds is among rows and may move to status to.
+func checkTransitions(bidIds []uuid.UUID, rows []BidRow, to SongStatus) error {
+	byId := map[uuid.UUID]BidRow{}
+	for _, row := range rows {
+		byId[row.BidId] = row
+	}
+
+	for _, bidId := range bidIds {
+		row, ok := byId[bidId]
+		if !ok {
+			return fmt.Errorf("%w: %v", ErrBidNotFound, bidId)
+		}
+		if !canTransition(row.SongStatus, to) {
+			return fmt.Errorf("%w: bid %v is %v", ErrInvalidTransition, bidId, row.SongStatus)
+		}
+	}
+	return nil
+}
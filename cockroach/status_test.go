@@ -0,0 +1,64 @@
+package cockroach
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSongStatusJSON(t *testing.T) {
+	for status, name := range map[SongStatus]string{
+		Queued:    `"queued"`,
+		Playing:   `"playing"`,
+		Played:    `"played"`,
+		Skipped:   `"skipped"`,
+		Refunded:  `"refunded"`,
+		Cancelled: `"cancelled"`,
+	} {
+		buf, err := json.Marshal(status)
+		if err != nil || string(buf) != name {
+			t.Fatalf("Expected %v to marshal to %s, instead got %s and %v", int(status), name, buf, err)
+		}
+
+		var decoded SongStatus
+		if err := json.Unmarshal(buf, &decoded); err != nil || decoded != status {
+			t.Fatalf("Expected %s to unmarshal to %v, instead got %v and %v", name, status, decoded, err)
+		}
+	}
+}
+
+func TestSongStatusUnmarshalLegacyAndInvalid(t *testing.T) {
+	var status SongStatus
+	if err := json.Unmarshal([]byte("2"), &status); err != nil || status != Played {
+		t.Fatalf("Expected the legacy integer 2 to unmarshal to played, instead got %v and %v", status, err)
+	}
+
+	for _, invalid := range []string{`"paused"`, "9", "-1", "true"} {
+		if err := json.Unmarshal([]byte(invalid), &status); err == nil {
+			t.Fatalf("Expected an error when unmarshalling %s", invalid)
+		}
+	}
+
+	if _, err := json.Marshal(SongStatus(9)); err == nil {
+		t.Fatal("Expected an error when marshalling an unknown status")
+	}
+}
+
+func TestCanTransition(t *testing.T) {
+	statuses := []SongStatus{Queued, Playing, Played, Skipped, Refunded, Cancelled}
+	allowed := map[[2]SongStatus]bool{
+		{Queued, Playing}:   true,
+		{Queued, Refunded}:  true,
+		{Queued, Cancelled}: true,
+		{Playing, Played}:   true,
+		{Playing, Skipped}:  true,
+		{Skipped, Refunded}: true,
+	}
+
+	for _, from := range statuses {
+		for _, to := range statuses {
+			if got := canTransition(from, to); got != allowed[[2]SongStatus{from, to}] {
+				t.Errorf("canTransition(%v, %v) = %v, expected %v", from, to, got, !got)
+			}
+		}
+	}
+}
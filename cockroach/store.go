@@ -0,0 +1,59 @@
+package cockroach
+
+import (
+	"time"
+
+	"github.com/acidleroy/song-bid/events"
+	"github.com/google/uuid"
+)
+
+// BidStore describes everything the http server needs from the bid storage. Database is the
+// CockroachDB backed implementation and MemoryStore keeps everything in process, which makes it
+// useful for tests and for running the server without a database.
+type BidStore interface {
+	// PostBid records a new bid for a song that has not yet been played.
+	PostBid(data PostBidData) (*uuid.UUID, error)
+	// PostBidOnce posts a bid like PostBid, unless a bid was already posted with the same
+	// idempotency key; then it returns that bid's id and reports that it was replayed. Anonymous
+	// bids fail with ErrInvalidBid.
+	PostBidOnce(data PostBidData, key IdempotencyKey) (uuid.UUID, bool, error)
+	// PurgeIdempotencyKeys deletes the idempotency keys older than retention and returns how many.
+	PurgeIdempotencyKeys(retention time.Duration) (int64, error)
+	// GetBids returns every bid in the store.
+	GetBids() ([]BidRow, error)
+	// GetBidsByUser returns every bid placed by the given user.
+	GetBidsByUser(userId uuid.UUID) ([]BidRow, error)
+	// GetPlayingBids returns the bids of the song that is playing, none while no song is.
+	GetPlayingBids() ([]BidRow, error)
+	// GetBid returns a single bid, or ErrBidNotFound.
+	GetBid(bidId uuid.UUID) (BidRow, error)
+	// GetHighestBid returns the song that plays next and the sum of its queued bids.
+	GetHighestBid() (PostBidData, error)
+	// GetBidsGroupBySongId returns the queued bids summed by song, in the order the songs play.
+	GetBidsGroupBySongId() ([]PostBidData, error)
+	// GetQueue returns the queued songs ranked the way PlayNextSong picks them, and the playing song.
+	GetQueue() (Queue, error)
+	// PlayNextSong marks the bids of the song ranked first by the auction strategy as playing and
+	// returns them. It fails with ErrSongAlreadyPlaying while another song is playing.
+	PlayNextSong() ([]BidRow, error)
+	// SetAuctionConfig sets the auction strategies that rank the queued songs.
+	SetAuctionConfig(config AuctionConfig)
+	// FinalizeCurrentSong marks the bids of the playing song as played and returns them.
+	FinalizeCurrentSong() ([]BidRow, error)
+	// SkipCurrentSong marks the bids of the playing song as skipped and returns them.
+	SkipCurrentSong() ([]BidRow, error)
+	// RefundBids refunds queued or skipped bids, or cancels queued ones, credits their bidders and
+	// returns them.
+	RefundBids(bidIds []uuid.UUID, reason RefundReason) ([]BidRow, error)
+	// Events returns the bus the store publishes every change of the queue to.
+	Events() *events.Bus
+	// ClearRows removes every bid, and the record of the songs they started, from the store.
+	ClearRows() error
+	// Close releases any resources held by the store.
+	Close()
+}
+
+var (
+	_ BidStore = (*Database)(nil)
+	_ BidStore = (*MemoryStore)(nil)
+)
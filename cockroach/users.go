@@ -0,0 +1,147 @@
+package cockroach
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/cockroach-go/v2/crdb/crdbpgx"
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// User is a listener or operator who can place bids. The password is only ever stored as a hash,
+// which is never encoded to JSON.
+type User struct {
+	UserId       uuid.UUID
+	Username     string
+	PasswordHash string `json:"-"`
+	CreatedAt    time.Time
+}
+
+// UserStore describes the user accounts and login sessions the http server needs. Sessions are
+// looked up by the hash of their token so that the tokens themselves are never stored.
+type UserStore interface {
+	// CreateUser stores a new user, or fails with ErrUserExists if the username is taken.
+	CreateUser(username string, passwordHash string) (User, error)
+	// GetUser returns the user with the given id, or ErrUserNotFound.
+	GetUser(userId uuid.UUID) (User, error)
+	// GetUserByName returns the user with the given username, or ErrUserNotFound.
+	GetUserByName(username string) (User, error)
+	// CreateSession stores a session for the user that is valid until expiresAt.
+	CreateSession(tokenHash string, userId uuid.UUID, expiresAt time.Time) error
+	// GetSessionUser returns the user of an unexpired session, or ErrSessionNotFound.
+	GetSessionUser(tokenHash string) (User, error)
+}
+
+// Store is everything the http server needs: bids, the users who place them, their coins, the
+// invoices they buy them with, the refunds they get back and the pricing rules bids have to meet.
+type Store interface {
+	BidStore
+	UserStore
+	CoinStore
+	InvoiceStore
+	RefundStore
+	PlayerStore
+	PricingStore
+}
+
+var (
+	_ Store = (*Database)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// uniqueViolation is the SQLSTATE CockroachDB returns when a unique constraint is violated.
+const uniqueViolation string = "23505"
+
+const userColumns string = "user_id, username, password_hash, created_at"
+
+// CreateUser stores a new user. ErrUserExists is returned if the username is taken.
+func (db *Database) CreateUser(username string, passwordHash string) (User, error) {
+	user := User{UserId: uuid.New(), Username: username, PasswordHash: passwordHash, CreatedAt: time.Now()}
+
+	err := crdbpgx.ExecuteTx(context.Background(), db.connection, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(),
+			"INSERT INTO users ("+userColumns+") VALUES ($1, $2, $3, $4)",
+			user.UserId, user.Username, user.PasswordHash, user.CreatedAt)
+		return err
+	})
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return User{}, fmt.Errorf("create user: %w: %v", ErrUserExists, username)
+		}
+		return User{}, storeError("create user", err)
+	}
+	return user, nil
+}
+
+// GetUser returns the user with the given id, or ErrUserNotFound if there is none.
+func (db *Database) GetUser(userId uuid.UUID) (User, error) {
+	return db.queryUser("get user", "SELECT "+userColumns+" FROM users WHERE user_id = $1", userId)
+}
+
+// GetUserByName returns the user with the given username, or ErrUserNotFound if there is none.
+func (db *Database) GetUserByName(username string) (User, error) {
+	return db.queryUser("get user by name", "SELECT "+userColumns+" FROM users WHERE username = $1", username)
+}
+
+func (db *Database) queryUser(op string, query string, arg interface{}) (User, error) {
+	user := User{}
+	err := db.connection.QueryRow(context.Background(), query, arg).
+		Scan(&user.UserId, &user.Username, &user.PasswordHash, &user.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, fmt.Errorf("%s: %w: %v", op, ErrUserNotFound, arg)
+	}
+	if err != nil {
+		return User{}, storeError(op, err)
+	}
+	return user, nil
+}
+
+// CreateSession stores a login session for the user that is valid until expiresAt.
+func (db *Database) CreateSession(tokenHash string, userId uuid.UUID, expiresAt time.Time) error {
+	err := crdbpgx.ExecuteTx(context.Background(), db.connection, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(),
+			"INSERT INTO sessions (token_hash, user_id, expires_at) VALUES ($1, $2, $3)",
+			tokenHash, userId, expiresAt)
+		return err
+	})
+	if err != nil {
+		return storeError("create session", err)
+	}
+	return nil
+}
+
+// GetSessionUser returns the user of the session with the given token hash. ErrSessionNotFound is
+// returned if there is no such session or it has expired.
+func (db *Database) GetSessionUser(tokenHash string) (User, error) {
+	user := User{}
+	err := db.connection.QueryRow(context.Background(),
+		"SELECT u.user_id, u.username, u.password_hash, u.created_at FROM sessions s JOIN users u ON u.user_id = s.user_id WHERE s.token_hash = $1 AND s.expires_at > now()",
+		tokenHash).Scan(&user.UserId, &user.Username, &user.PasswordHash, &user.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, fmt.Errorf("get session user: %w", ErrSessionNotFound)
+	}
+	if err != nil {
+		return User{}, storeError("get session user", err)
+	}
+	return user, nil
+}
+
+// GetBidsByUser returns every bid placed by the given user.
+func (db *Database) GetBidsByUser(userId uuid.UUID) ([]BidRow, error) {
+	rows, err := db.connection.Query(context.Background(), "SELECT "+bidColumns+" FROM tbl_bid WHERE user_id = $1", userId)
+	if err != nil {
+		return nil, storeError("get bids by user", err)
+	}
+	defer rows.Close()
+
+	result, err := scanBidRows(rows)
+	if err != nil {
+		return nil, storeError("get bids by user", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,71 @@
+package cockroach
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// userStoreHelper runs the same checks against every UserStore. The username is unique because
+// ClearRows leaves the users of the database in place.
+func userStoreHelper(t *testing.T, store Store) {
+	name := "alice-" + uuid.New().String()[:8]
+	alice, err := store.CreateUser(name, "alice-hash")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if _, err := store.CreateUser(name, "other-hash"); !errors.Is(err, ErrUserExists) {
+		t.Fatalf("Expected ErrUserExists for a taken username, instead received %v", err)
+	}
+
+	if user, err := store.GetUser(alice.UserId); err != nil || user.Username != name || user.PasswordHash != "alice-hash" {
+		t.Fatalf("Expected GetUser to return alice, instead got %v and %v", user, err)
+	}
+	if user, err := store.GetUserByName(name); err != nil || user.UserId != alice.UserId {
+		t.Fatalf("Expected GetUserByName to return alice, instead got %v and %v", user, err)
+	}
+	if _, err := store.GetUser(uuid.New()); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("Expected ErrUserNotFound for an unknown user, instead received %v", err)
+	}
+	if _, err := store.GetUserByName("bob"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("Expected ErrUserNotFound for an unknown username, instead received %v", err)
+	}
+
+	if err := store.CreateSession(name+"-valid", alice.UserId, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if err := store.CreateSession(name+"-expired", alice.UserId, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if user, err := store.GetSessionUser(name + "-valid"); err != nil || user.UserId != alice.UserId {
+		t.Fatalf("Expected the session to belong to alice, instead got %v and %v", user, err)
+	}
+	for _, tokenHash := range []string{name + "-expired", name + "-unknown"} {
+		if _, err := store.GetSessionUser(tokenHash); !errors.Is(err, ErrSessionNotFound) {
+			t.Fatalf("Expected ErrSessionNotFound for the %v session, instead received %v", tokenHash, err)
+		}
+	}
+
+	if err := store.CreditCoins(alice.UserId, 2, name+"-purchase"); err != nil {
+		t.Fatalf("Failed to credit coins: %v", err)
+	}
+	if _, err := store.PostBid(PostBidData{BidAmount: 2, SongId: "song-a", UserId: uuid.NullUUID{UUID: alice.UserId, Valid: true}}); err != nil {
+		t.Fatalf("Failed to post bid: %v", err)
+	}
+	if _, err := store.PostBid(PostBidData{BidAmount: 3, SongId: "song-b"}); err != nil {
+		t.Fatalf("Failed to post bid: %v", err)
+	}
+	bids, err := store.GetBidsByUser(alice.UserId)
+	if err != nil {
+		t.Fatalf("Failed to get bids by user: %v", err)
+	}
+	if len(bids) != 1 || bids[0].SongId != "song-a" || bids[0].UserId.UUID != alice.UserId {
+		t.Fatalf("Expected only alice's bid, instead got %v", bids)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	eachStore(t, userStoreHelper)
+}
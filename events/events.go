@@ -0,0 +1,127 @@
+// Package events tells listeners what happens to the bid queue as it happens. The stores publish
+// an Event to a Bus for every change, and the http server streams them to the phones in the room
+// over Server-Sent Events and WebSocket.
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Type names what happened. It is sent as the event name of Server-Sent Events.
+type Type string
+
+const (
+	// BidPlaced is published with the new bid, as a cockroach.PublicBid, after it was posted.
+	BidPlaced Type = "bid.placed"
+	// QueueReordered is published with the ranked songs, as returned by GetBidsGroupBySongId,
+	// whenever the totals of the queue changed.
+	QueueReordered Type = "queue.reordered"
+	// SongStarted is published with the public bids of the song that started playing.
+	SongStarted Type = "song.started"
+	// SongFinished is published with the public bids of the song that finished, played or skipped.
+	SongFinished Type = "song.finished"
+	// BidRefunded is published with each refunded or cancelled bid, as a cockroach.PublicBid.
+	BidRefunded Type = "bid.refunded"
+)
+
+// Types lists every event type.
+var Types = []Type{BidPlaced, QueueReordered, SongStarted, SongFinished, BidRefunded}
+
+// Event is something that happened to the queue. Ids increase by one with every event of a bus.
+type Event struct {
+	Id   uint64      `json:"id"`
+	Type Type        `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind before it is dropped.
+const subscriberBuffer int = 64
+
+// Bus passes published events on to every subscriber. Publishing never blocks: a subscriber that
+// falls too far behind is dropped, its channel is closed and it has to subscribe again. A nil
+// *Bus discards everything published to it.
+type Bus struct {
+	mu          sync.Mutex
+	nextId      uint64
+	subscribers map[*subscriber]bool
+	now         func() time.Time
+}
+
+type subscriber struct {
+	types  map[Type]bool
+	events chan Event
+}
+
+func NewBus() *Bus {
+	return &Bus{nextId: 1, subscribers: map[*subscriber]bool{}, now: time.Now}
+}
+
+// Publish sends an event to every subscriber that listens to its type.
+func (b *Bus) Publish(eventType Type, data interface{}) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event := Event{Id: b.nextId, Type: eventType, Time: b.now(), Data: data}
+	b.nextId++
+	for s := range b.subscribers {
+		if len(s.types) > 0 && !s.types[eventType] {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+			log.Printf("Dropping an event subscriber that fell %d events behind", subscriberBuffer)
+			b.remove(s)
+		}
+	}
+}
+
+// HasSubscribers reports whether anybody listens, so that publishers can skip building events
+// that are expensive to compute.
+func (b *Bus) HasSubscribers() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers) > 0
+}
+
+// Subscribe returns a channel that receives the events of the given types, or of every type when
+// none are given, published from now on. The channel is closed when ctx is done or the subscriber
+// is dropped for falling behind.
+func (b *Bus) Subscribe(ctx context.Context, types ...Type) <-chan Event {
+	s := &subscriber{types: map[Type]bool{}, events: make(chan Event, subscriberBuffer)}
+	for _, t := range types {
+		s.types[t] = true
+	}
+
+	b.mu.Lock()
+	b.subscribers[s] = true
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(s)
+	}()
+	return s.events
+}
+
+// remove closes the channel of a subscriber, once. The caller must hold b.mu.
+func (b *Bus) remove(s *subscriber) {
+	if b.subscribers[s] {
+		delete(b.subscribers, s)
+		close(s.events)
+	}
+}
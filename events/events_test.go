@@ -0,0 +1,87 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// receive waits for the next event of a subscription.
+func receive(t *testing.T, subscription <-chan Event) Event {
+	t.Helper()
+	select {
+	case event, ok := <-subscription:
+		if !ok {
+			t.Fatalf("Expected an event, instead the subscription was closed")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("Expected an event, instead received nothing")
+	}
+	return Event{}
+}
+
+func TestBusDeliversEventsInOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bus := NewBus()
+	all := bus.Subscribe(ctx)
+	songs := bus.Subscribe(ctx, SongStarted, SongFinished)
+
+	bus.Publish(BidPlaced, "bid")
+	bus.Publish(SongStarted, "song")
+
+	for i, expected := range []Type{BidPlaced, SongStarted} {
+		if event := receive(t, all); event.Type != expected || event.Id != uint64(i+1) {
+			t.Fatalf("Expected event %d to be %v, instead got %+v", i+1, expected, event)
+		}
+	}
+	if event := receive(t, songs); event.Type != SongStarted || event.Data != "song" {
+		t.Fatalf("Expected only the song event, instead got %+v", event)
+	}
+}
+
+func TestBusClosesSubscriptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	bus := NewBus()
+	subscription := bus.Subscribe(ctx)
+	if !bus.HasSubscribers() {
+		t.Fatalf("Expected the bus to have a subscriber")
+	}
+
+	cancel()
+	if _, ok := <-subscription; ok {
+		t.Fatalf("Expected the subscription to be closed when its context is done")
+	}
+	if bus.HasSubscribers() {
+		t.Fatalf("Expected the subscriber to be removed")
+	}
+}
+
+func TestBusDropsSlowSubscribers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bus := NewBus()
+	slow := bus.Subscribe(ctx)
+	for i := 0; i <= subscriberBuffer; i++ {
+		bus.Publish(BidPlaced, i)
+	}
+
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Fatalf("Expected the %d buffered events before the subscription closed, instead got %d", subscriberBuffer, received)
+	}
+}
+
+func TestNilBusDiscardsEvents(t *testing.T) {
+	var bus *Bus
+	bus.Publish(BidPlaced, "bid")
+	if bus.HasSubscribers() {
+		t.Fatalf("Expected a nil bus to have no subscribers")
+	}
+}
@@ -0,0 +1,156 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/cockroach-go/v2/crdb/crdbpgx"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Database is a Ledger stored in the ledger_transactions and ledger_entries tables, which are
+// created by the migrations of the cockroach package.
+type Database struct {
+	connection *pgxpool.Pool
+}
+
+// NewDatabase returns a Ledger that uses the given connection pool.
+func NewDatabase(connection *pgxpool.Pool) *Database {
+	return &Database{connection: connection}
+}
+
+// Post validates and stores a transaction in a transaction of its own.
+func (db *Database) Post(ctx context.Context, t Transaction) (Transaction, error) {
+	var result Transaction
+	err := crdbpgx.ExecuteTx(ctx, db.connection, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		var err error
+		result, err = PostTx(ctx, tx, t)
+		return err
+	})
+	return result, err
+}
+
+// PostTx stores a transaction as part of the database transaction tx, which lets callers debit an
+// account atomically with their own changes, e.g. storing a bid. Balances are checked after the
+// entries are written; the serializable isolation of CockroachDB makes concurrent posts to the
+// same account retry rather than overdraw it.
+func PostTx(ctx context.Context, tx pgx.Tx, t Transaction) (Transaction, error) {
+	if err := t.Validate(); err != nil {
+		return Transaction{}, err
+	}
+
+	t.TransactionId = uuid.New()
+	t.CreatedAt = time.Now()
+	tag, err := tx.Exec(ctx,
+		"INSERT INTO ledger_transactions (transaction_id, reference, created_at) VALUES ($1, $2, $3) ON CONFLICT (reference) DO NOTHING",
+		t.TransactionId, t.Reference, t.CreatedAt)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if tag.RowsAffected() == 0 {
+		return Transaction{}, fmt.Errorf("%w: %v", ErrDuplicateReference, t.Reference)
+	}
+
+	for _, entry := range t.Entries {
+		if _, err := tx.Exec(ctx,
+			"INSERT INTO ledger_entries (transaction_id, account_id, amount) VALUES ($1, $2, $3)",
+			t.TransactionId, string(entry.AccountId), entry.Amount); err != nil {
+			return Transaction{}, err
+		}
+	}
+
+	for _, entry := range t.Entries {
+		if entry.AccountId.IsSystem() || entry.Amount > 0 {
+			continue
+		}
+		balance, err := balance(ctx, tx, entry.AccountId)
+		if err != nil {
+			return Transaction{}, err
+		}
+		if balance < 0 {
+			return Transaction{}, insufficientFunds(entry, balance-entry.Amount)
+		}
+	}
+	return t, nil
+}
+
+// Balance returns the number of coins in an account.
+func (db *Database) Balance(ctx context.Context, account AccountId) (int64, error) {
+	return balance(ctx, db.connection, account)
+}
+
+// queryRower is implemented by both connection pools and transactions.
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+func balance(ctx context.Context, q queryRower, account AccountId) (int64, error) {
+	var result int64
+	err := q.QueryRow(ctx, "SELECT COALESCE(SUM(amount), 0)::INT8 FROM ledger_entries WHERE account_id = $1", string(account)).Scan(&result)
+	return result, err
+}
+
+// History returns the transactions that touched an account, oldest first.
+func (db *Database) History(ctx context.Context, account AccountId) ([]Transaction, error) {
+	return queryTransactions(ctx, db.connection,
+		"t.transaction_id IN (SELECT transaction_id FROM ledger_entries WHERE account_id = $1)", string(account))
+}
+
+// Lookup returns the transaction with the given reference, or ErrTransactionNotFound.
+func (db *Database) Lookup(ctx context.Context, reference string) (Transaction, error) {
+	return lookup(ctx, db.connection, reference)
+}
+
+// LookupTx returns the transaction with the given reference as part of the database transaction
+// tx, or ErrTransactionNotFound.
+func LookupTx(ctx context.Context, tx pgx.Tx, reference string) (Transaction, error) {
+	return lookup(ctx, tx, reference)
+}
+
+func lookup(ctx context.Context, q querier, reference string) (Transaction, error) {
+	result, err := queryTransactions(ctx, q, "t.reference = $1", reference)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if len(result) == 0 {
+		return Transaction{}, fmt.Errorf("%w: %v", ErrTransactionNotFound, reference)
+	}
+	return result[0], nil
+}
+
+// querier is implemented by both connection pools and transactions.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// queryTransactions returns the transactions matching the where clause with their entries.
+func queryTransactions(ctx context.Context, q querier, where string, args ...interface{}) ([]Transaction, error) {
+	rows, err := q.Query(ctx, `
+		SELECT t.transaction_id, t.reference, t.created_at, e.account_id, e.amount
+		FROM ledger_transactions t JOIN ledger_entries e ON e.transaction_id = t.transaction_id
+		WHERE `+where+`
+		ORDER BY t.created_at, t.transaction_id, e.account_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []Transaction{}
+	for rows.Next() {
+		t := Transaction{}
+		var accountId string
+		var amount int64
+		if err := rows.Scan(&t.TransactionId, &t.Reference, &t.CreatedAt, &accountId, &amount); err != nil {
+			return nil, err
+		}
+		if len(result) == 0 || result[len(result)-1].TransactionId != t.TransactionId {
+			result = append(result, t)
+		}
+		last := &result[len(result)-1]
+		last.Entries = append(last.Entries, Entry{AccountId: AccountId(accountId), Amount: amount})
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,133 @@
+// Package ledger keeps track of the coins users buy and spend on bids. Every change to a balance is
+// a double-entry Transaction: its entries move coins between accounts and always sum to zero, so
+// coins are never created or lost, only moved. Coins enter the system from IssuedAccount, whose
+// balance is the negative of every coin ever sold.
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AccountId names an account. Users each have an account, see UserAccount; the system accounts
+// are prefixed with "system:".
+type AccountId string
+
+const (
+	// IssuedAccount is where coins come from when a user buys them. Its balance is negative.
+	IssuedAccount AccountId = "system:issued"
+	// BidsAccount holds the coins spent on bids.
+	BidsAccount AccountId = "system:bids"
+)
+
+// UserAccount returns the account holding the coins of a user.
+func UserAccount(userId uuid.UUID) AccountId {
+	return AccountId("user:" + userId.String())
+}
+
+// IsSystem reports whether the account belongs to the system rather than a user. System accounts
+// may have a negative balance, user accounts may not.
+func (a AccountId) IsSystem() bool {
+	return strings.HasPrefix(string(a), "system:")
+}
+
+// Entry moves Amount coins into an account, or out of it when Amount is negative.
+type Entry struct {
+	AccountId AccountId
+	Amount    int64
+}
+
+// Transaction is a set of entries that are applied together. Reference is unique and says why the
+// coins moved, e.g. "bid:<bidId>", so that the same transaction can't be posted twice.
+type Transaction struct {
+	TransactionId uuid.UUID
+	Reference     string
+	CreatedAt     time.Time
+	Entries       []Entry
+}
+
+// ledgerError is the type of the errors the ledger returns for transactions it turns down, as
+// opposed to failures of the database it writes to. Its DomainError method marks them as such, so
+// they can be told apart from those failures wherever they are passed on.
+type ledgerError string
+
+func (e ledgerError) Error() string { return string(e) }
+
+func (ledgerError) DomainError() {}
+
+var (
+	// ErrInvalidTransaction is returned for transactions that can't be posted, e.g. without a reference.
+	ErrInvalidTransaction = error(ledgerError("invalid ledger transaction"))
+	// ErrUnbalanced is returned for transactions whose entries don't sum to zero.
+	ErrUnbalanced = error(ledgerError("ledger transaction is unbalanced"))
+	// ErrInsufficientFunds is returned when a transaction would leave a user account with a
+	// negative balance.
+	ErrInsufficientFunds = error(ledgerError("insufficient funds"))
+	// ErrTransactionNotFound is returned when no transaction has the given reference.
+	ErrTransactionNotFound = error(ledgerError("ledger transaction not found"))
+	// ErrDuplicateReference is returned when a transaction with the same reference was already posted.
+	ErrDuplicateReference = error(ledgerError("ledger transaction was already posted"))
+)
+
+// Ledger stores transactions and the balances they add up to. Database is the CockroachDB backed
+// implementation and Memory keeps everything in process.
+type Ledger interface {
+	// Post validates and stores a transaction and returns it with its id and time filled in.
+	Post(ctx context.Context, t Transaction) (Transaction, error)
+	// Balance returns the number of coins in an account.
+	Balance(ctx context.Context, account AccountId) (int64, error)
+	// History returns the transactions that touched an account, oldest first.
+	History(ctx context.Context, account AccountId) ([]Transaction, error)
+	// Lookup returns the transaction with the given reference, or ErrTransactionNotFound.
+	Lookup(ctx context.Context, reference string) (Transaction, error)
+}
+
+var (
+	_ Ledger = (*Database)(nil)
+	_ Ledger = (*Memory)(nil)
+)
+
+// Transfer returns a transaction that moves amount coins from one account to another.
+func Transfer(reference string, from, to AccountId, amount int64) Transaction {
+	return Transaction{
+		Reference: reference,
+		Entries:   []Entry{{AccountId: from, Amount: -amount}, {AccountId: to, Amount: amount}},
+	}
+}
+
+// Validate checks that a transaction has a reference and at least two entries for different
+// accounts, none of them zero, that sum to zero.
+func (t Transaction) Validate() error {
+	if t.Reference == "" {
+		return fmt.Errorf("%w: missing reference", ErrInvalidTransaction)
+	}
+	if len(t.Entries) < 2 {
+		return fmt.Errorf("%w: %v needs at least two entries", ErrInvalidTransaction, t.Reference)
+	}
+
+	var sum int64
+	accounts := map[AccountId]bool{}
+	for _, entry := range t.Entries {
+		if entry.AccountId == "" || entry.Amount == 0 {
+			return fmt.Errorf("%w: %v has an empty entry", ErrInvalidTransaction, t.Reference)
+		}
+		if accounts[entry.AccountId] {
+			return fmt.Errorf("%w: %v has two entries for %v", ErrInvalidTransaction, t.Reference, entry.AccountId)
+		}
+		accounts[entry.AccountId] = true
+		sum += entry.Amount
+	}
+	if sum != 0 {
+		return fmt.Errorf("%w: %v entries sum to %d", ErrUnbalanced, t.Reference, sum)
+	}
+	return nil
+}
+
+// insufficientFunds describes the account that would be overdrawn by an entry.
+func insufficientFunds(entry Entry, balance int64) error {
+	return fmt.Errorf("%w: %v has %d coins, %d needed", ErrInsufficientFunds, entry.AccountId, balance, -entry.Amount)
+}
@@ -0,0 +1,101 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestTransactionValidate(t *testing.T) {
+	alice := UserAccount(uuid.New())
+
+	testTable := []struct {
+		Name        string
+		Transaction Transaction
+		Expected    error
+	}{
+		{"transfer", Transfer("purchase:1", IssuedAccount, alice, 10), nil},
+		{"three entries", Transaction{Reference: "split", Entries: []Entry{{alice, -3}, {BidsAccount, 2}, {IssuedAccount, 1}}}, nil},
+		{"no reference", Transfer("", IssuedAccount, alice, 10), ErrInvalidTransaction},
+		{"single entry", Transaction{Reference: "single", Entries: []Entry{{alice, 10}}}, ErrInvalidTransaction},
+		{"zero amount", Transfer("zero", IssuedAccount, alice, 0), ErrInvalidTransaction},
+		{"same account", Transfer("self", alice, alice, 10), ErrInvalidTransaction},
+		{"unbalanced", Transaction{Reference: "unbalanced", Entries: []Entry{{alice, -3}, {BidsAccount, 2}}}, ErrUnbalanced},
+	}
+
+	for _, test := range testTable {
+		if err := test.Transaction.Validate(); !errors.Is(err, test.Expected) || (test.Expected == nil && err != nil) {
+			t.Fatalf("%v: expected %v, instead received %v", test.Name, test.Expected, err)
+		}
+	}
+}
+
+func TestMemoryPost(t *testing.T) {
+	ctx := context.Background()
+	ledger := NewMemory()
+	alice := UserAccount(uuid.New())
+
+	if _, err := ledger.Post(ctx, Transfer("purchase:1", IssuedAccount, alice, 10)); err != nil {
+		t.Fatalf("Failed to post purchase: %v", err)
+	}
+	if _, err := ledger.Post(ctx, Transfer("purchase:1", IssuedAccount, alice, 10)); !errors.Is(err, ErrDuplicateReference) {
+		t.Fatalf("Expected ErrDuplicateReference when posting twice, instead received %v", err)
+	}
+	if _, err := ledger.Post(ctx, Transfer("bid:1", alice, BidsAccount, 4)); err != nil {
+		t.Fatalf("Failed to post bid: %v", err)
+	}
+	if _, err := ledger.Post(ctx, Transfer("bid:2", alice, BidsAccount, 7)); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("Expected ErrInsufficientFunds when overdrawing, instead received %v", err)
+	}
+
+	for account, expected := range map[AccountId]int64{alice: 6, BidsAccount: 4, IssuedAccount: -10} {
+		if balance, err := ledger.Balance(ctx, account); err != nil || balance != expected {
+			t.Fatalf("Expected %v to hold %d coins, instead got %d and %v", account, expected, balance, err)
+		}
+	}
+
+	history, err := ledger.History(ctx, alice)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(history) != 2 || history[0].Reference != "purchase:1" || history[1].Reference != "bid:1" {
+		t.Fatalf("Expected the purchase and the bid, instead got %v", history)
+	}
+}
+
+func TestMemoryPostAll(t *testing.T) {
+	ctx := context.Background()
+	ledger := NewMemory()
+	alice := UserAccount(uuid.New())
+
+	// The second bid overdraws alice once the first one is paid, so neither is posted.
+	if _, err := ledger.PostAll(ctx, []Transaction{
+		Transfer("purchase:1", IssuedAccount, alice, 10),
+		Transfer("bid:1", alice, BidsAccount, 6),
+		Transfer("bid:2", alice, BidsAccount, 6),
+	}); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("Expected ErrInsufficientFunds, instead received %v", err)
+	}
+	if balance, _ := ledger.Balance(ctx, alice); balance != 0 {
+		t.Fatalf("Expected nothing to be posted, instead alice holds %d coins", balance)
+	}
+	if _, err := ledger.PostAll(ctx, []Transaction{
+		Transfer("purchase:1", IssuedAccount, alice, 10),
+		Transfer("purchase:1", IssuedAccount, alice, 10),
+	}); !errors.Is(err, ErrDuplicateReference) {
+		t.Fatalf("Expected ErrDuplicateReference for a reference posted twice, instead received %v", err)
+	}
+
+	posted, err := ledger.PostAll(ctx, []Transaction{
+		Transfer("purchase:1", IssuedAccount, alice, 10),
+		Transfer("bid:1", alice, BidsAccount, 6),
+	})
+	if err != nil || len(posted) != 2 || posted[1].TransactionId == uuid.Nil {
+		t.Fatalf("Expected both transactions to be posted, instead got %v and %v", posted, err)
+	}
+	if balance, _ := ledger.Balance(ctx, alice); balance != 4 {
+		t.Fatalf("Expected alice to hold 4 coins, instead got %d", balance)
+	}
+}
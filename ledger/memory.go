@@ -0,0 +1,117 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Memory is an in-process Ledger, useful for tests and for running without a database.
+type Memory struct {
+	mu           sync.Mutex
+	transactions []Transaction
+	balances     map[AccountId]int64
+	references   map[string]bool
+	now          func() time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{balances: map[AccountId]int64{}, references: map[string]bool{}, now: time.Now}
+}
+
+// Post validates and stores a transaction. Nothing is changed if it would overdraw a user account.
+func (m *Memory) Post(ctx context.Context, t Transaction) (Transaction, error) {
+	posted, err := m.PostAll(ctx, []Transaction{t})
+	if err != nil {
+		return Transaction{}, err
+	}
+	return posted[0], nil
+}
+
+// PostAll validates and stores the transactions together, like the posts of a single database
+// transaction: nothing is changed if any of them is invalid, was already posted or would overdraw
+// a user account.
+func (m *Memory) PostAll(ctx context.Context, ts []Transaction) ([]Transaction, error) {
+	for _, t := range ts {
+		if err := t.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	balances := map[AccountId]int64{}
+	references := map[string]bool{}
+	for _, t := range ts {
+		if m.references[t.Reference] || references[t.Reference] {
+			return nil, fmt.Errorf("%w: %v", ErrDuplicateReference, t.Reference)
+		}
+		references[t.Reference] = true
+		for _, entry := range t.Entries {
+			balance, ok := balances[entry.AccountId]
+			if !ok {
+				balance = m.balances[entry.AccountId]
+			}
+			if !entry.AccountId.IsSystem() && balance+entry.Amount < 0 {
+				return nil, insufficientFunds(entry, balance)
+			}
+			balances[entry.AccountId] = balance + entry.Amount
+		}
+	}
+
+	posted := make([]Transaction, len(ts))
+	for i, t := range ts {
+		t.TransactionId = uuid.New()
+		t.CreatedAt = m.now()
+		t.Entries = append([]Entry(nil), t.Entries...)
+		for _, entry := range t.Entries {
+			m.balances[entry.AccountId] += entry.Amount
+		}
+		m.references[t.Reference] = true
+		m.transactions = append(m.transactions, t)
+		posted[i] = t
+	}
+	return posted, nil
+}
+
+// Balance returns the number of coins in an account.
+func (m *Memory) Balance(ctx context.Context, account AccountId) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.balances[account], nil
+}
+
+// History returns the transactions that touched an account, oldest first.
+func (m *Memory) History(ctx context.Context, account AccountId) ([]Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := []Transaction{}
+	for _, t := range m.transactions {
+		for _, entry := range t.Entries {
+			if entry.AccountId == account {
+				result = append(result, t)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// Lookup returns the transaction with the given reference, or ErrTransactionNotFound.
+func (m *Memory) Lookup(ctx context.Context, reference string) (Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.transactions {
+		if t.Reference == reference {
+			return t, nil
+		}
+	}
+	return Transaction{}, fmt.Errorf("%w: %v", ErrTransactionNotFound, reference)
+}
@@ -0,0 +1,113 @@
+package payments
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Fake is an in-process PaymentBackend. Its invoices are only paid when Settle is called, which
+// makes it useful for tests and for trying the server without a Lightning node.
+type Fake struct {
+	mu       sync.Mutex
+	invoices map[string]Invoice
+	now      func() time.Time
+
+	subMu       sync.Mutex
+	subscribers map[*fakeSubscriber]bool
+}
+
+type fakeSubscriber struct {
+	ctx         context.Context
+	settlements chan Invoice
+}
+
+func NewFake() *Fake {
+	return &Fake{invoices: map[string]Invoice{}, now: time.Now, subscribers: map[*fakeSubscriber]bool{}}
+}
+
+// CreateInvoice creates an open invoice with a random payment hash.
+func (f *Fake) CreateInvoice(ctx context.Context, amountSats int64, memo string, expiry time.Duration) (Invoice, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return Invoice{}, fmt.Errorf("%w: %v", ErrBackend, err)
+	}
+	hash := hex.EncodeToString(buf)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now()
+	invoice := Invoice{
+		PaymentHash:    hash,
+		PaymentRequest: fmt.Sprintf("lnfake%dn1%s", amountSats, hash[:20]),
+		AmountSats:     amountSats,
+		Memo:           memo,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(expiry),
+	}
+	f.invoices[hash] = invoice
+	return invoice, nil
+}
+
+// LookupInvoice returns the current state of an invoice, or ErrInvoiceNotFound.
+func (f *Fake) LookupInvoice(ctx context.Context, paymentHash string) (Invoice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	invoice, ok := f.invoices[paymentHash]
+	if !ok {
+		return Invoice{}, fmt.Errorf("%w: %v", ErrInvoiceNotFound, paymentHash)
+	}
+	return invoice, nil
+}
+
+// SubscribeSettlements returns a channel that receives the invoices passed to Settle.
+func (f *Fake) SubscribeSettlements(ctx context.Context) (<-chan Invoice, error) {
+	subscriber := &fakeSubscriber{ctx: ctx, settlements: make(chan Invoice, 16)}
+
+	f.subMu.Lock()
+	f.subscribers[subscriber] = true
+	f.subMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		f.subMu.Lock()
+		delete(f.subscribers, subscriber)
+		close(subscriber.settlements)
+		f.subMu.Unlock()
+	}()
+	return subscriber.settlements, nil
+}
+
+// Settle pays an invoice as if the buyer did and tells every subscriber about it. Settling an
+// invoice twice only notifies the subscribers once.
+func (f *Fake) Settle(paymentHash string) error {
+	f.mu.Lock()
+	invoice, ok := f.invoices[paymentHash]
+	if !ok {
+		f.mu.Unlock()
+		return fmt.Errorf("%w: %v", ErrInvoiceNotFound, paymentHash)
+	}
+	if invoice.Settled {
+		f.mu.Unlock()
+		return nil
+	}
+	invoice.Settled = true
+	invoice.SettledAt = f.now()
+	f.invoices[paymentHash] = invoice
+	f.mu.Unlock()
+
+	f.subMu.Lock()
+	defer f.subMu.Unlock()
+	for subscriber := range f.subscribers {
+		select {
+		case subscriber.settlements <- invoice:
+		case <-subscriber.ctx.Done():
+		}
+	}
+	return nil
+}
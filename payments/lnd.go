@@ -0,0 +1,238 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Environment variables that configure the LND node, see LoadLNDConfig.
+const (
+	EnvLNDHost     = "SONG_BID_LND_HOST"
+	EnvLNDMacaroon = "SONG_BID_LND_MACAROON"
+	EnvLNDTLSCert  = "SONG_BID_LND_TLSCERT"
+)
+
+// LNDConfig describes how to reach the REST api of an LND node. Host is e.g. "localhost:8080",
+// MacaroonPath points to a macaroon that may create and read invoices (invoice.macaroon) and
+// TLSCertPath to the node's tls.cert.
+type LNDConfig struct {
+	Host         string
+	MacaroonPath string
+	TLSCertPath  string
+}
+
+// LoadLNDConfig reads the LND settings from the SONG_BID_LND_* environment variables.
+func LoadLNDConfig() LNDConfig {
+	return LNDConfig{
+		Host:         os.Getenv(EnvLNDHost),
+		MacaroonPath: os.Getenv(EnvLNDMacaroon),
+		TLSCertPath:  os.Getenv(EnvLNDTLSCert),
+	}
+}
+
+// LND is a PaymentBackend for the REST api of an LND node, or anything compatible with it.
+type LND struct {
+	client   *http.Client
+	baseUrl  string
+	macaroon string
+}
+
+// NewLND returns a backend that sends its requests to baseUrl, e.g. "https://localhost:8080",
+// authenticated with the hex encoded macaroon.
+func NewLND(client *http.Client, baseUrl string, macaroon string) *LND {
+	return &LND{client: client, baseUrl: strings.TrimSuffix(baseUrl, "/"), macaroon: macaroon}
+}
+
+// ConnectLND reads the macaroon and TLS certificate named by the config and returns a backend for
+// the node.
+func ConnectLND(config LNDConfig) (*LND, error) {
+	if config.Host == "" {
+		return nil, fmt.Errorf("%w: no LND host configured", ErrBackend)
+	}
+	macaroon, err := ioutil.ReadFile(config.MacaroonPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the LND macaroon: %w", err)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.TLSCertPath != "" {
+		cert, err := ioutil.ReadFile(config.TLSCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the LND TLS certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(cert) {
+			return nil, fmt.Errorf("no certificate found in %v", config.TLSCertPath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	return NewLND(client, "https://"+config.Host, hex.EncodeToString(macaroon)), nil
+}
+
+// lndInvoice is an invoice as encoded by the LND REST api, which sends 64 bit integers as strings
+// and bytes as base64.
+type lndInvoice struct {
+	Memo           string `json:"memo"`
+	RHash          []byte `json:"r_hash"`
+	PaymentRequest string `json:"payment_request"`
+	Value          int64  `json:"value,string"`
+	CreationDate   int64  `json:"creation_date,string"`
+	SettleDate     int64  `json:"settle_date,string"`
+	Expiry         int64  `json:"expiry,string"`
+	State          string `json:"state"`
+}
+
+func (i lndInvoice) invoice() Invoice {
+	invoice := Invoice{
+		PaymentHash:    hex.EncodeToString(i.RHash),
+		PaymentRequest: i.PaymentRequest,
+		AmountSats:     i.Value,
+		Memo:           i.Memo,
+		CreatedAt:      time.Unix(i.CreationDate, 0),
+		ExpiresAt:      time.Unix(i.CreationDate+i.Expiry, 0),
+		Settled:        i.State == "SETTLED",
+	}
+	if invoice.Settled {
+		invoice.SettledAt = time.Unix(i.SettleDate, 0)
+	}
+	return invoice
+}
+
+// CreateInvoice adds an invoice to the node.
+func (l *LND) CreateInvoice(ctx context.Context, amountSats int64, memo string, expiry time.Duration) (Invoice, error) {
+	body, err := json.Marshal(map[string]string{
+		"value":  fmt.Sprint(amountSats),
+		"memo":   memo,
+		"expiry": fmt.Sprint(int64(expiry.Seconds())),
+	})
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	created := struct {
+		RHash          []byte `json:"r_hash"`
+		PaymentRequest string `json:"payment_request"`
+	}{}
+	if err := l.do(ctx, http.MethodPost, "/v1/invoices", bytes.NewReader(body), &created); err != nil {
+		return Invoice{}, err
+	}
+
+	now := time.Now()
+	return Invoice{
+		PaymentHash:    hex.EncodeToString(created.RHash),
+		PaymentRequest: created.PaymentRequest,
+		AmountSats:     amountSats,
+		Memo:           memo,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(expiry),
+	}, nil
+}
+
+// LookupInvoice returns the current state of an invoice, or ErrInvoiceNotFound.
+func (l *LND) LookupInvoice(ctx context.Context, paymentHash string) (Invoice, error) {
+	if _, err := hex.DecodeString(paymentHash); err != nil {
+		return Invoice{}, fmt.Errorf("%w: %v", ErrInvoiceNotFound, paymentHash)
+	}
+	invoice := lndInvoice{}
+	if err := l.do(ctx, http.MethodGet, "/v1/invoice/"+paymentHash, nil, &invoice); err != nil {
+		return Invoice{}, err
+	}
+	return invoice.invoice(), nil
+}
+
+// SubscribeSettlements streams the invoices of the node and passes on the settled ones.
+func (l *LND) SubscribeSettlements(ctx context.Context) (<-chan Invoice, error) {
+	response, err := l.send(ctx, http.MethodGet, "/v1/invoices/subscribe", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	settlements := make(chan Invoice)
+	go func() {
+		defer close(settlements)
+		defer response.Body.Close()
+
+		// The stream is a sequence of JSON objects, each with either a result or an error.
+		decoder := json.NewDecoder(response.Body)
+		for {
+			message := struct {
+				Result *lndInvoice      `json:"result"`
+				Error  *json.RawMessage `json:"error"`
+			}{}
+			if err := decoder.Decode(&message); err != nil {
+				if ctx.Err() == nil {
+					log.Printf("The LND invoice subscription ended: %v", err)
+				}
+				return
+			}
+			if message.Error != nil {
+				log.Printf("The LND invoice subscription failed: %s", *message.Error)
+				return
+			}
+			if message.Result == nil || message.Result.State != "SETTLED" {
+				continue
+			}
+			select {
+			case settlements <- message.Result.invoice():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return settlements, nil
+}
+
+// do sends a request and decodes the JSON response into out.
+func (l *LND) do(ctx context.Context, method string, path string, body io.Reader, out interface{}) error {
+	response, err := l.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+		return fmt.Errorf("%w: could not decode the response of %v: %v", ErrBackend, path, err)
+	}
+	return nil
+}
+
+// send sends an authenticated request and checks the status of the response.
+func (l *LND) send(ctx context.Context, method string, path string, body io.Reader) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, l.baseUrl+path, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Grpc-Metadata-macaroon", l.macaroon)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := l.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBackend, err)
+	}
+	if response.StatusCode == http.StatusOK {
+		return response, nil
+	}
+
+	defer response.Body.Close()
+	buf, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrInvoiceNotFound, buf)
+	}
+	return nil, fmt.Errorf("%w: %v %v answered %v: %s", ErrBackend, method, path, response.Status, buf)
+}
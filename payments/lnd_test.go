@@ -0,0 +1,120 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testMacaroon = "0201036c6e64"
+
+// lndMock answers the invoice endpoints of the LND REST api for a single invoice.
+func lndMock(t *testing.T, settled chan struct{}) *httptest.Server {
+	mux := http.NewServeMux()
+	invoice := map[string]string{
+		"memo":            "coins",
+		"r_hash":          "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=",
+		"payment_request": "lnbc10u1test",
+		"value":           "1000",
+		"creation_date":   "1700000000",
+		"expiry":          "3600",
+		"state":           "OPEN",
+	}
+
+	mux.HandleFunc("/v1/invoices", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&body) != nil || body["value"] != "1000" || body["expiry"] != "3600" {
+			t.Errorf("Unexpected request to create an invoice: %v %v", r.Method, body)
+		}
+		fmt.Fprintf(w, `{"r_hash": %q, "payment_request": %q, "add_index": "1"}`, invoice["r_hash"], invoice["payment_request"])
+	})
+	mux.HandleFunc("/v1/invoice/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/invoice/000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f" {
+			http.Error(w, `{"code": 5, "message": "unable to locate invoice"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(invoice)
+	})
+	mux.HandleFunc("/v1/invoices/subscribe", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": invoice})
+		w.(http.Flusher).Flush()
+		<-settled
+
+		paid := map[string]string{"settle_date": "1700000100", "state": "SETTLED"}
+		for key, value := range invoice {
+			if _, ok := paid[key]; !ok {
+				paid[key] = value
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": paid})
+	})
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Grpc-Metadata-macaroon") != testMacaroon {
+			http.Error(w, `{"code": 2, "message": "permission denied"}`, http.StatusInternalServerError)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+}
+
+func TestLNDInvoices(t *testing.T) {
+	settled := make(chan struct{})
+	server := lndMock(t, settled)
+	defer server.Close()
+	ctx := context.Background()
+	lnd := NewLND(server.Client(), server.URL, testMacaroon)
+
+	invoice, err := lnd.CreateInvoice(ctx, 1000, "coins", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create invoice: %v", err)
+	}
+	if invoice.PaymentHash != "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f" || invoice.PaymentRequest != "lnbc10u1test" {
+		t.Fatalf("Expected the hash to be hex encoded, instead got %+v", invoice)
+	}
+
+	looked, err := lnd.LookupInvoice(ctx, invoice.PaymentHash)
+	if err != nil || looked.AmountSats != 1000 || looked.Settled || !looked.ExpiresAt.Equal(time.Unix(1700003600, 0)) {
+		t.Fatalf("Expected the open invoice, instead got %+v and %v", looked, err)
+	}
+	if _, err := lnd.LookupInvoice(ctx, "ff"); !errors.Is(err, ErrInvoiceNotFound) {
+		t.Fatalf("Expected ErrInvoiceNotFound for an unknown invoice, instead received %v", err)
+	}
+
+	if _, err := NewLND(server.Client(), server.URL, "wrong").LookupInvoice(ctx, invoice.PaymentHash); !errors.Is(err, ErrBackend) {
+		t.Fatalf("Expected ErrBackend for a rejected macaroon, instead received %v", err)
+	}
+}
+
+func TestLNDSubscribeSettlements(t *testing.T) {
+	settled := make(chan struct{})
+	server := lndMock(t, settled)
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lnd := NewLND(server.Client(), server.URL, testMacaroon)
+
+	settlements, err := lnd.SubscribeSettlements(ctx)
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	close(settled)
+
+	select {
+	case invoice := <-settlements:
+		if !invoice.Settled || !invoice.SettledAt.Equal(time.Unix(1700000100, 0)) {
+			t.Fatalf("Expected only the settled invoice, instead got %+v", invoice)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the settlement")
+	}
+
+	if _, ok := <-settlements; ok {
+		t.Fatal("Expected the channel to be closed when the stream ends")
+	}
+}
@@ -0,0 +1,46 @@
+// Package payments sells coins over the Lightning Network. A PaymentBackend creates invoices and
+// reports when they are paid; LND talks to an LND node over its REST api and Fake keeps invoices
+// in process for tests. The Watcher credits the coins of settled invoices.
+package payments
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Invoice is a Lightning invoice. PaymentHash is hex encoded and identifies the invoice,
+// PaymentRequest is the BOLT 11 string the buyer pays.
+type Invoice struct {
+	PaymentHash    string
+	PaymentRequest string
+	AmountSats     int64
+	Memo           string
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+	Settled        bool
+	SettledAt      time.Time
+}
+
+// PaymentBackend creates invoices and reports their payment.
+type PaymentBackend interface {
+	// CreateInvoice asks for an invoice over amountSats that can be paid until expiry has passed.
+	CreateInvoice(ctx context.Context, amountSats int64, memo string, expiry time.Duration) (Invoice, error)
+	// LookupInvoice returns the current state of an invoice, or ErrInvoiceNotFound.
+	LookupInvoice(ctx context.Context, paymentHash string) (Invoice, error)
+	// SubscribeSettlements returns a channel that receives every invoice settled from now on. The
+	// channel is closed when ctx is done or the subscription fails.
+	SubscribeSettlements(ctx context.Context) (<-chan Invoice, error)
+}
+
+var (
+	_ PaymentBackend = (*LND)(nil)
+	_ PaymentBackend = (*Fake)(nil)
+)
+
+var (
+	// ErrInvoiceNotFound is returned when the backend doesn't know an invoice.
+	ErrInvoiceNotFound = errors.New("invoice not found")
+	// ErrBackend is returned when the payment backend can't be reached or fails a request.
+	ErrBackend = errors.New("payment backend failed")
+)
@@ -0,0 +1,101 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Settler records that an invoice was paid. It must credit each invoice at most once, however
+// often SettleInvoice is called for it, and report whether this call credited it. Invoices it
+// doesn't know, e.g. those of other applications using the same node, are ignored without an error.
+type Settler interface {
+	// PendingPaymentHashes returns the invoices that are not yet settled.
+	PendingPaymentHashes() ([]string, error)
+	// SettleInvoice credits the coins of a paid invoice.
+	SettleInvoice(paymentHash string) (bool, error)
+}
+
+// Watcher credits the coins of invoices once the backend reports them as settled.
+type Watcher struct {
+	Backend PaymentBackend
+	Settler Settler
+	// RetryInterval is how long to wait before subscribing again when the subscription fails.
+	RetryInterval time.Duration
+}
+
+// Run watches for settled invoices until ctx is done. When the subscription to the backend or
+// crediting an invoice fails it starts over after RetryInterval, which looks up the pending
+// invoices again.
+func (w *Watcher) Run(ctx context.Context) error {
+	for {
+		err := w.watch(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("Watching for settled invoices failed: %v, retrying in %v", err, w.RetryInterval)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(w.RetryInterval):
+		}
+	}
+}
+
+func (w *Watcher) watch(ctx context.Context) error {
+	settlements, err := w.Backend.SubscribeSettlements(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Invoices settled while nobody was subscribed are looked up once the subscription is running,
+	// so that none fall in between.
+	if err := w.CatchUp(ctx); err != nil {
+		return err
+	}
+
+	for invoice := range settlements {
+		if err := w.settle(invoice.PaymentHash); err != nil {
+			return err
+		}
+	}
+	return errors.New("the settlement subscription was closed")
+}
+
+// CatchUp looks up every pending invoice and settles the paid ones.
+func (w *Watcher) CatchUp(ctx context.Context) error {
+	hashes, err := w.Settler.PendingPaymentHashes()
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		invoice, err := w.Backend.LookupInvoice(ctx, hash)
+		if errors.Is(err, ErrInvoiceNotFound) {
+			log.Printf("The payment backend doesn't know invoice %v", hash)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if invoice.Settled {
+			if err := w.settle(hash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *Watcher) settle(paymentHash string) error {
+	credited, err := w.Settler.SettleInvoice(paymentHash)
+	if err != nil {
+		return fmt.Errorf("could not settle invoice %v: %w", paymentHash, err)
+	}
+	if credited {
+		log.Printf("Invoice %v was paid, its coins were credited", paymentHash)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package payments
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// settlerMock credits invoices the way a store would, counting how often each was credited.
+type settlerMock struct {
+	mu       sync.Mutex
+	pending  map[string]bool
+	credited map[string]int
+}
+
+func newSettlerMock(hashes ...string) *settlerMock {
+	settler := &settlerMock{pending: map[string]bool{}, credited: map[string]int{}}
+	for _, hash := range hashes {
+		settler.pending[hash] = true
+	}
+	return settler
+}
+
+func (s *settlerMock) PendingPaymentHashes() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hashes := []string{}
+	for hash := range s.pending {
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+func (s *settlerMock) SettleInvoice(paymentHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.pending[paymentHash] {
+		return false, nil
+	}
+	delete(s.pending, paymentHash)
+	s.credited[paymentHash]++
+	return true, nil
+}
+
+func (s *settlerMock) creditedCount(paymentHash string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.credited[paymentHash]
+}
+
+func TestWatcherCreditsSettledInvoicesOnce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend := NewFake()
+	paidEarlier, _ := backend.CreateInvoice(ctx, 100, "paid before the watcher started", time.Hour)
+	paidLater, _ := backend.CreateInvoice(ctx, 200, "paid while watching", time.Hour)
+	unpaid, _ := backend.CreateInvoice(ctx, 300, "never paid", time.Hour)
+	if err := backend.Settle(paidEarlier.PaymentHash); err != nil {
+		t.Fatalf("Failed to settle invoice: %v", err)
+	}
+
+	settler := newSettlerMock(paidEarlier.PaymentHash, paidLater.PaymentHash, unpaid.PaymentHash)
+	watcher := Watcher{Backend: backend, Settler: settler, RetryInterval: time.Millisecond}
+	done := make(chan error)
+	go func() { done <- watcher.Run(ctx) }()
+
+	waitFor(t, func() bool { return settler.creditedCount(paidEarlier.PaymentHash) == 1 })
+	if err := backend.Settle(paidLater.PaymentHash); err != nil {
+		t.Fatalf("Failed to settle invoice: %v", err)
+	}
+	waitFor(t, func() bool { return settler.creditedCount(paidLater.PaymentHash) == 1 })
+
+	// Settling again, or catching up again, must not credit anything twice.
+	backend.Settle(paidLater.PaymentHash)
+	if err := watcher.CatchUp(ctx); err != nil {
+		t.Fatalf("Failed to catch up: %v", err)
+	}
+	cancel()
+	<-done
+
+	if settler.creditedCount(paidEarlier.PaymentHash) != 1 || settler.creditedCount(paidLater.PaymentHash) != 1 || settler.creditedCount(unpaid.PaymentHash) != 0 {
+		t.Fatalf("Expected the paid invoices to be credited once, instead got %v", settler.credited)
+	}
+}
+
+// waitFor polls condition until it holds, failing the test after a second.
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the watcher")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,156 @@
+package player
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultFakeDuration is the length of the tracks of a Fake that weren't given one with SetDuration.
+const DefaultFakeDuration time.Duration = 3 * time.Minute
+
+// Fake is an in-process Player that plays on a virtual clock: time only passes when Advance is
+// called, so tests of the whole bid-to-playback loop are deterministic.
+type Fake struct {
+	mu        sync.Mutex
+	now       time.Time
+	durations map[string]time.Duration
+	track     string
+	playing   bool
+	// progress is how far the track had played when it was last started, paused or resumed, at
+	// the virtual time resumedAt.
+	progress  time.Duration
+	resumedAt time.Time
+	played    []string
+	playErr   error
+}
+
+func NewFake() *Fake {
+	return &Fake{now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), durations: map[string]time.Duration{}}
+}
+
+// Now returns the virtual time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the virtual clock forward, playing the current track for d.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// SetDuration sets the length of a track.
+func (f *Fake) SetDuration(uri string, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.durations[uri] = d
+}
+
+// FailPlay makes the next call to Play fail with err.
+func (f *Fake) FailPlay(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.playErr = err
+}
+
+// Played returns every track Play started, in order.
+func (f *Fake) Played() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.played...)
+}
+
+// Play starts the track from the beginning.
+func (f *Fake) Play(ctx context.Context, uri string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.playErr; err != nil {
+		f.playErr = nil
+		return fmt.Errorf("%w: %v", ErrPlayer, err)
+	}
+	f.track, f.playing, f.progress, f.resumedAt = uri, true, 0, f.now
+	f.played = append(f.played, uri)
+	return nil
+}
+
+// State returns what plays at the virtual time. A track that reached its duration has ended.
+func (f *Fake) State(ctx context.Context) (State, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.update()
+	return State{Track: f.track, Playing: f.playing, Progress: f.progress, Duration: f.duration(), Device: "Fake"}, nil
+}
+
+// Pause pauses the track at its current progress.
+func (f *Fake) Pause(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.track == "" {
+		return ErrNoTrack
+	}
+	f.update()
+	f.playing = false
+	return nil
+}
+
+// Resume continues a paused track, or starts an ended one again.
+func (f *Fake) Resume(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.track == "" {
+		return ErrNoTrack
+	}
+	f.update()
+	f.playing, f.resumedAt = true, f.now
+	return nil
+}
+
+// Skip stops the track as if it had ended.
+func (f *Fake) Skip(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.track == "" {
+		return ErrNoTrack
+	}
+	f.playing, f.progress = false, 0
+	return nil
+}
+
+// Devices returns the single device of the fake.
+func (f *Fake) Devices(ctx context.Context) ([]Device, error) {
+	return []Device{{Id: "fake", Name: "Fake", Type: "Computer", Active: true}}, nil
+}
+
+// update brings the progress of a playing track up to the virtual time, ending the track once it
+// reached its duration. The caller must hold f.mu.
+func (f *Fake) update() {
+	if !f.playing {
+		return
+	}
+	f.progress += f.now.Sub(f.resumedAt)
+	f.resumedAt = f.now
+	if f.progress >= f.duration() {
+		f.playing, f.progress = false, 0
+	}
+}
+
+// duration returns the length of the current track. The caller must hold f.mu.
+func (f *Fake) duration() time.Duration {
+	if f.track == "" {
+		return 0
+	}
+	if d, ok := f.durations[f.track]; ok {
+		return d
+	}
+	return DefaultFakeDuration
+}
@@ -0,0 +1,189 @@
+package player
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	bidclient "github.com/acidleroy/song-bid/client/http-client"
+	cr "github.com/acidleroy/song-bid/cockroach"
+)
+
+const (
+	// startTimeout is how long a track may take to start playing before it is given up on.
+	startTimeout time.Duration = 10 * time.Second
+	// reportInterval is how often the progress of the playing track is reported to the http-server.
+	reportInterval time.Duration = 5 * time.Second
+)
+
+// Queue is the part of the http-server api the Jukebox plays, implemented by the http-client
+// package.
+type Queue interface {
+	PlayNextSong(ctx context.Context) ([]cr.BidRow, error)
+	Finalize(ctx context.Context) ([]cr.BidRow, error)
+	Skip(ctx context.Context) ([]cr.BidRow, error)
+	ReportState(ctx context.Context, state cr.PlayerState) (cr.PlayerState, error)
+}
+
+// track is the track the jukebox started.
+type track struct {
+	uri string
+	// fromQueue is set for the songs bid on, which have to be finalized once they played.
+	fromQueue bool
+	// requested is set once the player accepted the request to play the track, and started once it
+	// reported the track playing.
+	requested bool
+	started   bool
+	startedAt time.Time
+}
+
+// Jukebox plays the song with the highest bid whenever the player stops, and a track of the
+// default playlist when nothing is queued.
+type Jukebox struct {
+	queue    Queue
+	player   Player
+	playlist []string
+	// next is the index of the playlist track to play when the queue is empty next.
+	next       int
+	room       string
+	current    *track
+	lastReport time.Time
+	now        func() time.Time
+}
+
+// NewJukebox returns a jukebox that plays queue on player and reports its progress for room.
+func NewJukebox(queue Queue, player Player, playlist []string, room string) *Jukebox {
+	return &Jukebox{queue: queue, player: player, playlist: playlist, room: room, now: time.Now}
+}
+
+// Run steps the jukebox every interval until ctx is done.
+func (j *Jukebox) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := j.Step(ctx); err != nil {
+			log.Printf("Jukebox: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Step looks at the player once: it reports the progress of the current track, and when the track
+// ended it finalizes its bids and starts the next one.
+func (j *Jukebox) Step(ctx context.Context) error {
+	state, err := j.player.State(ctx)
+	if err != nil {
+		return err
+	}
+
+	if j.current != nil {
+		if state.Playing && state.Track == j.current.uri {
+			j.current.started = true
+			j.report(ctx, state)
+			return nil
+		}
+		if !j.ended(state) {
+			if !j.current.requested {
+				return j.start(ctx)
+			}
+			return nil
+		}
+		j.finish(ctx)
+	}
+	return j.playNext(ctx)
+}
+
+// ended reports whether the current track, which isn't playing, is over. A track that never
+// started is given up on after startTimeout.
+func (j *Jukebox) ended(state State) bool {
+	if !j.current.started {
+		return j.now().Sub(j.current.startedAt) > startTimeout
+	}
+	return state.Track != j.current.uri || (!state.Playing && state.Progress == 0)
+}
+
+// finish finalizes the bids of the current track, or skips them if it never played.
+func (j *Jukebox) finish(ctx context.Context) {
+	current := j.current
+	j.current = nil
+	if !current.fromQueue {
+		return
+	}
+
+	finish, name := j.queue.Finalize, "finalize"
+	if !current.started {
+		log.Printf("%v did not start playing, skipping it", current.uri)
+		finish, name = j.queue.Skip, "skip"
+	}
+	if _, err := finish(ctx); err != nil {
+		log.Printf("Failed to %s %v: %v", name, current.uri, err)
+	}
+}
+
+// playNext starts the song with the highest bid, or the next playlist track if nothing is queued
+// or the queue can't be reached.
+func (j *Jukebox) playNext(ctx context.Context) error {
+	bids, err := j.queue.PlayNextSong(ctx)
+	apiError := &bidclient.ApiError{}
+	if errors.As(err, &apiError) && apiError.Code == "song_already_playing" {
+		// A song was left playing, e.g. by a previous run of the music server. Nothing tells whether
+		// it played to the end, so it is skipped, which refunds its bidders.
+		log.Println("A song is still playing according to the queue, skipping it")
+		if _, err := j.queue.Skip(ctx); err != nil {
+			return err
+		}
+		bids, err = j.queue.PlayNextSong(ctx)
+	}
+	if err != nil {
+		log.Printf("Could not get the next song from the queue: %v", err)
+	}
+
+	next := &track{startedAt: j.now()}
+	switch {
+	case len(bids) > 0:
+		next.uri, next.fromQueue = bids[0].SongId, true
+	case len(j.playlist) > 0:
+		next.uri = j.playlist[j.next%len(j.playlist)]
+		j.next++
+	default:
+		return nil
+	}
+
+	log.Printf("Playing %v", next.uri)
+	j.current = next
+	return j.start(ctx)
+}
+
+// start asks the player to play the current track. A request that fails, e.g. because no device is
+// active, is sent again on the next step until the track is given up on after startTimeout.
+func (j *Jukebox) start(ctx context.Context) error {
+	if err := j.player.Play(ctx, j.current.uri); err != nil {
+		return err
+	}
+	j.current.requested = true
+	return nil
+}
+
+// report sends the progress of the current track to the http-server, at most every reportInterval.
+func (j *Jukebox) report(ctx context.Context, state State) {
+	if j.now().Sub(j.lastReport) < reportInterval {
+		return
+	}
+	j.lastReport = j.now()
+
+	_, err := j.queue.ReportState(ctx, cr.PlayerState{
+		Room:       j.room,
+		Track:      j.current.uri,
+		ProgressMs: state.Progress.Milliseconds(),
+		DurationMs: state.Duration.Milliseconds(),
+		Device:     state.Device,
+	})
+	if err != nil {
+		log.Printf("Failed to report the player state: %v", err)
+	}
+}
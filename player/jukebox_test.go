@@ -0,0 +1,150 @@
+package player
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	bidclient "github.com/acidleroy/song-bid/client/http-client"
+	cr "github.com/acidleroy/song-bid/cockroach"
+)
+
+// fakeQueue answers PlayNextSong with the queued songs in order and records the other calls.
+type fakeQueue struct {
+	queued   []string
+	playing  bool
+	calls    []string
+	reported []cr.PlayerState
+}
+
+func (q *fakeQueue) PlayNextSong(ctx context.Context) ([]cr.BidRow, error) {
+	q.calls = append(q.calls, "play")
+	if q.playing {
+		return nil, &bidclient.ApiError{StatusCode: 409, Code: "song_already_playing"}
+	}
+	if len(q.queued) == 0 {
+		return []cr.BidRow{}, nil
+	}
+	song := q.queued[0]
+	q.queued = q.queued[1:]
+	q.playing = true
+	return []cr.BidRow{{BidAmount: 1, SongId: song, SongStatus: cr.Playing}}, nil
+}
+
+func (q *fakeQueue) Finalize(ctx context.Context) ([]cr.BidRow, error) {
+	return q.finish("finalize")
+}
+
+func (q *fakeQueue) Skip(ctx context.Context) ([]cr.BidRow, error) {
+	return q.finish("skip")
+}
+
+func (q *fakeQueue) finish(name string) ([]cr.BidRow, error) {
+	q.calls = append(q.calls, name)
+	if !q.playing {
+		return nil, &bidclient.ApiError{StatusCode: 409, Code: "no_song_playing"}
+	}
+	q.playing = false
+	return []cr.BidRow{}, nil
+}
+
+func (q *fakeQueue) ReportState(ctx context.Context, state cr.PlayerState) (cr.PlayerState, error) {
+	q.reported = append(q.reported, state)
+	return state, nil
+}
+
+func newTestJukebox(queue *fakeQueue, player *Fake, playlist ...string) *Jukebox {
+	j := NewJukebox(queue, player, playlist, cr.DefaultRoom)
+	j.now = player.Now
+	return j
+}
+
+func stepHelper(t *testing.T, j *Jukebox) {
+	t.Helper()
+	if err := j.Step(context.Background()); err != nil {
+		t.Fatalf("Failed to step the jukebox: %v", err)
+	}
+}
+
+func equalCalls(calls []string, expected ...string) bool {
+	if len(calls) != len(expected) {
+		return false
+	}
+	for i := range calls {
+		if calls[i] != expected[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestJukeboxPlaysTheQueueThenThePlaylist(t *testing.T) {
+	queue := &fakeQueue{queued: []string{"spotify:track:bid"}}
+	player := NewFake()
+	j := newTestJukebox(queue, player, "spotify:track:filler-1", "spotify:track:filler-2")
+
+	stepHelper(t, j)
+	if !equalCalls(player.Played(), "spotify:track:bid") {
+		t.Fatalf("Expected the song with the highest bid to be played, instead played %v", player.Played())
+	}
+
+	player.Advance(time.Second)
+	stepHelper(t, j)
+	if len(queue.reported) != 1 || queue.reported[0].Track != "spotify:track:bid" || queue.reported[0].ProgressMs != 1000 || queue.reported[0].DurationMs != DefaultFakeDuration.Milliseconds() {
+		t.Fatalf("Expected the progress to be reported, instead got %+v", queue.reported)
+	}
+
+	// Pausing keeps the progress, it doesn't end the track.
+	player.Pause(context.Background())
+	player.Advance(time.Hour)
+	stepHelper(t, j)
+	if !equalCalls(queue.calls, "play") {
+		t.Fatalf("Expected a paused track to keep playing, instead the queue got %v", queue.calls)
+	}
+
+	player.Resume(context.Background())
+	player.Advance(DefaultFakeDuration)
+	stepHelper(t, j)
+	if !equalCalls(queue.calls, "play", "finalize", "play") || !equalCalls(player.Played(), "spotify:track:bid", "spotify:track:filler-1") {
+		t.Fatalf("Expected the bid song to be finalized and the playlist to start, instead the queue got %v and played %v", queue.calls, player.Played())
+	}
+
+	// Playlist tracks aren't finalized, and skipping a track ends it.
+	player.Advance(time.Second)
+	stepHelper(t, j)
+	player.Skip(context.Background())
+	stepHelper(t, j)
+	if !equalCalls(queue.calls, "play", "finalize", "play", "play") || !equalCalls(player.Played(), "spotify:track:bid", "spotify:track:filler-1", "spotify:track:filler-2") {
+		t.Fatalf("Expected the next playlist track, instead the queue got %v and played %v", queue.calls, player.Played())
+	}
+}
+
+func TestJukeboxSkipsSongsThatDontStart(t *testing.T) {
+	queue := &fakeQueue{queued: []string{"spotify:track:bid"}}
+	player := NewFake()
+	j := newTestJukebox(queue, player)
+
+	player.FailPlay(errors.New("no active device"))
+	if err := j.Step(context.Background()); !errors.Is(err, ErrPlayer) {
+		t.Fatalf("Expected the failure to play to be returned, instead received %v", err)
+	}
+
+	player.Advance(startTimeout + time.Second)
+	player.FailPlay(errors.New("no active device"))
+	stepHelper(t, j)
+	if !equalCalls(queue.calls, "play", "skip", "play") || len(player.Played()) != 0 {
+		t.Fatalf("Expected the song to be skipped and nothing else to play, instead the queue got %v", queue.calls)
+	}
+}
+
+func TestJukeboxSkipsALeftoverSong(t *testing.T) {
+	queue := &fakeQueue{queued: []string{"spotify:track:bid"}, playing: true}
+	player := NewFake()
+	j := newTestJukebox(queue, player)
+
+	stepHelper(t, j)
+	if !equalCalls(queue.calls, "play", "skip", "play") || !equalCalls(player.Played(), "spotify:track:bid") {
+		t.Fatalf("Expected the leftover song to be skipped before playing, instead the queue got %v and played %v", queue.calls, player.Played())
+	}
+}
@@ -0,0 +1,223 @@
+package player
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mpdTimeout bounds every exchange with MPD whose context has no deadline.
+const mpdTimeout time.Duration = 5 * time.Second
+
+// MPD is a Player for a Music Player Daemon, for venues that play local files rather than
+// Spotify. Track URIs are the paths of songs in MPD's music directory, or URLs MPD can stream.
+// Every command opens its own connection, so a restarted daemon is picked up transparently.
+type MPD struct {
+	address  string
+	password string
+}
+
+// NewMPD returns a Player for the daemon listening on address, e.g. "localhost:6600". password is
+// sent before every command when it is set.
+func NewMPD(address string, password string) *MPD {
+	return &MPD{address: address, password: password}
+}
+
+// mpdField is one "key: value" line of an MPD response.
+type mpdField struct {
+	key   string
+	value string
+}
+
+// mpdResponse is the response to one or more MPD commands.
+type mpdResponse []mpdField
+
+// get returns the value of the first field with the key.
+func (r mpdResponse) get(key string) (string, bool) {
+	for _, field := range r {
+		if field.key == key {
+			return field.value, true
+		}
+	}
+	return "", false
+}
+
+// mpdQuote quotes an argument of an MPD command.
+func mpdQuote(arg string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+// command runs the commands as one command list, which MPD runs in order and stops at the first
+// failure, and returns the fields of all their responses.
+func (m *MPD) command(ctx context.Context, commands ...string) (mpdResponse, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", m.address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPlayer, err)
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(mpdTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	reader := bufio.NewReader(conn)
+	greeting, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(greeting, "OK MPD ") {
+		return nil, fmt.Errorf("%w: %v did not greet like MPD: %q %v", ErrPlayer, m.address, greeting, err)
+	}
+
+	request := "command_list_begin\n"
+	if m.password != "" {
+		request += "password " + mpdQuote(m.password) + "\n"
+	}
+	request += strings.Join(commands, "\n") + "\ncommand_list_end\n"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPlayer, err)
+	}
+
+	response := mpdResponse{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrPlayer, err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "OK":
+			return response, nil
+		case strings.HasPrefix(line, "ACK "):
+			return nil, fmt.Errorf("%w: %v", ErrPlayer, strings.TrimPrefix(line, "ACK "))
+		}
+		if key, value, ok := strings.Cut(line, ": "); ok {
+			response = append(response, mpdField{key, value})
+		}
+	}
+}
+
+// Play replaces MPD's playlist with the track and plays it.
+func (m *MPD) Play(ctx context.Context, uri string) error {
+	_, err := m.command(ctx, "clear", "add "+mpdQuote(uri), "play")
+	return err
+}
+
+// State returns the status of MPD and its current song. The device is the first enabled output.
+func (m *MPD) State(ctx context.Context) (State, error) {
+	response, err := m.command(ctx, "status", "currentsong", "outputs")
+	if err != nil {
+		return State{}, err
+	}
+
+	state := State{}
+	status, _ := response.get("state")
+	state.Playing = status == "play"
+	if status != "stop" {
+		elapsed, _ := response.get("elapsed")
+		state.Progress = mpdSeconds(elapsed)
+	}
+	state.Track, _ = response.get("file")
+	if duration, ok := response.get("duration"); ok {
+		state.Duration = mpdSeconds(duration)
+	} else if duration, ok := response.get("Time"); ok {
+		state.Duration = mpdSeconds(duration)
+	}
+	for _, device := range mpdOutputs(response) {
+		if device.Active {
+			state.Device = device.Name
+			break
+		}
+	}
+	return state, nil
+}
+
+// Pause pauses the current song.
+func (m *MPD) Pause(ctx context.Context) error {
+	if _, err := m.status(ctx); err != nil {
+		return err
+	}
+	_, err := m.command(ctx, "pause 1")
+	return err
+}
+
+// Resume continues the current song, or plays it again if it was stopped.
+func (m *MPD) Resume(ctx context.Context) error {
+	status, err := m.status(ctx)
+	if err != nil {
+		return err
+	}
+	if state, _ := status.get("state"); state == "stop" {
+		_, err = m.command(ctx, "play")
+	} else {
+		_, err = m.command(ctx, "pause 0")
+	}
+	return err
+}
+
+// Skip stops the current song.
+func (m *MPD) Skip(ctx context.Context) error {
+	if _, err := m.status(ctx); err != nil {
+		return err
+	}
+	_, err := m.command(ctx, "stop")
+	return err
+}
+
+// Devices returns the audio outputs of MPD, the enabled ones being active.
+func (m *MPD) Devices(ctx context.Context) ([]Device, error) {
+	response, err := m.command(ctx, "outputs")
+	if err != nil {
+		return nil, err
+	}
+	return mpdOutputs(response), nil
+}
+
+// status returns the status of MPD, or ErrNoTrack if its playlist has no current song.
+func (m *MPD) status(ctx context.Context) (mpdResponse, error) {
+	status, err := m.command(ctx, "status")
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := status.get("song"); !ok {
+		return nil, ErrNoTrack
+	}
+	return status, nil
+}
+
+// mpdOutputs collects the outputs listed in a response, each starting with its outputid.
+func mpdOutputs(response mpdResponse) []Device {
+	devices := []Device{}
+	for _, field := range response {
+		if field.key == "outputid" {
+			devices = append(devices, Device{Id: field.value, Type: "Output"})
+			continue
+		}
+		if len(devices) == 0 {
+			continue
+		}
+		switch device := &devices[len(devices)-1]; field.key {
+		case "outputname":
+			device.Name = field.value
+		case "plugin":
+			device.Type = field.value
+		case "outputenabled":
+			device.Active = field.value == "1"
+		}
+	}
+	return devices
+}
+
+// mpdSeconds parses the seconds MPD reports, e.g. "12.345" or the "elapsed:total" of Time.
+func mpdSeconds(value string) time.Duration {
+	if _, total, ok := strings.Cut(value, ":"); ok {
+		value = total
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
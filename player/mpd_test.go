@@ -0,0 +1,182 @@
+package player
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mpdMock is a Music Player Daemon that plays a single song and answers the commands the MPD
+// player sends.
+type mpdMock struct {
+	mu       sync.Mutex
+	state    string
+	file     string
+	commands []string
+}
+
+func (m *mpdMock) serve(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go m.handle(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func (m *mpdMock) handle(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprint(conn, "OK MPD 0.23.5\n")
+
+	reader := bufio.NewReader(conn)
+	response := ""
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.TrimSuffix(line, "\n")
+		switch {
+		case command == "command_list_begin":
+		case command == "command_list_end":
+			fmt.Fprint(conn, response+"OK\n")
+			return
+		default:
+			// MPD stops at the first failing command of a list and only answers its ACK.
+			fields := m.run(command)
+			if strings.HasPrefix(fields, "ACK ") {
+				fmt.Fprint(conn, fields)
+				return
+			}
+			response += fields
+		}
+	}
+}
+
+// snapshot returns the state of the mock, which is changed by the connections it serves.
+func (m *mpdMock) snapshot() (state string, file string, commands []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state, m.file, append([]string{}, m.commands...)
+}
+
+// run applies a command and returns its response fields, or an ACK.
+func (m *mpdMock) run(command string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.commands = append(m.commands, command)
+	switch {
+	case command == "clear":
+		m.state, m.file = "stop", ""
+	case strings.HasPrefix(command, "add "):
+		m.file = strings.Trim(strings.TrimPrefix(command, "add "), `"`)
+	case command == "play":
+		if m.file == "" {
+			return "ACK [2@0] {play} Bad song index\n"
+		}
+		m.state = "play"
+	case command == "pause 1":
+		m.state = "pause"
+	case command == "pause 0":
+		m.state = "play"
+	case command == "stop":
+		m.state = "stop"
+	case command == "status":
+		status := "volume: 80\nstate: " + m.state + "\n"
+		if m.file != "" {
+			status += "song: 0\n"
+		}
+		if m.state != "stop" {
+			status += "elapsed: 61.500\nduration: 215.000\n"
+		}
+		return status
+	case command == "currentsong":
+		if m.file == "" {
+			return ""
+		}
+		return "file: " + m.file + "\nTime: 215\nduration: 215.000\n"
+	case command == "outputs":
+		return "outputid: 0\noutputname: Null\nplugin: null\noutputenabled: 0\n" +
+			"outputid: 1\noutputname: Speakers\nplugin: pulse\noutputenabled: 1\n"
+	}
+	return ""
+}
+
+func TestMPD(t *testing.T) {
+	mock := &mpdMock{state: "stop"}
+	mpd := NewMPD(mock.serve(t), "")
+	ctx := context.Background()
+
+	if err := mpd.Pause(ctx); !errors.Is(err, ErrNoTrack) {
+		t.Fatalf("Expected ErrNoTrack before a song was added, instead received %v", err)
+	}
+	if err := mpd.Play(ctx, `albums/say "hi".mp3`); err != nil {
+		t.Fatalf("Failed to play: %v", err)
+	}
+	if _, file, _ := mock.snapshot(); file != `albums/say \"hi\".mp3` {
+		t.Fatalf("Expected the quoted path to be added, instead got %q", file)
+	}
+
+	state, err := mpd.State(ctx)
+	if err != nil || !state.Playing || state.Progress != 61500*time.Millisecond || state.Duration != 215*time.Second || state.Device != "Speakers" {
+		t.Fatalf("Expected the song to be playing on the speakers, instead got %+v and %v", state, err)
+	}
+
+	if err := mpd.Pause(ctx); err != nil {
+		t.Fatalf("Failed to pause: %v", err)
+	}
+	if state, _ := mpd.State(ctx); state.Playing || state.Progress == 0 {
+		t.Fatalf("Expected a paused song to keep its progress, instead got %+v", state)
+	}
+	if err := mpd.Skip(ctx); err != nil {
+		t.Fatalf("Failed to skip: %v", err)
+	}
+	if state, _ := mpd.State(ctx); state.Playing || state.Progress != 0 {
+		t.Fatalf("Expected a skipped song to be stopped, instead got %+v", state)
+	}
+	if err := mpd.Resume(ctx); err != nil {
+		t.Fatalf("Failed to resume: %v", err)
+	}
+	if state, _, _ := mock.snapshot(); state != "play" {
+		t.Fatalf("Expected resuming a stopped song to play it, instead it is in %v", state)
+	}
+
+	devices, err := mpd.Devices(ctx)
+	if err != nil || len(devices) != 2 || devices[0].Active || devices[1].Name != "Speakers" || !devices[1].Active || devices[1].Type != "pulse" {
+		t.Fatalf("Expected the two outputs, instead got %+v and %v", devices, err)
+	}
+}
+
+func TestMPDErrors(t *testing.T) {
+	mock := &mpdMock{state: "stop"}
+	mpd := NewMPD(mock.serve(t), "secret")
+
+	if err := mpd.Play(context.Background(), ""); !errors.Is(err, ErrPlayer) || !strings.Contains(err.Error(), "Bad song index") {
+		t.Fatalf("Expected the ACK to be returned as ErrPlayer, instead received %v", err)
+	}
+	if _, _, commands := mock.snapshot(); commands[0] != `password "secret"` {
+		t.Fatalf("Expected the password to be sent first, instead got %v", commands)
+	}
+
+	closed := NewMPD("127.0.0.1:1", "")
+	if _, err := closed.State(context.Background()); !errors.Is(err, ErrPlayer) {
+		t.Fatalf("Expected ErrPlayer when MPD can't be reached, instead received %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+// Package player plays the songs that won the bidding. A Player starts tracks and reports what it
+// plays; Spotify controls a Spotify Connect device, MPD a Music Player Daemon playing local files,
+// and Fake plays on a virtual clock for tests. The Jukebox plays the bid queue on a Player.
+package player
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// State is what a player is playing. Track is the URI of the loaded track, empty when there is
+// none. A track that played to its end, or was skipped, is reported as not Playing at Progress 0,
+// while a paused track keeps its progress.
+type State struct {
+	Track    string
+	Playing  bool
+	Progress time.Duration
+	Duration time.Duration
+	Device   string
+}
+
+// Device is something a player can play on, e.g. a Spotify Connect speaker or an MPD output.
+type Device struct {
+	Id     string
+	Name   string
+	Type   string
+	Active bool
+}
+
+// Player plays one track at a time.
+type Player interface {
+	// Play replaces whatever is playing with the track and starts it from the beginning.
+	Play(ctx context.Context, uri string) error
+	// State returns what is playing now.
+	State(ctx context.Context) (State, error)
+	// Pause pauses the track, keeping its progress.
+	Pause(ctx context.Context) error
+	// Resume continues a paused track.
+	Resume(ctx context.Context) error
+	// Skip stops the track as if it had ended.
+	Skip(ctx context.Context) error
+	// Devices returns the devices the player can play on.
+	Devices(ctx context.Context) ([]Device, error)
+}
+
+var (
+	_ Player = (*Spotify)(nil)
+	_ Player = (*MPD)(nil)
+	_ Player = (*Fake)(nil)
+)
+
+var (
+	// ErrNoTrack is returned when pausing, resuming or skipping while no track is loaded.
+	ErrNoTrack = errors.New("no track is loaded")
+	// ErrPlayer is returned when the player can't be reached or fails a command.
+	ErrPlayer = errors.New("player failed")
+)
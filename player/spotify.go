@@ -0,0 +1,103 @@
+package player
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// Spotify is a Player for a Spotify Connect device, controlled over the Web API with a client
+// authorized for the user-read-playback-state and user-modify-playback-state scopes. Controlling
+// playback needs Spotify Premium.
+type Spotify struct {
+	client *spotify.Client
+	// deviceId is the device tracks are played on, the user's active device when it is empty.
+	deviceId spotify.ID
+}
+
+// NewSpotify returns a Player that plays on deviceId, or on the active device if it is empty.
+func NewSpotify(client *spotify.Client, deviceId string) *Spotify {
+	return &Spotify{client: client, deviceId: spotify.ID(deviceId)}
+}
+
+// options targets the configured device.
+func (s *Spotify) options() *spotify.PlayOptions {
+	opt := &spotify.PlayOptions{}
+	if s.deviceId != "" {
+		opt.DeviceID = &s.deviceId
+	}
+	return opt
+}
+
+// Play plays the track, replacing Spotify's queue and context.
+func (s *Spotify) Play(ctx context.Context, uri string) error {
+	opt := s.options()
+	opt.URIs = []spotify.URI{spotify.URI(uri)}
+	if err := s.client.PlayOpt(ctx, opt); err != nil {
+		return fmt.Errorf("%w: play %v: %v", ErrPlayer, uri, err)
+	}
+	return nil
+}
+
+// State returns the playback state of the user. Spotify rewinds a track that played to its end and
+// pauses it.
+func (s *Spotify) State(ctx context.Context) (State, error) {
+	playerState, err := s.client.PlayerState(ctx)
+	if err != nil {
+		return State{}, fmt.Errorf("%w: get state: %v", ErrPlayer, err)
+	}
+
+	state := State{
+		Playing:  playerState.Playing,
+		Progress: time.Duration(playerState.Progress) * time.Millisecond,
+		Device:   playerState.Device.Name,
+	}
+	if item := playerState.Item; item != nil {
+		state.Track = string(item.URI)
+		state.Duration = item.TimeDuration()
+	}
+	return state, nil
+}
+
+// Pause pauses playback.
+func (s *Spotify) Pause(ctx context.Context) error {
+	if err := s.client.PauseOpt(ctx, s.options()); err != nil {
+		return fmt.Errorf("%w: pause: %v", ErrPlayer, err)
+	}
+	return nil
+}
+
+// Resume continues playback.
+func (s *Spotify) Resume(ctx context.Context) error {
+	if err := s.client.PlayOpt(ctx, s.options()); err != nil {
+		return fmt.Errorf("%w: resume: %v", ErrPlayer, err)
+	}
+	return nil
+}
+
+// Skip pauses the track and rewinds it, which is how Spotify leaves a track that ended.
+func (s *Spotify) Skip(ctx context.Context) error {
+	if err := s.client.PauseOpt(ctx, s.options()); err != nil {
+		return fmt.Errorf("%w: skip: %v", ErrPlayer, err)
+	}
+	if err := s.client.SeekOpt(ctx, 0, s.options()); err != nil {
+		return fmt.Errorf("%w: skip: %v", ErrPlayer, err)
+	}
+	return nil
+}
+
+// Devices returns the Spotify Connect devices of the user.
+func (s *Spotify) Devices(ctx context.Context) ([]Device, error) {
+	devices, err := s.client.PlayerDevices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: get devices: %v", ErrPlayer, err)
+	}
+
+	result := []Device{}
+	for _, device := range devices {
+		result = append(result, Device{Id: string(device.ID), Name: device.Name, Type: device.Type, Active: device.Active})
+	}
+	return result, nil
+}
@@ -0,0 +1,82 @@
+package player
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// spotifyMock answers the player endpoints of the Spotify Web API and records the commands sent
+// to it.
+func spotifyMock(t *testing.T, commands *[]string) *Spotify {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/me/player", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"device": {"id": "speaker-id", "name": "Speaker", "type": "Speaker", "is_active": true},
+			"progress_ms": 61000, "is_playing": true,
+			"item": {"uri": "spotify:track:6ADzlFXHPk846zUCEOM2C1", "duration_ms": 215000}}`)
+	})
+	mux.HandleFunc("/me/player/devices", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"devices": [{"id": "speaker-id", "name": "Speaker", "type": "Speaker", "is_active": true}]}`)
+	})
+	for _, path := range []string{"/me/player/play", "/me/player/pause", "/me/player/seek"} {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			body = bytes.TrimSpace(body)
+			*commands = append(*commands, fmt.Sprintf("%s %s?%s %s", r.Method, r.URL.Path, r.URL.RawQuery, body))
+			if r.URL.Query().Get("device_id") != "speaker-id" {
+				http.Error(w, `{"error": {"status": 404, "message": "Device not found"}}`, http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client := spotify.New(server.Client(), spotify.WithBaseURL(server.URL+"/"))
+	return NewSpotify(client, "speaker-id")
+}
+
+func TestSpotify(t *testing.T) {
+	commands := []string{}
+	player := spotifyMock(t, &commands)
+	ctx := context.Background()
+
+	state, err := player.State(ctx)
+	if err != nil || state.Track != "spotify:track:6ADzlFXHPk846zUCEOM2C1" || !state.Playing || state.Progress != 61*time.Second || state.Duration != 215*time.Second || state.Device != "Speaker" {
+		t.Fatalf("Expected the playing track, instead got %+v and %v", state, err)
+	}
+
+	if err := player.Play(ctx, "spotify:track:1eVnOimXaPos2ua7Rxb7vY"); err != nil {
+		t.Fatalf("Failed to play: %v", err)
+	}
+	if err := player.Skip(ctx); err != nil {
+		t.Fatalf("Failed to skip: %v", err)
+	}
+	expected := []string{
+		`PUT /me/player/play?device_id=speaker-id {"uris":["spotify:track:1eVnOimXaPos2ua7Rxb7vY"]}`,
+		`PUT /me/player/pause?device_id=speaker-id `,
+		`PUT /me/player/seek?device_id=speaker-id&position_ms=0 `,
+	}
+	if fmt.Sprint(commands) != fmt.Sprint(expected) {
+		t.Fatalf("Expected %v to be sent, instead got %v", expected, commands)
+	}
+
+	devices, err := player.Devices(ctx)
+	if err != nil || len(devices) != 1 || devices[0].Id != "speaker-id" || !devices[0].Active {
+		t.Fatalf("Expected the speaker, instead got %+v and %v", devices, err)
+	}
+
+	player.deviceId = "unknown"
+	if err := player.Pause(ctx); !errors.Is(err, ErrPlayer) {
+		t.Fatalf("Expected ErrPlayer for an unknown device, instead received %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+// Package pricing holds the rules operators set on what bids have to be worth: the fewest coins a
+// bid may have, the increment a bid needs to take a song past the leader, and the reserve prices
+// below which a song, or any song of an artist, can't win. The rules only judge bids and songs;
+// the stores keep the rules of every room and apply them when bids are placed and the next song
+// is chosen.
+package pricing
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxKeyLength is the longest song id or artist a reserve can be set for.
+const maxKeyLength int = 255
+
+// pricingError is the type of the errors the rules return for bids and rules they turn down. Its
+// DomainError method marks them as a decision of the rules rather than a failure, so callers can
+// tell them apart from errors of their own.
+type pricingError string
+
+func (e pricingError) Error() string { return string(e) }
+
+func (pricingError) DomainError() {}
+
+var (
+	// ErrBidRejected is matched by the RuleError returned for bids that break the rules.
+	ErrBidRejected = error(pricingError("bid rejected by the pricing rules"))
+	// ErrInvalidRules is returned when storing rules that Validate finds problems with.
+	ErrInvalidRules = error(pricingError("invalid pricing rules"))
+)
+
+// Rules are the pricing rules of a room. Zero values don't restrict anything, so the zero Rules
+// accept every bid and let every song win.
+type Rules struct {
+	// MinBid is the fewest coins a single bid may have.
+	MinBid int
+	// MinIncrement is how many coins more than it takes to draw level a bid has to add when it takes
+	// a song past the song that would play next, counted the way the songs are ranked.
+	MinIncrement int
+	// SongReserves are the totals below which a song can't win, by song id.
+	SongReserves map[string]int
+	// ArtistReserves are the totals below which a song of the artist can't win. Artists are
+	// compared without regard to case.
+	ArtistReserves map[string]int
+}
+
+// Bid is a bid about to be placed.
+type Bid struct {
+	SongId string
+	Amount int
+}
+
+// Song is what was bid on a queued song so far. Artists are the artists the song is by, as far as
+// they are known.
+type Song struct {
+	SongId  string
+	Artists []string
+	Total   int
+}
+
+// Leader is the song that would play next, as seen from another song that is bid on.
+type Leader struct {
+	SongId string
+	// Behind is the largest bid that doesn't take the other song past the leader. It is found the
+	// way the songs are ranked, so it accounts for decay and for strategies other than the sum.
+	Behind int
+}
+
+// Violation is a rule a bid or the rules themselves break, with the field it concerns.
+type Violation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// RuleError lists the rules a bid breaks. It matches ErrBidRejected with errors.Is.
+type RuleError struct {
+	Violations []Violation
+}
+
+func (e *RuleError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Field + " " + v.Message
+	}
+	return ErrBidRejected.Error() + ": " + strings.Join(messages, ", ")
+}
+
+func (e *RuleError) Unwrap() error {
+	return ErrBidRejected
+}
+
+// Clone returns a deep copy of the rules, with empty rather than nil reserves.
+func (r Rules) Clone() Rules {
+	clone := Rules{MinBid: r.MinBid, MinIncrement: r.MinIncrement, SongReserves: map[string]int{}, ArtistReserves: map[string]int{}}
+	for songId, reserve := range r.SongReserves {
+		clone.SongReserves[songId] = reserve
+	}
+	for artist, reserve := range r.ArtistReserves {
+		clone.ArtistReserves[artist] = reserve
+	}
+	return clone
+}
+
+// Validate returns every problem with the rules, or nothing if they are valid.
+func (r Rules) Validate() []Violation {
+	violations := []Violation{}
+	if r.MinBid < 0 {
+		violations = append(violations, Violation{"MinBid", "must not be negative"})
+	}
+	if r.MinIncrement < 0 {
+		violations = append(violations, Violation{"MinIncrement", "must not be negative"})
+	}
+	for _, reserves := range []struct {
+		field    string
+		reserves map[string]int
+	}{
+		{"SongReserves", r.SongReserves},
+		{"ArtistReserves", r.ArtistReserves},
+	} {
+		keys := make([]string, 0, len(reserves.reserves))
+		for key := range reserves.reserves {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field := fmt.Sprintf("%v[%q]", reserves.field, key)
+			if key == "" || len(key) > maxKeyLength {
+				violations = append(violations, Violation{field, fmt.Sprintf("must be keyed by 1 to %d characters", maxKeyLength)})
+			}
+			if reserves.reserves[key] <= 0 {
+				violations = append(violations, Violation{field, "must be a positive number of coins"})
+			}
+		}
+	}
+	return violations
+}
+
+// Reserve returns the total the song needs to be able to win: the highest of its own reserve and
+// the reserves of its artists, 0 if it has none.
+func (r Rules) Reserve(song Song) int {
+	reserve := r.SongReserves[song.SongId]
+	for artist, amount := range r.ArtistReserves {
+		for _, named := range song.Artists {
+			if strings.EqualFold(artist, named) && amount > reserve {
+				reserve = amount
+			}
+		}
+	}
+	return reserve
+}
+
+// MeetsReserve reports whether enough was bid on the song for it to win.
+func (r Rules) MeetsReserve(song Song) bool {
+	return song.Total >= r.Reserve(song)
+}
+
+// Raise returns the smallest bid of at least amount coins that the rules accept on the song.
+// leader is the song that would play next, or nil if there is none.
+func (r Rules) Raise(amount int, song Song, leader *Leader) int {
+	if amount < r.MinBid {
+		amount = r.MinBid
+	}
+	if r.passesLeaderBy(amount, song, leader) {
+		amount = leader.Behind + r.MinIncrement
+	}
+	return amount
+}
+
+// passesLeaderBy reports whether a bid of amount takes the song past the leader by less than the
+// increment.
+func (r Rules) passesLeaderBy(amount int, song Song, leader *Leader) bool {
+	if r.MinIncrement <= 0 || leader == nil || leader.SongId == song.SongId {
+		return false
+	}
+	return amount > leader.Behind && amount < leader.Behind+r.MinIncrement
+}
+
+// CheckBid returns a RuleError if the bid breaks the rules. song is what was bid on the song of
+// the bid so far, and leader the song that would play next, or nil if there is none. A bid may
+// leave its song level with the leader or behind it, but once it takes the song past the leader
+// it has to add at least the increment to what it takes to draw level.
+func (r Rules) CheckBid(bid Bid, song Song, leader *Leader) error {
+	violations := []Violation{}
+	if bid.Amount < r.MinBid {
+		violations = append(violations, Violation{"BidAmount", fmt.Sprintf("must be at least %d coins", r.MinBid)})
+	} else if r.passesLeaderBy(bid.Amount, song, leader) {
+		message := fmt.Sprintf("must be at least %d coins", r.Raise(bid.Amount, song, leader))
+		if leader.Behind > 0 && leader.Behind >= r.MinBid {
+			message = fmt.Sprintf("must be at most %d or at least %d coins", leader.Behind, r.Raise(bid.Amount, song, leader))
+		}
+		violations = append(violations, Violation{"BidAmount", fmt.Sprintf(
+			"%v, a song that passes %v has to lead it by %d coins", message, leader.SongId, r.MinIncrement)})
+	}
+	if len(violations) > 0 {
+		return &RuleError{Violations: violations}
+	}
+	return nil
+}
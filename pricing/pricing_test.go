@@ -0,0 +1,99 @@
+package pricing
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckBid(t *testing.T) {
+	rules := Rules{MinBid: 2, MinIncrement: 5}
+	// song-b draws level with song-a at 6 coins.
+	leader := &Leader{SongId: "song-a", Behind: 6}
+	song := Song{SongId: "song-b", Total: 4}
+
+	for _, test := range []struct {
+		bid    Bid
+		song   Song
+		leader *Leader
+		ok     bool
+	}{
+		{Bid{SongId: "song-b", Amount: 1}, song, leader, false},
+		// Up to a tie with the leader, or past it by the increment.
+		{Bid{SongId: "song-b", Amount: 6}, song, leader, true},
+		{Bid{SongId: "song-b", Amount: 7}, song, leader, false},
+		{Bid{SongId: "song-b", Amount: 10}, song, leader, false},
+		{Bid{SongId: "song-b", Amount: 11}, song, leader, true},
+		// The leader itself and songs without a leader only need the minimum.
+		{Bid{SongId: "song-a", Amount: 2}, Song{SongId: "song-a", Total: 10}, leader, true},
+		{Bid{SongId: "song-b", Amount: 2}, song, nil, true},
+	} {
+		err := rules.CheckBid(test.bid, test.song, test.leader)
+		if (err == nil) != test.ok {
+			t.Fatalf("Expected %+v to be accepted: %v, instead got %v", test.bid, test.ok, err)
+		}
+		var rejected *RuleError
+		if err != nil && (!errors.Is(err, ErrBidRejected) || !errors.As(err, &rejected) || rejected.Violations[0].Field != "BidAmount") {
+			t.Fatalf("Expected a RuleError on BidAmount, instead got %v", err)
+		}
+	}
+
+	if err := (Rules{}).CheckBid(Bid{SongId: "song-b", Amount: 1}, song, leader); err != nil {
+		t.Fatalf("Expected the zero rules to accept every bid, instead got %v", err)
+	}
+}
+
+func TestRaise(t *testing.T) {
+	rules := Rules{MinBid: 2, MinIncrement: 5}
+	leader := &Leader{SongId: "song-a", Behind: 6}
+	for _, test := range []struct {
+		amount, raised int
+	}{
+		{1, 2},
+		{6, 6},
+		{7, 11},
+		{12, 12},
+	} {
+		if raised := rules.Raise(test.amount, Song{SongId: "song-b", Total: 4}, leader); raised != test.raised {
+			t.Fatalf("Expected a bid of %d to be raised to %d, instead got %d", test.amount, test.raised, raised)
+		}
+	}
+}
+
+func TestReserve(t *testing.T) {
+	rules := Rules{SongReserves: map[string]int{"song-a": 5}, ArtistReserves: map[string]int{"The Band": 8}}
+
+	for _, test := range []struct {
+		song    Song
+		reserve int
+	}{
+		{Song{SongId: "song-a", Total: 4}, 5},
+		{Song{SongId: "song-a", Artists: []string{"the band"}, Total: 4}, 8},
+		{Song{SongId: "song-b", Artists: []string{"Someone Else"}, Total: 4}, 0},
+	} {
+		if reserve := rules.Reserve(test.song); reserve != test.reserve {
+			t.Fatalf("Expected %+v to have a reserve of %d, instead got %d", test.song, test.reserve, reserve)
+		}
+		if rules.MeetsReserve(test.song) != (test.song.Total >= test.reserve) {
+			t.Fatalf("Expected %+v to meet its reserve only with enough coins", test.song)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if violations := (Rules{MinBid: 1, SongReserves: map[string]int{"song-a": 3}}).Validate(); len(violations) != 0 {
+		t.Fatalf("Expected valid rules, instead got %v", violations)
+	}
+	violations := Rules{MinBid: -1, MinIncrement: -1, SongReserves: map[string]int{"": 3}, ArtistReserves: map[string]int{"The Band": 0}}.Validate()
+	if len(violations) != 4 || violations[2].Field != `SongReserves[""]` || violations[3].Field != `ArtistReserves["The Band"]` {
+		t.Fatalf("Expected 4 violations, instead got %v", violations)
+	}
+}
+
+func TestClone(t *testing.T) {
+	rules := Rules{SongReserves: map[string]int{"song-a": 3}}
+	clone := rules.Clone()
+	clone.SongReserves["song-a"] = 4
+	if rules.SongReserves["song-a"] != 3 || clone.ArtistReserves == nil {
+		t.Fatalf("Expected a deep copy with empty reserves, instead got %+v", clone)
+	}
+}
@@ -0,0 +1,122 @@
+// Package refunds gives bidders their coins back when the song they bid on won't be played. The
+// Engine refunds the queued bids that are older than the maximum bid age, those still queued when
+// the session ends, those of songs the operator bans and those of skipped songs, and cancels the
+// bids their bidders withdraw. The store records the refunds in the ledger, so a bid is never
+// credited twice.
+package refunds
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/acidleroy/song-bid/cockroach"
+	"github.com/google/uuid"
+)
+
+// Store refunds bids and credits their bidders, see cockroach.RefundStore.
+type Store interface {
+	RefundBids(bidIds []uuid.UUID, reason cockroach.RefundReason) ([]cockroach.BidRow, error)
+	RefundQueuedBids(filter cockroach.RefundFilter, reason cockroach.RefundReason) ([]cockroach.BidRow, error)
+	BanSong(songId string) ([]cockroach.BidRow, error)
+}
+
+var _ Store = (cockroach.Store)(nil)
+
+// Engine runs the refund triggers against a store.
+type Engine struct {
+	store Store
+	// maxBidAge is how long a bid may stay queued before it is refunded, 0 keeps bids forever.
+	maxBidAge time.Duration
+	// checkInterval is how often Run looks for expired bids.
+	checkInterval time.Duration
+	now           func() time.Time
+}
+
+// NewEngine returns an engine that refunds the bids of the store that stayed queued for longer
+// than maxBidAge, checking every checkInterval. A maxBidAge of 0 disables the expiry.
+func NewEngine(store Store, maxBidAge time.Duration, checkInterval time.Duration) *Engine {
+	return &Engine{store: store, maxBidAge: maxBidAge, checkInterval: checkInterval, now: time.Now}
+}
+
+// Run refunds expired bids every check interval until ctx is done. Failed checks are logged and
+// tried again on the next tick.
+func (e *Engine) Run(ctx context.Context) error {
+	if e.maxBidAge <= 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	ticker := time.NewTicker(e.checkInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := e.ExpireBids(); err != nil {
+			log.Printf("Refunding expired bids failed: %v, retrying in %v", err, e.checkInterval)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExpireBids refunds the queued bids older than the maximum bid age.
+func (e *Engine) ExpireBids() ([]cockroach.BidRow, error) {
+	if e.maxBidAge <= 0 {
+		return nil, nil
+	}
+	filter := cockroach.RefundFilter{CreatedBefore: e.now().Add(-e.maxBidAge)}
+	return logRefunds(e.store.RefundQueuedBids(filter, cockroach.ReasonExpired))
+}
+
+// EndSession refunds every queued bid, e.g. when the venue closes.
+func (e *Engine) EndSession() ([]cockroach.BidRow, error) {
+	return logRefunds(e.store.RefundQueuedBids(cockroach.RefundFilter{}, cockroach.ReasonSessionEnded))
+}
+
+// BanSong rejects future bids for the song and refunds its queued bids.
+func (e *Engine) BanSong(songId string) ([]cockroach.BidRow, error) {
+	return logRefunds(e.store.BanSong(songId))
+}
+
+// RefundSkipped refunds the bids of a song that was skipped, since it didn't play out.
+func (e *Engine) RefundSkipped(skipped []cockroach.BidRow) ([]cockroach.BidRow, error) {
+	bidIds := make([]uuid.UUID, len(skipped))
+	for i, row := range skipped {
+		bidIds[i] = row.BidId
+	}
+	return logRefunds(e.store.RefundBids(bidIds, cockroach.ReasonSkipped))
+}
+
+// Refund refunds bids by hand, e.g. those of a song the operator skipped. Only queued and skipped
+// bids can be refunded; nothing is refunded if any of the bids can't be.
+func (e *Engine) Refund(bidIds []uuid.UUID) ([]cockroach.BidRow, error) {
+	return logRefunds(e.store.RefundBids(bidIds, cockroach.ReasonOperator))
+}
+
+// Cancel withdraws a queued bid and gives its coins back. It fails with
+// cockroach.ErrInvalidTransition once the song is playing or played, and with
+// cockroach.ErrBidNotFound if the store cancelled no bid.
+func (e *Engine) Cancel(bidId uuid.UUID) (cockroach.BidRow, error) {
+	rows, err := logRefunds(e.store.RefundBids([]uuid.UUID{bidId}, cockroach.ReasonCancelled))
+	if err != nil {
+		return cockroach.BidRow{}, err
+	}
+	if len(rows) == 0 {
+		return cockroach.BidRow{}, fmt.Errorf("cancel bid: %w: %v", cockroach.ErrBidNotFound, bidId)
+	}
+	return rows[0], nil
+}
+
+// logRefunds logs the refunded bids and passes them on.
+func logRefunds(rows []cockroach.BidRow, err error) ([]cockroach.BidRow, error) {
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		log.Printf("Refunded bid %v of %d coins on %v: %v", row.BidId, row.BidAmount, row.SongId, row.RefundReason)
+	}
+	return rows, nil
+}
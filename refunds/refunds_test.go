@@ -0,0 +1,201 @@
+package refunds
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/acidleroy/song-bid/cockroach"
+	"github.com/google/uuid"
+)
+
+// bidderHelper creates a user with coins and posts a bid for each song.
+func bidderHelper(t *testing.T, store cockroach.Store, songIds ...string) (uuid.UUID, []uuid.UUID) {
+	user, err := store.CreateUser("bidder-"+uuid.New().String()[:8], "hash")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := store.CreditCoins(user.UserId, 100, "test:"+user.Username); err != nil {
+		t.Fatalf("Failed to credit coins: %v", err)
+	}
+
+	bidIds := []uuid.UUID{}
+	for _, songId := range songIds {
+		bidId, err := store.PostBid(cockroach.PostBidData{BidAmount: 10, SongId: songId, UserId: uuid.NullUUID{UUID: user.UserId, Valid: true}})
+		if err != nil {
+			t.Fatalf("Failed to post bid: %v", err)
+		}
+		bidIds = append(bidIds, *bidId)
+	}
+	return user.UserId, bidIds
+}
+
+func balanceHelper(t *testing.T, store cockroach.Store, userId uuid.UUID, expected int64) {
+	if balance, err := store.GetBalance(userId); err != nil || balance != expected {
+		t.Fatalf("Expected a balance of %d, instead got %d and %v", expected, balance, err)
+	}
+}
+
+func TestExpireBids(t *testing.T) {
+	store := cockroach.NewMemoryStore()
+	userId, bidIds := bidderHelper(t, store, "song-a", "song-b")
+	engine := NewEngine(store, time.Hour, time.Minute)
+
+	if expired, err := engine.ExpireBids(); err != nil || len(expired) != 0 {
+		t.Fatalf("Expected new bids not to expire, instead got %v and %v", expired, err)
+	}
+
+	engine.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	expired, err := engine.ExpireBids()
+	if err != nil || len(expired) != 2 {
+		t.Fatalf("Expected both bids to expire, instead got %v and %v", expired, err)
+	}
+	balanceHelper(t, store, userId, 100)
+
+	bid, _ := store.GetBid(bidIds[0])
+	if bid.SongStatus != cockroach.Refunded || bid.RefundReason != cockroach.ReasonExpired {
+		t.Fatalf("Expected the bid to be refunded because it expired, instead got %+v", bid)
+	}
+	if _, err := store.GetBidRefund(bidIds[0]); err != nil {
+		t.Fatalf("Expected the refund to be in the ledger, instead received %v", err)
+	}
+}
+
+func TestExpireBidsDisabled(t *testing.T) {
+	store := cockroach.NewMemoryStore()
+	userId, _ := bidderHelper(t, store, "song-a")
+	engine := NewEngine(store, 0, time.Minute)
+	engine.now = func() time.Time { return time.Now().Add(24 * 365 * time.Hour) }
+
+	if expired, err := engine.ExpireBids(); err != nil || len(expired) != 0 {
+		t.Fatalf("Expected no bid to expire without a maximum age, instead got %v and %v", expired, err)
+	}
+	balanceHelper(t, store, userId, 90)
+}
+
+func TestEndSessionLeavesPlayingSong(t *testing.T) {
+	store := cockroach.NewMemoryStore()
+	userId, _ := bidderHelper(t, store, "song-a", "song-b", "song-c")
+	if _, err := store.PlayNextSong(); err != nil {
+		t.Fatalf("Failed to play next song: %v", err)
+	}
+
+	refunded, err := NewEngine(store, 0, time.Minute).EndSession()
+	if err != nil || len(refunded) != 2 {
+		t.Fatalf("Expected the 2 queued bids to be refunded, instead got %v and %v", refunded, err)
+	}
+	for _, row := range refunded {
+		if row.RefundReason != cockroach.ReasonSessionEnded {
+			t.Fatalf("Expected the bid to be refunded because the session ended, instead got %+v", row)
+		}
+	}
+	balanceHelper(t, store, userId, 90)
+}
+
+func TestBanSong(t *testing.T) {
+	store := cockroach.NewMemoryStore()
+	userId, _ := bidderHelper(t, store, "song-a", "song-a", "song-b")
+	engine := NewEngine(store, 0, time.Minute)
+
+	refunded, err := engine.BanSong("song-a")
+	if err != nil || len(refunded) != 2 {
+		t.Fatalf("Expected the 2 bids on the song to be refunded, instead got %v and %v", refunded, err)
+	}
+	balanceHelper(t, store, userId, 90)
+	if _, err := store.PostBid(cockroach.PostBidData{BidAmount: 1, SongId: "song-a"}); !errors.Is(err, cockroach.ErrSongBanned) {
+		t.Fatalf("Expected ErrSongBanned after banning the song, instead received %v", err)
+	}
+}
+
+func TestRefundSkippedBids(t *testing.T) {
+	store := cockroach.NewMemoryStore()
+	userId, bidIds := bidderHelper(t, store, "song-a", "song-b")
+	engine := NewEngine(store, 0, time.Minute)
+	playing, err := store.PlayNextSong()
+	if err != nil {
+		t.Fatalf("Failed to play next song: %v", err)
+	}
+	skipped, err := store.SkipCurrentSong()
+	if err != nil {
+		t.Fatalf("Failed to skip: %v", err)
+	}
+
+	refunded, err := engine.RefundSkipped(skipped)
+	if err != nil || len(refunded) != 1 || refunded[0].SongStatus != cockroach.Refunded || refunded[0].RefundReason != cockroach.ReasonSkipped {
+		t.Fatalf("Expected the skipped bid to be refunded, instead got %v and %v", refunded, err)
+	}
+	if _, err := engine.Refund([]uuid.UUID{playing[0].BidId}); !errors.Is(err, cockroach.ErrInvalidTransition) {
+		t.Fatalf("Expected ErrInvalidTransition when the operator refunds it again, instead received %v", err)
+	}
+	balanceHelper(t, store, userId, 90)
+	// Nothing is refunded twice, not even the queued bid sent along.
+	if _, err := engine.Refund(bidIds); !errors.Is(err, cockroach.ErrInvalidTransition) {
+		t.Fatalf("Expected ErrInvalidTransition for a refunded bid, instead received %v", err)
+	}
+	balanceHelper(t, store, userId, 90)
+}
+
+func TestCancel(t *testing.T) {
+	store := cockroach.NewMemoryStore()
+	userId, bidIds := bidderHelper(t, store, "song-a", "song-b")
+	engine := NewEngine(store, 0, time.Minute)
+
+	cancelled, err := engine.Cancel(bidIds[1])
+	if err != nil || cancelled.SongStatus != cockroach.Cancelled || cancelled.RefundReason != cockroach.ReasonCancelled {
+		t.Fatalf("Expected the bid to be cancelled, instead got %+v and %v", cancelled, err)
+	}
+	balanceHelper(t, store, userId, 90)
+
+	if _, err := store.PlayNextSong(); err != nil {
+		t.Fatalf("Failed to play next song: %v", err)
+	}
+	if _, err := engine.Cancel(bidIds[0]); !errors.Is(err, cockroach.ErrInvalidTransition) {
+		t.Fatalf("Expected ErrInvalidTransition when cancelling a playing bid, instead received %v", err)
+	}
+	if _, err := engine.Cancel(uuid.New()); !errors.Is(err, cockroach.ErrBidNotFound) {
+		t.Fatalf("Expected ErrBidNotFound for an unknown bid, instead received %v", err)
+	}
+	balanceHelper(t, store, userId, 90)
+}
+
+// emptyStore refunds nothing, whatever it is asked to refund.
+type emptyStore struct {
+	Store
+}
+
+func (emptyStore) RefundBids(bidIds []uuid.UUID, reason cockroach.RefundReason) ([]cockroach.BidRow, error) {
+	return nil, nil
+}
+
+func TestCancelWithoutRows(t *testing.T) {
+	if _, err := NewEngine(emptyStore{}, 0, time.Minute).Cancel(uuid.New()); !errors.Is(err, cockroach.ErrBidNotFound) {
+		t.Fatalf("Expected ErrBidNotFound when the store cancels no bid, instead received %v", err)
+	}
+}
+
+func TestRunExpiresBids(t *testing.T) {
+	store := cockroach.NewMemoryStore()
+	userId, bidIds := bidderHelper(t, store, "song-a")
+	engine := NewEngine(store, time.Millisecond, time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- engine.Run(ctx) }()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if bid, _ := store.GetBid(bidIds[0]); bid.SongStatus == cockroach.Refunded {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected Run to refund the expired bid")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected Run to stop with context.Canceled, instead received %v", err)
+	}
+	balanceHelper(t, store, userId, 100)
+}